@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// AccessList decides which source addresses may connect. Deny entries win;
+// when the allow list is non-empty only addresses in it are accepted.
+type AccessList struct {
+	sync.RWMutex
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+// AccessListConfig is the JSON view of an AccessList
+type AccessListConfig struct {
+	Allow []string
+	Deny  []string
+}
+
+var (
+	apiACL = &AccessList{}
+	p2pACL = &AccessList{}
+
+	// trustedProxies may name the client in X-Forwarded-For
+	trustedProxies []*net.IPNet
+)
+
+// loadAccessLists reads API_ALLOW, API_DENY, P2P_ALLOW, P2P_DENY and
+// TRUSTED_PROXIES, each a comma separated list of CIDRs or single IPs
+func loadAccessLists() {
+	proxies, err := parseNets(splitList(os.Getenv("TRUSTED_PROXIES")))
+	if err != nil {
+		log.Fatalf("TRUSTED_PROXIES: %v", err)
+	}
+	trustedProxies = proxies
+	for _, l := range []struct {
+		acl    *AccessList
+		prefix string
+	}{{apiACL, "API"}, {p2pACL, "P2P"}} {
+		config := AccessListConfig{
+			Allow: splitList(os.Getenv(l.prefix + "_ALLOW")),
+			Deny:  splitList(os.Getenv(l.prefix + "_DENY")),
+		}
+		if err := l.acl.Set(config); err != nil {
+			log.Fatalf("%s access list: %v", l.prefix, err)
+		}
+	}
+}
+
+func splitList(v string) []string {
+	var items []string
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parseNets(entries []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range entries {
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", entry)
+			}
+			bits := 8 * len(ip.To16())
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// Set replaces both lists
+func (l *AccessList) Set(config AccessListConfig) error {
+	allow, err := parseNets(config.Allow)
+	if err != nil {
+		return err
+	}
+	deny, err := parseNets(config.Deny)
+	if err != nil {
+		return err
+	}
+
+	l.Lock()
+	defer l.Unlock()
+	l.allow, l.deny = allow, deny
+	return nil
+}
+
+// Config returns the current lists
+func (l *AccessList) Config() AccessListConfig {
+	l.RLock()
+	defer l.RUnlock()
+
+	config := AccessListConfig{Allow: []string{}, Deny: []string{}}
+	for _, n := range l.allow {
+		config.Allow = append(config.Allow, n.String())
+	}
+	for _, n := range l.deny {
+		config.Deny = append(config.Deny, n.String())
+	}
+	return config
+}
+
+// Allowed reports whether ip may connect
+func (l *AccessList) Allowed(ip net.IP) bool {
+	l.RLock()
+	defer l.RUnlock()
+
+	for _, n := range l.deny {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(l.allow) == 0 {
+		return true
+	}
+	for _, n := range l.allow {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// configured reports whether either list has entries
+func (l *AccessList) configured() bool {
+	l.RLock()
+	defer l.RUnlock()
+	return len(l.allow) > 0 || len(l.deny) > 0
+}
+
+// AllowedAddr checks the IP of a host:port address
+func (l *AccessList) AllowedAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && l.Allowed(ip)
+}
+
+func trustedProxy(ip net.IP) bool {
+	for _, n := range trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// clientIP is the address a request came from. Behind a trusted proxy it's
+// the rightmost X-Forwarded-For entry that isn't a trusted proxy itself, as
+// anything left of that was written by the client and can't be believed.
+func clientIP(r *http.Request) net.IP {
+	ip := remoteIP(r)
+	if ip == nil || !trustedProxy(ip) {
+		return ip
+	}
+	hops := splitList(strings.Join(r.Header.Values("X-Forwarded-For"), ","))
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := net.ParseIP(hops[i])
+		if hop == nil {
+			return nil
+		}
+		if !trustedProxy(hop) {
+			return hop
+		}
+		ip = hop
+	}
+	return ip
+}
+
+// clientHost is clientIP as a string, falling back to RemoteAddr
+func clientHost(r *http.Request) string {
+	if ip := clientIP(r); ip != nil {
+		return ip.String()
+	}
+	return r.RemoteAddr
+}
+
+// aclMiddleware rejects API requests from clients outside apiACL. Loopback
+// clients connecting directly are let through so an operator can't lock
+// themselves out; a loopback trusted proxy gets no such pass, or it would
+// open the API to everyone it forwards.
+func aclMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer := remoteIP(r)
+		local := peer != nil && peer.IsLoopback() && !trustedProxy(peer)
+		ip := clientIP(r)
+		if ip == nil || (!local && !apiACL.Allowed(ip)) {
+			respondWithError(w, r, http.StatusForbidden, "Address not allowed to use the API")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func accessListByName(name string) *AccessList {
+	switch name {
+	case "api":
+		return apiACL
+	case "p2p":
+		return p2pACL
+	}
+	return nil
+}
+
+// shows the API and P2P access lists
+func handleGetAccessLists(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, r, http.StatusOK, map[string]AccessListConfig{
+		"api": apiACL.Config(),
+		"p2p": p2pACL.Config(),
+	})
+}
+
+// replaces the api or p2p access list
+func handleSetAccessList(w http.ResponseWriter, r *http.Request) {
+	acl := accessListByName(mux.Vars(r)["list"])
+	if acl == nil {
+		respondWithError(w, r, http.StatusNotFound, "unknown access list, use api or p2p")
+		return
+	}
+
+	var config AccessListConfig
+	if err := decodeRequest(r, &config); err != nil {
+		respondWithRequestError(w, r, err)
+		return
+	}
+	if err := acl.Set(config); err != nil {
+		respondWithRequestError(w, r, err)
+		return
+	}
+	if acl == p2pACL {
+		peerManager.disconnectDisallowed()
+	}
+
+	respondWithJSON(w, r, http.StatusOK, acl.Config())
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	defer func() { trustedProxies = nil }()
+
+	tests := []struct {
+		name      string
+		proxies   []string
+		remote    string
+		forwarded string
+		want      string
+	}{
+		{name: "direct client", remote: "192.0.2.1:1000", want: "192.0.2.1"},
+		{name: "header from an untrusted peer", remote: "192.0.2.1:1000", forwarded: "10.0.0.1", want: "192.0.2.1"},
+		{name: "trusted proxy", proxies: []string{"127.0.0.1"}, remote: "127.0.0.1:1000", forwarded: "192.0.2.1", want: "192.0.2.1"},
+		{name: "spoofed leftmost entry", proxies: []string{"127.0.0.1"}, remote: "127.0.0.1:1000", forwarded: "10.0.0.1, 192.0.2.1", want: "192.0.2.1"},
+		{name: "chain of proxies", proxies: []string{"127.0.0.1", "10.0.0.0/8"}, remote: "127.0.0.1:1000", forwarded: "192.0.2.1, 10.0.0.2", want: "192.0.2.1"},
+		{name: "trusted proxy without header", proxies: []string{"127.0.0.1"}, remote: "127.0.0.1:1000", want: "127.0.0.1"},
+		{name: "garbage entry", proxies: []string{"127.0.0.1"}, remote: "127.0.0.1:1000", forwarded: "nonsense", want: "<nil>"},
+	}
+	for _, tt := range tests {
+		proxies, err := parseNets(tt.proxies)
+		if err != nil {
+			t.Fatal(err)
+		}
+		trustedProxies = proxies
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remote
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if got := clientIP(r).String(); got != tt.want {
+			t.Errorf("%s: client %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestACLLoopbackProxy(t *testing.T) {
+	defer func() { trustedProxies = nil; apiACL = &AccessList{} }()
+	apiACL = &AccessList{}
+	if err := apiACL.Set(AccessListConfig{Allow: []string{"10.0.0.0/8"}}); err != nil {
+		t.Fatal(err)
+	}
+	handler := aclMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		name    string
+		proxies []string
+		remote  string
+		want    int
+	}{
+		{name: "local operator", remote: "127.0.0.1:1000", want: http.StatusOK},
+		{name: "outsider", remote: "192.0.2.1:1000", want: http.StatusForbidden},
+		{name: "outsider through a local proxy", proxies: []string{"127.0.0.1"}, remote: "127.0.0.1:1000", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		trustedProxies, _ = parseNets(tt.proxies)
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remote
+		r.Header.Set("X-Forwarded-For", "192.0.2.1")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
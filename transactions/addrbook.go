@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// maxKnownAddrs bounds the address book; the least recently seen
+	// addresses are forgotten first
+	maxKnownAddrs = 1000
+	// maxAddrsPerMessage caps the addresses sent in one addr message
+	maxAddrsPerMessage = 100
+	// maxOutbound is how many outbound connections discovery aims for
+	maxOutbound = 8
+	// maxAddrFailures is how many failed dials in a row drop an address
+	maxAddrFailures = 3
+
+	addrBookFile = "peers.json"
+)
+
+// AddrMessage shares the listening addresses a node knows about
+type AddrMessage struct {
+	Addrs []string
+}
+
+// KnownAddr is an address book entry
+type KnownAddr struct {
+	Addr     string
+	LastSeen time.Time
+	Failures int
+}
+
+// AddrBook remembers the listening addresses of peers, learned from their
+// version messages and from addr exchange, and persists them in DATA_DIR
+type AddrBook struct {
+	sync.Mutex
+	path  string
+	addrs map[string]*KnownAddr
+	dirty bool
+}
+
+var addrBook = &AddrBook{addrs: make(map[string]*KnownAddr)}
+
+// load reads the persisted address book
+func (ab *AddrBook) load(path string) error {
+	ab.Lock()
+	defer ab.Unlock()
+
+	ab.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var known []*KnownAddr
+	if err := json.Unmarshal(data, &known); err != nil {
+		return err
+	}
+	for _, ka := range known {
+		ab.addrs[ka.Addr] = ka
+	}
+	p2pLog.Info("Loaded known peer addresses", "count", len(known))
+	return nil
+}
+
+// save writes the address book if it changed since the last save
+func (ab *AddrBook) save() {
+	ab.Lock()
+	defer ab.Unlock()
+
+	if !ab.dirty || ab.path == "" {
+		return
+	}
+	known := make([]*KnownAddr, 0, len(ab.addrs))
+	for _, ka := range ab.addrs {
+		known = append(known, ka)
+	}
+	data, err := json.MarshalIndent(known, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(ab.path, data)
+	}
+	if err != nil {
+		p2pLog.Warn("Can't save peer addresses", "err", err)
+		return
+	}
+	ab.dirty = false
+}
+
+// Add records a listening address seen at the given time. Addresses that
+// aren't ip:port or that the P2P access list refuses are ignored.
+func (ab *AddrBook) Add(addr string, seen time.Time) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || port == "0" || net.ParseIP(host) == nil || !p2pACL.AllowedAddr(addr) {
+		return
+	}
+
+	ab.Lock()
+	defer ab.Unlock()
+
+	if ka, ok := ab.addrs[addr]; ok {
+		if seen.After(ka.LastSeen) {
+			ka.LastSeen = seen
+			ab.dirty = true
+		}
+		return
+	}
+	if len(ab.addrs) >= maxKnownAddrs {
+		var oldest *KnownAddr
+		for _, ka := range ab.addrs {
+			if oldest == nil || ka.LastSeen.Before(oldest.LastSeen) {
+				oldest = ka
+			}
+		}
+		delete(ab.addrs, oldest.Addr)
+	}
+	ab.addrs[addr] = &KnownAddr{Addr: addr, LastSeen: seen}
+	ab.dirty = true
+}
+
+// Good marks a successful connection to addr
+func (ab *AddrBook) Good(addr string) {
+	ab.Lock()
+	defer ab.Unlock()
+	if ka, ok := ab.addrs[addr]; ok {
+		ka.LastSeen, ka.Failures = time.Now(), 0
+		ab.dirty = true
+	}
+}
+
+// Failed records a failed dial, dropping addr after too many in a row
+func (ab *AddrBook) Failed(addr string) {
+	ab.Lock()
+	defer ab.Unlock()
+	if ka, ok := ab.addrs[addr]; ok {
+		if ka.Failures++; ka.Failures >= maxAddrFailures {
+			delete(ab.addrs, addr)
+		}
+		ab.dirty = true
+	}
+}
+
+// Remove forgets addr, e.g. because it turned out to be our own
+func (ab *AddrBook) Remove(addr string) {
+	ab.Lock()
+	defer ab.Unlock()
+	if _, ok := ab.addrs[addr]; ok {
+		delete(ab.addrs, addr)
+		ab.dirty = true
+	}
+}
+
+// Recent returns up to n addresses, most recently seen first
+func (ab *AddrBook) Recent(n int) []string {
+	ab.Lock()
+	known := make([]*KnownAddr, 0, len(ab.addrs))
+	for _, ka := range ab.addrs {
+		known = append(known, ka)
+	}
+	ab.Unlock()
+
+	sort.Slice(known, func(i, j int) bool { return known[i].LastSeen.After(known[j].LastSeen) })
+	addrs := []string{}
+	for i := 0; i < len(known) && i < n; i++ {
+		addrs = append(addrs, known[i].Addr)
+	}
+	return addrs
+}
+
+// startDiscovery loads the address book and keeps dialing known addresses
+// until the node has maxOutbound outbound peers
+func (pm *PeerManager) startDiscovery() {
+	if err := addrBook.load(filepath.Join(dataDir(), addrBookFile)); err != nil {
+		p2pLog.Warn("Can't load peer addresses", "err", err)
+	}
+
+	go func() {
+		for {
+			addrBook.save()
+			pm.dialKnown()
+			time.Sleep(reconnectInterval)
+		}
+	}()
+}
+
+// dialKnown opens connections to known addresses we aren't connected to
+func (pm *PeerManager) dialKnown() {
+	pm.Lock()
+	defer pm.Unlock()
+
+	connected := make(map[string]bool)
+	outbound := len(pm.dialing)
+	for p := range pm.peers {
+		connected[p.addr] = true
+		if !p.inbound && !pm.dialing[p.addr] {
+			outbound++
+		}
+	}
+
+	// prefer the addresses of peers that served us well before
+	candidates := addrBook.Recent(maxKnownAddrs)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return peerStats.Score(candidates[i]) > peerStats.Score(candidates[j])
+	})
+	for _, addr := range candidates {
+		if outbound >= maxOutbound {
+			return
+		}
+		if connected[addr] || pm.dialing[addr] {
+			continue
+		}
+		pm.dialing[addr] = true
+		outbound++
+		go pm.dial(addr)
+	}
+}
+
+// dial makes a single connection attempt to a discovered address
+func (pm *PeerManager) dial(addr string) {
+	defer func() {
+		pm.Lock()
+		delete(pm.dialing, addr)
+		pm.Unlock()
+	}()
+
+	conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+	if err != nil {
+		addrBook.Failed(addr)
+		return
+	}
+	secured, id, err := secureConn(conn, false, "")
+	if err != nil {
+		p2pLog.Warn("Can't connect to peer", "peer", addr, "err", err)
+		conn.Close()
+		addrBook.Failed(addr)
+		return
+	}
+	addrBook.Good(addr)
+	p := newPeer(secured, addr, false)
+	p.nodeID = id
+	pm.handle(p)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/gorilla/mux"
+)
+
+// adminPrefix starts the paths of the admin API
+const adminPrefix = "/admin/"
+
+var (
+	// adminAddr is where the admin API listens, empty when it's off
+	adminAddr string
+	// adminAuth checks the credentials of admin requests, apart from the
+	// public API's
+	adminAuth = &APIAuth{all: true}
+)
+
+// loadAdminConfig reads ADMIN_ADDR, the address of the admin API,
+// 127.0.0.1:8081 by default and off to disable it, and its credentials,
+// ADMIN_KEYS, ADMIN_JWT_SECRET and ADMIN_JWT_ISSUER. Without credentials the
+// admin API may only listen on a loopback address.
+func loadAdminConfig() {
+	adminAddr = os.Getenv("ADMIN_ADDR")
+	switch adminAddr {
+	case "":
+		adminAddr = "127.0.0.1:8081"
+	case "off":
+		adminAddr = ""
+		return
+	}
+
+	adminAuth = newAPIAuth(os.Getenv("ADMIN_KEYS"), os.Getenv("ADMIN_JWT_SECRET"), os.Getenv("ADMIN_JWT_ISSUER"))
+	adminAuth.all = true
+	host, _, err := net.SplitHostPort(adminAddr)
+	if err != nil {
+		log.Fatalf("ADMIN_ADDR must be a host:port address, got %q", adminAddr)
+	}
+	if !adminAuth.enabled() && !isLoopback(host) {
+		log.Fatalf("ADMIN_ADDR %s isn't a loopback address; set ADMIN_KEYS or ADMIN_JWT_SECRET to expose the admin API", adminAddr)
+	}
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// makeAdminRouter creates the handlers of the admin API: the endpoints that
+// change the chain, the mempool or who may connect, peer statistics, the
+// audit log, and pprof with the runtime statistics
+func makeAdminRouter() http.Handler {
+	muxRouter := mux.NewRouter()
+	muxRouter.HandleFunc("/admin/invalidateblock", handleInvalidateBlock).Methods("POST")
+	muxRouter.HandleFunc("/admin/reconsiderblock", handleReconsiderBlock).Methods("POST")
+	muxRouter.HandleFunc("/admin/replace-chain", handleReplaceChain).Methods("POST")
+	muxRouter.HandleFunc("/admin/verify", handleVerifyChain).Methods("POST")
+	muxRouter.HandleFunc("/admin/mempool/{txid}", handleDeleteMempoolTx).Methods("DELETE")
+	muxRouter.HandleFunc("/admin/peers", handleGetPeerStats).Methods("GET")
+	muxRouter.HandleFunc("/admin/acl", handleGetAccessLists).Methods("GET")
+	muxRouter.HandleFunc("/admin/acl/{list}", handleSetAccessList).Methods("PUT")
+	muxRouter.HandleFunc("/admin/difficulty", handleSetDifficulty).Methods("PUT")
+	muxRouter.HandleFunc("/admin/status", handleGetNodeStatus).Methods("GET")
+	muxRouter.HandleFunc("/admin/stop", handleStopNode).Methods("POST")
+	muxRouter.HandleFunc("/admin/audit", handleGetAudit).Methods("GET")
+	muxRouter.HandleFunc("/admin/webhooks", handleCreateWebhook).Methods("POST")
+	muxRouter.HandleFunc("/admin/webhooks", handleGetWebhooks).Methods("GET")
+	muxRouter.HandleFunc("/admin/webhooks/{id}", handleDeleteWebhook).Methods("DELETE")
+	addDebugRoutes(muxRouter)
+	muxRouter.HandleFunc("/admin/openapi.json", serveOpenAPI(muxRouter)).Methods("GET")
+	muxRouter.NotFoundHandler = http.HandlerFunc(handleNotFound)
+	muxRouter.MethodNotAllowedHandler = http.HandlerFunc(handleMethodNotAllowed)
+	muxRouter.Use(auditMiddleware(adminAuth), adminAuthMiddleware)
+	return muxRouter
+}
+
+// adminAuthMiddleware refuses admin requests without admin credentials, when
+// there are any
+func adminAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !adminAuth.authorized(r) {
+			respondUnauthorized(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// BlockHashMessage names a block by its hash
+type BlockHashMessage struct {
+	Hash string `validate:"required"`
+}
+
+// ReplaceChainMessage carries a complete candidate chain, genesis first
+type ReplaceChainMessage struct {
+	Blocks []*Block `validate:"required"`
+}
+
+// DifficultyMessage sets the difficulty the local miner aims for
+type DifficultyMessage struct {
+	Difficulty int `validate:"required,min=1"`
+}
+
+// changes the local miner's difficulty. Only development networks allow it;
+// blocks must still meet the network's difficulty, so it can only go up from
+// there.
+func handleSetDifficulty(w http.ResponseWriter, r *http.Request) {
+	if params.Name == "main" {
+		respondWithError(w, r, http.StatusForbidden, "The difficulty of main can't be changed")
+		return
+	}
+
+	var m DifficultyMessage
+	if err := decodeRequest(r, &m); err != nil {
+		respondWithRequestError(w, r, err)
+		return
+	}
+	if err := setMinerDifficulty(m.Difficulty); err != nil {
+		respondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	minerLog.Info("Miner difficulty changed", "difficulty", m.Difficulty)
+
+	respondWithJSON(w, r, http.StatusOK, DifficultyMessage{miningDifficulty()})
+}
+
+// VerifyMessage selects how thoroughly POST /admin/verify checks the chain:
+// headers, blocks or full, the default
+type VerifyMessage struct {
+	Level string
+}
+
+// checks the integrity of the active chain and reports the first
+// inconsistency found
+func handleVerifyChain(w http.ResponseWriter, r *http.Request) {
+	var m VerifyMessage
+	if err := decodeRequest(r, &m); err != nil {
+		respondWithRequestError(w, r, err)
+		return
+	}
+	if m.Level == "" {
+		m.Level = "full"
+	}
+	level, ok := verifyLevels[m.Level]
+	if !ok {
+		respondWithRequestError(w, r, invalidRequest("Level", "must be headers, blocks or full"))
+		return
+	}
+
+	report := bc.verifyActiveChain(m.Level, level)
+	if !report.Valid {
+		storageLog.Warn("Chain verification failed", "level", m.Level, "height", report.Violation.Height, "hash", report.Violation.Hash, "err", report.Violation.Reason)
+	}
+	respondWithJSON(w, r, http.StatusOK, report)
+}
+
+// marks a block invalid and reorganizes away from it
+func handleInvalidateBlock(w http.ResponseWriter, r *http.Request) {
+	var m BlockHashMessage
+	if err := decodeRequest(r, &m); err != nil {
+		respondWithRequestError(w, r, err)
+		return
+	}
+
+	if err := bc.InvalidateBlock(m.Hash); err != nil {
+		respondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, r, http.StatusOK, bc.Tip())
+}
+
+// clears the invalid mark of a block and reorganizes to the best chain
+func handleReconsiderBlock(w http.ResponseWriter, r *http.Request) {
+	var m BlockHashMessage
+	if err := decodeRequest(r, &m); err != nil {
+		respondWithRequestError(w, r, err)
+		return
+	}
+
+	if err := bc.ReconsiderBlock(m.Hash); err != nil {
+		respondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, r, http.StatusOK, bc.Tip())
+}
+
+// switches to a complete candidate chain if it is valid and has more work
+func handleReplaceChain(w http.ResponseWriter, r *http.Request) {
+	var m ReplaceChainMessage
+	if err := decodeRequest(r, &m); err != nil {
+		respondWithRequestError(w, r, err)
+		return
+	}
+
+	if err := bc.ReplaceChain(m.Blocks); err != nil {
+		respondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, r, http.StatusOK, bc.Tip())
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// alerts tell operators of multi-node setups about consensus trouble: a
+// reorganization deeper than REORG_ALERT_DEPTH blocks, or a side branch
+// growing longer than FORK_ALERT_LENGTH blocks, which means some miners
+// keep building on another chain. An alert is logged, counted in the
+// blockchain_alerts_total metric and sent to the webhooks subscribed to
+// alert events.
+
+const (
+	// defaultReorgAlertDepth lets one-block reorganizations, the usual
+	// outcome of two miners finding a block at once, pass without an alert
+	defaultReorgAlertDepth = 1
+	// defaultForkAlertLength is how long a side branch may grow unnoticed
+	defaultForkAlertLength = 3
+)
+
+// alert kinds
+const (
+	AlertReorg = "reorg"
+	AlertFork  = "fork"
+)
+
+var (
+	reorgAlertDepth = defaultReorgAlertDepth
+	forkAlertLength = defaultForkAlertLength
+
+	// alertedForks remembers the fork points already alerted about, so a
+	// side branch raises one alert however long it grows
+	alertedForks = &seenCache{set: make(map[string]bool)}
+)
+
+// ForkEvent describes a block stored on a side branch: the branch's tip,
+// the active chain block it forks from and its length
+type ForkEvent struct {
+	Tip        string
+	ForkHash   string
+	ForkHeight int
+	Length     int
+}
+
+// Alert is a warning about the consensus of the network
+type Alert struct {
+	Kind       string
+	Message    string
+	ForkHeight int
+	// Depth is how many blocks were disconnected or the side branch has
+	Depth int
+	Time  time.Time
+}
+
+// loadAlertConfig reads REORG_ALERT_DEPTH and FORK_ALERT_LENGTH; 0 turns
+// the alert off
+func loadAlertConfig() {
+	reorgAlertDepth = envAlertThreshold("REORG_ALERT_DEPTH", defaultReorgAlertDepth)
+	forkAlertLength = envAlertThreshold("FORK_ALERT_LENGTH", defaultForkAlertLength)
+}
+
+func envAlertThreshold(name string, fallback int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Fatalf("%s must be a non-negative integer, got %q", name, v)
+	}
+	return n
+}
+
+// watchConsensus raises alerts from the reorganizations and side branch
+// blocks of the chain
+func watchConsensus() {
+	chainEvents.Handle("Alerts", func(e ChainEvent) {
+		if alert, ok := consensusAlert(e); ok {
+			raiseAlert(alert)
+		}
+	}, Reorg, ForkBlock)
+}
+
+// consensusAlert returns the alert an event calls for, if any
+func consensusAlert(e ChainEvent) (Alert, bool) {
+	if reorg, ok := e.Reorg(); ok {
+		depth := len(reorg.Disconnected)
+		if reorgAlertDepth == 0 || depth <= reorgAlertDepth {
+			return Alert{}, false
+		}
+		return Alert{
+			Kind:       AlertReorg,
+			Message:    fmt.Sprintf("Reorganization disconnected %d blocks above height %d", depth, reorg.ForkHeight),
+			ForkHeight: reorg.ForkHeight,
+			Depth:      depth,
+			Time:       time.Now(),
+		}, true
+	}
+
+	fork, ok := e.Fork()
+	if !ok || forkAlertLength == 0 || fork.Length <= forkAlertLength {
+		return Alert{}, false
+	}
+	if !alertedForks.markSeen(fork.ForkHash) {
+		return Alert{}, false
+	}
+	return Alert{
+		Kind:       AlertFork,
+		Message:    fmt.Sprintf("A side branch of %d blocks forks at height %d, tip %s", fork.Length, fork.ForkHeight, fork.Tip),
+		ForkHeight: fork.ForkHeight,
+		Depth:      fork.Length,
+		Time:       time.Now(),
+	}, true
+}
+
+// raiseAlert logs, counts and delivers an alert
+func raiseAlert(a Alert) {
+	storageLog.Warn("ALERT: "+a.Message, "kind", a.Kind, "fork_height", a.ForkHeight, "depth", a.Depth)
+	chainAlerts.WithLabelValues(a.Kind).Inc()
+	webhooks.enqueue(WebhookAlert, a, nil)
+}
@@ -0,0 +1,25 @@
+package main
+
+// Amount is a quantity of coins. It is 64 bits wide on every platform, and
+// every amount the node accepts stays within maxMoney.
+type Amount int64
+
+// maxMoney bounds every amount: no output, sum of outputs or balance may
+// exceed it. It lies far below the int64 limit, so adding two amounts in
+// range can't overflow.
+const maxMoney Amount = 21_000_000 * 100_000_000
+
+// moneyRange tells if a is an amount the node accepts
+func moneyRange(a Amount) bool {
+	return a >= 0 && a <= maxMoney
+}
+
+// addAmount adds a to sum and tells if both a and the new sum are in the
+// money range
+func addAmount(sum *Amount, a Amount) bool {
+	if !moneyRange(a) {
+		return false
+	}
+	*sum += a
+	return moneyRange(*sum)
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// archiveSegment is how many blocks one archive file holds
+	archiveSegment = 1000
+	// archiveFinality is how deep a block must be before it is archived;
+	// reorganizations are assumed never to reach that far
+	archiveFinality = 100
+
+	archiveManifestFile = "manifest.json"
+)
+
+// ArchiveFile describes one immutable archive file: the active chain blocks
+// FirstHeight to LastHeight as JSON, one block per line
+type ArchiveFile struct {
+	Name        string
+	FirstHeight int
+	LastHeight  int
+	FirstHash   string
+	LastHash    string
+	Size        int64
+	SHA256      string
+}
+
+// Archive writes final stretches of the chain into files that other nodes
+// download over HTTP to bootstrap without syncing from peers
+type Archive struct {
+	sync.Mutex
+	dir   string
+	files []ArchiveFile
+}
+
+// archive is set when the node runs with ARCHIVE=1
+var archive *Archive
+
+// startArchive opens the archive in DATA_DIR when ARCHIVE is set and keeps
+// it up to date as blocks become final
+func startArchive() {
+	if os.Getenv("ARCHIVE") != "1" {
+		return
+	}
+
+	dir := filepath.Join(dataDir(), "archive")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		log.Fatal(err)
+	}
+	archive = &Archive{dir: dir}
+	if data, err := os.ReadFile(filepath.Join(dir, archiveManifestFile)); err == nil {
+		if err := json.Unmarshal(data, &archive.files); err != nil {
+			log.Fatal(err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		log.Fatal(err)
+	}
+
+	update := func(ChainEvent) {
+		if err := archive.update(); err != nil {
+			storageLog.Error("Can't update archive", "err", err)
+		}
+	}
+	chainEvents.Handle("Archive", update, BlockConnected, Reorg)
+	go update(ChainEvent{})
+}
+
+// update drops archive files a reorganization invalidated and writes files
+// for every complete segment of final blocks. Most blocks cross no segment
+// boundary and leave it with nothing to do; the chain lock is only held to
+// copy out what changed, never for file work.
+func (a *Archive) update() error {
+	a.Lock()
+	defer a.Unlock()
+
+	bc.RLock()
+	keep := len(a.files)
+	for keep > 0 {
+		last := a.files[keep-1]
+		if last.LastHeight < len(bc.blocks) && bc.blocks[last.LastHeight].Hash == last.LastHash {
+			break
+		}
+		keep--
+	}
+	var segments [][]*Block
+	first := keep * archiveSegment
+	for ; first+archiveSegment-1 <= len(bc.blocks)-1-archiveFinality; first += archiveSegment {
+		segments = append(segments, append([]*Block{}, bc.blocks[first:first+archiveSegment]...))
+	}
+	bc.RUnlock()
+
+	if keep == len(a.files) && len(segments) == 0 {
+		return nil
+	}
+	for _, stale := range a.files[keep:] {
+		storageLog.Warn("ALERT: archive file is no longer on the active chain, removing it", "file", stale.Name)
+		os.Remove(filepath.Join(a.dir, stale.Name))
+	}
+	a.files = a.files[:keep]
+	for _, blocks := range segments {
+		if err := a.write(blocks); err != nil {
+			return err
+		}
+	}
+	return a.saveManifest()
+}
+
+// write stores a segment that starts right after the last archive file
+func (a *Archive) write(blocks []*Block) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, b := range blocks {
+		if err := enc.Encode(b); err != nil {
+			return err
+		}
+	}
+
+	first := len(a.files) * archiveSegment
+	sum := sha256.Sum256(buf.Bytes())
+	f := ArchiveFile{
+		Name:        fmt.Sprintf("blocks-%08d.jsonl", first),
+		FirstHeight: first,
+		LastHeight:  first + len(blocks) - 1,
+		FirstHash:   blocks[0].Hash,
+		LastHash:    blocks[len(blocks)-1].Hash,
+		Size:        int64(buf.Len()),
+		SHA256:      hex.EncodeToString(sum[:]),
+	}
+	if err := writeFileAtomic(filepath.Join(a.dir, f.Name), buf.Bytes()); err != nil {
+		return err
+	}
+	a.files = append(a.files, f)
+	storageLog.Info("Archived blocks", "first", f.FirstHeight, "last", f.LastHeight, "file", f.Name)
+	return nil
+}
+
+func (a *Archive) saveManifest() error {
+	data, err := json.MarshalIndent(a.files, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(a.dir, archiveManifestFile), data)
+}
+
+// file returns the archive file called name
+func (a *Archive) file(name string) (ArchiveFile, bool) {
+	a.Lock()
+	defer a.Unlock()
+	for _, f := range a.files {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return ArchiveFile{}, false
+}
+
+// lists the archive files with their checksums
+func handleGetArchiveManifest(w http.ResponseWriter, r *http.Request) {
+	archive.Lock()
+	files := append([]ArchiveFile{}, archive.files...)
+	archive.Unlock()
+
+	respondWithJSON(w, r, http.StatusOK, files)
+}
+
+// serves an archive file, with range requests so downloads can resume
+func handleGetArchiveFile(w http.ResponseWriter, r *http.Request) {
+	f, ok := archive.file(mux.Vars(r)["name"])
+	if !ok {
+		respondWithError(w, r, http.StatusNotFound, "No such archive file")
+		return
+	}
+	file, err := os.Open(filepath.Join(archive.dir, f.Name))
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer file.Close()
+
+	// the files never change, so the checksum is a strong validator
+	w.Header().Set("ETag", `"`+f.SHA256+`"`)
+	w.Header().Set("X-Content-SHA256", f.SHA256)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	keepWriting(w)
+	http.ServeContent(w, r, f.Name, time.Time{}, file)
+}
+
+// bootstrapFromArchive downloads the archive files of the node at url that
+// go past our tip, checks their checksums and processes their blocks as if
+// they came from a peer. Interrupted downloads resume where they stopped.
+func bootstrapFromArchive(url string) error {
+	var files []ArchiveFile
+	if err := getJSON(url+"/archive/manifest", &files); err != nil {
+		return err
+	}
+
+	dir := filepath.Join(dataDir(), "bootstrap")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.LastHeight <= bc.Height() {
+			continue
+		}
+		path := filepath.Join(dir, filepath.Base(f.Name))
+		if err := downloadArchiveFile(url+"/archive/"+f.Name, path, f); err != nil {
+			return fmt.Errorf("%s: %v", f.Name, err)
+		}
+		if err := importArchiveFile(path); err != nil {
+			return fmt.Errorf("%s: %v", f.Name, err)
+		}
+		os.Remove(path)
+		storageLog.Info("Bootstrapped blocks", "first", f.FirstHeight, "last", f.LastHeight, "url", url)
+	}
+	return nil
+}
+
+// downloadArchiveFile fetches f into path, resuming a partial download, and
+// verifies its checksum
+func downloadArchiveFile(url, path string, f ArchiveFile) error {
+	out, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	offset, err := out.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	if offset < f.Size {
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		if offset > 0 {
+			req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+			req.Header.Set("If-Range", `"`+f.SHA256+`"`)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusPartialContent:
+		case http.StatusOK:
+			// the server sent the whole file
+			if err := out.Truncate(0); err != nil {
+				return err
+			}
+			if _, err := out.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("download failed: %s", resp.Status)
+		}
+		if _, err := io.Copy(out, resp.Body); err != nil {
+			return err
+		}
+	}
+
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != f.SHA256 {
+		os.Remove(path)
+		return errors.New("checksum mismatch, download discarded")
+	}
+	return nil
+}
+
+// importArchiveFile processes the blocks of a downloaded archive file
+func importArchiveFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var blocks []*Block
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), maxMessageSize)
+	for scanner.Scan() {
+		var b Block
+		if err := json.Unmarshal(scanner.Bytes(), &b); err != nil {
+			return err
+		}
+		blocks = append(blocks, &b)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	for i, check := range bc.verifyBlocks(blocks) {
+		err := check.err
+		if err == nil {
+			err = bc.processBlock(blocks[i], check.verified)
+		}
+		if err != nil && err != errBlockKnown {
+			return fmt.Errorf("block %s: %v", blocks[i].Hash, err)
+		}
+	}
+	return nil
+}
+
+// getJSON fetches url and decodes the JSON response into v
+func getJSON(url string, v interface{}) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// defaultAuditResults is how many entries GET /admin/audit returns
+	defaultAuditResults = 100
+	// maxAuditResults caps the limit parameter of GET /admin/audit
+	maxAuditResults = 1000
+	// maxAuditErrorBody is how much of an error response is kept to find
+	// its message
+	maxAuditErrorBody = 4096
+)
+
+// AuditEntry records a request that may change state. Each entry's Hash
+// covers the previous entry's, so editing or dropping a line of the log
+// breaks the chain of every line after it.
+type AuditEntry struct {
+	Seq         int64
+	Time        time.Time
+	Method      string
+	Route       string
+	Path        string
+	Caller      string
+	PayloadHash string
+	Status      int
+	Error       string `json:",omitempty"`
+	Hash        string
+}
+
+// AuditLog appends entries to a JSON lines file
+type AuditLog struct {
+	sync.Mutex
+	path     string
+	file     *os.File
+	seq      int64
+	lastHash string
+}
+
+// audit is nil when the audit log is off
+var audit *AuditLog
+
+// loadAuditConfig opens the audit log, audit.log in the data directory or
+// AUDIT_LOG, which off disables
+func loadAuditConfig() {
+	path := os.Getenv("AUDIT_LOG")
+	switch path {
+	case "off":
+		return
+	case "":
+		path = filepath.Join(dataDir(), "audit.log")
+	}
+	a, err := OpenAuditLog(path)
+	if err != nil {
+		log.Fatalf("AUDIT_LOG: %v", err)
+	}
+	audit = a
+}
+
+// OpenAuditLog opens the log at path for appending, continuing the sequence
+// and hash chain of its last entry
+func OpenAuditLog(path string) (*AuditLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	a := &AuditLog{path: path}
+	err := a.scan(func(e *AuditEntry) bool {
+		a.seq, a.lastHash = e.Seq, e.Hash
+		return true
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	a.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Append records an entry, filling in its sequence number and hash
+func (a *AuditLog) Append(e AuditEntry) error {
+	a.Lock()
+	defer a.Unlock()
+
+	e.Seq = a.seq + 1
+	e.Hash = ""
+	line, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(append([]byte(a.lastHash), line...))
+	e.Hash = hex.EncodeToString(sum[:])
+	if line, err = json.Marshal(e); err != nil {
+		return err
+	}
+	if _, err := a.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	a.seq, a.lastHash = e.Seq, e.Hash
+	return nil
+}
+
+// Close closes the log file
+func (a *AuditLog) Close() error {
+	a.Lock()
+	defer a.Unlock()
+	return a.file.Close()
+}
+
+// scan calls f with every entry, oldest first, until it returns false
+func (a *AuditLog) scan(f func(e *AuditEntry) bool) error {
+	file, err := os.Open(a.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	for scanner.Scan() {
+		var e AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return err
+		}
+		if !f(&e) {
+			break
+		}
+	}
+	return scanner.Err()
+}
+
+// AuditQuery filters entries; zero fields match everything
+type AuditQuery struct {
+	Since, Until time.Time
+	Method       string
+	Path         string
+	Caller       string
+	Failed       bool
+	Limit        int
+}
+
+func (q *AuditQuery) matches(e *AuditEntry) bool {
+	return (q.Since.IsZero() || !e.Time.Before(q.Since)) &&
+		(q.Until.IsZero() || e.Time.Before(q.Until)) &&
+		(q.Method == "" || strings.EqualFold(q.Method, e.Method)) &&
+		(q.Path == "" || strings.HasPrefix(e.Path, q.Path)) &&
+		(q.Caller == "" || q.Caller == e.Caller) &&
+		(!q.Failed || e.Status >= http.StatusBadRequest)
+}
+
+// Query returns the latest entries matching q, newest first
+func (a *AuditLog) Query(q AuditQuery) ([]AuditEntry, error) {
+	a.Lock()
+	defer a.Unlock()
+
+	var matched []AuditEntry
+	err := a.scan(func(e *AuditEntry) bool {
+		if q.matches(e) {
+			matched = append(matched, *e)
+			if len(matched) > q.Limit {
+				matched = matched[1:]
+			}
+		}
+		return true
+	})
+	for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+		matched[i], matched[j] = matched[j], matched[i]
+	}
+	return matched, err
+}
+
+// auditMiddleware records every request with a method that may change
+// state, with the caller as auth identifies them. Requests refused for
+// their credentials are recorded too.
+func auditMiddleware(auth *APIAuth) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if audit == nil || r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body, err := io.ReadAll(io.LimitReader(r.Body, int64(maxRequestBody)+1))
+			if err != nil {
+				respondWithError(w, r, http.StatusBadRequest, "Can't read request body")
+				return
+			}
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.MultiReader(bytes.NewReader(body), r.Body), r.Body}
+			payload := sha256.Sum256(body)
+
+			route := r.URL.Path
+			if current := mux.CurrentRoute(r); current != nil {
+				if template, err := current.GetPathTemplate(); err == nil {
+					route = template
+				}
+			}
+			entry := AuditEntry{
+				Time:        time.Now().UTC(),
+				Method:      r.Method,
+				Route:       route,
+				Path:        r.URL.Path,
+				Caller:      auth.caller(r),
+				PayloadHash: hex.EncodeToString(payload[:]),
+			}
+
+			rec := &auditRecorder{statusRecorder: statusRecorder{ResponseWriter: w, status: http.StatusOK}}
+			next.ServeHTTP(rec, r)
+			entry.Status = rec.status
+			if rec.status >= http.StatusBadRequest {
+				var e ErrorResponse
+				if json.Unmarshal(rec.body.Bytes(), &e) == nil {
+					entry.Error = e.Message
+				}
+			}
+			if err := audit.Append(entry); err != nil {
+				httpLog.Error("Can't write audit log", "err", err)
+			}
+		})
+	}
+}
+
+// auditRecorder keeps the start of error responses to find their message
+type auditRecorder struct {
+	statusRecorder
+	body bytes.Buffer
+}
+
+func (rec *auditRecorder) Write(p []byte) (int, error) {
+	if rec.status >= http.StatusBadRequest && rec.body.Len() < maxAuditErrorBody {
+		rec.body.Write(p[:min(len(p), maxAuditErrorBody-rec.body.Len())])
+	}
+	return rec.ResponseWriter.Write(p)
+}
+
+// caller identifies who made a request: the subject of its JWT or a
+// fingerprint of its API key when they are valid, else its IP address
+func (a *APIAuth) caller(r *http.Request) string {
+	if token := requestToken(r); token != "" && a.enabled() && a.authorized(r) {
+		if parts := strings.Split(token, "."); len(parts) == 3 {
+			var claims struct {
+				Sub string `json:"sub"`
+			}
+			if decodeJWTPart(parts[1], &claims) == nil && claims.Sub != "" {
+				return "jwt:" + claims.Sub
+			}
+		}
+		sum := sha256.Sum256([]byte(token))
+		return "key:" + hex.EncodeToString(sum[:8])
+	}
+	return "ip:" + clientHost(r)
+}
+
+// queries the audit log, newest first: since and until (RFC 3339), method,
+// path (a prefix), caller, failed (only 4xx and 5xx) and limit
+func handleGetAudit(w http.ResponseWriter, r *http.Request) {
+	if audit == nil {
+		respondWithError(w, r, http.StatusNotFound, "ERROR: The audit log is off")
+		return
+	}
+	params := r.URL.Query()
+	q := AuditQuery{
+		Method: params.Get("method"),
+		Path:   params.Get("path"),
+		Caller: params.Get("caller"),
+		Failed: params.Get("failed") == "true",
+		Limit:  defaultAuditResults,
+	}
+	for name, t := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		if v := params.Get(name); v != "" {
+			parsed, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				respondWithRequestError(w, r, invalidRequest(name, "must be an RFC 3339 time"))
+				return
+			}
+			*t = parsed
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAuditResults {
+			respondWithRequestError(w, r, invalidRequest("limit", "must be between 1 and %d", maxAuditResults))
+			return
+		}
+		q.Limit = n
+	}
+
+	entries, err := audit.Query(q)
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, "ERROR: Can't read the audit log")
+		return
+	}
+	if entries == nil {
+		entries = []AuditEntry{}
+	}
+	respondWithJSON(w, r, http.StatusOK, entries)
+}
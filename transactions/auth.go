@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/VOOVOOZEL/go_blockchain/transactions/sdk"
+	"github.com/gorilla/mux"
+)
+
+// APIAuth checks the credentials of API requests: one of the API keys, sent
+// as a bearer token or in X-API-Key, or a JWT signed with HS256 and the
+// shared secret. Without keys and secret, authentication is off.
+type APIAuth struct {
+	// keys are the SHA-256 of the API keys, so comparing them takes the
+	// same time whichever key is tried
+	keys      [][]byte
+	jwtSecret []byte
+	jwtIssuer string
+	// all makes reads need credentials too
+	all bool
+}
+
+var apiAuth = &APIAuth{}
+
+// publicWrites are the routes that take a body but only read. /rpc checks
+// its methods itself, see rpcWriteMethods; GraphQL only has queries.
+var publicWrites = map[string]bool{
+	"POST /balance": true,
+	"POST /graphql": true,
+	"POST /rpc":     true,
+}
+
+// loadAuthConfig reads API_KEYS, a comma separated list, JWT_SECRET and
+// JWT_ISSUER, and API_AUTH: "writes" (the default) to require credentials
+// for routes that change state only, "all" for every route
+func loadAuthConfig() {
+	auth := newAPIAuth(os.Getenv("API_KEYS"), os.Getenv("JWT_SECRET"), os.Getenv("JWT_ISSUER"))
+	switch mode := os.Getenv("API_AUTH"); mode {
+	case "", "writes":
+	case "all":
+		auth.all = true
+	default:
+		log.Fatalf("API_AUTH must be writes or all, got %q", mode)
+	}
+	if !auth.enabled() {
+		log.Println("API authentication is off; set API_KEYS or JWT_SECRET to require credentials for writes, spend the miner address's coins and use RPC write methods")
+	}
+	apiAuth = auth
+}
+
+// newAPIAuth accepts a comma separated list of API keys, and JWTs signed
+// with secret
+func newAPIAuth(keys, secret, issuer string) *APIAuth {
+	auth := &APIAuth{jwtSecret: []byte(secret), jwtIssuer: issuer}
+	for _, key := range splitList(keys) {
+		sum := sha256.Sum256([]byte(key))
+		auth.keys = append(auth.keys, sum[:])
+	}
+	return auth
+}
+
+func (a *APIAuth) enabled() bool {
+	return len(a.keys) > 0 || len(a.jwtSecret) > 0
+}
+
+// authorized reports whether a request carries valid credentials, which it
+// need not when authentication is off
+func (a *APIAuth) authorized(r *http.Request) bool {
+	if !a.enabled() {
+		return true
+	}
+	token := requestToken(r)
+	if token == "" {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(token))
+	for _, key := range a.keys {
+		if subtle.ConstantTimeCompare(sum[:], key) == 1 {
+			return true
+		}
+	}
+	if len(a.jwtSecret) > 0 && strings.Count(token, ".") == 2 {
+		return a.checkJWT(token, time.Now()) == nil
+	}
+	return false
+}
+
+// requestToken returns the bearer token or X-API-Key of a request
+func requestToken(r *http.Request) string {
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return strings.TrimSpace(bearer)
+	}
+	return r.Header.Get("X-API-Key")
+}
+
+// checkJWT verifies an HS256 token's signature and its exp, nbf and iss
+// claims
+func (a *APIAuth) checkJWT(token string, now time.Time) error {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return errors.New("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return err
+	}
+	if header.Alg != "HS256" {
+		return errors.New("token isn't signed with HS256")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, a.jwtSecret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return errors.New("bad token signature")
+	}
+
+	var claims struct {
+		Exp *int64 `json:"exp"`
+		Nbf *int64 `json:"nbf"`
+		Iss string `json:"iss"`
+	}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return err
+	}
+	if claims.Exp == nil || now.Unix() >= *claims.Exp {
+		return errors.New("token expired or without expiry")
+	}
+	if claims.Nbf != nil && now.Unix() < *claims.Nbf {
+		return errors.New("token not valid yet")
+	}
+	if a.jwtIssuer != "" && claims.Iss != a.jwtIssuer {
+		return errors.New("token from another issuer")
+	}
+	return nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// checkNodeFunds refuses unsigned spends from the miner address, whose coins
+// the node holds, unless the request carries API credentials. Without
+// authentication configured any client could spend them, so they are
+// refused outright.
+func checkNodeFunds(tx *Transaction, authorized bool) error {
+	if apiAuth.enabled() && authorized {
+		return nil
+	}
+	miner := minerAddress()
+	for _, in := range tx.Vin {
+		if len(in.Signature) == 0 && in.ScriptSig == miner {
+			return fmt.Errorf("ERROR: Spending from the miner address %s needs API credentials", miner)
+		}
+	}
+	return nil
+}
+
+// signNodeSpend signs the unsigned inputs of a transaction the node built
+// with the miner key. Any other wallet address it can't sign for, so those
+// spends are refused here rather than mined and rejected.
+func signNodeSpend(tx *Transaction) error {
+	unsigned := false
+	for _, in := range tx.Vin {
+		if len(in.Signature) > 0 || !sdk.ValidateAddress(in.ScriptSig) {
+			continue
+		}
+		if minerKey == nil || in.ScriptSig != minerKey.Address() {
+			return fmt.Errorf("ERROR: The node holds no key for %s; sign the transaction and send it to /tx/raw/send", in.ScriptSig)
+		}
+		unsigned = true
+	}
+	if !unsigned {
+		return nil
+	}
+	return tx.sign(minerKey)
+}
+
+// needsAuth tells whether a request must carry credentials, see
+// routeNeedsAuth
+func (a *APIAuth) needsAuth(r *http.Request) bool {
+	path := r.URL.Path
+	if route := mux.CurrentRoute(r); route != nil {
+		if template, err := route.GetPathTemplate(); err == nil {
+			path = template
+		}
+	}
+	return a.routeNeedsAuth(r.Method, unversioned(path))
+}
+
+// routeNeedsAuth tells whether a route must be called with credentials: with
+// API_AUTH at writes, every method but GET and HEAD, except the public
+// writes
+func (a *APIAuth) routeNeedsAuth(method, path string) bool {
+	if a.all {
+		return true
+	}
+	if method == http.MethodGet || method == http.MethodHead {
+		return false
+	}
+	return !publicWrites[method+" "+path]
+}
+
+// authMiddleware refuses requests without the credentials their route needs
+func authMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if apiAuth.needsAuth(r) && !apiAuth.authorized(r) {
+			respondUnauthorized(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func respondUnauthorized(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Bearer realm="go_blockchain"`)
+	respondWithError(w, r, http.StatusUnauthorized, "Missing or invalid API key or token")
+}
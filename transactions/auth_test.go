@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/VOOVOOZEL/go_blockchain/transactions/sdk"
+)
+
+// testJWT signs claims with HS256 and secret
+func testJWT(secret, claims string) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	payload := base64.RawURLEncoding.EncodeToString([]byte(claims))
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(header + "." + payload))
+	return header + "." + payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestAPIAuth(t *testing.T) {
+	now := time.Now().Unix()
+	valid := fmt.Sprintf(`{"exp":%d,"iss":"node"}`, now+3600)
+
+	tests := []struct {
+		name    string
+		header  string
+		value   string
+		wantErr bool
+	}{
+		{name: "no credentials", wantErr: true},
+		{name: "API key as bearer token", header: "Authorization", value: "Bearer key2"},
+		{name: "API key in X-API-Key", header: "X-API-Key", value: "key1"},
+		{name: "unknown API key", header: "X-API-Key", value: "key3", wantErr: true},
+		{name: "JWT", header: "Authorization", value: "Bearer " + testJWT("secret", valid)},
+		{name: "JWT in X-API-Key", header: "X-API-Key", value: testJWT("secret", valid)},
+		{name: "JWT with another secret", header: "Authorization", value: "Bearer " + testJWT("other", valid), wantErr: true},
+		{name: "expired JWT", header: "Authorization", value: "Bearer " + testJWT("secret", fmt.Sprintf(`{"exp":%d,"iss":"node"}`, now-1)), wantErr: true},
+		{name: "JWT without expiry", header: "Authorization", value: "Bearer " + testJWT("secret", `{"iss":"node"}`), wantErr: true},
+		{name: "JWT not valid yet", header: "Authorization", value: "Bearer " + testJWT("secret", fmt.Sprintf(`{"exp":%d,"nbf":%d,"iss":"node"}`, now+3600, now+600)), wantErr: true},
+		{name: "JWT from another issuer", header: "Authorization", value: "Bearer " + testJWT("secret", fmt.Sprintf(`{"exp":%d,"iss":"other"}`, now+3600)), wantErr: true},
+	}
+
+	auth := newAPIAuth("key1, key2", "secret", "node")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", nil)
+			if tt.header != "" {
+				r.Header.Set(tt.header, tt.value)
+			}
+			if got := auth.authorized(r); got == tt.wantErr {
+				t.Fatalf("authorized %v, want %v", got, !tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRouteNeedsAuth(t *testing.T) {
+	tests := []struct {
+		method, path string
+		want         bool
+	}{
+		{"GET", "/", false},
+		{"POST", "/", true},
+		{"POST", "/balance", false},
+		{"POST", "/rpc", false},
+		{"POST", "/tx", true},
+		{"POST", "/lottery/{name}/payout", true},
+	}
+	auth := newAPIAuth("key", "", "")
+	for _, tt := range tests {
+		if got := auth.routeNeedsAuth(tt.method, tt.path); got != tt.want {
+			t.Errorf("%s %s needs auth %v, want %v", tt.method, tt.path, got, tt.want)
+		}
+	}
+	auth.all = true
+	if !auth.routeNeedsAuth("GET", "/") {
+		t.Error("GET / needs no auth with API_AUTH=all")
+	}
+}
+
+func TestNodeFundsNeedCredentials(t *testing.T) {
+	t.Setenv("MINER_ADDRESS", "miner")
+	defer func() { apiAuth = &APIAuth{} }()
+
+	mined := testCoinbase("miner", "m")
+	fromMiner := testSpend(mined, []int{0}, TXOutput{subsidy, "bob"})
+	other := testCoinbase("alice", "a")
+	fromOther := testSpend(other, []int{0}, TXOutput{subsidy, "bob"})
+
+	apiAuth = &APIAuth{}
+	if err := checkNodeFunds(fromMiner, true); err == nil || !strings.Contains(err.Error(), "needs API credentials") {
+		t.Errorf("spend from the miner without authentication: %v", err)
+	}
+	if err := checkNodeFunds(fromOther, true); err != nil {
+		t.Errorf("spend from another address: %v", err)
+	}
+
+	apiAuth = newAPIAuth("key", "", "")
+	if err := checkNodeFunds(fromMiner, false); err == nil {
+		t.Error("spend from the miner without credentials accepted")
+	}
+	if err := checkNodeFunds(fromMiner, true); err != nil {
+		t.Errorf("spend from the miner with credentials: %v", err)
+	}
+}
+
+func TestSignNodeSpend(t *testing.T) {
+	key, err := sdk.NewMasterKey([]byte("miner key test seed, 32 bytes.."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := key.Child(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { minerKey = nil }()
+	minerKey = key
+
+	mined := testCoinbase(key.Address(), "m")
+	tx := testSpend(mined, []int{0}, TXOutput{subsidy, "bob"})
+	if err := signNodeSpend(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Verify(mined.Vout); err != nil {
+		t.Fatalf("signed spend from the miner: %v", err)
+	}
+
+	foreign := testCoinbase(other.Address(), "o")
+	if err := signNodeSpend(testSpend(foreign, []int{0}, TXOutput{subsidy, "bob"})); err == nil || !strings.Contains(err.Error(), "holds no key") {
+		t.Errorf("spend from another wallet address: %v", err)
+	}
+	if err := signNodeSpend(testSpend(testCoinbase("alice", "a"), []int{0}, TXOutput{subsidy, "bob"})); err != nil {
+		t.Errorf("spend from a name address: %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+// coinbaseMaturity is how many confirmations a coinbase needs before its
+// outputs count as mature; younger ones may vanish in a reorg
+const coinbaseMaturity = 100
+
+// BalanceInfo breaks down the balance of an address
+type BalanceInfo struct {
+	Address string
+	// Confirmed sums the unspent outputs of the active chain
+	Confirmed Amount
+	// Unconfirmed is what the mempool adds to Confirmed, negative when it
+	// spends more than it pays the address
+	Unconfirmed Amount
+	// Immature is the part of Confirmed paid by coinbases with fewer than
+	// coinbaseMaturity confirmations
+	Immature  Amount
+	UTXOCount int
+}
+
+// balanceInfo looks the balance of address up in the UTXO set and the
+// mempool
+func (bc *Blockchain) balanceInfo(address string) BalanceInfo {
+	bc.RLock()
+	defer bc.RUnlock()
+
+	young := make(map[string]bool)
+	start := len(bc.blocks) - coinbaseMaturity + 1
+	if start < 0 {
+		start = 0
+	}
+	for _, b := range bc.blocks[start:] {
+		if len(b.Transactions) > 0 && b.Transactions[0].IsCoinbase() {
+			young[b.Transactions[0].ID] = true
+		}
+	}
+
+	info := BalanceInfo{Address: address}
+	for _, e := range bc.utxo.outputs(address) {
+		info.Confirmed += e.Output.Value
+		info.UTXOCount++
+		if young[e.Txid] {
+			info.Immature += e.Output.Value
+		}
+	}
+
+	// pooled transactions may spend each other's outputs
+	view := bc.utxo.view()
+	for _, tx := range bc.mempool.Transactions() {
+		for _, in := range tx.Vin {
+			if prev, ok := view.get(outpoint{in.Txid, in.Vout}); ok && prev.ScriptPubKey == address {
+				info.Unconfirmed -= prev.Value
+			}
+		}
+		for _, out := range tx.Vout {
+			if out.ScriptPubKey == address {
+				info.Unconfirmed += out.Value
+			}
+		}
+		view.connectTransaction(tx)
+	}
+	return info
+}
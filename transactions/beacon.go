@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/VOOVOOZEL/go_blockchain/transactions/sdk"
+	"github.com/gorilla/mux"
+)
+
+// Beacon derives the randomness beacon at height from the active chain
+func (bc *Blockchain) Beacon(height int) (*sdk.Beacon, error) {
+	bc.RLock()
+	tip := len(bc.blocks) - 1
+	if height < 0 || height > tip {
+		bc.RUnlock()
+		return nil, fmt.Errorf("ERROR: no block at height %d", height)
+	}
+	first := height - sdk.BeaconWindow + 1
+	if first < 0 {
+		first = 0
+	}
+	var hashes []string
+	for _, b := range bc.blocks[first : height+1] {
+		hashes = append(hashes, b.Hash)
+	}
+	bc.RUnlock()
+
+	value, err := sdk.BeaconValue(height, hashes)
+	if err != nil {
+		return nil, err
+	}
+	confirmations := tip - height + 1
+	return &sdk.Beacon{
+		Height:        height,
+		Window:        len(hashes),
+		BlockHashes:   hashes,
+		Value:         hex.EncodeToString(value),
+		Confirmations: confirmations,
+		Final:         confirmations >= sdk.BeaconConfirmations,
+	}, nil
+}
+
+// returns the randomness beacon at a height
+func handleGetBeacon(w http.ResponseWriter, r *http.Request) {
+	height, err := strconv.Atoi(mux.Vars(r)["height"])
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, "invalid height")
+		return
+	}
+
+	beacon, err := bc.Beacon(height)
+	if err != nil {
+		respondWithError(w, r, http.StatusNotFound, err.Error())
+		return
+	}
+
+	respondWithJSON(w, r, http.StatusOK, beacon)
+}
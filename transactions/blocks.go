@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// BlockInfo is a block with its position in the chain. Blocks off the active
+// chain have -1 confirmations.
+type BlockInfo struct {
+	*Block
+	Height        int
+	Confirmations int
+}
+
+// lookupBlock looks a known block up, whether on the active chain or not
+func (bc *Blockchain) lookupBlock(hash string) (*BlockInfo, bool) {
+	bc.RLock()
+	defer bc.RUnlock()
+
+	b, ok := bc.known[hash]
+	if !ok {
+		return nil, false
+	}
+	info := &BlockInfo{Block: b, Height: bc.blockHeight(b), Confirmations: -1}
+	if info.Height < len(bc.blocks) && bc.blocks[info.Height].Hash == hash {
+		info.Confirmations = len(bc.blocks) - info.Height
+	}
+	return info, true
+}
+
+// lookupHeight looks a block of the active chain up by height
+func (bc *Blockchain) lookupHeight(height int) (*BlockInfo, bool) {
+	bc.RLock()
+	defer bc.RUnlock()
+
+	if height < 0 || height >= len(bc.blocks) {
+		return nil, false
+	}
+	return &BlockInfo{bc.blocks[height], height, len(bc.blocks) - height}, true
+}
+
+// looks a block up by its hash
+func handleGetBlock(w http.ResponseWriter, r *http.Request) {
+	info, ok := bc.lookupBlock(mux.Vars(r)["hash"])
+	if !ok {
+		respondWithError(w, r, http.StatusNotFound, "Block not found")
+		return
+	}
+	respondWithJSON(w, r, http.StatusOK, info)
+}
+
+// looks a block of the active chain up by its height
+func handleGetBlockAtHeight(w http.ResponseWriter, r *http.Request) {
+	height, err := strconv.Atoi(mux.Vars(r)["height"])
+	if err != nil || height < 0 {
+		respondWithError(w, r, http.StatusBadRequest, "Invalid height")
+		return
+	}
+	info, ok := bc.lookupHeight(height)
+	if !ok {
+		respondWithError(w, r, http.StatusNotFound, "Block not found")
+		return
+	}
+	respondWithJSON(w, r, http.StatusOK, info)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// verifyBatchSize is how many stored blocks are verified together on startup
+const verifyBatchSize = 512
+
+// blockCheck is the outcome of verifying a block ahead of processing it
+type blockCheck struct {
+	// err is the first rule the block breaks
+	err error
+	// verified is set once the header, the transaction IDs and every
+	// signature checked out, so processing can skip them
+	verified bool
+}
+
+// verifyBlocks checks what a block's validity doesn't owe to the chain
+// state, its limits, hash, proof of work, transaction IDs and signatures,
+// for a run of blocks in parallel. The blocks are then processed in order,
+// skipping the checks already done. Inputs whose outputs can't be found
+// yet, e.g. because they are on a side branch, leave their block unverified.
+func (bc *Blockchain) verifyBlocks(blocks []*Block) []blockCheck {
+	outputs := bc.prevOutputs(blocks)
+	checks := make([]blockCheck, len(blocks))
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(runtime.GOMAXPROCS(0), len(blocks)); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				checks[i] = verifyBlock(blocks[i], outputs)
+			}
+		}()
+	}
+	for i := range blocks {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return checks
+}
+
+// verifyBlock checks a block against the outputs its inputs spend
+func verifyBlock(b *Block, outputs map[outpoint]TXOutput) blockCheck {
+	if err := checkTransactionIDs(b); err != nil {
+		return blockCheck{err: err}
+	}
+	if err := checkBlockLimits(b); err != nil {
+		return blockCheck{err: err}
+	}
+	// a genesis block is only ever known already, processing tells
+	if b.PrevHash != "" {
+		if b.Hash == "" || calculateHash(b) != b.Hash {
+			return blockCheck{err: errors.New("ERROR: Block hash doesn't match its header")}
+		}
+		if !isHashValid(b.Hash, difficulty) {
+			return blockCheck{err: fmt.Errorf("ERROR: Block hash doesn't meet difficulty %d", difficulty)}
+		}
+	}
+
+	complete := true
+Transactions:
+	for _, tx := range b.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+		prevOuts := make([]TXOutput, 0, len(tx.Vin))
+		for _, in := range tx.Vin {
+			out, ok := outputs[outpoint{in.Txid, in.Vout}]
+			if !ok {
+				complete = false
+				continue Transactions
+			}
+			prevOuts = append(prevOuts, out)
+		}
+		if err := tx.Verify(prevOuts); err != nil {
+			return blockCheck{err: err}
+		}
+	}
+	return blockCheck{verified: complete}
+}
+
+// prevOutputs looks up the outputs spent by the inputs of blocks, in the
+// UTXO set, on the active chain or in an earlier block of the run. An
+// output is the same on every branch, its transaction ID covers it.
+func (bc *Blockchain) prevOutputs(blocks []*Block) map[outpoint]TXOutput {
+	bc.RLock()
+	defer bc.RUnlock()
+
+	outputs := make(map[outpoint]TXOutput)
+	for _, b := range blocks {
+		for _, tx := range b.Transactions {
+			if !tx.IsCoinbase() {
+				for _, in := range tx.Vin {
+					op := outpoint{in.Txid, in.Vout}
+					if _, ok := outputs[op]; ok {
+						continue
+					}
+					if out, ok := bc.utxo.UTXOSet[op]; ok {
+						outputs[op] = out
+					} else if out, ok := bc.chainOutput(op); ok {
+						outputs[op] = out
+					}
+				}
+			}
+			for i, out := range tx.Vout {
+				outputs[outpoint{tx.ID, i}] = out
+			}
+		}
+	}
+	return outputs
+}
+
+// chainOutput finds an output, spent or not, of an active chain transaction
+func (bc *Blockchain) chainOutput(op outpoint) (TXOutput, bool) {
+	b := bc.known[bc.txIndex[op.Txid]]
+	if b == nil {
+		return TXOutput{}, false
+	}
+	for _, tx := range b.Transactions {
+		if tx.ID == op.Txid && op.Vout >= 0 && op.Vout < len(tx.Vout) {
+			return tx.Vout[op.Vout], true
+		}
+	}
+	return TXOutput{}, false
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+)
+
+const (
+	// maxBloomFilterSize caps the bytes of a bloom filter a peer may load
+	maxBloomFilterSize = 36000
+	// maxBloomHashes caps the hash functions of a bloom filter
+	maxBloomHashes = 50
+	// lightFilterFalsePositives is the share of other addresses the light
+	// client's filter matches, hiding the watched ones among them
+	lightFilterFalsePositives = 0.001
+)
+
+// addressFilter decides which transactions a filtered peer or subscriber
+// is sent, by the addresses they pay or spend from
+type addressFilter interface {
+	matches(address string) bool
+}
+
+// addressSet matches exactly the addresses it holds
+type addressSet map[string]bool
+
+func (s addressSet) matches(address string) bool {
+	return s[address]
+}
+
+// BloomFilter is a probabilistic set of addresses. It matches every address
+// added to it and a share of the others, so a light client can load it
+// into a full peer without telling which addresses it watches.
+type BloomFilter struct {
+	Bits []byte
+	// Hashes is how many bits each address sets
+	Hashes uint32
+	// Tweak seeds the hash functions, so filters of the same addresses
+	// differ
+	Tweak uint32
+}
+
+// newBloomFilter sizes a filter for elements addresses to match others
+// with the false positive rate fp
+func newBloomFilter(elements int, fp float64, tweak uint32) *BloomFilter {
+	if elements < 1 {
+		elements = 1
+	}
+	bits := -float64(elements) * math.Log(fp) / (math.Ln2 * math.Ln2)
+	size := min(max(int(math.Ceil(bits/8)), 1), maxBloomFilterSize)
+	hashes := uint32(float64(size*8) / float64(elements) * math.Ln2)
+	return &BloomFilter{
+		Bits:   make([]byte, size),
+		Hashes: min(max(hashes, 1), maxBloomHashes),
+		Tweak:  tweak,
+	}
+}
+
+// check rejects filters beyond the limits
+func (f *BloomFilter) check() error {
+	if len(f.Bits) == 0 || len(f.Bits) > maxBloomFilterSize {
+		return fmt.Errorf("bloom filter has %d bytes, not 1 to %d", len(f.Bits), maxBloomFilterSize)
+	}
+	if f.Hashes == 0 || f.Hashes > maxBloomHashes {
+		return fmt.Errorf("bloom filter has %d hash functions, not 1 to %d", f.Hashes, maxBloomHashes)
+	}
+	return nil
+}
+
+// positions calls visit with the bit of each hash function for address.
+// The functions are h1 + i*h2, the halves of the 64-bit FNV-1a hash of the
+// tweak and the address.
+func (f *BloomFilter) positions(address string, visit func(bit uint32) bool) bool {
+	h := fnv.New64a()
+	var tweak [4]byte
+	binary.BigEndian.PutUint32(tweak[:], f.Tweak)
+	h.Write(tweak[:])
+	h.Write([]byte(address))
+	sum := h.Sum64()
+	h1, h2 := uint32(sum), uint32(sum>>32)|1
+
+	n := uint32(len(f.Bits)) * 8
+	for i := uint32(0); i < f.Hashes; i++ {
+		if !visit((h1 + i*h2) % n) {
+			return false
+		}
+	}
+	return true
+}
+
+// Add puts address in the filter
+func (f *BloomFilter) Add(address string) {
+	f.positions(address, func(bit uint32) bool {
+		f.Bits[bit/8] |= 1 << (bit % 8)
+		return true
+	})
+}
+
+func (f *BloomFilter) matches(address string) bool {
+	if len(f.Bits) == 0 {
+		return false
+	}
+	return f.positions(address, func(bit uint32) bool {
+		return f.Bits[bit/8]&(1<<(bit%8)) != 0
+	})
+}
+
+// filter returns the address filter a message describes, nil for an empty
+// one
+func (m *FilterLoadMessage) filter() (addressFilter, error) {
+	if m.Bloom != nil {
+		if len(m.Addresses) > 0 {
+			return nil, errors.New("filter has both addresses and a bloom filter")
+		}
+		if err := m.Bloom.check(); err != nil {
+			return nil, err
+		}
+		return m.Bloom, nil
+	}
+	if len(m.Addresses) > maxFilterAddresses {
+		return nil, fmt.Errorf("filter has %d addresses, more than %d", len(m.Addresses), maxFilterAddresses)
+	}
+	if len(m.Addresses) == 0 {
+		return nil, nil
+	}
+	set := make(addressSet)
+	for _, address := range m.Addresses {
+		set[address] = true
+	}
+	return set, nil
+}
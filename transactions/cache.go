@@ -0,0 +1,212 @@
+package main
+
+import (
+	"container/list"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+// defaultCacheMB is the default memory budget of the chain cache
+const defaultCacheMB = 64
+
+// ChainCache is an LRU of decoded blocks and transactions serving explorer
+// reads. It is bounded by an estimate of the memory its entries use; evicted
+// entries are decoded from the block store again when requested.
+type ChainCache struct {
+	sync.Mutex
+	budget int
+	used   int
+	lru    *list.List
+	items  map[string]*list.Element
+
+	hits, misses, evictions uint64
+}
+
+type cacheEntry struct {
+	key   string
+	value interface{}
+	size  int
+}
+
+// CacheStats reports the cache's usage and effectiveness
+type CacheStats struct {
+	BudgetBytes int
+	UsedBytes   int
+	Entries     int
+	Hits        uint64
+	Misses      uint64
+	Evictions   uint64
+}
+
+var chainCache = NewChainCache(defaultCacheMB << 20)
+
+// NewChainCache returns a cache holding at most budget bytes of entries
+func NewChainCache(budget int) *ChainCache {
+	return &ChainCache{budget: budget, lru: list.New(), items: make(map[string]*list.Element)}
+}
+
+// loadCacheConfig reads CACHE_MB
+func loadCacheConfig() {
+	if v := os.Getenv("CACHE_MB"); v != "" {
+		mb, err := strconv.Atoi(v)
+		if err != nil || mb < 0 {
+			log.Fatalf("CACHE_MB must be a non-negative integer, got %q", v)
+		}
+		chainCache = NewChainCache(mb << 20)
+	}
+}
+
+func (c *ChainCache) get(key string) (interface{}, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.hits++
+		c.lru.MoveToFront(el)
+		return el.Value.(*cacheEntry).value, true
+	}
+	c.misses++
+	return nil, false
+}
+
+func (c *ChainCache) remove(key string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.lru.Remove(el)
+		delete(c.items, key)
+		c.used -= el.Value.(*cacheEntry).size
+	}
+}
+
+// watchReorgs forgets the cached transactions of blocks a reorganization
+// took off the active chain, which the transaction index no longer has
+func (c *ChainCache) watchReorgs() {
+	chainEvents.Handle("Chain cache", func(e ChainEvent) {
+		reorg, _ := e.Reorg()
+		bc.RLock()
+		defer bc.RUnlock()
+		for _, hash := range reorg.Disconnected {
+			if b, ok := bc.known[hash]; ok {
+				for _, tx := range b.Transactions {
+					c.remove("tx:" + tx.ID)
+				}
+			}
+		}
+	}, Reorg)
+}
+
+func (c *ChainCache) add(key string, value interface{}, size int) {
+	c.Lock()
+	defer c.Unlock()
+
+	if size > c.budget {
+		return
+	}
+	if el, ok := c.items[key]; ok {
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.lru.PushFront(&cacheEntry{key, value, size})
+	c.used += size
+	for c.used > c.budget {
+		oldest := c.lru.Back()
+		entry := oldest.Value.(*cacheEntry)
+		c.lru.Remove(oldest)
+		delete(c.items, entry.key)
+		c.used -= entry.size
+		c.evictions++
+	}
+}
+
+// Stats returns a snapshot of the cache statistics
+func (c *ChainCache) Stats() CacheStats {
+	c.Lock()
+	defer c.Unlock()
+	return CacheStats{c.budget, c.used, c.lru.Len(), c.hits, c.misses, c.evictions}
+}
+
+// GetBlock returns a stored block, from the cache if possible. Callers must
+// treat the block as read-only since it is shared with other readers.
+func (bc *Blockchain) GetBlock(hash string) (*Block, error) {
+	if b, ok := chainCache.get("block:" + hash); ok {
+		return b.(*Block), nil
+	}
+
+	b, size, err := bc.store.Get(hash)
+	if err != nil {
+		return nil, errors.New("ERROR: Block not found")
+	}
+	chainCache.add("block:"+hash, b, size)
+	return b, nil
+}
+
+// TransactionInfo is a transaction together with the block confirming it
+type TransactionInfo struct {
+	Transaction *Transaction
+	BlockHash   string
+}
+
+// GetTransaction returns a transaction of the active chain, from the cache
+// if possible
+func (bc *Blockchain) GetTransaction(txid string) (*TransactionInfo, error) {
+	if info, ok := chainCache.get("tx:" + txid); ok {
+		return info.(*TransactionInfo), nil
+	}
+
+	bc.RLock()
+	blockHash, ok := bc.txIndex[txid]
+	bc.RUnlock()
+	if !ok {
+		return nil, errors.New("ERROR: Transaction not found")
+	}
+
+	b, err := bc.GetBlock(blockHash)
+	if err != nil {
+		return nil, err
+	}
+	for _, tx := range b.Transactions {
+		if tx.ID == txid {
+			info := &TransactionInfo{tx, blockHash}
+			// the transaction is shared with its cached block, count its ID only
+			chainCache.add("tx:"+txid, info, len(txid)+len(blockHash))
+			return info, nil
+		}
+	}
+	return nil, errors.New("ERROR: Transaction not found")
+}
+
+// lookupTransaction finds a confirmed or pooled transaction; pooled ones have
+// no BlockHash
+func lookupTransaction(txid string) (*TransactionInfo, bool) {
+	if info, err := bc.GetTransaction(txid); err == nil {
+		return info, true
+	}
+	if tx, ok := bc.mempool.Get(txid); ok {
+		return &TransactionInfo{Transaction: tx}, true
+	}
+	return nil, false
+}
+
+// looks a confirmed transaction up by its ID
+func handleGetTransaction(w http.ResponseWriter, r *http.Request) {
+	info, err := bc.GetTransaction(mux.Vars(r)["txid"])
+	if err != nil {
+		respondWithError(w, r, http.StatusNotFound, err.Error())
+		return
+	}
+	respondWithJSON(w, r, http.StatusOK, info)
+}
+
+// reports chain cache statistics
+func handleGetCacheStats(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, r, http.StatusOK, chainCache.Stats())
+}
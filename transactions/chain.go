@@ -0,0 +1,690 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+var (
+	errBlockKnown  = errors.New("ERROR: Block already known")
+	errOrphanBlock = errors.New("ERROR: Previous block not found")
+)
+
+// blockWork returns the expected number of hashes needed to mine a block
+func blockWork(b *Block) *big.Int {
+	return workForDifficulty(difficulty)
+}
+
+// workForDifficulty returns the expected number of hashes needed to find a
+// hash with the given number of leading zero hex digits
+func workForDifficulty(d int) *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(4*d))
+}
+
+// chainWork sums the work of a series of blocks
+func chainWork(blocks []*Block) *big.Int {
+	work := new(big.Int)
+	for _, b := range blocks {
+		work.Add(work, blockWork(b))
+	}
+	return work
+}
+
+// ProcessBlock validates a block against its parent and stores it. A block
+// extending the active tip is connected directly; a block on a side branch is
+// kept, and if its branch now has more work than the active chain the node
+// reorganizes onto it.
+func (bc *Blockchain) ProcessBlock(b *Block) error {
+	return bc.processBlock(b, false)
+}
+
+// processBlock is ProcessBlock for a block verifyBlocks may have verified
+// already
+func (bc *Blockchain) processBlock(b *Block, verified bool) error {
+	bc.Lock()
+	defer bc.Unlock()
+
+	if _, ok := bc.known[b.Hash]; ok {
+		return errBlockKnown
+	}
+	parent, ok := bc.known[b.PrevHash]
+	if !ok {
+		return errOrphanBlock
+	}
+	if bc.isInvalid(parent) {
+		return errors.New("ERROR: Block descends from an invalid block")
+	}
+	if !verified {
+		if !isBlockValid(b, parent) {
+			return errors.New("ERROR: Block is not valid")
+		}
+		if err := checkTransactionIDs(b); err != nil {
+			return err
+		}
+		if err := checkBlockLimits(b); err != nil {
+			return err
+		}
+	}
+	if err := bc.checkCheckpoints(b, parent); err != nil {
+		return err
+	}
+
+	tip := bc.blocks[len(bc.blocks)-1]
+	if b.PrevHash == tip.Hash {
+		view := bc.utxo.view()
+		err := checkUniqueTransactions(b, func(txid string) bool {
+			_, ok := bc.txIndex[txid]
+			return ok
+		})
+		undo, connectErr := view.connectBlock(b, bc.blocks, verified)
+		if err == nil {
+			err = connectErr
+		}
+		if err != nil {
+			bc.invalid[b.Hash] = true
+			bc.headers.setInvalid(bc.invalid)
+			return err
+		}
+		if err := bc.store.Put(b); err != nil {
+			return err
+		}
+		view.commit()
+		bc.utxo.flush(b.Hash)
+		bc.addKnown(b)
+		bc.undo[b.Hash] = undo
+		bc.index[b.Hash] = len(bc.blocks)
+		bc.blocks = append(bc.blocks, b)
+		bc.indexTransactions(b)
+		bc.mempool.removeBlockTxs(b)
+		bc.headers.addBlock(b)
+		chainEvents.publish(BlockConnected, b)
+		return nil
+	}
+
+	if err := bc.store.Put(b); err != nil {
+		return err
+	}
+	bc.addKnown(b)
+	bc.headers.addBlock(b)
+	forkHeight, branch := bc.findFork(b)
+	if chainWork(branch).Cmp(chainWork(bc.blocks[forkHeight+1:])) <= 0 {
+		storageLog.Info("Block stored on a side branch", "hash", b.Hash, "fork_height", forkHeight)
+		chainEvents.publish(ForkBlock, ForkEvent{b.Hash, bc.blocks[forkHeight].Hash, forkHeight, len(branch)})
+		return nil
+	}
+
+	return bc.reorganize(forkHeight, branch)
+}
+
+// findFork walks back from b to the active chain and returns the height of
+// the fork point together with the side branch blocks, oldest first
+func (bc *Blockchain) findFork(b *Block) (int, []*Block) {
+	var branch []*Block
+
+	for {
+		if height := bc.heightOf(b.Hash); height >= 0 {
+			for i, j := 0, len(branch)-1; i < j; i, j = i+1, j-1 {
+				branch[i], branch[j] = branch[j], branch[i]
+			}
+			return height, branch
+		}
+		branch = append(branch, b)
+		b = bc.known[b.PrevHash]
+	}
+}
+
+// Tip returns the last block of the active chain
+func (bc *Blockchain) Tip() *Block {
+	bc.RLock()
+	defer bc.RUnlock()
+	return bc.blocks[len(bc.blocks)-1]
+}
+
+// Height returns the height of the active chain's tip
+func (bc *Blockchain) Height() int {
+	bc.RLock()
+	defer bc.RUnlock()
+	return len(bc.blocks) - 1
+}
+
+// Blocks returns a copy of the active chain blocks from height from up to,
+// but not including, height to. Heights outside the chain are left out.
+func (bc *Blockchain) Blocks(from, to int) []*Block {
+	bc.RLock()
+	defer bc.RUnlock()
+	if from < 0 {
+		from = 0
+	}
+	if to > len(bc.blocks) {
+		to = len(bc.blocks)
+	}
+	if from >= to {
+		return []*Block{}
+	}
+	return append([]*Block{}, bc.blocks[from:to]...)
+}
+
+// heightOf returns the height of a block on the active chain, or -1
+func (bc *Blockchain) heightOf(hash string) int {
+	if height, ok := bc.index[hash]; ok {
+		return height
+	}
+	return -1
+}
+
+// addKnown records a validated block whose parent is known
+func (bc *Blockchain) addKnown(b *Block) {
+	bc.known[b.Hash] = b
+	bc.heights[b.Hash] = bc.heights[b.PrevHash] + 1
+}
+
+// reorganize disconnects the active chain back to forkHeight and connects
+// branch in its place. UTXO changes are staged and only committed once every
+// block of the branch has connected, so a failing branch leaves the active
+// chain untouched.
+func (bc *Blockchain) reorganize(forkHeight int, branch []*Block) error {
+	view := bc.utxo.view()
+
+	var disconnected []*Block
+	for i := len(bc.blocks) - 1; i > forkHeight; i-- {
+		b := bc.blocks[i]
+		view.disconnectBlock(b, bc.undo[b.Hash])
+		disconnected = append(disconnected, b)
+	}
+
+	undos := make(map[string][]spentOutput)
+	chain := append([]*Block{}, bc.blocks[:forkHeight+1]...)
+	// transactions stay confirmed below the fork and in the branch so far
+	branchTxs := make(map[string]bool)
+	confirmed := func(txid string) bool {
+		if hash, ok := bc.txIndex[txid]; ok {
+			if height := bc.heightOf(hash); height >= 0 && height <= forkHeight {
+				return true
+			}
+		}
+		return branchTxs[txid]
+	}
+	for _, b := range branch {
+		err := checkUniqueTransactions(b, confirmed)
+		// side branch blocks were stored without their transactions checked
+		undo, connectErr := view.connectBlock(b, chain, false)
+		if err == nil {
+			err = connectErr
+		}
+		if err != nil {
+			bc.invalid[b.Hash] = true
+			bc.headers.setInvalid(bc.invalid)
+			return fmt.Errorf("ERROR: Reorganization aborted at block %s: %v", b.Hash, err)
+		}
+		undos[b.Hash] = undo
+		chain = append(chain, b)
+		for _, tx := range b.Transactions {
+			branchTxs[tx.ID] = true
+		}
+	}
+
+	view.commit()
+	for hash, undo := range undos {
+		bc.undo[hash] = undo
+	}
+	bc.blocks = append(bc.blocks[:forkHeight+1:forkHeight+1], branch...)
+	bc.utxo.flush(bc.blocks[len(bc.blocks)-1].Hash)
+	for _, b := range disconnected {
+		delete(bc.index, b.Hash)
+	}
+	for i, b := range branch {
+		bc.index[b.Hash] = forkHeight + 1 + i
+	}
+	for _, b := range disconnected {
+		for _, tx := range b.Transactions {
+			delete(bc.txIndex, tx.ID)
+		}
+	}
+	for _, b := range branch {
+		bc.indexTransactions(b)
+	}
+
+	for i := len(disconnected) - 1; i >= 0; i-- {
+		for _, tx := range disconnected[i].Transactions {
+			if !tx.IsCoinbase() {
+				bc.mempool.Add(tx)
+			}
+		}
+	}
+	for _, b := range branch {
+		bc.mempool.removeBlockTxs(b)
+	}
+	bc.mempool.prune(bc.utxo.UTXOSet)
+
+	storageLog.Warn("Reorganized", "disconnected", len(disconnected), "connected", len(branch), "fork_height", forkHeight)
+
+	reorg := ReorgEvent{ForkHeight: forkHeight}
+	for _, b := range disconnected {
+		reorg.Disconnected = append(reorg.Disconnected, b.Hash)
+	}
+	for _, b := range branch {
+		reorg.Connected = append(reorg.Connected, b.Hash)
+	}
+	chainEvents.publish(Reorg, reorg)
+	for _, b := range branch {
+		chainEvents.publish(BlockConnected, b)
+	}
+	return nil
+}
+
+// blockHeight returns the height of a block, which may be on a side branch.
+// Blocks not known yet must have a known parent.
+func (bc *Blockchain) blockHeight(b *Block) int {
+	if height, ok := bc.heights[b.Hash]; ok {
+		return height
+	}
+	return bc.heights[b.PrevHash] + 1
+}
+
+// checkCheckpoints rejects blocks that conflict with the trusted checkpoints
+func (bc *Blockchain) checkCheckpoints(b, parent *Block) error {
+	height := bc.blockHeight(parent) + 1
+
+	if hash, ok := params.checkpoint(height); ok && hash != b.Hash {
+		return fmt.Errorf("ERROR: Block at height %d doesn't match checkpoint %s", height, hash)
+	}
+	if cp := params.lastCheckpoint(len(bc.blocks) - 1); cp >= 0 && height <= cp {
+		return fmt.Errorf("ERROR: Block at height %d forks below checkpoint %d", height, cp)
+	}
+	return nil
+}
+
+// isInvalid reports whether b or one of its ancestors is marked invalid
+func (bc *Blockchain) isInvalid(b *Block) bool {
+	for ; b != nil; b = bc.known[b.PrevHash] {
+		if bc.invalid[b.Hash] {
+			return true
+		}
+	}
+	return false
+}
+
+// chainWorkTo sums the work from genesis up to and including b
+func (bc *Blockchain) chainWorkTo(b *Block) *big.Int {
+	work := new(big.Int)
+	for ; b != nil; b = bc.known[b.PrevHash] {
+		work.Add(work, blockWork(b))
+	}
+	return work
+}
+
+// bestTip returns the block with the most chain work among the known blocks
+// that have no invalid ancestor and no valid children. The active tip wins
+// ties.
+func (bc *Blockchain) bestTip() *Block {
+	hasChildren := make(map[string]bool)
+	for _, b := range bc.known {
+		if !bc.isInvalid(b) {
+			hasChildren[b.PrevHash] = true
+		}
+	}
+
+	// start from the highest valid block of the active chain so it wins ties
+	best := bc.blocks[len(bc.blocks)-1]
+	for i := len(bc.blocks) - 1; bc.isInvalid(best); i-- {
+		best = bc.blocks[i-1]
+	}
+	bestWork := bc.chainWorkTo(best)
+	for hash, b := range bc.known {
+		if hasChildren[hash] || bc.isInvalid(b) {
+			continue
+		}
+		if work := bc.chainWorkTo(b); work.Cmp(bestWork) > 0 {
+			best, bestWork = b, work
+		}
+	}
+	return best
+}
+
+// activateBestChain reorganizes onto the best valid tip. A branch that fails
+// to connect gets its failing block marked invalid and the next best tip is
+// tried.
+func (bc *Blockchain) activateBestChain() error {
+	for {
+		tip := bc.bestTip()
+		if tip.Hash == bc.blocks[len(bc.blocks)-1].Hash {
+			return nil
+		}
+
+		forkHeight, branch := bc.findFork(tip)
+		if err := bc.reorganize(forkHeight, branch); err != nil {
+			storageLog.Error("Reorganization failed", "err", err)
+		}
+	}
+}
+
+// InvalidateBlock marks a block as invalid. If it is on the active chain the
+// node disconnects it and its descendants and moves to the best remaining
+// branch. Checkpointed blocks, and active blocks at or below the last
+// checkpoint the chain passed, stay valid: no fork below it is accepted.
+func (bc *Blockchain) InvalidateBlock(hash string) error {
+	bc.Lock()
+	defer bc.Unlock()
+
+	if _, ok := bc.known[hash]; !ok {
+		return errors.New("ERROR: Block not found")
+	}
+	if hash == bc.blocks[0].Hash {
+		return errors.New("ERROR: The genesis block can't be invalidated")
+	}
+	for _, cp := range params.Checkpoints {
+		if cp.Hash == hash {
+			return errors.New("ERROR: Checkpointed blocks can't be invalidated")
+		}
+	}
+	if cp := params.lastCheckpoint(len(bc.blocks) - 1); cp >= 0 {
+		if height := bc.heightOf(hash); height >= 0 && height <= cp {
+			return fmt.Errorf("ERROR: Blocks at or below checkpoint %d can't be invalidated", cp)
+		}
+	}
+
+	bc.invalid[hash] = true
+	bc.headers.setInvalid(bc.invalid)
+	return bc.activateBestChain()
+}
+
+// ReconsiderBlock removes the invalid mark from a block, its ancestors and
+// its descendants, and moves to the best chain again
+func (bc *Blockchain) ReconsiderBlock(hash string) error {
+	bc.Lock()
+	defer bc.Unlock()
+
+	b, ok := bc.known[hash]
+	if !ok {
+		return errors.New("ERROR: Block not found")
+	}
+
+	for a := b; a != nil; a = bc.known[a.PrevHash] {
+		delete(bc.invalid, a.Hash)
+	}
+	for h, d := range bc.known {
+		for a := d; a != nil; a = bc.known[a.PrevHash] {
+			if a.Hash == hash {
+				delete(bc.invalid, h)
+				break
+			}
+		}
+	}
+	bc.headers.setInvalid(bc.invalid)
+
+	return bc.activateBestChain()
+}
+
+// ReplaceChain validates a complete candidate chain and, if it has more
+// work than the active chain, switches to it. The candidate must start at
+// our genesis block and hold no block marked invalid; every block is checked
+// for its link, proof of work, checkpoints and transactions, the latter by
+// replaying the whole chain on a fresh UTXO set. The switch is a single
+// reorganization, so either the candidate is adopted entirely or the active
+// chain is left untouched.
+func (bc *Blockchain) ReplaceChain(candidate []*Block) error {
+	bc.Lock()
+	defer bc.Unlock()
+
+	if len(candidate) == 0 || candidate[0].Hash != bc.blocks[0].Hash {
+		return errors.New("ERROR: Candidate chain doesn't start at our genesis block")
+	}
+	if chainWork(candidate).Cmp(chainWork(bc.blocks)) <= 0 {
+		return errors.New("ERROR: Candidate chain doesn't have more work than the active chain")
+	}
+
+	view := newUTXOView(make(UTXOSet))
+	confirmed := make(map[string]bool)
+	for height, b := range candidate {
+		if bc.invalid[b.Hash] {
+			return fmt.Errorf("ERROR: Block %d of the candidate chain is marked invalid", height)
+		}
+		if err := checkTransactionIDs(b); err != nil {
+			return err
+		}
+		if err := checkUniqueTransactions(b, func(txid string) bool { return confirmed[txid] }); err != nil {
+			return fmt.Errorf("ERROR: Block %d of the candidate chain: %v", height, err)
+		}
+		for _, tx := range b.Transactions {
+			confirmed[tx.ID] = true
+		}
+		if height == 0 {
+			// the hash matched ours, the transactions must match the hash
+			if calculateHash(b) != b.Hash {
+				return errors.New("ERROR: Candidate genesis block doesn't match its hash")
+			}
+			if _, err := view.connectBlock(b, nil, true); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := checkBlockLimits(b); err != nil {
+			return fmt.Errorf("ERROR: Block %d of the candidate chain: %v", height, err)
+		}
+		if !isBlockValid(b, candidate[height-1]) {
+			return fmt.Errorf("ERROR: Block %d of the candidate chain is not valid", height)
+		}
+		if hash, ok := params.checkpoint(height); ok && hash != b.Hash {
+			return fmt.Errorf("ERROR: Block at height %d doesn't match checkpoint %s", height, hash)
+		}
+		if _, err := view.connectBlock(b, candidate[:height], false); err != nil {
+			return fmt.Errorf("ERROR: Block %d of the candidate chain: %v", height, err)
+		}
+	}
+
+	forkHeight := 0
+	for forkHeight+1 < len(candidate) && forkHeight+1 < len(bc.blocks) &&
+		candidate[forkHeight+1].Hash == bc.blocks[forkHeight+1].Hash {
+		forkHeight++
+	}
+	if cp := params.lastCheckpoint(len(bc.blocks) - 1); forkHeight < cp {
+		return fmt.Errorf("ERROR: Candidate chain forks below checkpoint %d", cp)
+	}
+
+	branch := candidate[forkHeight+1:]
+	for _, b := range branch {
+		if err := bc.store.Put(b); err != nil {
+			return err
+		}
+		bc.addKnown(b)
+		bc.headers.addBlock(b)
+	}
+	bc.headers.setInvalid(bc.invalid)
+	return bc.reorganize(forkHeight, branch)
+}
+
+// checkUniqueTransactions rejects a block repeating a transaction ID, either
+// within itself or one confirmed reports already on the chain below it
+func checkUniqueTransactions(b *Block, confirmed func(txid string) bool) error {
+	seen := make(map[string]bool, len(b.Transactions))
+	for _, tx := range b.Transactions {
+		if seen[tx.ID] || confirmed(tx.ID) {
+			return fmt.Errorf("ERROR: Transaction %s is already confirmed", tx.ID)
+		}
+		seen[tx.ID] = true
+	}
+	return nil
+}
+
+// indexTransactions records b as the block confirming its transactions
+func (bc *Blockchain) indexTransactions(b *Block) {
+	for _, tx := range b.Transactions {
+		bc.txIndex[tx.ID] = b.Hash
+	}
+}
+
+// loadBlocks replays the stored blocks descending from genesis, parents
+// before children, rebuilding side branches and the active chain
+func (bc *Blockchain) loadBlocks() error {
+	hashes, err := bc.store.Hashes()
+	if err != nil {
+		return err
+	}
+
+	children := make(map[string][]*Block)
+	for _, hash := range hashes {
+		if hash == bc.blocks[0].Hash {
+			continue
+		}
+		b, _, err := bc.store.Get(hash)
+		if err != nil {
+			return err
+		}
+		children[b.PrevHash] = append(children[b.PrevHash], b)
+	}
+
+	var ordered []*Block
+	queue := []string{bc.blocks[0].Hash}
+	for len(queue) > 0 {
+		hash := queue[0]
+		queue = queue[1:]
+		for _, b := range children[hash] {
+			ordered = append(ordered, b)
+			queue = append(queue, b.Hash)
+		}
+	}
+
+	// blocks are verified a batch at a time in parallel, then processed in
+	// order; the descendants of a skipped block are skipped too
+	skipped := make(map[string]bool)
+	for start := 0; start < len(ordered); start += verifyBatchSize {
+		batch := ordered[start:min(start+verifyBatchSize, len(ordered))]
+		for i, check := range bc.verifyBlocks(batch) {
+			b := batch[i]
+			if skipped[b.PrevHash] {
+				skipped[b.Hash] = true
+				continue
+			}
+			err := check.err
+			if err == nil {
+				err = bc.processBlock(b, check.verified)
+			}
+			if err != nil && err != errBlockKnown {
+				storageLog.Warn("Skipping stored block", "hash", b.Hash, "err", err)
+				skipped[b.Hash] = true
+			}
+		}
+	}
+
+	storageLog.Info("Loaded stored blocks", "blocks", len(hashes), "height", len(bc.blocks)-1)
+	return nil
+}
+
+// locator returns active chain hashes from the tip back to genesis, dense
+// near the tip and exponentially sparser further back, so a peer can find
+// the last block we share in a few steps
+func (bc *Blockchain) locator() []string {
+	bc.RLock()
+	defer bc.RUnlock()
+
+	var hashes []string
+	step := 1
+	for i := len(bc.blocks) - 1; i > 0; i -= step {
+		hashes = append(hashes, bc.blocks[i].Hash)
+		if len(hashes) >= 10 {
+			step *= 2
+		}
+	}
+	return append(hashes, bc.blocks[0].Hash)
+}
+
+// blocksAfter returns up to max active chain blocks following the first
+// locator hash on the active chain, or nil if none of them is
+func (bc *Blockchain) blocksAfter(locator []string, max int) []*Block {
+	bc.RLock()
+	defer bc.RUnlock()
+
+	for _, hash := range locator {
+		if height := bc.heightOf(hash); height >= 0 {
+			end := height + 1 + max
+			if end > len(bc.blocks) {
+				end = len(bc.blocks)
+			}
+			return append([]*Block{}, bc.blocks[height+1:end]...)
+		}
+	}
+	return nil
+}
+
+// checkMinedTransaction validates a transaction given to the miner against
+// view the way connectBlock will, and applies it to view
+func (bc *Blockchain) checkMinedTransaction(view *utxoView, tx *Transaction) ([]spentOutput, error) {
+	if tx.IsCoinbase() {
+		return nil, errors.New("ERROR: A coinbase can't be mined as a transaction")
+	}
+	if err := view.checkTransaction(tx); err != nil {
+		return nil, err
+	}
+	if err := checkLotteryRules(view, tx, len(bc.blocks), bc.blocks); err != nil {
+		return nil, err
+	}
+	return view.connectTransaction(tx)
+}
+
+// checkMinedTransactions validates transactions to be mined into the next
+// block, so an invalid one is refused before any work goes into it
+func (bc *Blockchain) checkMinedTransactions(txs ...*Transaction) error {
+	bc.RLock()
+	defer bc.RUnlock()
+
+	view := bc.utxo.view()
+	for _, tx := range txs {
+		if _, err := bc.checkMinedTransaction(view, tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// blockTransactions picks the transactions for a new block on top of the
+// active tip: txs first, followed by pooled transactions that still connect
+// and fit within the block limits next to the coinbase. It returns them with
+// the fees they pay the block's coinbase, or an error when txs are invalid
+// or already break the limits.
+func (bc *Blockchain) blockTransactions(txs ...*Transaction) ([]*Transaction, Amount, error) {
+	bc.RLock()
+	defer bc.RUnlock()
+
+	if len(txs)+1 > params.MaxBlockTxs {
+		return nil, 0, fmt.Errorf("ERROR: %d transactions and the coinbase are more than a block's %d", len(txs), params.MaxBlockTxs)
+	}
+	view := bc.utxo.view()
+	picked := make(map[string]bool)
+	var fees Amount
+	size := blockReserve
+	for _, tx := range txs {
+		spent, err := bc.checkMinedTransaction(view, tx)
+		if err != nil {
+			return nil, 0, err
+		}
+		fees += transactionFee(tx, spent)
+		picked[tx.ID] = true
+		size += txSize(tx)
+	}
+	if size > params.MaxBlockSize {
+		return nil, 0, fmt.Errorf("ERROR: The transactions need %d bytes with the coinbase, more than a block's %d", size, params.MaxBlockSize)
+	}
+	for _, pooled := range bc.mempool.Transactions() {
+		if len(txs)+1 >= params.MaxBlockTxs {
+			break
+		}
+		if picked[pooled.ID] {
+			continue
+		}
+		if checkLotteryRules(view, pooled, len(bc.blocks), bc.blocks) != nil {
+			continue
+		}
+		// a smaller transaction may still fit
+		pooledSize := txSize(pooled)
+		if size+pooledSize > params.MaxBlockSize {
+			continue
+		}
+		if spent, err := view.connectTransaction(pooled); err == nil {
+			txs = append(txs, pooled)
+			fees += transactionFee(pooled, spent)
+			size += pooledSize
+		}
+	}
+	return txs, fees, nil
+}
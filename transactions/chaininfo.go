@@ -0,0 +1,92 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+// blockIntervalWindow is how many recent blocks the average block interval
+// is measured over
+const blockIntervalWindow = 100
+
+// ChainInfo summarizes the state of the chain for explorers
+type ChainInfo struct {
+	Network    string
+	Height     int
+	TipHash    string
+	Difficulty int
+	// Target is the hash a block must not exceed, in hex
+	Target string
+	// TotalWork is the expected number of hashes behind the active chain, in
+	// decimal since it outgrows JSON numbers
+	TotalWork   string
+	MempoolSize int
+	// CirculatingSupply is the sum of all unspent outputs
+	CirculatingSupply Amount
+	// AverageBlockInterval is in seconds over the last blocks, 0 when it
+	// can't be told
+	AverageBlockInterval float64
+}
+
+// parseBlockTime reads a block timestamp as written by generateBlock. Blocks
+// from external miners may carry anything there.
+func parseBlockTime(timestamp string) (time.Time, error) {
+	// drop the monotonic clock reading time.Time.String appends
+	if i := strings.Index(timestamp, " m="); i >= 0 {
+		timestamp = timestamp[:i]
+	}
+	return time.Parse("2006-01-02 15:04:05.999999999 -0700 MST", timestamp)
+}
+
+// averageBlockInterval measures the time between the parseable timestamps
+// of the last blocks, in seconds. The genesis block's timestamp is fixed by
+// the chain params, not by when it was mined, so it doesn't count.
+func averageBlockInterval(blocks []*Block) float64 {
+	if len(blocks) > blockIntervalWindow+1 {
+		blocks = blocks[len(blocks)-blockIntervalWindow-1:]
+	}
+	var first, last time.Time
+	firstHeight, lastHeight := 0, 0
+	for i, b := range blocks {
+		t, err := parseBlockTime(b.Timestamp)
+		if err != nil || b.PrevHash == "" {
+			continue
+		}
+		if first.IsZero() {
+			first, firstHeight = t, i
+		}
+		last, lastHeight = t, i
+	}
+	if lastHeight <= firstHeight || !last.After(first) {
+		return 0
+	}
+	return last.Sub(first).Seconds() / float64(lastHeight-firstHeight)
+}
+
+// chainInfo takes a snapshot of the chain's state
+func (bc *Blockchain) chainInfo() ChainInfo {
+	bc.RLock()
+	defer bc.RUnlock()
+
+	info := ChainInfo{
+		Network:              params.Name,
+		Height:               len(bc.blocks) - 1,
+		TipHash:              bc.blocks[len(bc.blocks)-1].Hash,
+		Difficulty:           difficulty,
+		Target:               strings.Repeat("0", difficulty) + strings.Repeat("f", 64-difficulty),
+		TotalWork:            chainWork(bc.blocks).String(),
+		MempoolSize:          bc.mempool.Len(),
+		AverageBlockInterval: averageBlockInterval(bc.blocks),
+	}
+	for _, out := range bc.utxo.UTXOSet {
+		info.CirculatingSupply += out.Value
+	}
+	return info
+}
+
+// reports height, tip, difficulty, work, mempool size, supply and block
+// interval in one call
+func handleGetChainInfo(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, r, http.StatusOK, bc.chainInfo())
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+
+	"github.com/VOOVOOZEL/go_blockchain/transactions/sdk"
+	"github.com/spf13/cobra"
+)
+
+// the command line. Node commands work on the local data directory; wallet
+// and tx commands talk to a running node's API.
+var (
+	nodeURL    string
+	apiKey     string
+	walletPath string
+)
+
+var rootCmd = &cobra.Command{
+	Use:          "blockchain",
+	Short:        "A small proof of work blockchain node and wallet",
+	SilenceUsage: true,
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := loadSettings(cmd); err != nil {
+			return err
+		}
+		setupLogging(os.Stderr)
+		loadChainParams()
+		return nil
+	},
+}
+
+var nodeCmd = &cobra.Command{
+	Use:   "node",
+	Short: "Run and inspect the node",
+}
+
+var nodeStartCmd = &cobra.Command{
+	Use:   "start",
+	Short: "Start the node with the settings of the environment",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runNodeStart()
+	},
+}
+
+var nodePrintChainCmd = &cobra.Command{
+	Use:   "printchain",
+	Short: "Print the blocks of the stored active chain",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := openChain(); err != nil {
+			return err
+		}
+		out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(out, "HEIGHT\tHASH\tTXS\tMINER\tTIME")
+		for height, b := range bc.Blocks(0, bc.Height()+1) {
+			fmt.Fprintf(out, "%d\t%s\t%d\t%s\t%s\n", height, b.Hash, len(b.Transactions), blockMiner(b), b.Timestamp)
+		}
+		return out.Flush()
+	},
+}
+
+var nodeValidateCmd = &cobra.Command{
+	Use:     "validate",
+	Aliases: []string{"validate-chain"},
+	Short:   "Fully validate the stored chain, failing on the first violation",
+	Long: `Replays the stored active chain from its genesis block, checking block links,
+hashes and proof of work, transaction IDs and signatures and that every input
+spends an unspent output, then compares the outputs left with the node's UTXO
+set. Stored blocks that don't connect to the chain are violations too.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := openChain(); err != nil {
+			return err
+		}
+		if err := bc.checkStoredBlocks(); err != nil {
+			return err
+		}
+		utxo, err := bc.validateActiveChain()
+		if err != nil {
+			return err
+		}
+		if err := compareUTXO(bc.utxo.UTXOSet, utxo); err != nil {
+			return err
+		}
+		fmt.Printf("%d blocks valid, %d unspent outputs\n", bc.Height()+1, len(utxo))
+		return nil
+	},
+}
+
+// blockMiner returns the address the coinbase of b pays
+func blockMiner(b *Block) string {
+	if len(b.Transactions) == 0 || !b.Transactions[0].IsCoinbase() || len(b.Transactions[0].Vout) == 0 {
+		return "-"
+	}
+	return b.Transactions[0].Vout[0].ScriptPubKey
+}
+
+var walletCmd = &cobra.Command{
+	Use:   "wallet",
+	Short: "Manage the local wallet",
+}
+
+// walletFile is what wallet create writes: the master key and its address
+type walletFile struct {
+	Key     string
+	Address string
+}
+
+var walletCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a wallet with a new random key",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		w, err := createWallet(walletPath)
+		if err != nil {
+			return err
+		}
+		fmt.Println(w.Address)
+		return nil
+	},
+}
+
+var walletBalanceCmd = &cobra.Command{
+	Use:   "balance [address]",
+	Short: "Show the balance of an address, by default the wallet's",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		address, err := walletAddress(args)
+		if err != nil {
+			return err
+		}
+		balance, err := apiClient().AddressBalance(context.Background(), address)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("address:     %s\n", balance.Address)
+		fmt.Printf("confirmed:   %d\n", balance.Confirmed)
+		fmt.Printf("unconfirmed: %d\n", balance.Unconfirmed)
+		fmt.Printf("immature:    %d\n", balance.Immature)
+		fmt.Printf("utxos:       %d\n", balance.UTXOCount)
+		return nil
+	},
+}
+
+var txCmd = &cobra.Command{
+	Use:   "tx",
+	Short: "Send transactions",
+}
+
+var (
+	sendFrom   string
+	sendTo     string
+	sendAmount int
+)
+
+var txSendCmd = &cobra.Command{
+	Use:   "send",
+	Short: "Pay an amount to an address and have the node mine it",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		from := sendFrom
+		if from == "" {
+			var err error
+			if from, err = walletAddress(nil); err != nil {
+				return err
+			}
+		}
+		if sendTo == "" || sendAmount < 1 {
+			return errors.New("ERROR: --to and a positive --amount are required")
+		}
+		job, err := apiClient().Send(context.Background(), from, sendTo, sendAmount)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("job %s %s, transaction %s\n", job.ID, job.Status, job.Txid)
+		return nil
+	},
+}
+
+func init() {
+	addSettingFlags(rootCmd)
+	rootCmd.PersistentFlags().StringVar(&nodeURL, "node", "", "node API URL (default $NODE_URL or http://localhost:$PORT)")
+	rootCmd.PersistentFlags().StringVar(&apiKey, "api-key", os.Getenv("API_KEY"), "API key for nodes with API_KEYS")
+	rootCmd.PersistentFlags().StringVar(&walletPath, "wallet", "wallet.json", "wallet file")
+
+	txSendCmd.Flags().StringVar(&sendFrom, "from", "", "paying address (default the wallet's)")
+	txSendCmd.Flags().StringVar(&sendTo, "to", "", "receiving address")
+	txSendCmd.Flags().IntVar(&sendAmount, "amount", 0, "amount to send")
+
+	nodeCmd.AddCommand(nodeStartCmd, nodePrintChainCmd, nodeValidateCmd)
+	walletCmd.AddCommand(walletCreateCmd, walletBalanceCmd)
+	txCmd.AddCommand(txSendCmd)
+	rootCmd.AddCommand(nodeCmd, walletCmd, txCmd)
+}
+
+// apiClient returns a client for the node the wallet and tx commands use
+func apiClient() *sdk.Client {
+	url := nodeURL
+	if url == "" {
+		url = os.Getenv("NODE_URL")
+	}
+	if url == "" {
+		url = "http://localhost:" + os.Getenv("PORT")
+	}
+	client := sdk.NewClient(url)
+	client.APIKey = apiKey
+	return client
+}
+
+// createWallet writes a wallet with a new random key to path
+func createWallet(path string) (*walletFile, error) {
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("ERROR: %s already exists", path)
+	}
+	seed := make([]byte, 32)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, err
+	}
+	key, err := sdk.NewMasterKey(seed)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &walletFile{Key: key.String(), Address: key.Address()}
+	data, err := json.MarshalIndent(w, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// readWallet reads the wallet at path
+func readWallet(path string) (*walletFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var w walletFile
+	if err := json.Unmarshal(data, &w); err != nil {
+		return nil, fmt.Errorf("ERROR: Reading %s: %v", path, err)
+	}
+	return &w, nil
+}
+
+// walletAddress returns the address given as argument, or else the wallet's
+func walletAddress(args []string) (string, error) {
+	if len(args) > 0 {
+		return args[0], nil
+	}
+	w, err := readWallet(walletPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", fmt.Errorf("ERROR: No address given and no wallet %s", walletPath)
+	}
+	if err != nil {
+		return "", err
+	}
+	return w.Address, nil
+}
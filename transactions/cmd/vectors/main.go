@@ -0,0 +1,86 @@
+// Command vectors exports the node's address and sighash test vectors as
+// JSON, and verifies a vector file against this implementation.
+//
+//	vectors generate [-seeds 3] [-count 5] [-o vectors.json]
+//	vectors verify vectors.json
+//
+// The published vectors are sdk/testdata/vectors.json, made with the default
+// flags.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/VOOVOOZEL/go_blockchain/transactions/sdk"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	switch os.Args[1] {
+	case "generate":
+		generate(os.Args[2:])
+	case "verify":
+		verify(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: vectors generate [-seeds n] [-count n] [-o file] | vectors verify file")
+	os.Exit(2)
+}
+
+func generate(args []string) {
+	fs := flag.NewFlagSet("generate", flag.ExitOnError)
+	seeds := fs.Int("seeds", 3, "number of seeds")
+	count := fs.Int("count", 5, "addresses per seed")
+	out := fs.String("o", "", "output file (default stdout)")
+	fs.Parse(args)
+
+	// seeds are fixed and signatures deterministic, so regenerating gives
+	// the same file
+	vectors, err := sdk.GenerateVectors(sdk.VectorSeeds(*seeds), *count)
+	if err != nil {
+		log.Fatal(err)
+	}
+	encoded, err := json.MarshalIndent(vectors, "", "  ")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *out == "" {
+		os.Stdout.Write(append(encoded, '\n'))
+		return
+	}
+	if err := os.WriteFile(*out, encoded, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func verify(args []string) {
+	if len(args) != 1 {
+		usage()
+	}
+
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+	var vectors sdk.Vectors
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		log.Fatal(err)
+	}
+	if err := vectors.Verify(); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("%d address and %d sighash vectors OK\n", len(vectors.Addresses), len(vectors.SigHashes))
+}
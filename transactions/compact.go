@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+
+	"github.com/VOOVOOZEL/go_blockchain/transactions/wire"
+)
+
+// compactBlocksVersion is the first protocol version that understands
+// cmpctblock, getblocktxn and blocktxn
+const compactBlocksVersion = 4
+
+// maxPendingCompact caps the compact blocks a peer may leave waiting for
+// their missing transactions
+const maxPendingCompact = 8
+
+// CompactBlockMessage announces a block as its header and transaction IDs.
+// Peers rebuild it from their mempool and ask only for what they miss.
+// Prefilled carries the transactions a peer can't have, i.e. the coinbase.
+type CompactBlockMessage struct {
+	Header    BlockHeader
+	TxIDs     []string
+	Prefilled []*Transaction
+}
+
+// GetBlockTxnMessage asks for transactions of a compact block by position
+type GetBlockTxnMessage struct {
+	Hash    string
+	Indexes []int
+}
+
+// BlockTxnMessage answers getblocktxn, in the order asked for
+type BlockTxnMessage struct {
+	Hash         string
+	Transactions []*Transaction
+}
+
+// partialBlock is a compact block waiting for missing transactions
+type partialBlock struct {
+	header  BlockHeader
+	txs     []*Transaction
+	missing []int
+}
+
+func newCompactBlock(b *Block) CompactBlockMessage {
+	m := CompactBlockMessage{Header: b.Header()}
+	for _, tx := range b.Transactions {
+		m.TxIDs = append(m.TxIDs, tx.ID)
+		if tx.IsCoinbase() {
+			m.Prefilled = append(m.Prefilled, tx)
+		}
+	}
+	return m
+}
+
+// wantsCompact reports whether a peer gets blocks in compact form: it has to
+// understand them and be synced up to the block's parent, so it is likely
+// to have the transactions in its mempool
+func (p *Peer) wantsCompact(height int) bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.version != nil && p.version.Version >= compactBlocksVersion && p.version.Height >= height-1
+}
+
+// receiveCompactBlock rebuilds an announced block from the mempool and asks
+// the peer for the transactions we don't have
+func (p *Peer) receiveCompactBlock(m *CompactBlockMessage) error {
+	hash := m.Header.Hash
+	if sum, err := m.Header.calculateHash(); err != nil || sum != hash {
+		peerStats.recordInvalid(p)
+		return fmt.Errorf("compact block %s has an invalid header", m.Header.Hash)
+	}
+
+	bc.RLock()
+	_, known := bc.known[hash]
+	_, haveParent := bc.known[m.Header.PrevHash]
+	bc.RUnlock()
+	if known {
+		seenBlocks.markSeen(hash)
+		return nil
+	}
+	if !haveParent {
+		// we are missing its ancestors, catch up with this peer first
+		return p.send(wire.CmdGetHeaders, GetHeadersMessage{bc.headers.locator()})
+	}
+
+	available := make(map[string]*Transaction)
+	for _, tx := range bc.mempool.Transactions() {
+		available[tx.ID] = tx
+	}
+	for _, tx := range m.Prefilled {
+		available[tx.ID] = tx
+	}
+	partial := &partialBlock{header: m.Header, txs: make([]*Transaction, len(m.TxIDs))}
+	for i, txid := range m.TxIDs {
+		if tx, ok := available[txid]; ok {
+			partial.txs[i] = tx
+		} else {
+			partial.missing = append(partial.missing, i)
+		}
+	}
+	if len(partial.missing) == 0 {
+		return p.completeCompactBlock(partial)
+	}
+
+	p.Lock()
+	if p.compact == nil {
+		p.compact = make(map[string]*partialBlock)
+	}
+	if len(p.compact) >= maxPendingCompact {
+		p.Unlock()
+		return p.requestBlock(hash)
+	}
+	p.compact[hash] = partial
+	p.Unlock()
+	return p.send(wire.CmdGetBlockTxn, GetBlockTxnMessage{hash, partial.missing})
+}
+
+// receiveBlockTxn fills in the transactions of a pending compact block
+func (p *Peer) receiveBlockTxn(m *BlockTxnMessage) error {
+	p.Lock()
+	partial, ok := p.compact[m.Hash]
+	delete(p.compact, m.Hash)
+	p.Unlock()
+	if !ok {
+		return nil
+	}
+	if len(m.Transactions) != len(partial.missing) {
+		peerStats.recordInvalid(p)
+		return p.requestBlock(m.Hash)
+	}
+	for i, index := range partial.missing {
+		if m.Transactions[i] == nil {
+			peerStats.recordInvalid(p)
+			return p.requestBlock(m.Hash)
+		}
+		partial.txs[index] = m.Transactions[i]
+	}
+	return p.completeCompactBlock(partial)
+}
+
+// completeCompactBlock processes a rebuilt block. If the transactions don't
+// add up to the header, the peer sent us bad data and we fetch the block in
+// full instead.
+func (p *Peer) completeCompactBlock(partial *partialBlock) error {
+	h := partial.header
+	b := &Block{Timestamp: h.Timestamp, Transactions: partial.txs, Hash: h.Hash, PrevHash: h.PrevHash, Nonce: h.Nonce}
+	if err := checkTransactionIDs(b); err != nil || hex.EncodeToString(b.HashTransactions()) != h.TransactionsHash {
+		p2pLog.Warn("Can't rebuild compact block, fetching it in full", "hash", h.Hash, "peer", p.addr)
+		peerStats.recordInvalid(p)
+		return p.requestBlock(h.Hash)
+	}
+	return p.acceptBlock(b, false)
+}
+
+// requestBlock fetches a block in full, going through acceptBlock like an
+// announced one when it arrives. Past maxAnnouncedBlocks outstanding the
+// block is left for sync to catch up on.
+func (p *Peer) requestBlock(hash string) error {
+	if !p.expectBlock(hash) {
+		return nil
+	}
+	return p.send(wire.CmdGetData, wire.GetDataMessage{Items: wire.NewInv(wire.InvBlock, hash)})
+}
+
+// sendBlockTxn answers getblocktxn from the block's transactions
+func (p *Peer) sendBlockTxn(m *GetBlockTxnMessage) error {
+	blocks := bc.blocksByHash([]string{m.Hash})
+	if len(blocks) == 0 {
+		return nil
+	}
+	b := blocks[0]
+
+	reply := BlockTxnMessage{Hash: m.Hash}
+	for _, i := range m.Indexes {
+		if i < 0 || i >= len(b.Transactions) {
+			return fmt.Errorf("asked for transaction %d of block %s, which has %d", i, m.Hash, len(b.Transactions))
+		}
+		reply.Transactions = append(reply.Transactions, b.Transactions[i])
+	}
+	return p.send(wire.CmdBlockTxn, reply)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is a node's configuration file, YAML or TOML by its extension.
+// Every setting is one of the environment variables the node reads, so the
+// file only fills in what the environment leaves unset; Env sets any other.
+type Config struct {
+	Port         int               `yaml:"port" toml:"port"`
+	Difficulty   int               `yaml:"difficulty" toml:"difficulty"`
+	DataDir      string            `yaml:"data_dir" toml:"data_dir"`
+	Peers        []string          `yaml:"peers" toml:"peers"`
+	MinerAddress string            `yaml:"miner_address" toml:"miner_address"`
+	Network      string            `yaml:"network" toml:"network"`
+	Env          map[string]string `yaml:"env" toml:"env"`
+}
+
+// settings returns the environment variables the file sets
+func (c *Config) settings() map[string]string {
+	settings := make(map[string]string)
+	for name, value := range c.Env {
+		settings[strings.ToUpper(name)] = value
+	}
+	if c.Port != 0 {
+		settings["PORT"] = strconv.Itoa(c.Port)
+	}
+	if c.Difficulty != 0 {
+		settings["DIFFICULTY"] = strconv.Itoa(c.Difficulty)
+	}
+	if c.DataDir != "" {
+		settings["DATA_DIR"] = c.DataDir
+	}
+	if len(c.Peers) > 0 {
+		settings["PEERS"] = strings.Join(c.Peers, ",")
+	}
+	if c.MinerAddress != "" {
+		settings["MINER_ADDRESS"] = c.MinerAddress
+	}
+	if c.Network != "" {
+		settings["NETWORK"] = c.Network
+	}
+	return settings
+}
+
+// readConfig parses a configuration file
+func readConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var c Config
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &c)
+	case ".toml":
+		err = toml.Unmarshal(data, &c)
+	default:
+		return nil, fmt.Errorf("ERROR: Config file %s must be .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ERROR: Reading config file %s: %v", path, err)
+	}
+	return &c, nil
+}
+
+// loadConfigFile sets the environment variables of the file at path that
+// aren't set yet
+func loadConfigFile(path string) error {
+	c, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+	settings := c.settings()
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if _, ok := os.LookupEnv(name); !ok {
+			os.Setenv(name, settings[name])
+		}
+	}
+	return nil
+}
+
+// settingFlags are the command line flags overriding settings, by the
+// environment variable they override
+var settingFlags = []struct{ flag, env, usage string }{
+	{"port", "PORT", "HTTP API port"},
+	{"difficulty", "DIFFICULTY", "leading zero hex digits of a block hash"},
+	{"data-dir", "DATA_DIR", "directory of the block store"},
+	{"peers", "PEERS", "comma separated P2P seed addresses"},
+	{"miner-address", "MINER_ADDRESS", "address block rewards are paid to"},
+	{"network", "NETWORK", "main, testnet, regtest or a custom network"},
+}
+
+var (
+	configPath    string
+	settingValues = make(map[string]*string)
+)
+
+func addSettingFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().StringVar(&configPath, "config", "", "YAML or TOML config file (default $CONFIG_FILE)")
+	for _, f := range settingFlags {
+		value := new(string)
+		settingValues[f.flag] = value
+		cmd.PersistentFlags().StringVar(value, f.flag, "", f.usage+" (overrides $"+f.env+")")
+	}
+}
+
+// loadSettings applies the configuration: flags first, then the environment
+// and .env, then the config file
+func loadSettings(cmd *cobra.Command) error {
+	for _, f := range settingFlags {
+		if cmd.Flags().Changed(f.flag) {
+			os.Setenv(f.env, *settingValues[f.flag])
+		}
+	}
+	// .env is optional, the environment may already hold the settings
+	if err := godotenv.Load(); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	path := configPath
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	if path == "" {
+		return nil
+	}
+	return loadConfigFile(path)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/peterh/liner"
+	"github.com/spf13/cobra"
+)
+
+// consoleCommands are the console's own commands; every other line is sent
+// to the node as a JSON-RPC call
+var consoleCommands = map[string]string{
+	"getbalance": "getbalance [address]  balance of an address, by default the miner's",
+	"send":       "send <address> <amount>  pay from the miner address",
+	"mine":       "mine [n]  mine n blocks, by default one",
+	"help":       "help  list the commands and the node's RPC methods",
+	"exit":       "exit  leave the console",
+}
+
+var nodeConsoleCmd = &cobra.Command{
+	Use:   "console",
+	Short: "Open an interactive shell on a running node's JSON-RPC API",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c := &rpcConsole{url: apiClient().BaseURL + "/rpc", apiKey: apiKey, client: &http.Client{Timeout: 5 * time.Minute}}
+		return c.run()
+	},
+}
+
+func init() {
+	nodeCmd.AddCommand(nodeConsoleCmd)
+}
+
+// rpcConsole reads commands from the terminal and calls the node with them
+type rpcConsole struct {
+	url     string
+	apiKey  string
+	client  *http.Client
+	methods []string
+	nextID  int
+}
+
+func (c *rpcConsole) run() error {
+	var help string
+	if err := c.call("help", nil, &help); err != nil {
+		return fmt.Errorf("ERROR: Can't reach the node at %s: %v", c.url, err)
+	}
+	c.methods = methodNames(help)
+
+	line := liner.NewLiner()
+	defer line.Close()
+	line.SetCtrlCAborts(true)
+	line.SetCompleter(c.complete)
+	fmt.Printf("Connected to %s, type help for the commands\n", c.url)
+
+	for {
+		input, err := line.Prompt("> ")
+		if err == liner.ErrPromptAborted {
+			continue
+		}
+		if err == io.EOF {
+			fmt.Println()
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		fields := strings.Fields(input)
+		if len(fields) == 0 {
+			continue
+		}
+		line.AppendHistory(input)
+		if fields[0] == "exit" || fields[0] == "quit" {
+			return nil
+		}
+		if err := c.execute(fields[0], fields[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+		}
+	}
+}
+
+// methodNames picks the method names, the first word of each line, out of
+// the node's help text
+func methodNames(help string) []string {
+	var names []string
+	for _, l := range strings.Split(help, "\n") {
+		if fields := strings.Fields(l); len(fields) > 0 {
+			names = append(names, fields[0])
+		}
+	}
+	return names
+}
+
+// complete offers the commands and methods starting with the line's first word
+func (c *rpcConsole) complete(line string) []string {
+	if strings.Contains(line, " ") {
+		return nil
+	}
+	var matches []string
+	seen := make(map[string]bool)
+	for _, name := range c.names() {
+		if strings.HasPrefix(name, line) && !seen[name] {
+			seen[name] = true
+			matches = append(matches, name)
+		}
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+func (c *rpcConsole) names() []string {
+	names := append([]string{}, c.methods...)
+	for name := range consoleCommands {
+		names = append(names, name)
+	}
+	return names
+}
+
+// execute runs a console command or calls the RPC method of that name,
+// reading its arguments as JSON where they parse and as strings otherwise
+func (c *rpcConsole) execute(name string, args []string) error {
+	switch name {
+	case "help":
+		var help []string
+		for _, text := range consoleCommands {
+			help = append(help, "  "+text)
+		}
+		sort.Strings(help)
+		fmt.Println("Console commands:")
+		fmt.Println(strings.Join(help, "\n"))
+		fmt.Println("Node RPC methods, called with their arguments:")
+		fmt.Println("  " + strings.Join(c.methods, " "))
+		return nil
+	case "send":
+		if len(args) != 2 {
+			return errors.New("usage: " + consoleCommands["send"])
+		}
+		name = "sendtoaddress"
+	case "mine":
+		name = "generate"
+	}
+
+	params := make([]interface{}, len(args))
+	for i, arg := range args {
+		if json.Valid([]byte(arg)) {
+			params[i] = json.RawMessage(arg)
+		} else {
+			params[i] = arg
+		}
+	}
+	var result interface{}
+	if err := c.call(name, params, &result); err != nil {
+		return err
+	}
+	if s, ok := result.(string); ok {
+		fmt.Println(strings.TrimRight(s, "\n"))
+		return nil
+	}
+	out, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(out))
+	return nil
+}
+
+// call makes one JSON-RPC call and decodes its result into out
+func (c *rpcConsole) call(method string, params []interface{}, out interface{}) error {
+	c.nextID++
+	if params == nil {
+		params = []interface{}{}
+	}
+	body, err := json.Marshal(map[string]interface{}{"jsonrpc": "2.0", "id": c.nextID, "method": method, "params": params})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var r struct {
+		Result json.RawMessage `json:"result"`
+		Error  *rpcError       `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return fmt.Errorf("ERROR: %s answered %s", c.url, resp.Status)
+	}
+	if r.Error != nil {
+		return fmt.Errorf("ERROR: %s (code %d)", r.Error.Message, r.Error.Code)
+	}
+	if len(r.Result) == 0 {
+		return nil
+	}
+	return json.Unmarshal(r.Result, out)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// corsMaxAge is how long browsers may cache a preflight answer, in seconds
+const corsMaxAge = 600
+
+// CORS lets browser pages from other origins call the API. Credentials go
+// in the Authorization or X-API-Key header, so cookies are never allowed.
+type CORS struct {
+	origins map[string]bool
+	any     bool
+	methods string
+	headers string
+}
+
+// cors is nil unless CORS_ORIGINS is set, which keeps browsers to the
+// same-origin policy
+var cors *CORS
+
+// exposedHeaders are the response headers scripts may read
+var exposedHeaders = "X-Chain-Height, Retry-After, ETag, Deprecation, Sunset, Link, Idempotent-Replayed"
+
+// loadCORSConfig reads CORS_ORIGINS, a comma separated list of origins or *,
+// and CORS_METHODS and CORS_HEADERS, the methods and request headers
+// allowed in cross-origin requests
+func loadCORSConfig() {
+	origins := splitList(os.Getenv("CORS_ORIGINS"))
+	if len(origins) == 0 {
+		cors = nil
+		return
+	}
+	c := &CORS{
+		origins: make(map[string]bool),
+		methods: "GET, HEAD, POST, PUT",
+		headers: "Authorization, Content-Type, X-API-Key, Idempotency-Key",
+	}
+	for _, origin := range origins {
+		if origin == "*" {
+			c.any = true
+		}
+		c.origins[strings.TrimRight(origin, "/")] = true
+	}
+	if methods := splitList(os.Getenv("CORS_METHODS")); len(methods) > 0 {
+		c.methods = strings.ToUpper(strings.Join(methods, ", "))
+	}
+	if headers := splitList(os.Getenv("CORS_HEADERS")); len(headers) > 0 {
+		c.headers = strings.Join(headers, ", ")
+	}
+	cors = c
+}
+
+func (c *CORS) allowed(origin string) bool {
+	return c.any || c.origins[origin]
+}
+
+// corsHandler wraps a router rather than being its middleware, since
+// preflight OPTIONS requests match none of the routes
+func corsHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if cors == nil || origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		if !cors.allowed(origin) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if cors.any {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			h.Set("Access-Control-Allow-Methods", cors.methods)
+			h.Set("Access-Control-Allow-Headers", cors.headers)
+			h.Set("Access-Control-Max-Age", strconv.Itoa(corsMaxAge))
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.Set("Access-Control-Expose-Headers", exposedHeaders)
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// daemon mode: node start --daemon starts the node again in the background,
+// detached from the terminal, with a PID file and a rotating log file.
+// node status and node stop reach it through the admin API.
+var (
+	startDaemon   bool
+	pidFile       string
+	logFile       string
+	logMaxSize    int
+	logMaxBackups int
+	adminKey      string
+)
+
+// startedAt is when the node process started
+var startedAt = time.Now()
+
+// NodeStatus is what GET /admin/status reports
+type NodeStatus struct {
+	PID     int
+	Network string
+	Started time.Time
+	Uptime  string
+	Height  int
+	Tip     string
+	Peers   int
+	Mempool int
+}
+
+var nodeStatusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the state of the node running on this machine",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var status NodeStatus
+		if err := callAdmin(http.MethodGet, "/admin/status", &status); err != nil {
+			return err
+		}
+		printNodeStatus(&status)
+		return nil
+	},
+}
+
+var nodeStopCmd = &cobra.Command{
+	Use:   "stop",
+	Short: "Shut down the node running on this machine",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var status NodeStatus
+		if err := callAdmin(http.MethodPost, "/admin/stop", &status); err != nil {
+			return err
+		}
+		fmt.Printf("Stopping node %d\n", status.PID)
+		return nil
+	},
+}
+
+func init() {
+	nodeStartCmd.Flags().BoolVar(&startDaemon, "daemon", false, "run in the background, logging to --log-file")
+	nodeStartCmd.Flags().StringVar(&pidFile, "pid-file", "", "file to write the process ID to (default node.pid in the data directory with --daemon)")
+	nodeStartCmd.Flags().StringVar(&logFile, "log-file", "", "file to log to, rotated by size (default node.log in the data directory with --daemon)")
+	nodeStartCmd.Flags().IntVar(&logMaxSize, "log-max-size", 100, "megabytes a log file grows to before it's rotated")
+	nodeStartCmd.Flags().IntVar(&logMaxBackups, "log-max-backups", 5, "rotated log files to keep")
+	rootCmd.PersistentFlags().StringVar(&adminKey, "admin-key", os.Getenv("ADMIN_KEY"), "admin API key for nodes with ADMIN_KEYS")
+	nodeCmd.AddCommand(nodeStatusCmd, nodeStopCmd)
+}
+
+// runNodeStart starts the node in the foreground or, with --daemon, in a
+// background process
+func runNodeStart() error {
+	if startDaemon {
+		if pidFile == "" {
+			pidFile = filepath.Join(dataDir(), "node.pid")
+		}
+		if logFile == "" {
+			logFile = filepath.Join(dataDir(), "node.log")
+		}
+		return daemonize()
+	}
+
+	if logFile != "" {
+		if err := os.MkdirAll(filepath.Dir(logFile), 0755); err != nil {
+			return err
+		}
+		logger := &lumberjack.Logger{Filename: logFile, MaxSize: logMaxSize, MaxBackups: logMaxBackups}
+		defer logger.Close()
+		setupLogging(logger)
+	}
+	if pidFile != "" {
+		if err := writePIDFile(pidFile); err != nil {
+			return err
+		}
+		defer os.Remove(pidFile)
+	}
+	startNode()
+	return nil
+}
+
+// daemonize starts this command again in a new session, without --daemon
+// and with the PID and log files, and returns once it's running
+func daemonize() error {
+	if _, err := os.Stat(pidFile); err == nil {
+		return fmt.Errorf("ERROR: %s exists, is the node already running? Remove it if not", pidFile)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	var args []string
+	for _, arg := range os.Args[1:] {
+		if arg != "--daemon" && !strings.HasPrefix(arg, "--daemon=") {
+			args = append(args, arg)
+		}
+	}
+	args = append(args, "--pid-file", pidFile, "--log-file", logFile)
+
+	node := exec.Command(exe, args...)
+	node.SysProcAttr = detachedProcess()
+	if err := node.Start(); err != nil {
+		return err
+	}
+	fmt.Printf("Started node %d, logging to %s\n", node.Process.Pid, logFile)
+	return node.Process.Release()
+}
+
+// writePIDFile records the process ID, refusing to replace another's
+func writePIDFile(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("ERROR: %s exists, is the node already running? Remove it if not", path)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintln(f, os.Getpid()); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// callAdmin calls the admin API of the node at ADMIN_ADDR
+func callAdmin(method, path string, out interface{}) error {
+	loadAdminConfig()
+	if adminAddr == "" {
+		return errors.New("ERROR: The admin API is off, ADMIN_ADDR=off")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, "http://"+adminAddr+path, nil)
+	if err != nil {
+		return err
+	}
+	if adminKey != "" {
+		req.Header.Set("Authorization", "Bearer "+adminKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("ERROR: No node answers at %s: %v", adminAddr, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		var e ErrorResponse
+		json.NewDecoder(resp.Body).Decode(&e)
+		return fmt.Errorf("ERROR: %s %s: %s %s", method, path, resp.Status, e.Message)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func printNodeStatus(s *NodeStatus) {
+	fmt.Printf("pid:     %d\n", s.PID)
+	fmt.Printf("network: %s\n", s.Network)
+	fmt.Printf("started: %s (%s ago)\n", s.Started.Format(time.RFC3339), s.Uptime)
+	fmt.Printf("height:  %d\n", s.Height)
+	fmt.Printf("tip:     %s\n", s.Tip)
+	fmt.Printf("peers:   %d\n", s.Peers)
+	fmt.Printf("mempool: %d\n", s.Mempool)
+}
+
+func nodeStatus() NodeStatus {
+	bc.RLock()
+	height := len(bc.blocks) - 1
+	tip := bc.blocks[height].Hash
+	bc.RUnlock()
+	return NodeStatus{
+		PID:     os.Getpid(),
+		Network: params.Name,
+		Started: startedAt,
+		Uptime:  time.Since(startedAt).Round(time.Second).String(),
+		Height:  height,
+		Tip:     tip,
+		Peers:   len(peerManager.Info()),
+		Mempool: bc.mempool.Len(),
+	}
+}
+
+// reports the process and chain state of the node
+func handleGetNodeStatus(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, r, http.StatusOK, nodeStatus())
+}
+
+// shuts the node down once the answer is sent
+func handleStopNode(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, r, http.StatusAccepted, nodeStatus())
+	select {
+	case stopRequests <- struct{}{}:
+	default:
+	}
+}
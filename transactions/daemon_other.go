@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "syscall"
+
+// detachedProcess leaves daemons in the default process group where there
+// are no sessions to detach from
+func detachedProcess() *syscall.SysProcAttr {
+	return nil
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	rpprof "runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// RuntimeStats is what GET /admin/debug/runtime reports: the goroutines,
+// the size of the in-memory chain and the Go memory statistics
+type RuntimeStats struct {
+	Goroutines   int
+	GOMAXPROCS   int
+	MinerThreads int
+	Height       int
+	KnownBlocks  int
+	UTXOs        int
+	Transactions int
+	Mempool      int
+	Memory       MemoryStats
+}
+
+// MemoryStats are the parts of runtime.MemStats that show the chain growing
+type MemoryStats struct {
+	HeapAlloc    uint64
+	HeapInuse    uint64
+	HeapObjects  uint64
+	StackInuse   uint64
+	Sys          uint64
+	TotalAlloc   uint64
+	NumGC        uint32
+	GCPauseTotal time.Duration
+	LastGC       *time.Time `json:",omitempty"`
+}
+
+// UTXOStats is what GET /debug/utxo-stats reports about the unspent
+// outputs of the active chain
+type UTXOStats struct {
+	Height     int
+	Tip        string
+	Outputs    int
+	Addresses  int
+	TotalValue Amount
+	// Buckets spread the outputs by value, a power of ten wide each
+	Buckets []UTXOBucket
+	// Largest are the addresses holding the most, most first
+	Largest []UTXOHolder
+}
+
+// UTXOBucket counts the outputs worth Min up to Max, inclusive
+type UTXOBucket struct {
+	Min, Max Amount
+	Outputs  int
+	Value    Amount
+}
+
+// UTXOHolder is what an address holds in unspent outputs
+type UTXOHolder struct {
+	Address string
+	Outputs int
+	Value   Amount
+}
+
+const (
+	// defaultUTXOHolders is how many of the largest holders are reported
+	defaultUTXOHolders = 10
+	// maxUTXOHolders caps the top parameter of GET /debug/utxo-stats
+	maxUTXOHolders = 1000
+)
+
+// GoroutineGroup counts the goroutines started by one function
+type GoroutineGroup struct {
+	Function string
+	Count    int
+}
+
+// addDebugRoutes mounts net/http/pprof, the runtime statistics and the UTXO
+// set statistics on the admin API. The CPU profile and the execution trace
+// run for as long as their seconds parameter asks, past the write timeout.
+func addDebugRoutes(muxRouter *mux.Router) {
+	muxRouter.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline).Methods("GET")
+	muxRouter.HandleFunc("/debug/pprof/profile", streaming(pprof.Profile)).Methods("GET")
+	muxRouter.HandleFunc("/debug/pprof/symbol", pprof.Symbol).Methods("GET", "POST")
+	muxRouter.HandleFunc("/debug/pprof/trace", streaming(pprof.Trace)).Methods("GET")
+	muxRouter.PathPrefix("/debug/pprof/").HandlerFunc(pprof.Index).Methods("GET")
+	muxRouter.HandleFunc("/admin/debug/runtime", handleGetRuntimeStats).Methods("GET")
+	muxRouter.HandleFunc("/admin/debug/goroutines", handleGetGoroutines).Methods("GET")
+	muxRouter.HandleFunc("/debug/utxo-stats", handleGetUTXOStats).Methods("GET")
+}
+
+// streaming lifts the write timeout off a handler's responses
+func streaming(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		keepWriting(w)
+		handler(w, r)
+	}
+}
+
+func runtimeStats() RuntimeStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	memory := MemoryStats{
+		HeapAlloc:    m.HeapAlloc,
+		HeapInuse:    m.HeapInuse,
+		HeapObjects:  m.HeapObjects,
+		StackInuse:   m.StackInuse,
+		Sys:          m.Sys,
+		TotalAlloc:   m.TotalAlloc,
+		NumGC:        m.NumGC,
+		GCPauseTotal: time.Duration(m.PauseTotalNs),
+	}
+	if m.LastGC > 0 {
+		last := time.Unix(0, int64(m.LastGC))
+		memory.LastGC = &last
+	}
+
+	bc.RLock()
+	defer bc.RUnlock()
+	return RuntimeStats{
+		Goroutines:   runtime.NumGoroutine(),
+		GOMAXPROCS:   runtime.GOMAXPROCS(0),
+		MinerThreads: minerThreads,
+		Height:       len(bc.blocks) - 1,
+		KnownBlocks:  len(bc.known),
+		UTXOs:        len(bc.utxo.UTXOSet),
+		Transactions: len(bc.txIndex),
+		Mempool:      bc.mempool.Len(),
+		Memory:       memory,
+	}
+}
+
+// goroutineGroups counts the running goroutines by the function that
+// started them, most first
+func goroutineGroups() []GoroutineGroup {
+	var profile bytes.Buffer
+	rpprof.Lookup("goroutine").WriteTo(&profile, 1)
+
+	// each stack is a "<count> @ <pcs>" line followed by "#\t<pc>\t<function>+<offset>\t<file>"
+	// frames, innermost first, so the last frame is the goroutine's entry
+	counts := make(map[string]int)
+	count, entry := 0, ""
+	scanner := bufio.NewScanner(&profile)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.Contains(line, " @ "):
+			count, _ = strconv.Atoi(strings.Fields(line)[0])
+		case strings.HasPrefix(line, "#\t"):
+			if fields := strings.Split(line, "\t"); len(fields) > 2 {
+				entry = fields[2]
+				if i := strings.LastIndex(entry, "+"); i > 0 {
+					entry = entry[:i]
+				}
+			}
+		case line == "" && count > 0:
+			counts[entry] += count
+			count, entry = 0, ""
+		}
+	}
+	if count > 0 {
+		counts[entry] += count
+	}
+
+	groups := make([]GoroutineGroup, 0, len(counts))
+	for function, n := range counts {
+		groups = append(groups, GoroutineGroup{function, n})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+		return groups[i].Function < groups[j].Function
+	})
+	return groups
+}
+
+// reports the goroutines, the in-memory chain's size and the memory stats
+func handleGetRuntimeStats(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, r, http.StatusOK, runtimeStats())
+}
+
+// counts the goroutines by the function that started them
+func handleGetGoroutines(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, r, http.StatusOK, goroutineGroups())
+}
+
+// utxoStats sums up a UTXO set by value and by address, reporting the top
+// largest holders
+func utxoStats(utxo UTXOSet, top int) UTXOStats {
+	var stats UTXOStats
+	holders := make(map[string]*UTXOHolder)
+	var buckets []UTXOBucket
+	for _, out := range utxo {
+		stats.Outputs++
+		stats.TotalValue += out.Value
+
+		i := valueBucket(out.Value)
+		for len(buckets) <= i {
+			buckets = append(buckets, bucketBounds(len(buckets)))
+		}
+		buckets[i].Outputs++
+		buckets[i].Value += out.Value
+
+		h, ok := holders[out.ScriptPubKey]
+		if !ok {
+			h = &UTXOHolder{Address: out.ScriptPubKey}
+			holders[out.ScriptPubKey] = h
+		}
+		h.Outputs++
+		h.Value += out.Value
+	}
+	stats.Addresses = len(holders)
+	stats.Buckets = buckets
+	if stats.Buckets == nil {
+		stats.Buckets = []UTXOBucket{}
+	}
+
+	stats.Largest = make([]UTXOHolder, 0, len(holders))
+	for _, h := range holders {
+		stats.Largest = append(stats.Largest, *h)
+	}
+	sort.Slice(stats.Largest, func(i, j int) bool {
+		if stats.Largest[i].Value != stats.Largest[j].Value {
+			return stats.Largest[i].Value > stats.Largest[j].Value
+		}
+		return stats.Largest[i].Address < stats.Largest[j].Address
+	})
+	if len(stats.Largest) > top {
+		stats.Largest = stats.Largest[:top]
+	}
+	return stats
+}
+
+// valueBucket is the index of the bucket of a value: 0 for 0, then one per
+// power of ten, 1 to 9 in bucket 1, 10 to 99 in bucket 2 and so on
+func valueBucket(value Amount) int {
+	i := 0
+	for ; value > 0; value /= 10 {
+		i++
+	}
+	return i
+}
+
+func bucketBounds(i int) UTXOBucket {
+	if i == 0 {
+		return UTXOBucket{}
+	}
+	min := Amount(1)
+	for j := 1; j < i; j++ {
+		min *= 10
+	}
+	return UTXOBucket{Min: min, Max: min*10 - 1}
+}
+
+// sums up the unspent outputs of the active chain by value and by address;
+// top sets how many of the largest holders are listed
+func handleGetUTXOStats(w http.ResponseWriter, r *http.Request) {
+	top := defaultUTXOHolders
+	if v := r.URL.Query().Get("top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > maxUTXOHolders {
+			respondWithRequestError(w, r, invalidRequest("top", "must be between 0 and %d", maxUTXOHolders))
+			return
+		}
+		top = n
+	}
+
+	bc.RLock()
+	stats := utxoStats(bc.utxo.UTXOSet, top)
+	stats.Height = len(bc.blocks) - 1
+	stats.Tip = bc.blocks[stats.Height].Hash
+	bc.RUnlock()
+	respondWithJSON(w, r, http.StatusOK, stats)
+}
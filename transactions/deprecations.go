@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// Deprecation announces that an endpoint is going away. Responses from it
+// carry Deprecation and Sunset headers (RFC 9745 and RFC 8594), and
+// /v1/deprecations lists them all, so clients can find out in advance.
+type Deprecation struct {
+	Method string
+	// Path is the route template, as registered in makeMuxRouter
+	Path string
+	// Since is when the endpoint was deprecated
+	Since time.Time
+	// Sunset is when it stops being served
+	Sunset time.Time
+	// Replacement is the endpoint to move to, if there is one
+	Replacement string `json:",omitempty"`
+	Note        string `json:",omitempty"`
+}
+
+// deprecations is the registry of deprecated endpoints. Add an entry here
+// when an endpoint is superseded and remove its route once it's past sunset.
+var deprecations = []Deprecation{
+	{
+		Method:      "POST",
+		Path:        "/",
+		Since:       time.Date(2026, time.October, 17, 0, 0, 0, 0, time.UTC),
+		Sunset:      time.Date(2027, time.April, 1, 0, 0, 0, 0, time.UTC),
+		Replacement: "/tx",
+		Note:        "Submit a transaction to the mempool and let the miner include it instead of mining a block per request",
+	},
+	{
+		Method:      "POST",
+		Path:        "/balance",
+		Since:       time.Date(2026, time.October, 17, 0, 0, 0, 0, time.UTC),
+		Sunset:      time.Date(2027, time.October, 1, 0, 0, 0, 0, time.UTC),
+		Replacement: "/balance/{address}",
+		Note:        "Look the balance up with a GET, which browsers, curl and caches handle",
+	},
+}
+
+// deprecationFor returns the registry entry for a route, if any
+func deprecationFor(method, path string) (Deprecation, bool) {
+	for _, d := range deprecations {
+		if strings.EqualFold(d.Method, method) && d.Path == path {
+			return d, true
+		}
+	}
+	return Deprecation{}, false
+}
+
+// deprecationMiddleware adds the deprecation headers to responses from
+// deprecated endpoints
+func deprecationMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if route := mux.CurrentRoute(r); route != nil {
+			if path, err := route.GetPathTemplate(); err == nil {
+				if d, ok := deprecationFor(r.Method, unversioned(path)); ok {
+					setDeprecationHeaders(w.Header(), d)
+				}
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func setDeprecationHeaders(h http.Header, d Deprecation) {
+	h.Set("Deprecation", fmt.Sprintf("@%d", d.Since.Unix()))
+	h.Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+	h.Add("Link", `</v1/deprecations>; rel="deprecation"; type="application/json"`)
+	if d.Replacement != "" {
+		h.Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, d.Replacement))
+	}
+}
+
+// lists the deprecated endpoints and when they go away
+func handleGetDeprecations(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, r, http.StatusOK, deprecations)
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/VOOVOOZEL/go_blockchain/transactions/sdk"
+	"github.com/gorilla/mux"
+)
+
+// maxDeriveCount caps how many addresses one request may derive
+const maxDeriveCount = 1000
+
+// derives a range of receiving addresses from an account xpub
+func handleDeriveAddresses(w http.ResponseWriter, r *http.Request) {
+	from, err := queryUint32(r, "from", 0)
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	count, err := queryUint32(r, "count", 1)
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if count == 0 || count > maxDeriveCount {
+		respondWithError(w, r, http.StatusBadRequest, "count must be between 1 and "+strconv.Itoa(maxDeriveCount))
+		return
+	}
+
+	addresses, err := sdk.DeriveAddresses(mux.Vars(r)["xpub"], from, count)
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, r, http.StatusOK, addresses)
+}
+
+// derives the receiving address at a single index of an account xpub
+func handleDeriveAddress(w http.ResponseWriter, r *http.Request) {
+	index, err := strconv.ParseUint(mux.Vars(r)["index"], 10, 32)
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, "invalid index")
+		return
+	}
+
+	address, err := sdk.DeriveAddress(mux.Vars(r)["xpub"], uint32(index))
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondWithJSON(w, r, http.StatusOK, address)
+}
+
+// queryUint32 reads an optional unsigned query parameter
+func queryUint32(r *http.Request, name string, def uint32) (uint32, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseUint(v, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s parameter %q", name, v)
+	}
+	return uint32(n), nil
+}
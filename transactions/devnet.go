@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/VOOVOOZEL/go_blockchain/transactions/sdk"
+	"github.com/spf13/cobra"
+)
+
+// devnet runs a local regtest network. The node keeps its state in package
+// globals, so every node is a child process of this binary rather than a
+// goroutine; they share regtest's genesis block, peer with each other on
+// sequential ports and pay their block rewards to a faucet wallet, which
+// every node's POST /faucet pays out of.
+var (
+	devnetNodes    int
+	devnetHTTPPort int
+	devnetP2PPort  int
+	devnetDir      string
+	devnetMine     time.Duration
+)
+
+// devnetReadyTimeout is how long devnet waits for the first node's API
+const devnetReadyTimeout = 30 * time.Second
+
+var devnetCmd = &cobra.Command{
+	Use:   "devnet",
+	Short: "Run a local network of nodes with a funded faucet wallet",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if devnetNodes < 1 {
+			return errors.New("ERROR: --nodes must be at least 1")
+		}
+		return runDevnet()
+	},
+}
+
+func init() {
+	devnetCmd.Flags().IntVar(&devnetNodes, "nodes", 3, "number of nodes")
+	devnetCmd.Flags().IntVar(&devnetHTTPPort, "http-port", 9000, "HTTP API port of the first node, the others count up")
+	devnetCmd.Flags().IntVar(&devnetP2PPort, "p2p-port", 19000, "P2P port of the first node, the others count up")
+	devnetCmd.Flags().StringVar(&devnetDir, "dir", "devnet", "directory of the nodes' data and the faucet wallet")
+	devnetCmd.Flags().DurationVar(&devnetMine, "mine", 0, "have every node mine a block when none came for this long, e.g. 10s")
+	rootCmd.AddCommand(devnetCmd)
+}
+
+func runDevnet() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	faucetPath := filepath.Join(devnetDir, "faucet.json")
+	wallet, err := devnetFaucet(faucetPath)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var wg sync.WaitGroup
+	failed := make(chan error, devnetNodes)
+	var peers []string
+	for i := 0; i < devnetNodes; i++ {
+		dir := filepath.Join(devnetDir, "node"+strconv.Itoa(i))
+		env := []string{
+			"NETWORK=regtest",
+			"DATA_DIR=" + dir,
+			"PORT=" + strconv.Itoa(devnetHTTPPort+i),
+			"P2P_PORT=" + strconv.Itoa(devnetP2PPort+i),
+			"PEERS=" + strings.Join(peers, ","),
+			"MINER_ADDRESS=" + wallet.Address,
+			"FAUCET_WALLET=" + faucetPath,
+			"ADMIN_ADDR=off",
+			"GRPC_PORT=",
+			"CONFIG_FILE=",
+		}
+		if devnetMine > 0 {
+			env = append(env, "HEARTBEAT_INTERVAL="+devnetMine.String())
+		}
+		peers = append(peers, "127.0.0.1:"+strconv.Itoa(devnetP2PPort+i))
+
+		node := exec.CommandContext(ctx, exe, "node", "start")
+		node.Env = append(os.Environ(), env...)
+		node.Cancel = func() error { return node.Process.Signal(os.Interrupt) }
+		node.WaitDelay = 10 * time.Second
+		if err := devnetOutput(node, fmt.Sprintf("[node%d] ", i)); err != nil {
+			return err
+		}
+		if err := node.Start(); err != nil {
+			stop()
+			wg.Wait()
+			return err
+		}
+		log.Printf("Started node%d: API http://localhost:%d, P2P port %d", i, devnetHTTPPort+i, devnetP2PPort+i)
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := node.Wait(); err != nil && ctx.Err() == nil {
+				failed <- fmt.Errorf("node%d stopped: %v", i, err)
+			}
+		}(i)
+	}
+
+	if err := devnetFund(ctx, wallet); err != nil {
+		log.Printf("Couldn't fund the faucet: %v", err)
+	}
+	log.Printf("Faucet wallet %s, address %s; Ctrl-C stops the network", faucetPath, wallet.Address)
+
+	select {
+	case <-ctx.Done():
+	case err = <-failed:
+	}
+	stop()
+	wg.Wait()
+	return err
+}
+
+// devnetFaucet reads the faucet wallet, creating it on the first run
+func devnetFaucet(path string) (*walletFile, error) {
+	w, err := readWallet(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return createWallet(path)
+	}
+	return w, err
+}
+
+// devnetOutput prefixes each line a node logs with its name
+func devnetOutput(node *exec.Cmd, prefix string) error {
+	out, err := node.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	node.Stderr = node.Stdout
+	go func(out io.Reader) {
+		scanner := bufio.NewScanner(out)
+		for scanner.Scan() {
+			fmt.Println(prefix + scanner.Text())
+		}
+	}(out)
+	return nil
+}
+
+// devnetFund pays the genesis output to the faucet once the first node is up.
+// The block mining it pays its reward to the faucet too. Later runs find the
+// genesis output spent and leave the faucet as it is.
+func devnetFund(ctx context.Context, wallet *walletFile) error {
+	client := sdk.NewClient("http://localhost:" + strconv.Itoa(devnetHTTPPort))
+	ctx, cancel := context.WithTimeout(ctx, devnetReadyTimeout)
+	defer cancel()
+	for {
+		if _, err := client.Height(ctx); err == nil {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return errors.New("the first node didn't come up")
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+	genesisAddress := genesisParams("regtest").Address
+	if balance, err := client.Balance(ctx, genesisAddress); err != nil || balance < subsidy {
+		return err
+	}
+	job, err := client.Send(ctx, genesisAddress, wallet.Address, subsidy)
+	if err != nil {
+		return err
+	}
+	log.Printf("Funding the faucet with job %s", job.ID)
+	return nil
+}
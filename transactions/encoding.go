@@ -0,0 +1,395 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"mime"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// response media types besides JSON. MessagePack works for every response;
+// protobuf, with the messages of proto/chain.proto, for blocks and
+// transactions.
+const (
+	mediaJSON     = "application/json"
+	mediaMsgpack  = "application/msgpack"
+	mediaProtobuf = "application/x-protobuf"
+)
+
+// mediaTypes maps the Accept header's types to the ones served
+var mediaTypes = map[string]string{
+	"*/*":                             mediaJSON,
+	"application/*":                   mediaJSON,
+	"application/json":                mediaJSON,
+	"application/msgpack":             mediaMsgpack,
+	"application/x-msgpack":           mediaMsgpack,
+	"application/vnd.msgpack":         mediaMsgpack,
+	"application/protobuf":            mediaProtobuf,
+	"application/x-protobuf":          mediaProtobuf,
+	"application/vnd.google.protobuf": mediaProtobuf,
+}
+
+// negotiate picks the response media type the Accept header prefers, JSON
+// when it names none the node serves
+func negotiate(r *http.Request, protobuf bool) string {
+	best, bestQ := mediaJSON, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+		q := 1.0
+		if v, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(v, 64); err != nil {
+				continue
+			}
+		}
+		served, ok := mediaTypes[mediaType]
+		if !ok || (served == mediaProtobuf && !protobuf) || q <= bestQ {
+			continue
+		}
+		best, bestQ = served, q
+	}
+	return best
+}
+
+// acceptsGzip reports whether the Accept-Encoding header allows a gzip
+// compressed response
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if q, err := strconv.ParseFloat(v, 64); err != nil || q <= 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// blockList is a range of active chain blocks starting at height start,
+// going down with desc. JSON and MessagePack clients get the blocks alone.
+type blockList struct {
+	blocks []*Block
+	start  int
+	desc   bool
+}
+
+// protoPayload returns the protobuf message of a response, if it has one
+func protoPayload(payload interface{}) (protoMessage, bool) {
+	switch v := payload.(type) {
+	case *BlockInfo:
+		return toPBBlock(v.Block, v.Height), true
+	case *TransactionInfo:
+		return toPBTransaction(v.Transaction), true
+	case *Transaction:
+		return toPBTransaction(v), true
+	case blockList:
+		m := &pbGetChainResponse{}
+		for i, b := range v.blocks {
+			height := v.start + i
+			if v.desc {
+				height = v.start - i
+			}
+			m.Blocks = append(m.Blocks, toPBBlock(b, height))
+		}
+		return m, true
+	}
+	return nil, false
+}
+
+// encodeResponse encodes payload in the media type the request prefers
+func encodeResponse(r *http.Request, payload interface{}) (string, []byte, error) {
+	pb, ok := protoPayload(payload)
+	mediaType := negotiate(r, ok)
+	if list, ok := payload.(blockList); ok {
+		payload = list.blocks
+	}
+
+	switch mediaType {
+	case mediaProtobuf:
+		return mediaType, pb.appendProto(nil), nil
+	case mediaMsgpack:
+		data, err := marshalMsgpack(payload)
+		return mediaType, data, err
+	}
+	data, err := json.MarshalIndent(payload, "", "  ")
+	return mediaJSON, data, err
+}
+
+// marshalMsgpack encodes v in MessagePack the way encoding/json would encode
+// it in JSON: structs become maps keyed by their JSON field names
+func marshalMsgpack(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := encodeMsgpack(&buf, reflect.ValueOf(v)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var (
+	timeValueType     = reflect.TypeOf(time.Time{})
+	jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+func encodeMsgpack(buf *bytes.Buffer, v reflect.Value) error {
+	if !v.IsValid() {
+		buf.WriteByte(0xc0)
+		return nil
+	}
+	if v.Type() == timeValueType {
+		msgpackString(buf, v.Interface().(time.Time).Format(time.RFC3339Nano))
+		return nil
+	}
+	if v.Type().Implements(jsonMarshalerType) && (v.Kind() != reflect.Ptr || !v.IsNil()) {
+		return msgpackFromJSON(buf, v.Interface().(json.Marshaler))
+	}
+
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			buf.WriteByte(0xc0)
+			return nil
+		}
+		return encodeMsgpack(buf, v.Elem())
+	case reflect.Bool:
+		if v.Bool() {
+			buf.WriteByte(0xc3)
+		} else {
+			buf.WriteByte(0xc2)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		msgpackInt(buf, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		msgpackUint(buf, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		buf.WriteByte(0xcb)
+		binary.Write(buf, binary.BigEndian, math.Float64bits(v.Float()))
+	case reflect.String:
+		msgpackString(buf, v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			buf.WriteByte(0xc0)
+			return nil
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			msgpackBinary(buf, v)
+			return nil
+		}
+		msgpackHeader(buf, v.Len(), 0x90, 0xdc, 0xdd)
+		for i := 0; i < v.Len(); i++ {
+			if err := encodeMsgpack(buf, v.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			buf.WriteByte(0xc0)
+			return nil
+		}
+		keys := make([]string, 0, v.Len())
+		values := make(map[string]reflect.Value, v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			key := fmt.Sprint(iter.Key().Interface())
+			keys = append(keys, key)
+			values[key] = iter.Value()
+		}
+		sort.Strings(keys)
+		msgpackHeader(buf, len(keys), 0x80, 0xde, 0xdf)
+		for _, key := range keys {
+			msgpackString(buf, key)
+			if err := encodeMsgpack(buf, values[key]); err != nil {
+				return err
+			}
+		}
+	case reflect.Struct:
+		var names []string
+		var fields []reflect.Value
+		msgpackFields(v, &names, &fields)
+		msgpackHeader(buf, len(names), 0x80, 0xde, 0xdf)
+		for i, name := range names {
+			msgpackString(buf, name)
+			if err := encodeMsgpack(buf, fields[i]); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("can't encode %s as MessagePack", v.Type())
+	}
+	return nil
+}
+
+// msgpackFields collects the fields of a struct that encoding/json writes,
+// with their names
+func msgpackFields(v reflect.Value, names *[]string, fields *[]reflect.Value) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		value := v.Field(i)
+
+		if field.Anonymous && name == "" {
+			if value.Kind() == reflect.Ptr {
+				if value.IsNil() {
+					continue
+				}
+				value = value.Elem()
+			}
+			if value.Kind() == reflect.Struct {
+				msgpackFields(value, names, fields)
+				continue
+			}
+		}
+		if !field.IsExported() || strings.Contains(opts, "omitempty") && value.IsZero() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		*names = append(*names, name)
+		*fields = append(*fields, value)
+	}
+}
+
+// msgpackFromJSON encodes a value with its own JSON encoding, such as the
+// ordered GraphQL results
+func msgpackFromJSON(buf *bytes.Buffer, m json.Marshaler) error {
+	data, err := m.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var v interface{}
+	if err := decoder.Decode(&v); err != nil {
+		return err
+	}
+	return encodeMsgpack(buf, reflect.ValueOf(jsonNumbers(v)))
+}
+
+// jsonNumbers turns the json.Numbers of a decoded value into int64 or
+// float64
+func jsonNumbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case []interface{}:
+		for i := range v {
+			v[i] = jsonNumbers(v[i])
+		}
+	case map[string]interface{}:
+		for k := range v {
+			v[k] = jsonNumbers(v[k])
+		}
+	}
+	return v
+}
+
+// msgpackHeader writes the length of an array or map in its shortest form
+func msgpackHeader(buf *bytes.Buffer, n int, fix, b16, b32 byte) {
+	switch {
+	case n < 16:
+		buf.WriteByte(fix | byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(b16)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(b32)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+func msgpackString(buf *bytes.Buffer, s string) {
+	switch n := len(s); {
+	case n < 32:
+		buf.WriteByte(0xa0 | byte(n))
+	case n <= math.MaxUint8:
+		buf.WriteByte(0xd9)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(0xda)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(0xdb)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+	buf.WriteString(s)
+}
+
+func msgpackBinary(buf *bytes.Buffer, v reflect.Value) {
+	b := make([]byte, v.Len())
+	reflect.Copy(reflect.ValueOf(b), v)
+	switch n := len(b); {
+	case n <= math.MaxUint8:
+		buf.WriteByte(0xc4)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(0xc5)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(0xc6)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+	buf.Write(b)
+}
+
+func msgpackInt(buf *bytes.Buffer, n int64) {
+	switch {
+	case n >= 0:
+		msgpackUint(buf, uint64(n))
+	case n >= -32:
+		buf.WriteByte(byte(int8(n)))
+	case n >= math.MinInt8:
+		buf.WriteByte(0xd0)
+		buf.WriteByte(byte(int8(n)))
+	case n >= math.MinInt16:
+		buf.WriteByte(0xd1)
+		binary.Write(buf, binary.BigEndian, int16(n))
+	case n >= math.MinInt32:
+		buf.WriteByte(0xd2)
+		binary.Write(buf, binary.BigEndian, int32(n))
+	default:
+		buf.WriteByte(0xd3)
+		binary.Write(buf, binary.BigEndian, n)
+	}
+}
+
+func msgpackUint(buf *bytes.Buffer, n uint64) {
+	switch {
+	case n < 128:
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint8:
+		buf.WriteByte(0xcc)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(0xcd)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	case n <= math.MaxUint32:
+		buf.WriteByte(0xce)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	default:
+		buf.WriteByte(0xcf)
+		binary.Write(buf, binary.BigEndian, n)
+	}
+}
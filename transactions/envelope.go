@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// apiVersionPrefix starts the paths of the versioned API. Its responses are
+// wrapped in an Envelope; the unversioned paths keep their bare bodies for
+// existing clients.
+const apiVersionPrefix = "/v1"
+
+// Envelope wraps every response of the versioned API. Either Data or Error
+// is set.
+type Envelope struct {
+	Data  interface{}    `json:"data"`
+	Error *ErrorResponse `json:"error"`
+	Meta  Meta           `json:"meta"`
+}
+
+// Meta describes a versioned API response
+type Meta struct {
+	Version string `json:"version"`
+	// Pagination is set for responses listing a page of a longer list
+	Pagination *Pagination `json:"pagination,omitempty"`
+}
+
+// Pagination locates a page in a list. Next is the path of the following
+// page, empty on the last one.
+type Pagination struct {
+	From  int    `json:"from"`
+	Limit int    `json:"limit"`
+	Count int    `json:"count"`
+	Total int    `json:"total"`
+	Next  string `json:"next,omitempty"`
+}
+
+// versioned tells whether a request is for the versioned API
+func versioned(r *http.Request) bool {
+	return r.URL.Path == apiVersionPrefix || strings.HasPrefix(r.URL.Path, apiVersionPrefix+"/")
+}
+
+// unversioned strips the version prefix off a route template, giving the
+// route's name in apiDocs, deprecations and the auth rules
+func unversioned(template string) string {
+	if path, ok := strings.CutPrefix(template, apiVersionPrefix+"/"); ok {
+		return "/" + path
+	}
+	return template
+}
+
+// envelope wraps the payload of a versioned response
+func envelope(payload interface{}, page *Pagination) Envelope {
+	e := Envelope{Meta: Meta{Version: strings.TrimPrefix(apiVersionPrefix, "/"), Pagination: page}}
+	if err, ok := payload.(ErrorResponse); ok {
+		e.Error = &err
+	} else {
+		e.Data = payload
+	}
+	return e
+}
+
+// nextPage returns the path of the page after the one at from with count
+// items, stepping by step, or "" if there's none
+func nextPage(r *http.Request, from, limit, count, step, total int) string {
+	next := from + step*count
+	if limit == 0 || count < limit || next < 0 || next >= total {
+		return ""
+	}
+	u := *r.URL
+	q := u.Query()
+	q.Set("from", strconv.Itoa(next))
+	u.RawQuery = q.Encode()
+	return u.RequestURI()
+}
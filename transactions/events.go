@@ -0,0 +1,144 @@
+package main
+
+import (
+	"sync"
+)
+
+// EventType names a kind of chain event; it's the Type clients of the
+// WebSocket and event stream see
+type EventType string
+
+// chain event types
+const (
+	// BlockConnected carries a *Block that joined the active chain
+	BlockConnected EventType = "newBlock"
+	// TxAdmitted carries a *Transaction admitted to the mempool
+	TxAdmitted EventType = "newTransaction"
+	// Reorg carries a ReorgEvent, published before the BlockConnected
+	// events of the new branch
+	Reorg EventType = "reorg"
+	// ForkBlock carries a ForkEvent for a block stored on a side branch
+	ForkBlock EventType = "forkBlock"
+)
+
+// eventBuffer is how many events a subscriber may fall behind before it is
+// dropped
+const eventBuffer = 256
+
+// ChainEvent is pushed to subscribers when the chain or mempool changes
+type ChainEvent struct {
+	Type EventType
+	Data interface{}
+}
+
+// Block returns the block of a BlockConnected event
+func (e ChainEvent) Block() (*Block, bool) {
+	b, ok := e.Data.(*Block)
+	return b, ok && e.Type == BlockConnected
+}
+
+// Transaction returns the transaction of a TxAdmitted event
+func (e ChainEvent) Transaction() (*Transaction, bool) {
+	tx, ok := e.Data.(*Transaction)
+	return tx, ok && e.Type == TxAdmitted
+}
+
+// Fork returns the side branch of a ForkBlock event
+func (e ChainEvent) Fork() (ForkEvent, bool) {
+	fork, ok := e.Data.(ForkEvent)
+	return fork, ok && e.Type == ForkBlock
+}
+
+// Reorg returns the description of a Reorg event
+func (e ChainEvent) Reorg() (ReorgEvent, bool) {
+	reorg, ok := e.Data.(ReorgEvent)
+	return reorg, ok && e.Type == Reorg
+}
+
+// ReorgEvent describes a switch of the active chain to another branch
+type ReorgEvent struct {
+	ForkHeight   int
+	Disconnected []string
+	Connected    []string
+}
+
+// EventHub is the in-process bus of chain events. The chain and the mempool
+// publish to it; the miner, the archive, the metrics, the caches and the
+// WebSocket, event stream, gRPC and webhook layers consume from it instead
+// of being called by them.
+type EventHub struct {
+	sync.Mutex
+	// subs maps each subscriber to the types it wants, nil for all
+	subs map[chan ChainEvent][]EventType
+}
+
+var chainEvents = &EventHub{subs: make(map[chan ChainEvent][]EventType)}
+
+// Subscribe returns a channel receiving the events of the given types from
+// now on, or every event without types. The channel is closed if the
+// subscriber falls too far behind.
+func (h *EventHub) Subscribe(types ...EventType) chan ChainEvent {
+	h.Lock()
+	defer h.Unlock()
+	ch := make(chan ChainEvent, eventBuffer)
+	h.subs[ch] = types
+	return ch
+}
+
+// Unsubscribe stops delivering events to ch and closes it
+func (h *EventHub) Unsubscribe(ch chan ChainEvent) {
+	h.Lock()
+	defer h.Unlock()
+	if _, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// Handle calls handle with the events of the given types, one at a time on
+// a goroutine of its own, for as long as the node runs. A handler that
+// falls too far behind skips the events it missed.
+func (h *EventHub) Handle(name string, handle func(ChainEvent), types ...EventType) {
+	events := h.Subscribe(types...)
+	go func() {
+		for {
+			e, ok := <-events
+			if !ok {
+				storageLog.Warn("Chain event handler fell behind, some events were skipped", "handler", name)
+				events = h.Subscribe(types...)
+				continue
+			}
+			handle(e)
+		}
+	}()
+}
+
+// publish delivers an event without ever blocking the caller, which may hold
+// the chain lock
+func (h *EventHub) publish(eventType EventType, data interface{}) {
+	h.Lock()
+	defer h.Unlock()
+	for ch, types := range h.subs {
+		if !wantsEvent(types, eventType) {
+			continue
+		}
+		select {
+		case ch <- ChainEvent{eventType, data}:
+		default:
+			delete(h.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func wantsEvent(types []EventType, eventType EventType) bool {
+	if len(types) == 0 {
+		return true
+	}
+	for _, t := range types {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
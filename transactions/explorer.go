@@ -0,0 +1,26 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// explorerFiles is the block explorer, a single page on the /v1 API
+//
+//go:embed explorer
+var explorerFiles embed.FS
+
+// explorerHandler serves the explorer below /explorer/
+func explorerHandler() http.Handler {
+	files, err := fs.Sub(explorerFiles, "explorer")
+	if err != nil {
+		panic(err)
+	}
+	return http.StripPrefix("/explorer/", http.FileServer(http.FS(files)))
+}
+
+// sends /explorer to the explorer's page
+func handleExplorerRedirect(w http.ResponseWriter, r *http.Request) {
+	http.Redirect(w, r, "/explorer/", http.StatusMovedPermanently)
+}
@@ -0,0 +1,154 @@
+// A single-page explorer on the node's /v1 JSON API. Pages are picked by the
+// URL fragment: #/, #/block/{hash}, #/tx/{txid} and #/address/{address}.
+"use strict";
+
+const view = document.getElementById("view");
+const recentBlocks = 20;
+
+async function api(path) {
+  const resp = await fetch("/v1" + path, { headers: { Accept: "application/json" } });
+  const body = await resp.json();
+  if (body.error) {
+    throw new Error(body.error.message);
+  }
+  return body.data;
+}
+
+async function graphql(query, variables) {
+  const resp = await fetch("/graphql", {
+    method: "POST",
+    headers: { "Content-Type": "application/json" },
+    body: JSON.stringify({ query, variables }),
+  });
+  const body = await resp.json();
+  if (body.errors && body.errors.length) {
+    throw new Error(body.errors[0].message);
+  }
+  return body.data;
+}
+
+function esc(s) {
+  return String(s).replace(/[&<>"']/g, (c) => "&#" + c.charCodeAt(0) + ";");
+}
+
+function link(kind, id, text) {
+  return `<a class="hash" href="#/${kind}/${encodeURIComponent(id)}">${esc(text || id)}</a>`;
+}
+
+function miner(block) {
+  const coinbase = block.Transactions && block.Transactions[0];
+  return coinbase && coinbase.Vout.length ? coinbase.Vout[0].ScriptPubKey : "";
+}
+
+function isCoinbase(tx) {
+  return tx.Vin.length === 1 && tx.Vin[0].Txid === "" && tx.Vin[0].Vout === -1;
+}
+
+function details(rows) {
+  return "<dl>" + rows.map(([k, v]) => `<dt>${esc(k)}</dt><dd>${v}</dd>`).join("") + "</dl>";
+}
+
+function txTable(txs) {
+  const rows = txs.map((tx) => {
+    const inputs = isCoinbase(tx)
+      ? '<span class="muted">coinbase</span>'
+      : tx.Vin.map((i) => link("tx", i.Txid, i.Txid.slice(0, 16) + "…:" + i.Vout)).join("<br>");
+    const outputs = tx.Vout.map((o) => `${o.Value} → ${link("address", o.ScriptPubKey)}`).join("<br>");
+    return `<tr><td>${link("tx", tx.ID)}</td><td>${inputs}</td><td>${outputs}</td></tr>`;
+  });
+  return `<table><tr><th>Transaction</th><th>Inputs</th><th>Outputs</th></tr>${rows.join("")}</table>`;
+}
+
+async function showRecent() {
+  const [tip, blocks] = await Promise.all([api("/height"), api(`/?order=desc&limit=${recentBlocks}`)]);
+  const rows = blocks.map((b, i) => `<tr>
+    <td>${link("block", b.Hash, tip.Height - i)}</td>
+    <td>${link("block", b.Hash)}</td>
+    <td>${esc(b.Timestamp)}</td>
+    <td>${b.Transactions.length}</td>
+    <td>${miner(b) ? link("address", miner(b)) : ""}</td></tr>`);
+  view.innerHTML = `<h2>Recent blocks</h2>
+    <table><tr><th>Height</th><th>Hash</th><th>Time</th><th>Txs</th><th>Miner</th></tr>${rows.join("")}</table>`;
+}
+
+async function showBlock(hash) {
+  const b = await api("/block/" + encodeURIComponent(hash));
+  view.innerHTML = `<h2>Block ${b.Height}</h2>` + details([
+    ["Hash", `<span class="hash">${esc(b.Hash)}</span>`],
+    ["Previous", b.PrevHash ? link("block", b.PrevHash) : '<span class="muted">genesis</span>'],
+    ["Confirmations", b.Confirmations < 0 ? "off the active chain" : b.Confirmations],
+    ["Time", esc(b.Timestamp)],
+    ["Nonce", b.Nonce],
+    ["Miner", miner(b) ? link("address", miner(b)) : ""],
+  ]) + `<h3>${b.Transactions.length} transactions</h3>` + txTable(b.Transactions);
+}
+
+async function showTransaction(txid) {
+  let tx, block;
+  try {
+    const info = await api("/tx/" + encodeURIComponent(txid));
+    tx = info.Transaction;
+    block = link("block", info.BlockHash);
+  } catch (err) {
+    const pooled = await api("/mempool/" + encodeURIComponent(txid)).catch(() => { throw err; });
+    tx = pooled.Transaction;
+    block = `<span class="muted">in the mempool, fee ${pooled.Fee}</span>`;
+  }
+  const total = tx.Vout.reduce((sum, o) => sum + o.Value, 0);
+  view.innerHTML = "<h2>Transaction</h2>" + details([
+    ["ID", `<span class="hash">${esc(tx.ID)}</span>`],
+    ["Block", block],
+    ["Output total", total],
+  ]) + txTable([tx]);
+}
+
+async function showAddress(address) {
+  const [balance, data] = await Promise.all([
+    api("/balance/" + encodeURIComponent(address)),
+    graphql("query($a: String!) { address(address: $a) { unspent { index value transaction { id } } } }", { a: address }),
+  ]);
+  const rows = data.address.unspent.map((o) =>
+    `<tr><td>${link("tx", o.transaction.id)}</td><td>${o.index}</td><td>${o.value}</td></tr>`);
+  view.innerHTML = "<h2>Address</h2>" + details([
+    ["Address", `<span class="hash">${esc(address)}</span>`],
+    ["Confirmed", balance.Confirmed],
+    ["Unconfirmed", balance.Unconfirmed],
+    ["Immature", balance.Immature],
+  ]) + `<h3>${balance.UTXOCount} unspent outputs</h3>
+    <table><tr><th>Transaction</th><th>Output</th><th>Value</th></tr>${rows.join("")}</table>`;
+}
+
+const pages = { block: showBlock, tx: showTransaction, address: showAddress };
+
+async function route() {
+  const [, kind, id] = location.hash.split("/");
+  view.innerHTML = '<p class="muted">Loading…</p>';
+  try {
+    if (pages[kind] && id) {
+      await pages[kind](decodeURIComponent(id));
+    } else {
+      await showRecent();
+    }
+  } catch (err) {
+    view.innerHTML = `<p class="error">${esc(err.message)}</p>`;
+  }
+}
+
+document.getElementById("search").addEventListener("submit", async (e) => {
+  e.preventDefault();
+  const q = e.target.q.value.trim();
+  if (!q) {
+    return;
+  }
+  try {
+    const r = await api("/search?q=" + encodeURIComponent(q));
+    const kinds = { block: ["block", r.Block && r.Block.Hash], transaction: ["tx", r.Transaction && r.Transaction.Transaction.ID], address: ["address", r.Address && r.Address.Address] };
+    const [kind, id] = kinds[r.Type];
+    location.hash = `#/${kind}/${encodeURIComponent(id)}`;
+  } catch (err) {
+    view.innerHTML = `<p class="error">${esc(err.message)}</p>`;
+  }
+});
+
+window.addEventListener("hashchange", route);
+route();
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/VOOVOOZEL/go_blockchain/transactions/sdk"
+)
+
+const (
+	// defaultFaucetAmount is the most one faucet request pays
+	defaultFaucetAmount = 5
+	// defaultFaucetInterval is how long an address waits between payments
+	defaultFaucetInterval = 24 * time.Hour
+)
+
+// Faucet hands out test coins from its address on networks other than main,
+// paying each address at most once per interval. The address is a key
+// address whose key the faucet holds, so its coins can only be spent by
+// payouts the faucet signs.
+type Faucet struct {
+	sync.Mutex
+	address  string
+	key      *sdk.ExtendedKey
+	amount   Amount
+	interval time.Duration
+	paid     map[string]time.Time
+	// claimed holds the outputs spent by payouts still being mined
+	claimed map[outpoint]bool
+}
+
+// faucet is nil unless FAUCET_WALLET is set
+var faucet *Faucet
+
+// FaucetMessage asks the faucet for coins; Amount defaults to the most it pays
+type FaucetMessage struct {
+	Address string `validate:"required,address"`
+	Amount  Amount `validate:"min=0"`
+}
+
+// loadFaucetConfig reads FAUCET_WALLET, a wallet file as "wallet create"
+// writes whose key signs the payouts, FAUCET_AMOUNT and FAUCET_INTERVAL
+func loadFaucetConfig() {
+	path := os.Getenv("FAUCET_WALLET")
+	if path == "" {
+		return
+	}
+	if params.Name == "main" {
+		log.Fatal("FAUCET_WALLET is for test networks only, set NETWORK")
+	}
+	w, err := readWallet(path)
+	if err != nil {
+		log.Fatalf("FAUCET_WALLET: %v", err)
+	}
+	key, err := sdk.ParseExtendedKey(w.Key)
+	if err != nil || !key.IsPrivate() {
+		log.Fatalf("FAUCET_WALLET: %s holds no private key", path)
+	}
+	address := key.Address()
+	amount := Amount(defaultFaucetAmount)
+	if v := os.Getenv("FAUCET_AMOUNT"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 1 || Amount(n) > maxMoney {
+			log.Fatalf("FAUCET_AMOUNT must be a positive integer up to %d, got %q", maxMoney, v)
+		}
+		amount = Amount(n)
+	}
+	faucet = &Faucet{
+		address:  address,
+		key:      key,
+		amount:   amount,
+		interval: envDuration("FAUCET_INTERVAL", defaultFaucetInterval),
+		paid:     make(map[string]time.Time),
+		claimed:  make(map[outpoint]bool),
+	}
+	log.Printf("Faucet paying up to %d from %s every %v per address", amount, address, faucet.interval)
+}
+
+// reserve records a payment to address unless it had one within the
+// interval, in which case it returns how long to wait
+func (f *Faucet) reserve(address string, now time.Time) (bool, time.Duration) {
+	f.Lock()
+	defer f.Unlock()
+
+	for a, t := range f.paid {
+		if now.Sub(t) >= f.interval {
+			delete(f.paid, a)
+		}
+	}
+	if t, ok := f.paid[address]; ok {
+		return false, t.Add(f.interval).Sub(now)
+	}
+	f.paid[address] = now
+	return true, 0
+}
+
+// payout builds and signs a payment of amount to address. Outputs that
+// earlier payouts still being mined or mempool transactions spend are left
+// alone, so concurrent requests don't spend the same coins; they stay
+// claimed until settle.
+func (f *Faucet) payout(address string, amount Amount) (*Transaction, error) {
+	f.Lock()
+	defer f.Unlock()
+
+	skip := make(map[outpoint]bool, len(f.claimed))
+	for op := range f.claimed {
+		skip[op] = true
+	}
+	for _, pooled := range bc.mempool.Transactions() {
+		for _, in := range pooled.Vin {
+			skip[outpoint{in.Txid, in.Vout}] = true
+		}
+	}
+	tx, err := newSpend(f.address, address, amount, &bc, skip)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.sign(f.key); err != nil {
+		return nil, err
+	}
+	for _, in := range tx.Vin {
+		f.claimed[outpoint{in.Txid, in.Vout}] = true
+	}
+	return tx, nil
+}
+
+// settle frees the outputs of a payout once it's mined or failed, and lets
+// address ask again if it failed
+func (f *Faucet) settle(tx *Transaction, address string, err error) {
+	f.Lock()
+	defer f.Unlock()
+	for _, in := range tx.Vin {
+		delete(f.claimed, outpoint{in.Txid, in.Vout})
+	}
+	if err != nil {
+		delete(f.paid, address)
+	}
+}
+
+// release forgets a payment that didn't happen
+func (f *Faucet) release(address string) {
+	f.Lock()
+	defer f.Unlock()
+	delete(f.paid, address)
+}
+
+// pays test coins to an address, queued to be mined like POST /
+func handleFaucet(w http.ResponseWriter, r *http.Request) {
+	var m FaucetMessage
+	if err := decodeRequest(r, &m); err != nil {
+		respondWithRequestError(w, r, err)
+		return
+	}
+	if m.Amount == 0 {
+		m.Amount = faucet.amount
+	}
+	if m.Amount > faucet.amount {
+		respondWithError(w, r, http.StatusUnprocessableEntity, fmt.Sprintf("ERROR: The faucet pays at most %d", faucet.amount))
+		return
+	}
+	if m.Address == faucet.address {
+		respondWithError(w, r, http.StatusUnprocessableEntity, "ERROR: The faucet can't pay itself")
+		return
+	}
+
+	if ok, wait := faucet.reserve(m.Address, time.Now()); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		respondWithError(w, r, http.StatusTooManyRequests, fmt.Sprintf("ERROR: %s was paid recently, retry later", m.Address))
+		return
+	}
+	tx, err := faucet.payout(m.Address, m.Amount)
+	if err != nil {
+		faucet.release(m.Address)
+		if errors.Is(err, errNotEnoughFunds) {
+			respondWithError(w, r, http.StatusServiceUnavailable, "ERROR: The faucet is empty, or its coins wait on payouts being mined")
+		} else {
+			respondWithError(w, r, http.StatusInternalServerError, err.Error())
+		}
+		return
+	}
+
+	mineTransactionThen(w, r, tx, func(err error) { faucet.settle(tx, m.Address, err) })
+}
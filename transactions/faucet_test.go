@@ -0,0 +1,45 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/VOOVOOZEL/go_blockchain/transactions/sdk"
+)
+
+func TestFaucetPayouts(t *testing.T) {
+	key, err := sdk.NewMasterKey([]byte("faucet test seed, 32 bytes long."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := testChain(t, testBlock(testCoinbase(key.Address(), "1")), testBlock(testCoinbase(key.Address(), "2")))
+	utxo, blocks, txIndex, mempool := bc.utxo, bc.blocks, bc.txIndex, bc.mempool
+	defer func() { bc.utxo, bc.blocks, bc.txIndex, bc.mempool = utxo, blocks, txIndex, mempool }()
+	bc.utxo, bc.blocks, bc.txIndex, bc.mempool = c.utxo, c.blocks, c.txIndex, c.mempool
+
+	f := &Faucet{address: key.Address(), key: key, amount: 5, interval: time.Hour, paid: make(map[string]time.Time), claimed: make(map[outpoint]bool)}
+	first, err := f.payout("alice", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := f.payout("bob", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Vin[0].Txid == second.Vin[0].Txid {
+		t.Fatal("concurrent payouts spend the same output")
+	}
+	if _, err := f.payout("carol", 5); !errors.Is(err, errNotEnoughFunds) {
+		t.Fatalf("third payout with every output claimed: %v", err)
+	}
+
+	f.reserve("alice", time.Now())
+	f.settle(first, "alice", errors.New("mining failed"))
+	if ok, _ := f.reserve("alice", time.Now()); !ok {
+		t.Error("failed payout still holds the reservation")
+	}
+	if _, err := f.payout("carol", 5); err != nil {
+		t.Errorf("payout after a failed one freed its output: %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VOOVOOZEL/go_blockchain/transactions/sdk"
+	"github.com/gorilla/mux"
+)
+
+const federationTimeout = 10 * time.Second
+
+// FederatedNode is one node the gateway aggregates reads from
+type FederatedNode struct {
+	Name   string
+	URL    string
+	client *sdk.Client
+}
+
+// FederatedHeight is one node's answer to a height query
+type FederatedHeight struct {
+	Node    string
+	Height  int
+	TipHash string
+	Error   string `json:",omitempty"`
+}
+
+// FederatedBalance is one node's answer to a balance query
+type FederatedBalance struct {
+	Node    string
+	Balance int
+	Error   string `json:",omitempty"`
+}
+
+// FederatedBalanceReport combines the balance of an address across chains
+type FederatedBalanceReport struct {
+	Address string
+	Total   int
+	Nodes   []FederatedBalance
+}
+
+var federation []*FederatedNode
+
+// loadFederationConfig reads FEDERATION_NODES, a comma separated list of
+// name=url entries. With nodes configured the /federation API is served.
+func loadFederationConfig() {
+	for _, entry := range splitList(os.Getenv("FEDERATION_NODES")) {
+		name, url, ok := strings.Cut(entry, "=")
+		if !ok || name == "" || url == "" {
+			log.Fatalf("invalid federation node %q, want name=url", entry)
+		}
+		federation = append(federation, &FederatedNode{name, url, sdk.NewClient(url)})
+	}
+}
+
+// fanOut calls query for every federated node concurrently
+func fanOut(r *http.Request, query func(ctx context.Context, i int, n *FederatedNode)) {
+	ctx, cancel := context.WithTimeout(r.Context(), federationTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i, n := range federation {
+		wg.Add(1)
+		go func(i int, n *FederatedNode) {
+			defer wg.Done()
+			query(ctx, i, n)
+		}(i, n)
+	}
+	wg.Wait()
+}
+
+// reports the chain height of every federated node
+func handleFederationHeights(w http.ResponseWriter, r *http.Request) {
+	heights := make([]FederatedHeight, len(federation))
+	fanOut(r, func(ctx context.Context, i int, n *FederatedNode) {
+		heights[i] = FederatedHeight{Node: n.Name, Height: -1}
+		h, err := n.client.Height(ctx)
+		if err != nil {
+			heights[i].Error = err.Error()
+			return
+		}
+		heights[i].Height, heights[i].TipHash = h.Height, h.TipHash
+	})
+
+	respondWithJSON(w, r, http.StatusOK, heights)
+}
+
+// reports the balance of an address on every federated node and the total
+func handleFederationBalance(w http.ResponseWriter, r *http.Request) {
+	address := mux.Vars(r)["address"]
+	report := FederatedBalanceReport{Address: address, Nodes: make([]FederatedBalance, len(federation))}
+	fanOut(r, func(ctx context.Context, i int, n *FederatedNode) {
+		report.Nodes[i] = FederatedBalance{Node: n.Name}
+		balance, err := n.client.Balance(ctx, address)
+		if err != nil {
+			report.Nodes[i].Error = err.Error()
+			return
+		}
+		report.Nodes[i].Balance = balance
+	})
+	for _, n := range report.Nodes {
+		report.Total += n.Balance
+	}
+
+	respondWithJSON(w, r, http.StatusOK, report)
+}
+
+// lists the configured federation
+func handleFederationNodes(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, r, http.StatusOK, federation)
+}
+
+// reports the height and tip of the active chain
+func handleGetHeight(w http.ResponseWriter, r *http.Request) {
+	bc.RLock()
+	height := sdk.ChainHeight{Height: len(bc.blocks) - 1, TipHash: bc.blocks[len(bc.blocks)-1].Hash}
+	bc.RUnlock()
+
+	respondWithJSON(w, r, http.StatusOK, height)
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+)
+
+const (
+	// feeEstimateWindow is how many recent blocks the fee estimate looks at
+	feeEstimateWindow = 20
+	// maxFeeTarget is the furthest confirmation target estimated
+	maxFeeTarget = 100
+)
+
+// FeeEstimate recommends a fee rate, per byte of the transaction's
+// encoding, for confirmation within Target blocks. It is the higher of the
+// rate recent transactions paid, at a percentile falling from the median as
+// the target grows, and the rate outbidding the mempool transactions that
+// fill the next Target blocks of typical size.
+type FeeEstimate struct {
+	Target  int
+	FeeRate int
+	// HistoryRate and MempoolRate are the two rates considered
+	HistoryRate int
+	MempoolRate int
+	// Blocks is how many recent blocks were looked at, and BlockBytes their
+	// average size
+	Blocks       int
+	BlockBytes   int
+	MempoolCount int
+	MempoolBytes int
+}
+
+// feeRate is the fee paid by one transaction per byte
+type feeRate struct {
+	fee  Amount
+	size int
+}
+
+func (f feeRate) rate() float64 {
+	if f.size == 0 {
+		return 0
+	}
+	return float64(f.fee) / float64(f.size)
+}
+
+// recentFeeRates returns the fee rates of the non-coinbase transactions of
+// the last feeEstimateWindow active chain blocks, and the blocks' sizes
+func (bc *Blockchain) recentFeeRates() (rates []feeRate, blockSizes []int) {
+	bc.RLock()
+	start := len(bc.blocks) - feeEstimateWindow
+	if start < 1 {
+		start = 1
+	}
+	blocks := append([]*Block(nil), bc.blocks[start:]...)
+	bc.RUnlock()
+
+	// outputs spent within the window needn't be looked up
+	outputs := make(map[string][]TXOutput)
+	for _, b := range blocks {
+		for _, tx := range b.Transactions {
+			outputs[tx.ID] = tx.Vout
+		}
+	}
+	spent := func(in TXInput) (Amount, bool) {
+		vout, ok := outputs[in.Txid]
+		if !ok {
+			info, err := bc.GetTransaction(in.Txid)
+			if err != nil {
+				return 0, false
+			}
+			vout = info.Transaction.Vout
+			outputs[in.Txid] = vout
+		}
+		if in.Vout < 0 || in.Vout >= len(vout) {
+			return 0, false
+		}
+		return vout[in.Vout].Value, true
+	}
+
+	for _, b := range blocks {
+		blockSize := 0
+		for _, tx := range b.Transactions {
+			size := txSize(tx)
+			blockSize += size
+			if tx.IsCoinbase() {
+				continue
+			}
+			fee, known := Amount(0), true
+			for _, in := range tx.Vin {
+				value, ok := spent(in)
+				known = known && ok
+				fee += value
+			}
+			for _, out := range tx.Vout {
+				fee -= out.Value
+			}
+			if known {
+				rates = append(rates, feeRate{fee, size})
+			}
+		}
+		blockSizes = append(blockSizes, blockSize)
+	}
+	return rates, blockSizes
+}
+
+// estimateFee recommends a fee rate for confirmation within target blocks
+func (bc *Blockchain) estimateFee(target int) FeeEstimate {
+	rates, blockSizes := bc.recentFeeRates()
+	entries := bc.mempoolEntries()
+	estimate := FeeEstimate{Target: target, Blocks: len(blockSizes), MempoolCount: len(entries)}
+
+	for _, size := range blockSizes {
+		estimate.BlockBytes += size
+	}
+	if len(blockSizes) > 0 {
+		estimate.BlockBytes /= len(blockSizes)
+	}
+
+	if len(rates) > 0 {
+		sort.Slice(rates, func(i, j int) bool { return rates[i].rate() < rates[j].rate() })
+		// the median for the next block, down to the 10th percentile
+		percentile := 60 - 10*target
+		if percentile < 10 {
+			percentile = 10
+		}
+		estimate.HistoryRate = int(math.Ceil(rates[(len(rates)-1)*percentile/100].rate()))
+	}
+
+	pooled := make([]feeRate, len(entries))
+	for i, e := range entries {
+		pooled[i] = feeRate{e.Fee, e.Size}
+		estimate.MempoolBytes += e.Size
+	}
+	if estimate.BlockBytes > 0 {
+		sort.Slice(pooled, func(i, j int) bool { return pooled[i].rate() > pooled[j].rate() })
+		room := target * estimate.BlockBytes
+		for _, p := range pooled {
+			if room -= p.size; room < 0 {
+				estimate.MempoolRate = int(math.Floor(p.rate())) + 1
+				break
+			}
+		}
+	}
+
+	estimate.FeeRate = estimate.HistoryRate
+	if estimate.MempoolRate > estimate.FeeRate {
+		estimate.FeeRate = estimate.MempoolRate
+	}
+	return estimate
+}
+
+// recommends a fee rate for confirmation within ?target= blocks, 1 by
+// default
+func handleGetFeeEstimate(w http.ResponseWriter, r *http.Request) {
+	target := 1
+	if v := r.URL.Query().Get("target"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxFeeTarget {
+			respondWithError(w, r, http.StatusBadRequest, "target must be a number of blocks between 1 and "+strconv.Itoa(maxFeeTarget))
+			return
+		}
+		target = n
+	}
+	respondWithJSON(w, r, http.StatusOK, bc.estimateFee(target))
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/VOOVOOZEL/go_blockchain/transactions/wire"
+)
+
+// seenCacheSize bounds how many block and transaction IDs are remembered
+const seenCacheSize = 10000
+
+// seenCache remembers recently relayed items so gossip doesn't loop
+type seenCache struct {
+	sync.Mutex
+	set   map[string]bool
+	order []string
+}
+
+var (
+	seenBlocks = &seenCache{set: make(map[string]bool)}
+	seenTxs    = &seenCache{set: make(map[string]bool)}
+)
+
+// markSeen records id and reports whether it was new
+func (c *seenCache) markSeen(id string) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.set[id] {
+		return false
+	}
+	c.set[id] = true
+	c.order = append(c.order, id)
+	if len(c.order) > seenCacheSize {
+		delete(c.set, c.order[0])
+		c.order = c.order[1:]
+	}
+	return true
+}
+
+const (
+	// maxAnnouncedBlocks caps the announced blocks a peer may have us
+	// waiting for
+	maxAnnouncedBlocks = 16
+	// announceTimeout is how long a peer has to send a block we asked for
+	announceTimeout = time.Minute
+)
+
+// has reports whether id was seen
+func (c *seenCache) has(id string) bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.set[id]
+}
+
+// publish sends block and transaction announcements to the pubsub topics
+// when the libp2p transport is running
+var publish = func(command string, payload interface{}) {}
+
+// relayBlock announces a newly accepted block to every peer except from.
+// Peers that are in sync get it straight away as a compact block, the others
+// an inv to fetch it with.
+func relayBlock(b *Block, from *Peer) {
+	if !seenBlocks.markSeen(b.Hash) {
+		return
+	}
+	bc.RLock()
+	height := bc.blockHeight(b)
+	bc.RUnlock()
+	compact := newCompactBlock(b)
+	inv := wire.InvMessage{Items: wire.NewInv(wire.InvBlock, b.Hash)}
+
+	peerManager.Lock()
+	for p := range peerManager.peers {
+		if p == from || p.pubsub {
+			continue
+		}
+		if p.wantsCompact(height) {
+			go p.send(wire.CmdCmpctBlock, compact)
+		} else {
+			go p.send(wire.CmdInv, inv)
+		}
+	}
+	peerManager.Unlock()
+
+	publish(wire.CmdBlock, b)
+}
+
+// relayTransaction announces a newly admitted transaction to every peer
+// except from
+func relayTransaction(tx *Transaction, from *Peer) {
+	if !seenTxs.markSeen(tx.ID) {
+		return
+	}
+	inv := wire.InvMessage{Items: wire.NewInv(wire.InvTx, tx.ID)}
+
+	peerManager.Lock()
+	for p := range peerManager.peers {
+		if p != from && !p.pubsub && p.wantsTx(tx) {
+			go p.send(wire.CmdInv, inv)
+		}
+	}
+	peerManager.Unlock()
+
+	publish(wire.CmdTx, tx)
+}
+
+// receiveInv asks for the announced blocks and transactions we don't have.
+// A light client catches up on headers instead.
+func (p *Peer) receiveInv(m *wire.InvMessage) error {
+	if len(m.Items) > wire.MaxInvItems {
+		return fmt.Errorf("announced %d items, more than %d", len(m.Items), wire.MaxInvItems)
+	}
+
+	var want []wire.InvVect
+	for _, item := range m.Items {
+		switch item.Type {
+		case wire.InvBlock:
+			if light != nil {
+				return p.send(wire.CmdGetHeaders, GetHeadersMessage{bc.headers.locator()})
+			}
+			if seenBlocks.has(item.Hash) || bc.hasBlock(item.Hash) {
+				continue
+			}
+			if p.expectBlock(item.Hash) {
+				want = append(want, item)
+			}
+		case wire.InvTx:
+			if light == nil && !seenTxs.has(item.Hash) && !bc.mempool.Has(item.Hash) {
+				want = append(want, item)
+			}
+		}
+	}
+	if len(want) == 0 {
+		return nil
+	}
+	return p.send(wire.CmdGetData, wire.GetDataMessage{Items: want})
+}
+
+// expectBlock notes that we are about to ask the peer for a block, unless it
+// already has maxAnnouncedBlocks outstanding
+func (p *Peer) expectBlock(hash string) bool {
+	p.Lock()
+	defer p.Unlock()
+	if p.announced == nil {
+		p.announced = make(map[string]time.Time)
+	}
+	if _, ok := p.announced[hash]; !ok && len(p.announced) >= maxAnnouncedBlocks {
+		return false
+	}
+	p.announced[hash] = time.Now().Add(announceTimeout)
+	return true
+}
+
+// takeAnnounced reports whether we asked for a block because the peer
+// announced it, and forgets that we did
+func (p *Peer) takeAnnounced(hash string) bool {
+	p.Lock()
+	defer p.Unlock()
+	_, announced := p.announced[hash]
+	delete(p.announced, hash)
+	return announced
+}
+
+// expireAnnounced disconnects the peers that didn't send a block we asked
+// for in time, counting it against them
+func (pm *PeerManager) expireAnnounced() {
+	now := time.Now()
+	pm.Lock()
+	defer pm.Unlock()
+	for p := range pm.peers {
+		p.Lock()
+		var overdue []string
+		for hash, deadline := range p.announced {
+			if now.After(deadline) {
+				overdue = append(overdue, hash)
+				delete(p.announced, hash)
+			}
+		}
+		p.Unlock()
+		if len(overdue) > 0 {
+			p2pLog.Warn("Disconnecting peer that didn't send announced blocks in time", "peer", p.addr, "blocks", len(overdue))
+			peerStats.recordInvalid(p)
+			p.conn.Close()
+		}
+	}
+}
+
+// receiveNotFound forgets the announced blocks the peer turned out not to
+// have
+func (p *Peer) receiveNotFound(m *wire.NotFoundMessage) {
+	for _, item := range m.Items {
+		if item.Type == wire.InvBlock {
+			p.takeAnnounced(item.Hash)
+		}
+	}
+}
+
+// sendData answers getdata: the blocks in one blocks message, each
+// transaction from the mempool in a tx message and the rest in a notfound
+func (p *Peer) sendData(m *wire.GetDataMessage) error {
+	if len(m.Items) > wire.MaxInvItems {
+		return fmt.Errorf("asked for %d items, more than %d", len(m.Items), wire.MaxInvItems)
+	}
+
+	var hashes []string
+	var notFound []wire.InvVect
+	for _, item := range m.Items {
+		switch item.Type {
+		case wire.InvBlock:
+			hashes = append(hashes, item.Hash)
+		case wire.InvTx:
+			if tx, ok := bc.mempool.Get(item.Hash); ok {
+				if err := p.send(wire.CmdTx, tx); err != nil {
+					return err
+				}
+			} else {
+				notFound = append(notFound, item)
+			}
+		default:
+			return fmt.Errorf("asked for an item of unknown type %d", item.Type)
+		}
+	}
+	if len(hashes) > maxBlocksPerMessage {
+		return fmt.Errorf("asked for %d blocks, more than %d", len(hashes), maxBlocksPerMessage)
+	}
+
+	if len(hashes) > 0 {
+		blocks := bc.blocksByHash(hashes)
+		found := make(map[string]bool, len(blocks))
+		for _, b := range blocks {
+			found[b.Hash] = true
+		}
+		for _, hash := range hashes {
+			if !found[hash] {
+				notFound = append(notFound, wire.InvVect{Type: wire.InvBlock, Hash: hash})
+			}
+		}
+		if err := p.send(wire.CmdBlocks, BlocksMessage{blocks}); err != nil {
+			return err
+		}
+	}
+	if len(notFound) > 0 {
+		return p.send(wire.CmdNotFound, wire.NotFoundMessage{Items: notFound})
+	}
+	return nil
+}
+
+// receiveBlock handles a block gossiped by a peer
+func (p *Peer) receiveBlock(b *Block) error {
+	if err := checkTransactionIDs(b); err != nil {
+		peerStats.recordInvalid(p)
+		return err
+	}
+	return p.acceptBlock(b, false)
+}
+
+// acceptBlock processes a block announced by a peer and relays it on.
+// verified says verifyBlocks checked it already.
+func (p *Peer) acceptBlock(b *Block, verified bool) error {
+	switch err := bc.processBlock(b, verified); err {
+	case nil:
+		peerStats.recordBlock(p)
+		relayBlock(b, p)
+	case errBlockKnown:
+		seenBlocks.markSeen(b.Hash)
+	case errOrphanBlock:
+		// we are missing its ancestors, catch up with this peer first
+		return p.send(wire.CmdGetHeaders, GetHeadersMessage{bc.headers.locator()})
+	default:
+		p2pLog.Warn("Rejected block", "hash", b.Hash, "peer", p.addr, "err", err)
+		peerStats.recordInvalid(p)
+	}
+	return nil
+}
+
+// receiveTransaction handles a transaction gossiped by a peer
+func (p *Peer) receiveTransaction(tx *Transaction) error {
+	id := tx.ID
+	tx.ID = ""
+	tx.SetID()
+	if tx.ID != id {
+		p2pLog.Warn("Transaction doesn't match its ID", "txid", id, "peer", p.addr)
+		peerStats.recordInvalid(p)
+		return nil
+	}
+
+	if err := bc.AcceptTransaction(context.Background(), tx); err != nil {
+		seenTxs.markSeen(tx.ID)
+		return nil
+	}
+	relayTransaction(tx, p)
+	return nil
+}
+
+// admits a client transaction to the mempool and gossips it, without mining
+func handleSubmitTransaction(w http.ResponseWriter, r *http.Request) {
+	var tx Transaction
+	if err := decodeRequest(r, &tx); err != nil {
+		respondWithRequestError(w, r, err)
+		return
+	}
+
+	if err := assignTransactionID(&tx); err != nil {
+		respondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := checkNodeFunds(&tx, apiAuth.authorized(r)); err != nil {
+		respondWithError(w, r, http.StatusForbidden, err.Error())
+		return
+	}
+	if err := bc.AcceptTransaction(r.Context(), &tx); err != nil {
+		respondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	relayTransaction(&tx, nil)
+
+	respondWithJSON(w, r, http.StatusAccepted, tx)
+}
@@ -0,0 +1,460 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// The node answers GraphQL queries without a GraphQL library: the parser
+// below takes the query subset explorers need, that is fields with
+// arguments, aliases, nested selections, variables and __typename.
+// Fragments, directives, mutations and introspection aren't supported; GET
+// /graphql/schema returns the schema instead.
+
+const (
+	// maxGraphQLDepth bounds how deeply selections may nest
+	maxGraphQLDepth = 12
+	// maxGraphQLFields bounds how many fields one query may resolve
+	maxGraphQLFields = 10000
+)
+
+// gqlField is one field of a selection set
+type gqlField struct {
+	Alias      string
+	Name       string
+	Args       map[string]interface{}
+	Selections []*gqlField
+}
+
+// gqlVariable refers to a variable in an argument, resolved at execution
+type gqlVariable string
+
+// gqlObject is a value with fields. gqlField resolves one of them, returning
+// a scalar, a gqlObject or a slice of gqlObjects.
+type gqlObject interface {
+	gqlTypeName() string
+	gqlField(name string, args map[string]interface{}) (interface{}, error)
+}
+
+// GraphQLRequest is the body of POST /graphql
+type GraphQLRequest struct {
+	Query         string
+	Variables     map[string]interface{}
+	OperationName string
+}
+
+// GraphQLError is an error of a GraphQL response
+type GraphQLError struct {
+	Message string        `json:"message"`
+	Path    []interface{} `json:"path,omitempty"`
+}
+
+// GraphQLResponse is the answer of /graphql
+type GraphQLResponse struct {
+	Data   *gqlResult     `json:"data,omitempty"`
+	Errors []GraphQLError `json:"errors,omitempty"`
+}
+
+// gqlResult keeps fields in the order they were asked for, as GraphQL
+// requires
+type gqlResult struct {
+	keys   []string
+	values []interface{}
+}
+
+func (r *gqlResult) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, key := range r.keys {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		k, _ := json.Marshal(key)
+		v, err := json.Marshal(r.values[i])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(k)
+		buf.WriteByte(':')
+		buf.Write(v)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// gqlParser is a recursive descent parser over the query text
+type gqlParser struct {
+	src string
+	pos int
+}
+
+func (p *gqlParser) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("syntax error at offset %d: %s", p.pos, fmt.Sprintf(format, a...))
+}
+
+// skip passes whitespace, commas and comments, which GraphQL ignores
+func (p *gqlParser) skip() {
+	for p.pos < len(p.src) {
+		c := p.src[p.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			p.pos++
+		case c == '#':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *gqlParser) peek() byte {
+	p.skip()
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *gqlParser) expect(c byte) error {
+	if p.peek() != c {
+		return p.errorf("expected %q", c)
+	}
+	p.pos++
+	return nil
+}
+
+func isNameChar(c byte, first bool) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || !first && c >= '0' && c <= '9'
+}
+
+func (p *gqlParser) name() (string, error) {
+	p.skip()
+	start := p.pos
+	for p.pos < len(p.src) && isNameChar(p.src[p.pos], p.pos == start) {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", p.errorf("expected a name")
+	}
+	return p.src[start:p.pos], nil
+}
+
+// document parses the operation to run: a bare selection set or a query,
+// picked by name when the document has several
+func (p *gqlParser) document(operationName string) ([]*gqlField, error) {
+	var chosen []*gqlField
+	found := false
+	for p.peek() != 0 {
+		name := ""
+		if p.peek() != '{' {
+			keyword, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			switch keyword {
+			case "query":
+			case "fragment":
+				return nil, p.errorf("fragments aren't supported")
+			default:
+				return nil, p.errorf("only queries are supported, not %s", keyword)
+			}
+			if c := p.peek(); c != '{' && c != '(' {
+				if name, err = p.name(); err != nil {
+					return nil, err
+				}
+			}
+			if p.peek() == '(' {
+				if err := p.skipVariableDefinitions(); err != nil {
+					return nil, err
+				}
+			}
+		}
+		selections, err := p.selectionSet(1)
+		if err != nil {
+			return nil, err
+		}
+		if operationName == "" || operationName == name {
+			if found {
+				return nil, fmt.Errorf("the document has several operations, pick one with operationName")
+			}
+			chosen, found = selections, true
+		}
+	}
+	if !found {
+		if operationName != "" {
+			return nil, fmt.Errorf("no operation named %s", operationName)
+		}
+		return nil, fmt.Errorf("the document has no operation")
+	}
+	return chosen, nil
+}
+
+// skipVariableDefinitions passes ($name: Type = default, ...); the values
+// come from the request and the resolvers check their types
+func (p *gqlParser) skipVariableDefinitions() error {
+	depth := 0
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				p.pos++
+				return nil
+			}
+		}
+		p.pos++
+	}
+	return p.errorf("unterminated variable definitions")
+}
+
+func (p *gqlParser) selectionSet(depth int) ([]*gqlField, error) {
+	if depth > maxGraphQLDepth {
+		return nil, p.errorf("selections nest deeper than %d", maxGraphQLDepth)
+	}
+	if err := p.expect('{'); err != nil {
+		return nil, err
+	}
+	var fields []*gqlField
+	for p.peek() != '}' {
+		if p.peek() == 0 {
+			return nil, p.errorf("unterminated selection set")
+		}
+		if strings.HasPrefix(p.src[p.pos:], "...") {
+			return nil, p.errorf("fragments aren't supported")
+		}
+		f, err := p.field(depth)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+	p.pos++
+	if len(fields) == 0 {
+		return nil, p.errorf("empty selection set")
+	}
+	return fields, nil
+}
+
+func (p *gqlParser) field(depth int) (*gqlField, error) {
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	f := &gqlField{Alias: name, Name: name, Args: make(map[string]interface{})}
+	if p.peek() == ':' {
+		p.pos++
+		if f.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek() == '(' {
+		p.pos++
+		for p.peek() != ')' {
+			arg, err := p.name()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(':'); err != nil {
+				return nil, err
+			}
+			if f.Args[arg], err = p.value(); err != nil {
+				return nil, err
+			}
+		}
+		p.pos++
+	}
+	if p.peek() == '@' {
+		return nil, p.errorf("directives aren't supported")
+	}
+	if p.peek() == '{' {
+		if f.Selections, err = p.selectionSet(depth + 1); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// value parses a scalar argument: a string, a number, a boolean, null or a
+// variable
+func (p *gqlParser) value() (interface{}, error) {
+	switch c := p.peek(); {
+	case c == '$':
+		p.pos++
+		name, err := p.name()
+		return gqlVariable(name), err
+	case c == '"':
+		start := p.pos
+		for p.pos++; p.pos < len(p.src) && p.src[p.pos] != '"'; p.pos++ {
+			if p.src[p.pos] == '\\' {
+				p.pos++
+			}
+		}
+		if p.pos >= len(p.src) {
+			return nil, p.errorf("unterminated string")
+		}
+		p.pos++
+		return strconv.Unquote(p.src[start:p.pos])
+	case c == '-' || c >= '0' && c <= '9':
+		start := p.pos
+		for p.pos++; p.pos < len(p.src) && strings.IndexByte("0123456789.eE+-", p.src[p.pos]) >= 0; p.pos++ {
+		}
+		if n, err := strconv.Atoi(p.src[start:p.pos]); err == nil {
+			return n, nil
+		}
+		return strconv.ParseFloat(p.src[start:p.pos], 64)
+	case c == '[' || c == '{':
+		return nil, p.errorf("list and object arguments aren't supported")
+	}
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	switch name {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	return name, nil
+}
+
+// gqlExecutor resolves a parsed query
+type gqlExecutor struct {
+	variables map[string]interface{}
+	errors    []GraphQLError
+	resolved  int
+}
+
+func (e *gqlExecutor) args(f *gqlField) map[string]interface{} {
+	args := make(map[string]interface{}, len(f.Args))
+	for name, v := range f.Args {
+		if variable, ok := v.(gqlVariable); ok {
+			v = e.variables[string(variable)]
+			// JSON numbers decode as float64
+			if n, ok := v.(float64); ok && n == float64(int(n)) {
+				v = int(n)
+			}
+		}
+		args[name] = v
+	}
+	return args
+}
+
+// object resolves the selections on an object; a field that fails is null
+// and reported in errors
+func (e *gqlExecutor) object(obj gqlObject, selections []*gqlField, path []interface{}) *gqlResult {
+	result := &gqlResult{}
+	for _, f := range selections {
+		fieldPath := append(append([]interface{}{}, path...), f.Alias)
+		result.keys = append(result.keys, f.Alias)
+		result.values = append(result.values, e.field(obj, f, fieldPath))
+	}
+	return result
+}
+
+func (e *gqlExecutor) field(obj gqlObject, f *gqlField, path []interface{}) interface{} {
+	if e.resolved++; e.resolved > maxGraphQLFields {
+		e.fail(path, fmt.Errorf("the query resolves more than %d fields", maxGraphQLFields))
+		return nil
+	}
+	if f.Name == "__typename" {
+		return obj.gqlTypeName()
+	}
+	v, err := obj.gqlField(f.Name, e.args(f))
+	if err != nil {
+		e.fail(path, err)
+		return nil
+	}
+	return e.value(v, f, path)
+}
+
+func (e *gqlExecutor) value(v interface{}, f *gqlField, path []interface{}) interface{} {
+	if v == nil {
+		return nil
+	}
+	switch v := v.(type) {
+	case gqlObject:
+		if f.Selections == nil {
+			e.fail(path, fmt.Errorf("%s is an object, select its fields", f.Name))
+			return nil
+		}
+		return e.object(v, f.Selections, path)
+	case []gqlObject:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = e.value(item, f, append(append([]interface{}{}, path...), i))
+		}
+		return list
+	}
+	if f.Selections != nil {
+		e.fail(path, fmt.Errorf("%s has no fields to select", f.Name))
+		return nil
+	}
+	return v
+}
+
+func (e *gqlExecutor) fail(path []interface{}, err error) {
+	e.errors = append(e.errors, GraphQLError{errorMessage(err.Error()), path})
+}
+
+// executeGraphQL parses and runs a query against root
+func executeGraphQL(root gqlObject, req GraphQLRequest) (GraphQLResponse, bool) {
+	selections, err := (&gqlParser{src: req.Query}).document(req.OperationName)
+	if err != nil {
+		return GraphQLResponse{Errors: []GraphQLError{{Message: err.Error()}}}, false
+	}
+	e := &gqlExecutor{variables: req.Variables}
+	data := e.object(root, selections, nil)
+	return GraphQLResponse{Data: data, Errors: e.errors}, true
+}
+
+// answers GraphQL queries, in a POST body or the query parameters of a GET
+func handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req GraphQLRequest
+	if r.Method == http.MethodGet {
+		q := r.URL.Query()
+		req.Query, req.OperationName = q.Get("query"), q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				respondWithJSON(w, r, http.StatusBadRequest, GraphQLResponse{Errors: []GraphQLError{{Message: "variables must be a JSON object"}}})
+				return
+			}
+		}
+	} else {
+		body, err := io.ReadAll(io.LimitReader(r.Body, int64(maxRequestBody)+1))
+		if err == nil && len(body) > maxRequestBody {
+			err = fmt.Errorf("request body exceeds %d bytes", maxRequestBody)
+		}
+		if err == nil {
+			err = json.Unmarshal(body, &req)
+		}
+		if err != nil {
+			respondWithJSON(w, r, http.StatusBadRequest, GraphQLResponse{Errors: []GraphQLError{{Message: err.Error()}}})
+			return
+		}
+	}
+
+	resp, ok := executeGraphQL(gqlQuery{}, req)
+	status := http.StatusOK
+	if !ok {
+		status = http.StatusBadRequest
+	}
+	respondWithJSON(w, r, status, resp)
+}
+
+// shows the GraphQL schema
+func handleGetGraphQLSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, graphQLSchema)
+}
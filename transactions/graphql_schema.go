@@ -0,0 +1,409 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// maxGraphQLBlocks caps the blocks one blocks field returns
+const maxGraphQLBlocks = 100
+
+// graphQLSchema documents what the resolvers below serve
+const graphQLSchema = `type Query {
+  # by hash, or by height on the active chain
+  block(hash: String, height: Int): Block
+  # active chain blocks from a height, by default the genesis block going up
+  # and the tip going down, at most 100
+  blocks(from: Int, limit: Int = 10, order: String = "asc"): [Block!]!
+  tip: Block!
+  height: Int!
+  # a confirmed or pooled transaction
+  transaction(id: String!): Transaction
+  address(address: String!): Address!
+  mempool: Mempool!
+}
+
+type Block {
+  hash: String!
+  prevHash: String!
+  height: Int!
+  # -1 off the active chain
+  confirmations: Int!
+  timestamp: String!
+  nonce: String!
+  transactionCount: Int!
+  transactions: [Transaction!]!
+  previous: Block
+}
+
+type Transaction {
+  id: String!
+  coinbase: Boolean!
+  inputs: [Input!]!
+  outputs: [Output!]!
+  # null while in the mempool
+  block: Block
+}
+
+type Input {
+  txid: String!
+  vout: Int!
+  address: String!
+  # the output it spends
+  output: Output
+}
+
+type Output {
+  index: Int!
+  value: Int!
+  address: String!
+  transaction: Transaction!
+}
+
+type Address {
+  address: String!
+  balance: Int!
+  unspent: [Output!]!
+}
+
+type Mempool {
+  size: Int!
+  bytes: Int!
+  transactions: [MempoolEntry!]!
+}
+
+type MempoolEntry {
+  txid: String!
+  size: Int!
+  fee: Int!
+  time: String!
+  transaction: Transaction!
+}
+`
+
+// the GraphQL types, wrapping the chain's own
+type (
+	gqlQuery       struct{}
+	gqlBlock       struct{ *BlockInfo }
+	gqlTransaction struct{ *TransactionInfo }
+	gqlInput       struct{ TXInput }
+	gqlOutput      struct {
+		tx    *TransactionInfo
+		index int
+	}
+	gqlAddress      string
+	gqlMempool      []MempoolEntry
+	gqlMempoolEntry MempoolEntry
+)
+
+func errUnknownField(typ, name string) error {
+	return fmt.Errorf("%s has no field %s", typ, name)
+}
+
+// stringArg and intArg read an argument, def when it's missing
+func stringArg(args map[string]interface{}, name string) (string, bool, error) {
+	v, ok := args[name]
+	if !ok || v == nil {
+		return "", false, nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", false, fmt.Errorf("%s must be a string", name)
+	}
+	return s, true, nil
+}
+
+func intArg(args map[string]interface{}, name string, def int) (int, bool, error) {
+	v, ok := args[name]
+	if !ok || v == nil {
+		return def, false, nil
+	}
+	n, ok := v.(int)
+	if !ok {
+		return 0, false, fmt.Errorf("%s must be an integer", name)
+	}
+	return n, true, nil
+}
+
+// gqlBlocks wraps the result of blockRange
+func gqlBlocks(blocks []*Block, start, height int, desc bool) []gqlObject {
+	list := make([]gqlObject, len(blocks))
+	for i, b := range blocks {
+		h := start + i
+		if desc {
+			h = start - i
+		}
+		list[i] = gqlBlock{&BlockInfo{b, h, height - h + 1}}
+	}
+	return list
+}
+
+func (gqlQuery) gqlTypeName() string { return "Query" }
+
+func (gqlQuery) gqlField(name string, args map[string]interface{}) (interface{}, error) {
+	switch name {
+	case "block":
+		hash, byHash, err := stringArg(args, "hash")
+		if err != nil {
+			return nil, err
+		}
+		height, byHeight, err := intArg(args, "height", 0)
+		if err != nil {
+			return nil, err
+		}
+		var info *BlockInfo
+		var ok bool
+		switch {
+		case byHash == byHeight:
+			return nil, errors.New("give either hash or height")
+		case byHash:
+			info, ok = bc.lookupBlock(hash)
+		default:
+			info, ok = bc.lookupHeight(height)
+		}
+		if !ok {
+			return nil, nil
+		}
+		return gqlBlock{info}, nil
+
+	case "blocks":
+		from, _, err := intArg(args, "from", -1)
+		if err != nil {
+			return nil, err
+		}
+		limit, _, err := intArg(args, "limit", 10)
+		if err != nil {
+			return nil, err
+		}
+		order, _, err := stringArg(args, "order")
+		if err != nil {
+			return nil, err
+		}
+		if limit < 1 || limit > maxGraphQLBlocks {
+			return nil, fmt.Errorf("limit must be between 1 and %d", maxGraphQLBlocks)
+		}
+		if order != "" && order != "asc" && order != "desc" {
+			return nil, errors.New("order must be asc or desc")
+		}
+		blocks, start, height := bc.blockRange(from, limit, order == "desc")
+		return gqlBlocks(blocks, start, height, order == "desc"), nil
+
+	case "tip", "height":
+		bc.RLock()
+		height := len(bc.blocks) - 1
+		tip := bc.blocks[height]
+		bc.RUnlock()
+		if name == "height" {
+			return height, nil
+		}
+		return gqlBlock{&BlockInfo{tip, height, 1}}, nil
+
+	case "transaction":
+		txid, ok, err := stringArg(args, "id")
+		if err != nil || !ok {
+			return nil, errors.New("id is required")
+		}
+		info, ok := lookupTransaction(txid)
+		if !ok {
+			return nil, nil
+		}
+		return gqlTransaction{info}, nil
+
+	case "address":
+		address, _, err := stringArg(args, "address")
+		if err != nil {
+			return nil, err
+		}
+		if err := checkAddress(address); err != nil {
+			return nil, err
+		}
+		return gqlAddress(address), nil
+
+	case "mempool":
+		return gqlMempool(bc.mempoolEntries()), nil
+	}
+	return nil, errUnknownField("Query", name)
+}
+
+func (gqlBlock) gqlTypeName() string { return "Block" }
+
+func (b gqlBlock) gqlField(name string, args map[string]interface{}) (interface{}, error) {
+	switch name {
+	case "hash":
+		return b.Hash, nil
+	case "prevHash":
+		return b.PrevHash, nil
+	case "height":
+		return b.Height, nil
+	case "confirmations":
+		return b.Confirmations, nil
+	case "timestamp":
+		return b.Timestamp, nil
+	case "nonce":
+		// a string, since it overflows GraphQL's 32 bit Int
+		return fmt.Sprint(b.Nonce), nil
+	case "transactionCount":
+		return len(b.Transactions), nil
+	case "transactions":
+		list := make([]gqlObject, len(b.Transactions))
+		for i, tx := range b.Transactions {
+			list[i] = gqlTransaction{&TransactionInfo{tx, b.Hash}}
+		}
+		return list, nil
+	case "previous":
+		if b.PrevHash == "" {
+			return nil, nil
+		}
+		info, ok := bc.lookupBlock(b.PrevHash)
+		if !ok {
+			return nil, nil
+		}
+		return gqlBlock{info}, nil
+	}
+	return nil, errUnknownField("Block", name)
+}
+
+func (gqlTransaction) gqlTypeName() string { return "Transaction" }
+
+func (t gqlTransaction) gqlField(name string, args map[string]interface{}) (interface{}, error) {
+	switch name {
+	case "id":
+		return t.Transaction.ID, nil
+	case "coinbase":
+		return t.Transaction.IsCoinbase(), nil
+	case "inputs":
+		if t.Transaction.IsCoinbase() {
+			return []gqlObject{}, nil
+		}
+		list := make([]gqlObject, len(t.Transaction.Vin))
+		for i, in := range t.Transaction.Vin {
+			list[i] = gqlInput{in}
+		}
+		return list, nil
+	case "outputs":
+		list := make([]gqlObject, len(t.Transaction.Vout))
+		for i := range t.Transaction.Vout {
+			list[i] = gqlOutput{t.TransactionInfo, i}
+		}
+		return list, nil
+	case "block":
+		if t.BlockHash == "" {
+			return nil, nil
+		}
+		info, ok := bc.lookupBlock(t.BlockHash)
+		if !ok {
+			return nil, nil
+		}
+		return gqlBlock{info}, nil
+	}
+	return nil, errUnknownField("Transaction", name)
+}
+
+func (gqlInput) gqlTypeName() string { return "Input" }
+
+func (in gqlInput) gqlField(name string, args map[string]interface{}) (interface{}, error) {
+	switch name {
+	case "txid":
+		return in.Txid, nil
+	case "vout":
+		return in.Vout, nil
+	case "address":
+		return inputOwner(in.TXInput), nil
+	case "output":
+		info, ok := lookupTransaction(in.Txid)
+		if !ok || in.Vout < 0 || in.Vout >= len(info.Transaction.Vout) {
+			return nil, nil
+		}
+		return gqlOutput{info, in.Vout}, nil
+	}
+	return nil, errUnknownField("Input", name)
+}
+
+func (gqlOutput) gqlTypeName() string { return "Output" }
+
+func (o gqlOutput) gqlField(name string, args map[string]interface{}) (interface{}, error) {
+	out := o.tx.Transaction.Vout[o.index]
+	switch name {
+	case "index":
+		return o.index, nil
+	case "value":
+		return out.Value, nil
+	case "address":
+		return out.ScriptPubKey, nil
+	case "transaction":
+		return gqlTransaction{o.tx}, nil
+	}
+	return nil, errUnknownField("Output", name)
+}
+
+func (gqlAddress) gqlTypeName() string { return "Address" }
+
+func (a gqlAddress) gqlField(name string, args map[string]interface{}) (interface{}, error) {
+	switch name {
+	case "address":
+		return string(a), nil
+	case "balance":
+		return bc.Balance(string(a)), nil
+	case "unspent":
+		bc.RLock()
+		var ops []outpoint
+		for _, e := range bc.utxo.outputs(string(a)) {
+			ops = append(ops, e.outpoint)
+		}
+		bc.RUnlock()
+
+		list := []gqlObject{}
+		for _, op := range ops {
+			if info, ok := lookupTransaction(op.Txid); ok {
+				list = append(list, gqlOutput{info, op.Vout})
+			}
+		}
+		return list, nil
+	}
+	return nil, errUnknownField("Address", name)
+}
+
+func (gqlMempool) gqlTypeName() string { return "Mempool" }
+
+func (m gqlMempool) gqlField(name string, args map[string]interface{}) (interface{}, error) {
+	switch name {
+	case "size":
+		return len(m), nil
+	case "bytes":
+		total := 0
+		for _, e := range m {
+			total += e.Size
+		}
+		return total, nil
+	case "transactions":
+		list := make([]gqlObject, len(m))
+		for i, e := range m {
+			list[i] = gqlMempoolEntry(e)
+		}
+		return list, nil
+	}
+	return nil, errUnknownField("Mempool", name)
+}
+
+func (gqlMempoolEntry) gqlTypeName() string { return "MempoolEntry" }
+
+func (e gqlMempoolEntry) gqlField(name string, args map[string]interface{}) (interface{}, error) {
+	switch name {
+	case "txid":
+		return e.Txid, nil
+	case "size":
+		return e.Size, nil
+	case "fee":
+		return e.Fee, nil
+	case "time":
+		return e.Time.Format(time.RFC3339Nano), nil
+	case "transaction":
+		tx, ok := bc.mempool.Get(e.Txid)
+		if !ok {
+			return nil, errors.New("the transaction left the mempool")
+		}
+		return gqlTransaction{&TransactionInfo{Transaction: tx}}, nil
+	}
+	return nil, errUnknownField("MempoolEntry", name)
+}
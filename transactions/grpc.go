@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"os"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// chainService implements the Chain service of proto/chain.proto
+type chainService struct{}
+
+// chainServiceDesc is what protoc-gen-go-grpc would generate for the Chain
+// service, written out since the messages are encoded by hand
+var chainServiceDesc = grpc.ServiceDesc{
+	ServiceName: "go_blockchain.v1.Chain",
+	HandlerType: (*chainService)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "GetChain", Handler: chainGetChainHandler},
+		{MethodName: "GetBlock", Handler: chainGetBlockHandler},
+		{MethodName: "SendTransaction", Handler: chainSendTransactionHandler},
+		{MethodName: "GetBalance", Handler: chainGetBalanceHandler},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SubscribeBlocks",
+			ServerStreams: true,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				req := &pbSubscribeBlocksRequest{}
+				if err := stream.RecvMsg(req); err != nil {
+					return err
+				}
+				return srv.(*chainService).SubscribeBlocks(req, stream)
+			},
+		},
+	},
+	Metadata: "chain.proto",
+}
+
+// invokeUnary runs a decoded unary request through the interceptor, if any
+func invokeUnary(ctx context.Context, srv interface{}, req interface{}, method string, interceptor grpc.UnaryServerInterceptor, handler grpc.UnaryHandler) (interface{}, error) {
+	if interceptor == nil {
+		return handler(ctx, req)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/go_blockchain.v1.Chain/" + method}
+	return interceptor(ctx, req, info, handler)
+}
+
+func chainGetChainHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := &pbGetChainRequest{}
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	return invokeUnary(ctx, srv, req, "GetChain", interceptor, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(*chainService).GetChain(ctx, req.(*pbGetChainRequest))
+	})
+}
+
+func chainGetBlockHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := &pbGetBlockRequest{}
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	return invokeUnary(ctx, srv, req, "GetBlock", interceptor, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(*chainService).GetBlock(ctx, req.(*pbGetBlockRequest))
+	})
+}
+
+func chainSendTransactionHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := &pbSendTransactionRequest{}
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	return invokeUnary(ctx, srv, req, "SendTransaction", interceptor, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(*chainService).SendTransaction(ctx, req.(*pbSendTransactionRequest))
+	})
+}
+
+func chainGetBalanceHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := &pbGetBalanceRequest{}
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	return invokeUnary(ctx, srv, req, "GetBalance", interceptor, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(*chainService).GetBalance(ctx, req.(*pbGetBalanceRequest))
+	})
+}
+
+func (s *chainService) GetChain(ctx context.Context, req *pbGetChainRequest) (*pbGetChainResponse, error) {
+	limit := int(req.Limit)
+	if limit <= 0 || limit > maxBlocksPerMessage {
+		limit = maxBlocksPerMessage
+	}
+	if req.FromHeight < 0 {
+		return nil, status.Error(codes.InvalidArgument, "ERROR: from_height can't be negative")
+	}
+
+	bc.RLock()
+	defer bc.RUnlock()
+	resp := &pbGetChainResponse{}
+	for height := int(req.FromHeight); height < len(bc.blocks) && len(resp.Blocks) < limit; height++ {
+		resp.Blocks = append(resp.Blocks, toPBBlock(bc.blocks[height], height))
+	}
+	return resp, nil
+}
+
+func (s *chainService) GetBlock(ctx context.Context, req *pbGetBlockRequest) (*pbBlock, error) {
+	bc.RLock()
+	defer bc.RUnlock()
+
+	if req.Hash == "" {
+		if req.Height < 0 || req.Height >= int64(len(bc.blocks)) {
+			return nil, status.Error(codes.NotFound, "ERROR: No block at that height")
+		}
+		return toPBBlock(bc.blocks[req.Height], int(req.Height)), nil
+	}
+	b, ok := bc.known[req.Hash]
+	if !ok {
+		return nil, status.Error(codes.NotFound, "ERROR: Unknown block")
+	}
+	// side branch blocks have no active chain height and report -1
+	return toPBBlock(b, bc.heightOf(b.Hash)), nil
+}
+
+func (s *chainService) SendTransaction(ctx context.Context, req *pbSendTransactionRequest) (*pbSendTransactionResponse, error) {
+	if req.Transaction == nil {
+		return nil, status.Error(codes.InvalidArgument, "ERROR: Missing transaction")
+	}
+	tx := fromPBTransaction(req.Transaction)
+	if err := assignTransactionID(tx); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	// the gRPC API takes no credentials
+	if err := checkNodeFunds(tx, false); err != nil {
+		return nil, status.Error(codes.PermissionDenied, err.Error())
+	}
+	if err := bc.AcceptTransaction(ctx, tx); err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	relayTransaction(tx, nil)
+	return &pbSendTransactionResponse{Txid: tx.ID}, nil
+}
+
+func (s *chainService) GetBalance(ctx context.Context, req *pbGetBalanceRequest) (*pbGetBalanceResponse, error) {
+	if req.Address == "" {
+		return nil, status.Error(codes.InvalidArgument, "ERROR: Missing address")
+	}
+	return &pbGetBalanceResponse{Balance: int64(bc.Balance(req.Address))}, nil
+}
+
+// SubscribeBlocks streams blocks as they join the active chain until the
+// client goes away or falls too far behind
+func (s *chainService) SubscribeBlocks(req *pbSubscribeBlocksRequest, stream grpc.ServerStream) error {
+	events := chainEvents.Subscribe(BlockConnected)
+	defer chainEvents.Unsubscribe(events)
+
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return status.Error(codes.ResourceExhausted, "ERROR: Subscriber fell behind")
+			}
+			b, isBlock := e.Block()
+			if !isBlock {
+				continue
+			}
+			bc.RLock()
+			height := bc.blockHeight(b)
+			bc.RUnlock()
+			if err := stream.SendMsg(toPBBlock(b, height)); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// grpcAllowed applies the API access list to gRPC callers, letting loopback
+// through like aclMiddleware does
+func grpcAllowed(ctx context.Context) error {
+	if p, ok := peer.FromContext(ctx); ok {
+		host, _, _ := net.SplitHostPort(p.Addr.String())
+		if ip := net.ParseIP(host); ip != nil && (ip.IsLoopback() || apiACL.Allowed(ip)) {
+			return nil
+		}
+	}
+	return status.Error(codes.PermissionDenied, "ERROR: Forbidden")
+}
+
+// grpcServer is set while the gRPC API is served
+var grpcServer *grpc.Server
+
+// startGRPC serves the gRPC API on GRPC_PORT, if set
+func startGRPC() {
+	port := os.Getenv("GRPC_PORT")
+	if port == "" {
+		return
+	}
+	lis, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			if err := grpcAllowed(ctx); err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			if err := grpcAllowed(ss.Context()); err != nil {
+				return err
+			}
+			return handler(srv, ss)
+		}),
+	)
+	s.RegisterService(&chainServiceDesc, &chainService{})
+	grpcServer = s
+
+	log.Println("gRPC Server Listening on port :", port)
+	go func() {
+		if err := s.Serve(lis); err != nil {
+			log.Printf("gRPC server stopped: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// The messages of proto/chain.proto, encoded by hand with protowire so the
+// node needs no generated code. Field numbers must match the .proto file.
+
+// protoMessage is implemented by every message of the gRPC API
+type protoMessage interface {
+	appendProto(b []byte) []byte
+	unmarshalProto(b []byte) error
+}
+
+// protoCodec replaces gRPC's default "proto" codec with one for our
+// hand-encoded messages; the bytes on the wire are standard protobuf
+type protoCodec struct{}
+
+func init() {
+	encoding.RegisterCodec(protoCodec{})
+}
+
+func (protoCodec) Name() string { return "proto" }
+
+func (protoCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(protoMessage)
+	if !ok {
+		return nil, fmt.Errorf("can't encode %T as protobuf", v)
+	}
+	return m.appendProto(nil), nil
+}
+
+func (protoCodec) Unmarshal(data []byte, v interface{}) error {
+	m, ok := v.(protoMessage)
+	if !ok {
+		return fmt.Errorf("can't decode protobuf into %T", v)
+	}
+	return m.unmarshalProto(data)
+}
+
+func appendStringField(b []byte, num protowire.Number, v string) []byte {
+	if v == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, v)
+}
+
+func appendBytesField(b []byte, num protowire.Number, v []byte) []byte {
+	if len(v) == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, v)
+}
+
+func appendVarintField(b []byte, num protowire.Number, v uint64) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, v)
+}
+
+func appendMessageField(b []byte, num protowire.Number, m protoMessage) []byte {
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendBytes(b, m.appendProto(nil))
+}
+
+// protoField is one decoded field: varint fields set x, length-delimited
+// fields set v
+type protoField struct {
+	num protowire.Number
+	x   uint64
+	v   []byte
+}
+
+// parseProto calls f for every varint and length-delimited field of b and
+// skips the others
+func parseProto(b []byte, f func(field protoField) error) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		field := protoField{num: num}
+		switch typ {
+		case protowire.VarintType:
+			field.x, n = protowire.ConsumeVarint(b)
+		case protowire.BytesType:
+			field.v, n = protowire.ConsumeBytes(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if err := f(field); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type pbTxInput struct {
+	Txid      string
+	Vout      int64
+	ScriptSig string
+	Signature []byte
+	PubKey    []byte
+}
+
+func (m *pbTxInput) appendProto(b []byte) []byte {
+	b = appendStringField(b, 1, m.Txid)
+	b = appendVarintField(b, 2, uint64(m.Vout))
+	b = appendStringField(b, 3, m.ScriptSig)
+	b = appendBytesField(b, 4, m.Signature)
+	return appendBytesField(b, 5, m.PubKey)
+}
+
+func (m *pbTxInput) unmarshalProto(b []byte) error {
+	return parseProto(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			m.Txid = string(f.v)
+		case 2:
+			m.Vout = int64(f.x)
+		case 3:
+			m.ScriptSig = string(f.v)
+		case 4:
+			m.Signature = append([]byte{}, f.v...)
+		case 5:
+			m.PubKey = append([]byte{}, f.v...)
+		}
+		return nil
+	})
+}
+
+type pbTxOutput struct {
+	Value        int64
+	ScriptPubKey string
+}
+
+func (m *pbTxOutput) appendProto(b []byte) []byte {
+	b = appendVarintField(b, 1, uint64(m.Value))
+	return appendStringField(b, 2, m.ScriptPubKey)
+}
+
+func (m *pbTxOutput) unmarshalProto(b []byte) error {
+	return parseProto(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			m.Value = int64(f.x)
+		case 2:
+			m.ScriptPubKey = string(f.v)
+		}
+		return nil
+	})
+}
+
+type pbTransaction struct {
+	ID   string
+	Vin  []*pbTxInput
+	Vout []*pbTxOutput
+}
+
+func (m *pbTransaction) appendProto(b []byte) []byte {
+	b = appendStringField(b, 1, m.ID)
+	for _, in := range m.Vin {
+		b = appendMessageField(b, 2, in)
+	}
+	for _, out := range m.Vout {
+		b = appendMessageField(b, 3, out)
+	}
+	return b
+}
+
+func (m *pbTransaction) unmarshalProto(b []byte) error {
+	return parseProto(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			m.ID = string(f.v)
+		case 2:
+			in := &pbTxInput{}
+			m.Vin = append(m.Vin, in)
+			return in.unmarshalProto(f.v)
+		case 3:
+			out := &pbTxOutput{}
+			m.Vout = append(m.Vout, out)
+			return out.unmarshalProto(f.v)
+		}
+		return nil
+	})
+}
+
+type pbBlock struct {
+	Height       int64
+	Hash         string
+	PrevHash     string
+	Timestamp    string
+	Nonce        uint64
+	Transactions []*pbTransaction
+}
+
+func (m *pbBlock) appendProto(b []byte) []byte {
+	b = appendVarintField(b, 1, uint64(m.Height))
+	b = appendStringField(b, 2, m.Hash)
+	b = appendStringField(b, 3, m.PrevHash)
+	b = appendStringField(b, 4, m.Timestamp)
+	b = appendVarintField(b, 5, m.Nonce)
+	for _, tx := range m.Transactions {
+		b = appendMessageField(b, 6, tx)
+	}
+	return b
+}
+
+func (m *pbBlock) unmarshalProto(b []byte) error {
+	return parseProto(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			m.Height = int64(f.x)
+		case 2:
+			m.Hash = string(f.v)
+		case 3:
+			m.PrevHash = string(f.v)
+		case 4:
+			m.Timestamp = string(f.v)
+		case 5:
+			m.Nonce = f.x
+		case 6:
+			tx := &pbTransaction{}
+			m.Transactions = append(m.Transactions, tx)
+			return tx.unmarshalProto(f.v)
+		}
+		return nil
+	})
+}
+
+type pbGetChainRequest struct {
+	FromHeight int64
+	Limit      int64
+}
+
+func (m *pbGetChainRequest) appendProto(b []byte) []byte {
+	b = appendVarintField(b, 1, uint64(m.FromHeight))
+	return appendVarintField(b, 2, uint64(m.Limit))
+}
+
+func (m *pbGetChainRequest) unmarshalProto(b []byte) error {
+	return parseProto(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			m.FromHeight = int64(f.x)
+		case 2:
+			m.Limit = int64(f.x)
+		}
+		return nil
+	})
+}
+
+type pbGetChainResponse struct {
+	Blocks []*pbBlock
+}
+
+func (m *pbGetChainResponse) appendProto(b []byte) []byte {
+	for _, block := range m.Blocks {
+		b = appendMessageField(b, 1, block)
+	}
+	return b
+}
+
+func (m *pbGetChainResponse) unmarshalProto(b []byte) error {
+	return parseProto(b, func(f protoField) error {
+		if f.num == 1 {
+			block := &pbBlock{}
+			m.Blocks = append(m.Blocks, block)
+			return block.unmarshalProto(f.v)
+		}
+		return nil
+	})
+}
+
+type pbGetBlockRequest struct {
+	Hash   string
+	Height int64
+}
+
+func (m *pbGetBlockRequest) appendProto(b []byte) []byte {
+	b = appendStringField(b, 1, m.Hash)
+	return appendVarintField(b, 2, uint64(m.Height))
+}
+
+func (m *pbGetBlockRequest) unmarshalProto(b []byte) error {
+	return parseProto(b, func(f protoField) error {
+		switch f.num {
+		case 1:
+			m.Hash = string(f.v)
+		case 2:
+			m.Height = int64(f.x)
+		}
+		return nil
+	})
+}
+
+type pbSendTransactionRequest struct {
+	Transaction *pbTransaction
+}
+
+func (m *pbSendTransactionRequest) appendProto(b []byte) []byte {
+	if m.Transaction == nil {
+		return b
+	}
+	return appendMessageField(b, 1, m.Transaction)
+}
+
+func (m *pbSendTransactionRequest) unmarshalProto(b []byte) error {
+	return parseProto(b, func(f protoField) error {
+		if f.num == 1 {
+			m.Transaction = &pbTransaction{}
+			return m.Transaction.unmarshalProto(f.v)
+		}
+		return nil
+	})
+}
+
+type pbSendTransactionResponse struct {
+	Txid string
+}
+
+func (m *pbSendTransactionResponse) appendProto(b []byte) []byte {
+	return appendStringField(b, 1, m.Txid)
+}
+
+func (m *pbSendTransactionResponse) unmarshalProto(b []byte) error {
+	return parseProto(b, func(f protoField) error {
+		if f.num == 1 {
+			m.Txid = string(f.v)
+		}
+		return nil
+	})
+}
+
+type pbGetBalanceRequest struct {
+	Address string
+}
+
+func (m *pbGetBalanceRequest) appendProto(b []byte) []byte {
+	return appendStringField(b, 1, m.Address)
+}
+
+func (m *pbGetBalanceRequest) unmarshalProto(b []byte) error {
+	return parseProto(b, func(f protoField) error {
+		if f.num == 1 {
+			m.Address = string(f.v)
+		}
+		return nil
+	})
+}
+
+type pbGetBalanceResponse struct {
+	Balance int64
+}
+
+func (m *pbGetBalanceResponse) appendProto(b []byte) []byte {
+	return appendVarintField(b, 1, uint64(m.Balance))
+}
+
+func (m *pbGetBalanceResponse) unmarshalProto(b []byte) error {
+	return parseProto(b, func(f protoField) error {
+		if f.num == 1 {
+			m.Balance = int64(f.x)
+		}
+		return nil
+	})
+}
+
+type pbSubscribeBlocksRequest struct{}
+
+func (m *pbSubscribeBlocksRequest) appendProto(b []byte) []byte { return b }
+
+func (m *pbSubscribeBlocksRequest) unmarshalProto(b []byte) error {
+	return parseProto(b, func(protoField) error { return nil })
+}
+
+// conversions between the chain types and their messages
+
+func toPBBlock(b *Block, height int) *pbBlock {
+	m := &pbBlock{Height: int64(height), Hash: b.Hash, PrevHash: b.PrevHash, Timestamp: b.Timestamp, Nonce: b.Nonce}
+	for _, tx := range b.Transactions {
+		m.Transactions = append(m.Transactions, toPBTransaction(tx))
+	}
+	return m
+}
+
+func toPBTransaction(tx *Transaction) *pbTransaction {
+	m := &pbTransaction{ID: tx.ID}
+	for _, in := range tx.Vin {
+		m.Vin = append(m.Vin, &pbTxInput{in.Txid, int64(in.Vout), in.ScriptSig, in.Signature, in.PubKey})
+	}
+	for _, out := range tx.Vout {
+		m.Vout = append(m.Vout, &pbTxOutput{int64(out.Value), out.ScriptPubKey})
+	}
+	return m
+}
+
+func fromPBTransaction(m *pbTransaction) *Transaction {
+	tx := &Transaction{ID: m.ID}
+	for _, in := range m.Vin {
+		tx.Vin = append(tx.Vin, TXInput{in.Txid, int(in.Vout), in.ScriptSig, in.Signature, in.PubKey})
+	}
+	for _, out := range m.Vout {
+		tx.Vout = append(tx.Vout, TXOutput{Amount(out.Value), out.ScriptPubKey})
+	}
+	return tx
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// headerVectors pin the header encoding and hash. A change to either forks
+// every existing chain, so these only change along with headerVersion.
+var headerVectors = []struct {
+	name      string
+	timestamp string
+	prevHash  string
+	nonce     uint64
+	txHash    string
+	encoded   string
+	hash      string
+}{
+	{
+		name:    "empty genesis",
+		txHash:  "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		encoded: "01" + strings.Repeat("00", 32) + "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" + "0000000000000000" + "0000",
+		hash:    "4abdf241daa036065c219534008e4b70d1c3e69ea75ee313e133ef2b852ae226",
+	},
+	{
+		name:      "block",
+		timestamp: "2024-01-02 03:04:05.000000006 +0000 UTC",
+		prevHash:  "0000" + strings.Repeat("ab", 30),
+		nonce:     1<<40 + 7,
+		txHash:    "ccc0c16093737a88c16a9c286062581d32f82cc5779d785bb0ba7679acbaafca",
+		encoded: "01" + "0000" + strings.Repeat("ab", 30) +
+			"ccc0c16093737a88c16a9c286062581d32f82cc5779d785bb0ba7679acbaafca" +
+			"0000010000000007" + "0027" +
+			"323032342d30312d30322030333a30343a30352e303030303030303036202b3030303020555443",
+		hash: "65470bf85d0eafc8b5d7dced931888dc986f9228ae8551aa40bdc6eeac6e03aa",
+	},
+}
+
+func TestEncodeHeaderVectors(t *testing.T) {
+	for _, v := range headerVectors {
+		t.Run(v.name, func(t *testing.T) {
+			txHash, _ := hex.DecodeString(v.txHash)
+			encoded, err := encodeHeader(v.timestamp, v.prevHash, v.nonce, txHash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := hex.EncodeToString(encoded); got != v.encoded {
+				t.Errorf("encoded\n got %s\nwant %s", got, v.encoded)
+			}
+
+			hash, err := hashHeader(v.timestamp, v.prevHash, v.nonce, txHash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hash != v.hash {
+				t.Errorf("hash %s, want %s", hash, v.hash)
+			}
+
+			header := BlockHeader{v.timestamp, v.prevHash, v.hash, v.nonce, v.txHash}
+			if hash, err := header.calculateHash(); err != nil || hash != v.hash {
+				t.Errorf("BlockHeader.calculateHash() = %s, %v, want %s", hash, err, v.hash)
+			}
+		})
+	}
+}
+
+func TestCalculateHashMatchesHeader(t *testing.T) {
+	b := &Block{
+		Timestamp:    headerVectors[1].timestamp,
+		PrevHash:     headerVectors[1].prevHash,
+		Nonce:        headerVectors[1].nonce,
+		Transactions: []*Transaction{{ID: "5a2c0f1e6b0b1f2e0c9d6a7f3e4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d"}},
+	}
+	if got := hex.EncodeToString(b.HashTransactions()); got != headerVectors[1].txHash {
+		t.Fatalf("transactions hash %s, want %s", got, headerVectors[1].txHash)
+	}
+	if got := calculateHash(b); got != headerVectors[1].hash {
+		t.Errorf("calculateHash() = %s, want %s", got, headerVectors[1].hash)
+	}
+}
+
+// the old encoding concatenated the timestamp and previous hash, so moving
+// characters between them kept the hash
+func TestEncodeHeaderUnambiguous(t *testing.T) {
+	prev := strings.Repeat("ab", 32)
+	txHash := make([]byte, 32)
+	a, _ := encodeHeader("2024"+prev[:2], prev[2:]+"ab", 0, txHash)
+	b, _ := encodeHeader("2024", prev, 0, txHash)
+	if bytes.Equal(a, b) {
+		t.Error("shifting bytes from the timestamp to the previous hash kept the encoding")
+	}
+	c, _ := encodeHeader("2024ab", "", 0, txHash)
+	d, _ := encodeHeader("2024", "", 0, txHash)
+	if bytes.Equal(c, d) {
+		t.Error("different timestamps share an encoding")
+	}
+}
+
+func TestEncodeHeaderRejects(t *testing.T) {
+	txHash := make([]byte, 32)
+	for _, c := range []struct {
+		name                string
+		timestamp, prevHash string
+		txHash              []byte
+	}{
+		{"short previous hash", "", "abcd", txHash},
+		{"non-hex previous hash", "", strings.Repeat("zz", 32), txHash},
+		{"short transactions hash", "", "", txHash[:31]},
+		{"long timestamp", strings.Repeat("x", 1<<16), "", txHash},
+	} {
+		if _, err := encodeHeader(c.timestamp, c.prevHash, 0, c.txHash); err == nil {
+			t.Errorf("%s: encoded", c.name)
+		}
+	}
+	if hash := calculateHash(&Block{PrevHash: "abcd"}); hash != "" {
+		t.Errorf("calculateHash of an unencodable header = %q", hash)
+	}
+}
+
+// the mining loop hashes one header per nonce; it should allocate only the
+// hash string
+func BenchmarkCalculateHash(b *testing.B) {
+	block := &Block{
+		Timestamp:    headerVectors[1].timestamp,
+		PrevHash:     headerVectors[1].prevHash,
+		Transactions: []*Transaction{{ID: "5a2c0f1e6b0b1f2e0c9d6a7f3e4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d"}},
+	}
+	candidate := block.withTxHash()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		candidate.Nonce = uint64(i)
+		calculateHash(&candidate)
+	}
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"math"
+	"math/big"
+	"sync"
+
+	"github.com/VOOVOOZEL/go_blockchain/transactions/wire"
+)
+
+const (
+	// maxHeadersPerMessage caps the headers sent in reply to one getheaders
+	maxHeadersPerMessage = 2000
+	// maxBlocksInFlight caps the bodies asked for in one getdata
+	maxBlocksInFlight = 128
+)
+
+// BlockHeader is everything calculateHash commits to, without the
+// transactions themselves
+type BlockHeader struct {
+	Timestamp        string
+	PrevHash         string
+	Hash             string
+	Nonce            uint64
+	TransactionsHash string
+}
+
+// GetHeadersMessage asks for the active chain headers that follow the first
+// locator hash the peer knows
+type GetHeadersMessage struct {
+	Locator []string
+}
+
+// HeadersMessage carries consecutive headers, oldest first
+type HeadersMessage struct {
+	Headers []BlockHeader
+}
+
+// Header returns the header of b
+func (b *Block) Header() BlockHeader {
+	return BlockHeader{b.Timestamp, b.PrevHash, b.Hash, b.Nonce, hex.EncodeToString(b.HashTransactions())}
+}
+
+func (h *BlockHeader) calculateHash() (string, error) {
+	txHash, err := hex.DecodeString(h.TransactionsHash)
+	if err != nil {
+		return "", err
+	}
+	return hashHeader(h.Timestamp, h.PrevHash, h.Nonce, txHash)
+}
+
+// headerVersion leads every encoded header, so its layout can change later
+const headerVersion = 1
+
+// encodeHeader lays the fields a block hash commits to out in a fixed
+// binary format, integers big-endian:
+//
+//	version            1 byte, headerVersion
+//	previous hash     32 bytes, zeros for the genesis block
+//	transactions hash 32 bytes, the merkle root of the transaction IDs
+//	nonce              8 bytes
+//	timestamp length   2 bytes
+//	timestamp          as many bytes as its length says
+//
+// Every field has a fixed size or a length, so no two headers share an
+// encoding.
+func encodeHeader(timestamp, prevHash string, nonce uint64, txHash []byte) ([]byte, error) {
+	return appendHeader(nil, timestamp, prevHash, nonce, txHash)
+}
+
+// appendHeader appends the encoded header to dst. It doesn't allocate when
+// dst has room, which the mining loop relies on.
+func appendHeader(dst []byte, timestamp, prevHash string, nonce uint64, txHash []byte) ([]byte, error) {
+	if prevHash != "" && len(prevHash) != 2*sha256.Size {
+		return nil, fmt.Errorf("ERROR: Previous hash %q isn't a SHA-256 hash", prevHash)
+	}
+	if len(txHash) != sha256.Size {
+		return nil, fmt.Errorf("ERROR: Transactions hash has %d bytes, not %d", len(txHash), sha256.Size)
+	}
+	if len(timestamp) > math.MaxUint16 {
+		return nil, fmt.Errorf("ERROR: Timestamp is longer than %d bytes", math.MaxUint16)
+	}
+
+	dst = append(dst, headerVersion)
+	dst = append(dst, make([]byte, sha256.Size)...)
+	if !decodeHexInto(dst[len(dst)-sha256.Size:], prevHash) {
+		return nil, fmt.Errorf("ERROR: Previous hash %q isn't a SHA-256 hash", prevHash)
+	}
+	dst = append(dst, txHash...)
+	dst = binary.BigEndian.AppendUint64(dst, nonce)
+	dst = binary.BigEndian.AppendUint16(dst, uint16(len(timestamp)))
+	return append(dst, timestamp...), nil
+}
+
+// decodeHexInto decodes the hex string s into dst, which has room for it,
+// without the copy hex.Decode needs
+func decodeHexInto(dst []byte, s string) bool {
+	for i := 0; i+1 < len(s); i += 2 {
+		hi, ok1 := fromHexChar(s[i])
+		lo, ok2 := fromHexChar(s[i+1])
+		if !ok1 || !ok2 {
+			return false
+		}
+		dst[i/2] = hi<<4 | lo
+	}
+	return true
+}
+
+func fromHexChar(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// headerHasher holds the buffers and hasher state hashing a header needs.
+// They are reused through headerHashers, so hashing a header allocates only
+// the hash string.
+type headerHasher struct {
+	buf []byte
+	sha hash.Hash
+	sum [sha256.Size]byte
+	hex [2 * sha256.Size]byte
+}
+
+var headerHashers = sync.Pool{
+	New: func() interface{} { return &headerHasher{sha: sha256.New()} },
+}
+
+// hash returns the hex SHA-256 hash of the encoded header
+func (h *headerHasher) hash(timestamp, prevHash string, nonce uint64, txHash []byte) (string, error) {
+	var err error
+	if h.buf, err = appendHeader(h.buf[:0], timestamp, prevHash, nonce, txHash); err != nil {
+		return "", err
+	}
+	h.sha.Reset()
+	h.sha.Write(h.buf)
+	h.sha.Sum(h.sum[:0])
+	hex.Encode(h.hex[:], h.sum[:])
+	return string(h.hex[:]), nil
+}
+
+type headerNode struct {
+	BlockHeader
+	parent *headerNode
+	height int
+	work   *big.Int
+}
+
+// HeaderChain is the tree of validated headers. Syncing downloads headers
+// first to find the chain with the most work and only then fetches the
+// bodies along it, so bodies of stale forks are never downloaded.
+type HeaderChain struct {
+	sync.Mutex
+	nodes   map[string]*headerNode
+	best    *headerNode
+	invalid map[string]bool
+}
+
+func newHeaderChain(genesis *Block) *HeaderChain {
+	root := &headerNode{BlockHeader: genesis.Header(), work: blockWork(genesis)}
+	return &HeaderChain{nodes: map[string]*headerNode{genesis.Hash: root}, best: root, invalid: make(map[string]bool)}
+}
+
+func (hc *HeaderChain) insert(h BlockHeader, parent *headerNode) *headerNode {
+	n := &headerNode{h, parent, parent.height + 1, new(big.Int).Add(parent.work, workForDifficulty(difficulty))}
+	hc.nodes[h.Hash] = n
+	if n.work.Cmp(hc.best.work) > 0 && !hc.descendsFromInvalid(n) {
+		hc.best = n
+	}
+	return n
+}
+
+// addBlock records the header of a block accepted with its body
+func (hc *HeaderChain) addBlock(b *Block) {
+	hc.Lock()
+	defer hc.Unlock()
+
+	if _, ok := hc.nodes[b.Hash]; ok {
+		return
+	}
+	if parent, ok := hc.nodes[b.PrevHash]; ok {
+		hc.insert(b.Header(), parent)
+	}
+}
+
+// addHeaders validates headers received from a peer and adds them to the
+// tree. Every header must connect to a known one, commit to its hash, meet
+// the proof of work target and respect the checkpoints.
+func (hc *HeaderChain) addHeaders(headers []BlockHeader) error {
+	hc.Lock()
+	defer hc.Unlock()
+
+	for _, h := range headers {
+		if _, ok := hc.nodes[h.Hash]; ok {
+			continue
+		}
+		parent, ok := hc.nodes[h.PrevHash]
+		if !ok {
+			return fmt.Errorf("header %s doesn't connect to a known header", h.Hash)
+		}
+		if hash, err := h.calculateHash(); err != nil || hash != h.Hash {
+			return fmt.Errorf("header %s has a wrong hash", h.Hash)
+		}
+		if !isHashValid(h.Hash, difficulty) {
+			return fmt.Errorf("header %s doesn't meet the proof of work target", h.Hash)
+		}
+		height := parent.height + 1
+		if hash, ok := params.checkpoint(height); ok && hash != h.Hash {
+			return fmt.Errorf("header at height %d doesn't match checkpoint %s", height, hash)
+		}
+		if cp := params.lastCheckpoint(hc.best.height); cp >= 0 && height <= cp {
+			return fmt.Errorf("header at height %d forks below checkpoint %d", height, cp)
+		}
+		hc.insert(h, parent)
+	}
+	return nil
+}
+
+// setInvalid replaces the set of invalid blocks and picks the best header
+// chain again, skipping any that contains one of them
+func (hc *HeaderChain) setInvalid(invalid map[string]bool) {
+	hc.Lock()
+	defer hc.Unlock()
+
+	hc.invalid = make(map[string]bool, len(invalid))
+	for hash := range invalid {
+		hc.invalid[hash] = true
+	}
+
+	var best *headerNode
+	for _, n := range hc.nodes {
+		if (best == nil || n.work.Cmp(best.work) > 0) && !hc.descendsFromInvalid(n) {
+			best = n
+		}
+	}
+	hc.best = best
+}
+
+func (hc *HeaderChain) descendsFromInvalid(n *headerNode) bool {
+	if len(hc.invalid) == 0 {
+		return false
+	}
+	for ; n != nil; n = n.parent {
+		if hc.invalid[n.Hash] {
+			return true
+		}
+	}
+	return false
+}
+
+// bestHeight returns the height of the best header chain
+func (hc *HeaderChain) bestHeight() int {
+	hc.Lock()
+	defer hc.Unlock()
+	return hc.best.height
+}
+
+// bestChain returns the nodes of the best header chain, genesis first
+func (hc *HeaderChain) bestChain() []*headerNode {
+	hc.Lock()
+	defer hc.Unlock()
+
+	chain := make([]*headerNode, hc.best.height+1)
+	for n := hc.best; n != nil; n = n.parent {
+		chain[n.height] = n
+	}
+	return chain
+}
+
+// bestTip returns the height and hash of the best header
+func (hc *HeaderChain) bestTip() (int, string) {
+	hc.Lock()
+	defer hc.Unlock()
+	return hc.best.height, hc.best.Hash
+}
+
+// transactionsHash returns the Merkle root committed to by a known header
+func (hc *HeaderChain) transactionsHash(hash string) (string, bool) {
+	hc.Lock()
+	defer hc.Unlock()
+	n, ok := hc.nodes[hash]
+	if !ok {
+		return "", false
+	}
+	return n.TransactionsHash, true
+}
+
+// locator returns hashes of the best header chain from its tip back to
+// genesis, spaced like Blockchain.locator
+func (hc *HeaderChain) locator() []string {
+	hc.Lock()
+	defer hc.Unlock()
+
+	var hashes []string
+	step := 1
+	n := hc.best
+	for n.parent != nil {
+		hashes = append(hashes, n.Hash)
+		if len(hashes) >= 10 {
+			step *= 2
+		}
+		for i := 0; i < step && n.parent != nil; i++ {
+			n = n.parent
+		}
+	}
+	return append(hashes, n.Hash)
+}
+
+// missingBlocks returns up to max hashes of blocks on the best header chain
+// whose bodies we don't have yet, oldest first
+func (bc *Blockchain) missingBlocks(max int) []string {
+	bc.RLock()
+	defer bc.RUnlock()
+	bc.headers.Lock()
+	defer bc.headers.Unlock()
+
+	var missing []string
+	for n := bc.headers.best; n != nil; n = n.parent {
+		if _, ok := bc.known[n.Hash]; ok {
+			break
+		}
+		missing = append(missing, n.Hash)
+	}
+	for i, j := 0, len(missing)-1; i < j; i, j = i+1, j-1 {
+		missing[i], missing[j] = missing[j], missing[i]
+	}
+	if len(missing) > max {
+		missing = missing[:max]
+	}
+	return missing
+}
+
+// headersAfter returns up to max active chain headers following the first
+// locator hash on the active chain, or nil if none of them is
+func (bc *Blockchain) headersAfter(locator []string, max int) []BlockHeader {
+	blocks := bc.blocksAfter(locator, max)
+	if blocks == nil {
+		return nil
+	}
+	headers := []BlockHeader{}
+	for _, b := range blocks {
+		headers = append(headers, b.Header())
+	}
+	return headers
+}
+
+// hasBlock reports whether a block is known, on the active chain or not
+func (bc *Blockchain) hasBlock(hash string) bool {
+	bc.RLock()
+	defer bc.RUnlock()
+	_, ok := bc.known[hash]
+	return ok
+}
+
+// blocksByHash returns the known blocks among hashes, in the same order
+func (bc *Blockchain) blocksByHash(hashes []string) []*Block {
+	bc.RLock()
+	defer bc.RUnlock()
+
+	blocks := []*Block{}
+	for _, hash := range hashes {
+		if b, ok := bc.known[hash]; ok {
+			blocks = append(blocks, b)
+		}
+	}
+	return blocks
+}
+
+// syncWith starts or continues headers-first sync with a peer that may be
+// ahead: first headers until it has no more, then the missing bodies
+func (p *Peer) syncWith(height int) error {
+	if height > bc.headers.bestHeight() {
+		return p.send(wire.CmdGetHeaders, GetHeadersMessage{bc.headers.locator()})
+	}
+	return p.requestBlocks()
+}
+
+// requestBlocks asks the peer for the next bodies along the best header chain
+func (p *Peer) requestBlocks() error {
+	if light != nil {
+		return p.requestFiltered()
+	}
+	if missing := bc.missingBlocks(maxBlocksInFlight); len(missing) > 0 {
+		return p.send(wire.CmdGetData, wire.GetDataMessage{Items: wire.NewInv(wire.InvBlock, missing...)})
+	}
+	return nil
+}
+
+// receiveHeaders adds headers sent by a peer and asks for more, or for the
+// bodies once the peer has sent them all
+func (p *Peer) receiveHeaders(headers []BlockHeader) error {
+	if len(headers) > maxHeadersPerMessage {
+		return fmt.Errorf("sent %d headers, more than %d", len(headers), maxHeadersPerMessage)
+	}
+	if err := bc.headers.addHeaders(headers); err != nil {
+		peerStats.recordInvalid(p)
+		return err
+	}
+	if len(headers) == maxHeadersPerMessage {
+		return p.send(wire.CmdGetHeaders, GetHeadersMessage{bc.headers.locator()})
+	}
+	p2pLog.Info("Headers synced", "peer", p.addr, "best_height", bc.headers.bestHeight())
+	return p.requestBlocks()
+}
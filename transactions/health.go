@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"time"
+)
+
+// readinessTimeout is how long a readiness check may wait for a lock before
+// the node counts as stuck
+const readinessTimeout = time.Second
+
+// Health is the answer of /healthz
+type Health struct {
+	Status string
+}
+
+// ReadinessCheck is the outcome of one readiness condition
+type ReadinessCheck struct {
+	Name    string
+	OK      bool
+	Message string `json:",omitempty"`
+}
+
+// Readiness is the answer of /readyz
+type Readiness struct {
+	Ready  bool
+	Checks []ReadinessCheck
+}
+
+// withinTimeout runs f and reports whether it returned in time. f keeps
+// running if not; it's only used for calls that block on a lock.
+func withinTimeout(f func()) bool {
+	done := make(chan struct{})
+	go func() {
+		f()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(readinessTimeout):
+		return false
+	}
+}
+
+// readiness checks whether the node can serve traffic: it isn't shutting
+// down, its block store is open, it has caught up with its peers and its
+// mempool answers
+func readiness() Readiness {
+	var checks []ReadinessCheck
+	check := func(name string, ok bool, message string) {
+		if ok {
+			message = ""
+		}
+		checks = append(checks, ReadinessCheck{name, ok, message})
+	}
+
+	check("shutdown", shutdownCtx.Err() == nil, "the node is shutting down")
+	if light == nil {
+		check("storage", bc.store != nil && !bc.store.closed.Load(), "the block store is closed")
+	}
+
+	var status SyncStatus
+	if !withinTimeout(func() { status = syncStatus() }) {
+		check("sync", false, "the chain is locked")
+	} else {
+		check("sync", !status.InitialSync, "initial block download in progress")
+	}
+
+	if light == nil {
+		check("mempool", bc.mempool != nil && withinTimeout(func() { bc.mempool.Len() }), "the mempool doesn't answer")
+	}
+
+	r := Readiness{Ready: true, Checks: checks}
+	for _, c := range checks {
+		r.Ready = r.Ready && c.OK
+	}
+	return r
+}
+
+// answers as long as the process serves HTTP, for liveness probes
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, r, http.StatusOK, Health{Status: "ok"})
+}
+
+// answers 200 when the node is ready for traffic and 503 otherwise, for
+// readiness probes and load balancers
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	ready := readiness()
+	status := http.StatusOK
+	if !ready.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	respondWithJSON(w, r, status, ready)
+}
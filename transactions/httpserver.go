@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+// HTTPLimits bound what one client can hold of the HTTP servers. A zero
+// timeout means none.
+type HTTPLimits struct {
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+}
+
+var httpLimits = HTTPLimits{
+	ReadHeaderTimeout: 10 * time.Second,
+	ReadTimeout:       30 * time.Second,
+	WriteTimeout:      60 * time.Second,
+	IdleTimeout:       2 * time.Minute,
+	MaxHeaderBytes:    64 << 10,
+}
+
+// loadHTTPLimits reads HTTP_READ_HEADER_TIMEOUT, HTTP_READ_TIMEOUT,
+// HTTP_WRITE_TIMEOUT and HTTP_IDLE_TIMEOUT, durations such as 30s or 0 for
+// none, and HTTP_MAX_HEADER_BYTES and HTTP_MAX_BODY_BYTES. The event streams
+// and archive downloads aren't bound by the write timeout.
+func loadHTTPLimits() {
+	httpLimits.ReadHeaderTimeout = envDuration("HTTP_READ_HEADER_TIMEOUT", httpLimits.ReadHeaderTimeout)
+	httpLimits.ReadTimeout = envDuration("HTTP_READ_TIMEOUT", httpLimits.ReadTimeout)
+	httpLimits.WriteTimeout = envDuration("HTTP_WRITE_TIMEOUT", httpLimits.WriteTimeout)
+	httpLimits.IdleTimeout = envDuration("HTTP_IDLE_TIMEOUT", httpLimits.IdleTimeout)
+	httpLimits.MaxHeaderBytes = envBytes("HTTP_MAX_HEADER_BYTES", httpLimits.MaxHeaderBytes)
+	maxRequestBody = envBytes("HTTP_MAX_BODY_BYTES", maxRequestBody)
+}
+
+func envDuration(name string, def time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		log.Fatalf("%s must be a duration such as 30s, got %q", name, v)
+	}
+	return d
+}
+
+func envBytes(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		log.Fatalf("%s must be a positive number of bytes, got %q", name, v)
+	}
+	return n
+}
+
+// newHTTPServer returns a server with the configured limits, whose requests
+// are canceled when shutting down so streams end
+func newHTTPServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: httpLimits.ReadHeaderTimeout,
+		ReadTimeout:       httpLimits.ReadTimeout,
+		WriteTimeout:      httpLimits.WriteTimeout,
+		IdleTimeout:       httpLimits.IdleTimeout,
+		MaxHeaderBytes:    httpLimits.MaxHeaderBytes,
+		BaseContext:       func(net.Listener) context.Context { return shutdownCtx },
+	}
+}
+
+// keepWriting lifts the write timeout off a response that streams for as
+// long as the client wants
+func keepWriting(w http.ResponseWriter) {
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil && httpLimits.WriteTimeout > 0 {
+		httpLog.Warn("Can't lift the write timeout of a stream", "err", err)
+	}
+}
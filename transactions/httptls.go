@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/tls"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// loadHTTPTLS returns the TLS configuration of the HTTP API, or nil to serve
+// plain HTTP. TLS_CERT and TLS_KEY name a PEM certificate and key; or
+// TLS_AUTOCERT_DOMAINS lists the domains to get certificates for from Let's
+// Encrypt, which needs the API on port 443, or an HTTP challenge listener on
+// TLS_AUTOCERT_HTTP_PORT. TLS_AUTOCERT_EMAIL is the optional ACME contact.
+func loadHTTPTLS() *tls.Config {
+	cert, key := os.Getenv("TLS_CERT"), os.Getenv("TLS_KEY")
+	domains := splitList(os.Getenv("TLS_AUTOCERT_DOMAINS"))
+
+	switch {
+	case cert != "" || key != "":
+		if cert == "" || key == "" {
+			log.Fatal("TLS_CERT and TLS_KEY must be set together")
+		}
+		if len(domains) > 0 {
+			log.Fatal("Set either TLS_CERT and TLS_KEY or TLS_AUTOCERT_DOMAINS, not both")
+		}
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			log.Fatalf("Can't load the API certificate: %v", err)
+		}
+		return &tls.Config{Certificates: []tls.Certificate{pair}, MinVersion: tls.VersionTLS12}
+
+	case len(domains) > 0:
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(filepath.Join(dataDir(), "autocert")),
+			Email:      os.Getenv("TLS_AUTOCERT_EMAIL"),
+		}
+		if port := os.Getenv("TLS_AUTOCERT_HTTP_PORT"); port != "" {
+			go func() {
+				httpLog.Info("ACME HTTP challenges listening", "port", port)
+				log.Fatal(http.ListenAndServe(":"+port, m.HTTPHandler(nil)))
+			}()
+		}
+		httpLog.Info("Getting API certificates from Let's Encrypt", "domains", strings.Join(domains, ","))
+		config := m.TLSConfig()
+		config.MinVersion = tls.VersionTLS12
+		return config
+	}
+	return nil
+}
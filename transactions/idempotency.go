@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// idempotencyTTL is how long a response is replayed for its key
+	idempotencyTTL = 24 * time.Hour
+	// maxIdempotencyKeys bounds the remembered responses; the oldest go
+	// first
+	maxIdempotencyKeys = 10000
+	// maxIdempotencyKeyLength bounds an Idempotency-Key header
+	maxIdempotencyKeyLength = 255
+)
+
+// IdempotencyStore remembers the responses to requests carrying an
+// Idempotency-Key header, so a client retrying after a timeout gets the first
+// answer again instead of spending twice. Keys are per client, like rate
+// limits.
+type IdempotencyStore struct {
+	sync.Mutex
+	responses map[string]*idempotentResponse
+}
+
+type idempotentResponse struct {
+	// fingerprint is the hash of the method, path and body of the request
+	fingerprint [sha256.Size]byte
+	created     time.Time
+	// done is closed once the response below is recorded
+	done   chan struct{}
+	status int
+	header http.Header
+	body   []byte
+}
+
+var idempotency = NewIdempotencyStore()
+
+func NewIdempotencyStore() *IdempotencyStore {
+	return &IdempotencyStore{responses: make(map[string]*idempotentResponse)}
+}
+
+// begin returns the response remembered for key, or remembers a new one to
+// be recorded and returns it with true
+func (s *IdempotencyStore) begin(key string, fingerprint [sha256.Size]byte, now time.Time) (*idempotentResponse, bool) {
+	s.Lock()
+	defer s.Unlock()
+
+	if resp, ok := s.responses[key]; ok && now.Sub(resp.created) < idempotencyTTL {
+		return resp, false
+	}
+	s.prune(now)
+	resp := &idempotentResponse{fingerprint: fingerprint, created: now, done: make(chan struct{})}
+	s.responses[key] = resp
+	return resp, true
+}
+
+// prune drops the expired responses, and the oldest ones beyond
+// maxIdempotencyKeys
+func (s *IdempotencyStore) prune(now time.Time) {
+	var oldest string
+	for key, resp := range s.responses {
+		if now.Sub(resp.created) >= idempotencyTTL {
+			delete(s.responses, key)
+		} else if oldest == "" || resp.created.Before(s.responses[oldest].created) {
+			oldest = key
+		}
+	}
+	if len(s.responses) >= maxIdempotencyKeys {
+		delete(s.responses, oldest)
+	}
+}
+
+// forget drops a response that shouldn't be replayed, so the key can be
+// retried
+func (s *IdempotencyStore) forget(key string, resp *idempotentResponse) {
+	s.Lock()
+	if s.responses[key] == resp {
+		delete(s.responses, key)
+	}
+	s.Unlock()
+	close(resp.done)
+}
+
+// idempotencyRecorder records a response while writing it
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	resp *idempotentResponse
+}
+
+func (rec *idempotencyRecorder) WriteHeader(status int) {
+	if rec.resp.header == nil {
+		rec.resp.status = status
+		rec.resp.header = rec.Header().Clone()
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *idempotencyRecorder) Write(b []byte) (int, error) {
+	if rec.resp.header == nil {
+		rec.WriteHeader(http.StatusOK)
+	}
+	rec.resp.body = append(rec.resp.body, b...)
+	return rec.ResponseWriter.Write(b)
+}
+
+// idempotent replays the response to an earlier request with the same
+// Idempotency-Key header instead of handling it again. Reusing a key for
+// another request is refused, as is retrying while the first one runs.
+// Server errors aren't remembered.
+func idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			respondWithError(w, r, http.StatusBadRequest, "Idempotency-Key is too long")
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(r.Body, int64(maxRequestBody)+1))
+		r.Body.Close()
+		if err != nil {
+			respondWithError(w, r, http.StatusBadRequest, "Can't read request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		fingerprint := sha256.Sum256([]byte(r.Method + " " + r.URL.Path + "\n" + string(body)))
+
+		key = rateLimitClient(r) + " " + key
+		resp, fresh := idempotency.begin(key, fingerprint, time.Now())
+		if !fresh {
+			replayResponse(w, r, resp, fingerprint)
+			return
+		}
+
+		next(&idempotencyRecorder{w, resp}, r)
+		if resp.header == nil || resp.status >= http.StatusInternalServerError {
+			idempotency.forget(key, resp)
+			return
+		}
+		close(resp.done)
+	}
+}
+
+func replayResponse(w http.ResponseWriter, r *http.Request, resp *idempotentResponse, fingerprint [sha256.Size]byte) {
+	if resp.fingerprint != fingerprint {
+		respondWithError(w, r, http.StatusUnprocessableEntity, "Idempotency-Key was already used for another request")
+		return
+	}
+	select {
+	case <-resp.done:
+	default:
+		respondWithError(w, r, http.StatusConflict, "A request with this Idempotency-Key is still being handled")
+		return
+	}
+	if resp.header == nil || resp.status >= http.StatusInternalServerError {
+		// the first request failed and was forgotten after this one found it
+		respondWithError(w, r, http.StatusConflict, "The request with this Idempotency-Key failed, retry it")
+		return
+	}
+
+	for name, values := range resp.header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(resp.status)
+	w.Write(resp.body)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	nodeKeyFile = "node.key"
+
+	tlsHandshakeTimeout = 10 * time.Second
+)
+
+var (
+	// nodeKey is the node's long-lived identity key, kept in DATA_DIR
+	nodeKey ed25519.PrivateKey
+	// nodeID identifies the node to its peers, see nodeIDFor
+	nodeID string
+	// p2pTLS is the TLS configuration of peer connections, nil when P2P_TLS=0
+	p2pTLS *tls.Config
+)
+
+// nodeIDFor derives a node ID from an identity public key: the first 20
+// bytes of its SHA-256 in hex
+func nodeIDFor(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:20])
+}
+
+// loadNodeIdentity reads the identity key, creating one on first start, and
+// sets up TLS for peer connections unless P2P_TLS is 0
+func loadNodeIdentity() {
+	path := filepath.Join(dataDir(), nodeKeyFile)
+	key, err := loadNodeKey(path)
+	if err != nil {
+		log.Fatalf("Can't load node identity key %s: %v", path, err)
+	}
+	nodeKey = key
+	nodeID = nodeIDFor(key.Public().(ed25519.PublicKey))
+	log.Printf("Node ID %s", nodeID)
+
+	if os.Getenv("P2P_TLS") == "0" {
+		return
+	}
+	if p2pTLS, err = newP2PTLSConfig(key); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func loadNodeKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("not a PEM file")
+		}
+		key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		edKey, ok := key.(ed25519.PrivateKey)
+		if !ok {
+			return nil, errors.New("not an Ed25519 key")
+		}
+		return edKey, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	return key, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+}
+
+// newP2PTLSConfig presents a self-signed certificate for the identity key
+// and requires one from the other side. There is no CA: a peer is whoever
+// holds the key its certificate carries, and its node ID says which key.
+func newP2PTLSConfig(key ed25519.PrivateKey) (*tls.Config, error) {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	template.Subject.CommonName = nodeIDFor(key.Public().(ed25519.PublicKey))
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+		MinVersion:   tls.VersionTLS13,
+		ClientAuth:   tls.RequireAnyClientCert,
+		// the chain isn't verified against a CA, the peer's key is checked
+		// in verifyPeerKey instead
+		InsecureSkipVerify:    true,
+		VerifyPeerCertificate: verifyPeerKey,
+	}, nil
+}
+
+// verifyPeerKey accepts a certificate that carries an Ed25519 key
+func verifyPeerKey(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+	if len(rawCerts) == 0 {
+		return errors.New("peer sent no certificate")
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return err
+	}
+	if _, ok := cert.PublicKey.(ed25519.PublicKey); !ok {
+		return errors.New("peer certificate doesn't carry an Ed25519 identity key")
+	}
+	return nil
+}
+
+// splitPeerAddr splits a peer address of the form nodeid@host:port. The
+// node ID is optional; when given, the peer must prove it holds that key.
+func splitPeerAddr(addr string) (id, hostport string) {
+	if id, hostport, ok := strings.Cut(addr, "@"); ok {
+		return strings.ToLower(id), hostport
+	}
+	return "", addr
+}
+
+// secureConn runs the TLS handshake on a new peer connection and returns the
+// encrypted connection with the peer's authenticated node ID. Without TLS
+// the connection is returned as is, with no ID.
+func secureConn(conn net.Conn, inbound bool, wantID string) (net.Conn, string, error) {
+	if p2pTLS == nil {
+		if wantID != "" {
+			return nil, "", errors.New("can't authenticate the peer's node ID with P2P_TLS=0")
+		}
+		return conn, "", nil
+	}
+
+	var tlsConn *tls.Conn
+	if inbound {
+		tlsConn = tls.Server(conn, p2pTLS)
+	} else {
+		tlsConn = tls.Client(conn, p2pTLS)
+	}
+	tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, "", fmt.Errorf("TLS handshake failed: %v", err)
+	}
+	tlsConn.SetDeadline(time.Time{})
+
+	cert := tlsConn.ConnectionState().PeerCertificates[0]
+	id := nodeIDFor(cert.PublicKey.(ed25519.PublicKey))
+	if wantID != "" && id != wantID {
+		return nil, "", fmt.Errorf("peer has node ID %s, expected %s", id, wantID)
+	}
+	return tlsConn, id, nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// maxQueuedJobs is how many blocks may wait to be mined
+	maxQueuedJobs = 100
+	// jobRetention is how long finished jobs can be polled
+	jobRetention = time.Hour
+)
+
+// Job states
+const (
+	JobQueued = "queued"
+	JobMining = "mining"
+	JobDone   = "done"
+	JobFailed = "failed"
+)
+
+var errJobQueueFull = errors.New("ERROR: Too many blocks waiting to be mined, retry later")
+
+// Job is a block being mined for an API request
+type Job struct {
+	ID       string
+	Status   string
+	Txid     string
+	Created  time.Time
+	Finished *time.Time `json:",omitempty"`
+	// Block is the mined block once Status is done
+	Block *Block `json:",omitempty"`
+	// Error tells why a failed job failed
+	Error string `json:",omitempty"`
+}
+
+// JobQueue mines the transactions of API requests one block at a time, so
+// requests don't wait for the proof of work nor race each other for the tip
+type JobQueue struct {
+	sync.Mutex
+	jobs    map[string]*Job
+	pending chan jobRequest
+	start   sync.Once
+}
+
+type jobRequest struct {
+	id string
+	tx *Transaction
+	// span is the request's, which mining the job continues
+	span trace.SpanContext
+	// done, unless nil, is called with the job's outcome
+	done func(error)
+}
+
+var jobs = NewJobQueue()
+
+func NewJobQueue() *JobQueue {
+	return &JobQueue{jobs: make(map[string]*Job), pending: make(chan jobRequest, maxQueuedJobs)}
+}
+
+// Submit queues tx to be mined into a block of its own. The job's spans
+// join the trace of ctx.
+func (q *JobQueue) Submit(ctx context.Context, tx *Transaction) (Job, error) {
+	return q.SubmitThen(ctx, tx, nil)
+}
+
+// SubmitThen is Submit calling done, unless nil, once the job is mined or
+// failed. It isn't called when the job can't be queued.
+func (q *JobQueue) SubmitThen(ctx context.Context, tx *Transaction, done func(error)) (Job, error) {
+	q.start.Do(func() { go q.work() })
+
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return Job{}, err
+	}
+	job := &Job{ID: hex.EncodeToString(id[:]), Status: JobQueued, Txid: tx.ID, Created: time.Now()}
+
+	q.Lock()
+	defer q.Unlock()
+	q.prune(job.Created)
+	select {
+	case q.pending <- jobRequest{job.ID, tx, trace.SpanContextFromContext(ctx), done}:
+	default:
+		return Job{}, errJobQueueFull
+	}
+	q.jobs[job.ID] = job
+	return *job, nil
+}
+
+// Get returns a copy of a job
+func (q *JobQueue) Get(id string) (Job, bool) {
+	q.Lock()
+	defer q.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// prune forgets jobs that finished more than jobRetention ago
+func (q *JobQueue) prune(now time.Time) {
+	for id, job := range q.jobs {
+		if job.Finished != nil && now.Sub(*job.Finished) > jobRetention {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+func (q *JobQueue) update(id string, f func(job *Job)) {
+	q.Lock()
+	defer q.Unlock()
+	if job, ok := q.jobs[id]; ok {
+		f(job)
+	}
+}
+
+// work mines the queued jobs in order
+func (q *JobQueue) work() {
+	for req := range q.pending {
+		q.update(req.id, func(job *Job) { job.Status = JobMining })
+		ctx := trace.ContextWithSpanContext(shutdownCtx, req.span)
+		block, err := mineBlock(ctx, req.id, req.tx)
+
+		now := time.Now()
+		q.update(req.id, func(job *Job) {
+			job.Finished = &now
+			if err != nil {
+				job.Status, job.Error = JobFailed, errorMessage(err.Error())
+				return
+			}
+			job.Status, job.Block = JobDone, block
+		})
+		if err != nil {
+			minerLog.Error("Mining job failed", "job", req.id, "err", err)
+		}
+		if req.done != nil {
+			req.done(err)
+		}
+	}
+}
+
+// mineBlock mines tx into a new block on the tip and relays it
+func mineBlock(ctx context.Context, id string, tx *Transaction) (block *Block, err error) {
+	ctx, span := tracer.Start(ctx, "mining job", trace.WithAttributes(attribute.String("job.id", id), txAttribute(tx)))
+	defer func() { endSpan(span, err) }()
+
+	newBlock, err := generateBlock(ctx, bc.Tip(), tx)
+	if err != nil {
+		return nil, err
+	}
+	if err := commitBlock(ctx, newBlock); err != nil {
+		return nil, err
+	}
+	relayBlock(newBlock, nil)
+	minerLog.Info("Mined block", "hash", newBlock.Hash, "parent", newBlock.PrevHash, "txs", len(newBlock.Transactions))
+	return newBlock, nil
+}
+
+// reports the status of a mining job, and its block once mined
+func handleGetJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := jobs.Get(mux.Vars(r)["id"])
+	if !ok {
+		respondWithError(w, r, http.StatusNotFound, "No such job")
+		return
+	}
+	respondWithJSON(w, r, http.StatusOK, job)
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/VOOVOOZEL/go_blockchain/transactions/wire"
+	"github.com/gorilla/mux"
+)
+
+const (
+	lightStateFile = "light.json"
+
+	// maxFilterAddresses caps the addresses a light peer may watch
+	maxFilterAddresses = 1000
+)
+
+// FilterLoadMessage tells a full peer, or a WebSocket server, which
+// addresses a light client watches: listed, or hidden in a bloom filter
+type FilterLoadMessage struct {
+	Addresses []string     `json:",omitempty"`
+	Bloom     *BloomFilter `json:",omitempty"`
+}
+
+// filterLoadMessageV1 is version 1 of filterload, addresses only
+type filterLoadMessageV1 struct {
+	Addresses []string
+}
+
+func (m *filterLoadMessageV1) Upgrade() interface{} {
+	return &FilterLoadMessage{Addresses: m.Addresses}
+}
+
+// GetFilteredMessage asks a full peer for the watched transactions of blocks
+type GetFilteredMessage struct {
+	Hashes []string
+}
+
+// FilteredMessage answers getfiltered, one entry per block found
+type FilteredMessage struct {
+	Blocks []FilteredBlock
+}
+
+// FilteredBlock is the watched transactions of a block with proofs of
+// their inclusion
+type FilteredBlock struct {
+	Hash    string
+	Matches []FilteredTx
+}
+
+// FilteredTx is a transaction and its Merkle proof
+type FilteredTx struct {
+	Transaction *Transaction
+	Proof       MerkleProof
+}
+
+// LightClient keeps headers only, plus the transactions of the watched
+// addresses, which full peers prove are in blocks of the header chain
+type LightClient struct {
+	sync.Mutex
+	watched addressSet
+	// bloom is the filter of the watched addresses full peers load
+	bloom *BloomFilter
+	// matches are the verified watched transactions per block hash
+	matches map[string][]*Transaction
+	// scanned are the blocks whose watched transactions we have
+	scanned map[string]bool
+}
+
+// lightState is what a light client keeps on disk
+type lightState struct {
+	Headers []BlockHeader
+	Matches map[string][]*Transaction
+	Scanned []string
+}
+
+// light is set when the node runs with LIGHT=1
+var light *LightClient
+
+// startLight switches the node to light client mode when LIGHT is set,
+// watching the addresses in WATCH_ADDRESSES, and restores its saved headers
+func startLight() {
+	if os.Getenv("LIGHT") != "1" {
+		return
+	}
+
+	light = &LightClient{
+		watched: make(addressSet),
+		matches: make(map[string][]*Transaction),
+		scanned: make(map[string]bool),
+	}
+	for _, address := range strings.Split(os.Getenv("WATCH_ADDRESSES"), ",") {
+		if address = strings.TrimSpace(address); address != "" {
+			light.watched[address] = true
+		}
+	}
+	if len(light.watched) == 0 {
+		log.Fatal("LIGHT=1 needs the addresses to watch in WATCH_ADDRESSES")
+	}
+	if len(light.watched) > maxFilterAddresses {
+		log.Fatalf("WATCH_ADDRESSES may list at most %d addresses", maxFilterAddresses)
+	}
+	light.bloom = newBloomFilter(len(light.watched), lightFilterFalsePositives, rand.Uint32())
+	for address := range light.watched {
+		light.bloom.Add(address)
+	}
+
+	// we have the genesis block in full
+	genesis := bc.Blocks(0, 1)[0]
+	light.record(genesis.Hash, light.filter(genesis.Transactions))
+
+	data, err := os.ReadFile(filepath.Join(dataDir(), lightStateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		log.Printf("Light client watching %d addresses", len(light.watched))
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	var state lightState
+	if err := json.Unmarshal(data, &state); err != nil {
+		log.Fatal(err)
+	}
+	if err := bc.headers.addHeaders(state.Headers); err != nil {
+		log.Fatalf("Saved light client headers are invalid: %v", err)
+	}
+	for _, hash := range state.Scanned {
+		// the watched addresses may have changed since; rescan if so
+		light.record(hash, light.filter(state.Matches[hash]))
+	}
+	log.Printf("Light client watching %d addresses, header height %d", len(light.watched), bc.headers.bestHeight())
+}
+
+// filter returns the transactions that pay or spend from a watched address
+func (lc *LightClient) filter(txs []*Transaction) []*Transaction {
+	var matched []*Transaction
+	for _, tx := range txs {
+		if txMatches(tx, lc.watched) {
+			matched = append(matched, tx)
+		}
+	}
+	return matched
+}
+
+func (lc *LightClient) record(hash string, txs []*Transaction) {
+	lc.Lock()
+	defer lc.Unlock()
+	lc.matches[hash] = txs
+	lc.scanned[hash] = true
+}
+
+// save writes the best header chain and the verified transactions to disk
+func (lc *LightClient) save() error {
+	state := lightState{Matches: make(map[string][]*Transaction)}
+	for _, n := range bc.headers.bestChain()[1:] {
+		state.Headers = append(state.Headers, n.BlockHeader)
+	}
+
+	lc.Lock()
+	for hash := range lc.scanned {
+		state.Scanned = append(state.Scanned, hash)
+		if txs := lc.matches[hash]; len(txs) > 0 {
+			state.Matches[hash] = txs
+		}
+	}
+	lc.Unlock()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dataDir(), lightStateFile), data)
+}
+
+// unscanned returns up to max blocks of the best header chain whose watched
+// transactions we don't have yet, oldest first
+func (lc *LightClient) unscanned(max int) []string {
+	chain := bc.headers.bestChain()
+
+	lc.Lock()
+	defer lc.Unlock()
+	var hashes []string
+	for _, n := range chain {
+		if !lc.scanned[n.Hash] {
+			hashes = append(hashes, n.Hash)
+			if len(hashes) == max {
+				break
+			}
+		}
+	}
+	return hashes
+}
+
+// scannedHeight is the height up to which every block has been scanned
+func (lc *LightClient) scannedHeight() int {
+	chain := bc.headers.bestChain()
+
+	lc.Lock()
+	defer lc.Unlock()
+	for height, n := range chain {
+		if !lc.scanned[n.Hash] {
+			return height - 1
+		}
+	}
+	return len(chain) - 1
+}
+
+// Balance replays the watched transactions along the best header chain. Only
+// watched addresses have a known balance.
+func (lc *LightClient) Balance(address string) (Amount, error) {
+	if !lc.watched[address] {
+		return 0, errors.New("ERROR: Address is not watched by this light client")
+	}
+	chain := bc.headers.bestChain()
+
+	lc.Lock()
+	defer lc.Unlock()
+	unspent := make(map[string]TXOutput)
+	for _, n := range chain {
+		for _, tx := range lc.matches[n.Hash] {
+			for _, in := range tx.Vin {
+				delete(unspent, fmt.Sprintf("%s:%d", in.Txid, in.Vout))
+			}
+			for i, out := range tx.Vout {
+				if out.ScriptPubKey == address {
+					unspent[fmt.Sprintf("%s:%d", tx.ID, i)] = out
+				}
+			}
+		}
+	}
+
+	var balance Amount
+	for _, out := range unspent {
+		if !addAmount(&balance, out.Value) {
+			return 0, fmt.Errorf("ERROR: Balance of %s exceeds %d", address, maxMoney)
+		}
+	}
+	return balance, nil
+}
+
+// txMatches reports whether a transaction pays or spends from one of the
+// addresses
+func txMatches(tx *Transaction, addresses addressFilter) bool {
+	for _, out := range tx.Vout {
+		if addresses.matches(out.ScriptPubKey) {
+			return true
+		}
+	}
+	if tx.IsCoinbase() {
+		return false
+	}
+	for _, in := range tx.Vin {
+		if addresses.matches(inputOwner(in)) {
+			return true
+		}
+	}
+	return false
+}
+
+// requestFiltered loads our filter into a full peer and asks it for the
+// watched transactions of the next blocks we haven't scanned
+func (p *Peer) requestFiltered() error {
+	p.Lock()
+	loaded := p.filterLoaded
+	p.filterLoaded = true
+	p.Unlock()
+
+	if !loaded {
+		// the peer learns a bloom filter matching the watched addresses
+		// among others, not the addresses themselves
+		if err := p.send(wire.CmdFilterLoad, FilterLoadMessage{Bloom: light.bloom}); err != nil {
+			return err
+		}
+	}
+	if hashes := light.unscanned(maxBlocksPerMessage); len(hashes) > 0 {
+		return p.send(wire.CmdGetFiltered, GetFilteredMessage{hashes})
+	}
+	return nil
+}
+
+// receiveFiltered checks the proofs sent by a full peer and records the
+// transactions, then asks for more
+func (p *Peer) receiveFiltered(m *FilteredMessage) error {
+	recorded := 0
+	for _, fb := range m.Blocks {
+		root, ok := bc.headers.transactionsHash(fb.Hash)
+		if !ok {
+			continue
+		}
+		var txs []*Transaction
+		for _, match := range fb.Matches {
+			tx := match.Transaction
+			if tx == nil {
+				peerStats.recordInvalid(p)
+				return fmt.Errorf("sent an empty match for block %s", fb.Hash)
+			}
+			if err := checkTransactionID(tx); err != nil {
+				peerStats.recordInvalid(p)
+				return err
+			}
+			if err := match.Proof.verify(tx.ID, root); err != nil {
+				peerStats.recordInvalid(p)
+				return fmt.Errorf("transaction %s in block %s: %v", tx.ID, fb.Hash, err)
+			}
+			if txMatches(tx, light.watched) {
+				txs = append(txs, tx)
+			}
+		}
+		light.record(fb.Hash, txs)
+		recorded++
+	}
+	if recorded == 0 {
+		return nil
+	}
+	if err := light.save(); err != nil {
+		log.Printf("Can't save light client state: %v", err)
+	}
+	return p.requestFiltered()
+}
+
+// receiveAnnouncement handles a block announced to a light client: only its
+// header is kept, and the watched transactions are requested
+func (p *Peer) receiveAnnouncement(h BlockHeader) error {
+	if err := bc.headers.addHeaders([]BlockHeader{h}); err != nil {
+		return p.send(wire.CmdGetHeaders, GetHeadersMessage{bc.headers.locator()})
+	}
+	return p.requestFiltered()
+}
+
+// sendFiltered answers getfiltered with the transactions matching the
+// peer's filter
+func (p *Peer) sendFiltered(m *GetFilteredMessage) error {
+	if len(m.Hashes) > maxBlocksPerMessage {
+		return fmt.Errorf("asked for %d filtered blocks, more than %d", len(m.Hashes), maxBlocksPerMessage)
+	}
+	p.Lock()
+	filter := p.filter
+	p.Unlock()
+	if filter == nil {
+		return errors.New("asked for filtered blocks without loading a filter")
+	}
+
+	reply := FilteredMessage{Blocks: []FilteredBlock{}}
+	for _, b := range bc.blocksByHash(m.Hashes) {
+		var txids []string
+		for _, tx := range b.Transactions {
+			txids = append(txids, tx.ID)
+		}
+		fb := FilteredBlock{Hash: b.Hash, Matches: []FilteredTx{}}
+		for i, tx := range b.Transactions {
+			if txMatches(tx, filter) {
+				fb.Matches = append(fb.Matches, FilteredTx{tx, newMerkleProof(txids, i)})
+			}
+		}
+		reply.Blocks = append(reply.Blocks, fb)
+	}
+	return p.send(wire.CmdFiltered, reply)
+}
+
+// loadFilter sets the addresses a light peer watches
+func (p *Peer) loadFilter(m *FilterLoadMessage) error {
+	filter, err := m.filter()
+	if err != nil {
+		return err
+	}
+	if filter == nil {
+		filter = addressSet{}
+	}
+	p.Lock()
+	p.filter = filter
+	p.Unlock()
+	return nil
+}
+
+// wantsTx tells if a transaction matches the peer's filter; peers without
+// one want every transaction
+func (p *Peer) wantsTx(tx *Transaction) bool {
+	p.Lock()
+	filter := p.filter
+	p.Unlock()
+	return filter == nil || txMatches(tx, filter)
+}
+
+// LightStatus is what /light reports
+type LightStatus struct {
+	Watched       []string
+	HeaderHeight  int
+	ScannedHeight int
+}
+
+// reports the light client's watched addresses and scan progress
+func handleGetLight(w http.ResponseWriter, r *http.Request) {
+	status := LightStatus{Watched: []string{}, HeaderHeight: bc.headers.bestHeight(), ScannedHeight: light.scannedHeight()}
+	for address := range light.watched {
+		status.Watched = append(status.Watched, address)
+	}
+	sort.Strings(status.Watched)
+	respondWithJSON(w, r, http.StatusOK, status)
+}
+
+// makeLightRouter serves the few endpoints that make sense without the
+// chain's blocks
+func makeLightRouter() http.Handler {
+	muxRouter := mux.NewRouter()
+	for _, api := range []*mux.Router{muxRouter, muxRouter.PathPrefix(apiVersionPrefix).Subrouter()} {
+		api.HandleFunc("/balance", handleGetBalance).Methods("POST")
+		api.HandleFunc("/balance/{address}", handleGetAddressBalance).Methods("GET")
+		api.HandleFunc("/light", handleGetLight).Methods("GET")
+		api.HandleFunc("/sync", handleGetSync).Methods("GET")
+		api.HandleFunc("/peers", handleGetPeers).Methods("GET")
+	}
+	muxRouter.HandleFunc("/healthz", handleHealthz).Methods("GET")
+	muxRouter.HandleFunc("/readyz", handleReadyz).Methods("GET")
+	muxRouter.HandleFunc("/openapi.json", serveOpenAPI(muxRouter)).Methods("GET")
+	muxRouter.HandleFunc("/docs", handleDocs).Methods("GET")
+	muxRouter.NotFoundHandler = http.HandlerFunc(handleNotFound)
+	muxRouter.MethodNotAllowedHandler = http.HandlerFunc(handleMethodNotAllowed)
+	muxRouter.Use(aclMiddleware)
+	muxRouter.Use(rateLimitMiddleware)
+	muxRouter.Use(authMiddleware)
+	return corsHandler(muxRouter)
+}
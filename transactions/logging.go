@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// the subsystem loggers. Everything else logs through the log package,
+// which setupLogging routes into the same handler at level info.
+var (
+	minerLog   = slog.Default()
+	httpLog    = slog.Default()
+	p2pLog     = slog.Default()
+	storageLog = slog.Default()
+)
+
+// setupLogging writes leveled, structured logs to w: text or JSON as
+// LOG_FORMAT says, from LOG_LEVEL up (debug, info, warn or error)
+func setupLogging(w io.Writer) {
+	var level slog.Level
+	if v := os.Getenv("LOG_LEVEL"); v != "" {
+		if err := level.UnmarshalText([]byte(v)); err != nil {
+			log.Fatalf("LOG_LEVEL must be debug, info, warn or error, got %q", v)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+	switch format := strings.ToLower(os.Getenv("LOG_FORMAT")); format {
+	case "", "text":
+		handler = slog.NewTextHandler(w, opts)
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	default:
+		log.Fatalf("LOG_FORMAT must be text or json, got %q", format)
+	}
+
+	logger := slog.New(handler)
+	slog.SetDefault(logger)
+	minerLog = logger.With("subsystem", "miner")
+	httpLog = logger.With("subsystem", "http")
+	p2pLog = logger.With("subsystem", "p2p")
+	storageLog = logger.With("subsystem", "storage")
+}
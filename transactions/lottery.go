@@ -0,0 +1,307 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/VOOVOOZEL/go_blockchain/transactions/sdk"
+	"github.com/gorilla/mux"
+)
+
+// lotteryPotPrefix marks the pot address of a lottery. Nobody holds a key for
+// it; its outputs can only be spent by the lottery's payout.
+const lotteryPotPrefix = "lottery:"
+
+// Lottery is an on-chain lottery, part of the chain parameters.
+//
+// A ticket is an output paying exactly TicketPrice to the pot address in a
+// block at or below CloseHeight; it belongs to whoever unlocked the first
+// input of its transaction. Once the block at DrawHeight exists, its beacon
+// picks the winner and the payout may be mined: a transaction spending every
+// ticket in chain order to a single output paying the whole pot to the
+// winner. Consensus rejects late tickets, tickets of the wrong price and any
+// other spend of the pot.
+type Lottery struct {
+	Name        string
+	TicketPrice Amount
+	CloseHeight int
+}
+
+// Ticket is one ticket of a lottery
+type Ticket struct {
+	Txid   string
+	Vout   int
+	Height int
+	Owner  string
+}
+
+// LotteryStatus is the JSON view of a lottery
+type LotteryStatus struct {
+	Lottery
+	Pot        string
+	DrawHeight int
+	Tickets    []Ticket
+	Jackpot    Amount
+	Beacon     *sdk.Beacon  `json:",omitempty"`
+	Winner     string       `json:",omitempty"`
+	Payout     *Transaction `json:",omitempty"`
+	PaidOut    bool
+}
+
+// Pot is the address tickets are paid to
+func (l *Lottery) Pot() string {
+	return lotteryPotPrefix + l.Name
+}
+
+// DrawHeight is the height whose beacon draws the winner. Its window only
+// covers blocks after CloseHeight, so the outcome is unknown while tickets
+// are sold.
+func (l *Lottery) DrawHeight() int {
+	return l.CloseHeight + sdk.BeaconWindow
+}
+
+// parseLotteries reads LOTTERIES, a comma separated list of
+// name:ticketPrice:closeHeight
+func parseLotteries() []Lottery {
+	var lotteries []Lottery
+	for _, entry := range splitList(os.Getenv("LOTTERIES")) {
+		parts := strings.Split(entry, ":")
+		if len(parts) != 3 || parts[0] == "" {
+			log.Fatalf("invalid lottery %q, want name:ticketPrice:closeHeight", entry)
+		}
+		price, err1 := strconv.ParseInt(parts[1], 10, 64)
+		closeHeight, err2 := strconv.Atoi(parts[2])
+		if err1 != nil || err2 != nil || price <= 0 || Amount(price) > maxMoney || closeHeight < 0 {
+			log.Fatalf("invalid lottery %q, want name:ticketPrice:closeHeight", entry)
+		}
+		lotteries = append(lotteries, Lottery{parts[0], Amount(price), closeHeight})
+	}
+	return lotteries
+}
+
+// lotteryByPot returns the lottery owning a pot address
+func lotteryByPot(address string) *Lottery {
+	if !strings.HasPrefix(address, lotteryPotPrefix) {
+		return nil
+	}
+	for i := range params.Lotteries {
+		if params.Lotteries[i].Pot() == address {
+			return &params.Lotteries[i]
+		}
+	}
+	return nil
+}
+
+// tickets lists the lottery's tickets in chain order. chain is the active
+// chain up to at least CloseHeight, or shorter while tickets are still sold.
+func (l *Lottery) tickets(chain []*Block) []Ticket {
+	var tickets []Ticket
+	pot := l.Pot()
+	for height := 0; height < len(chain) && height <= l.CloseHeight; height++ {
+		for _, tx := range chain[height].Transactions {
+			if len(tx.Vin) == 0 {
+				continue
+			}
+			for idx, out := range tx.Vout {
+				if out.ScriptPubKey == pot {
+					tickets = append(tickets, Ticket{tx.ID, idx, height, inputOwner(tx.Vin[0])})
+				}
+			}
+		}
+	}
+	return tickets
+}
+
+// inputOwner returns the address that unlocked an input
+func inputOwner(in TXInput) string {
+	if len(in.PubKey) > 0 {
+		return sdk.AddressFromPubKey(in.PubKey)
+	}
+	return in.ScriptSig
+}
+
+// draw returns the beacon at DrawHeight and the winning ticket. chain must
+// reach DrawHeight and the lottery must have tickets.
+func (l *Lottery) draw(chain []*Block, tickets []Ticket) (*sdk.Beacon, Ticket, error) {
+	height := l.DrawHeight()
+	first := height - sdk.BeaconWindow + 1
+	if first < 0 {
+		first = 0
+	}
+	var hashes []string
+	for _, b := range chain[first : height+1] {
+		hashes = append(hashes, b.Hash)
+	}
+	value, err := sdk.BeaconValue(height, hashes)
+	if err != nil {
+		return nil, Ticket{}, err
+	}
+
+	winner := new(big.Int).SetBytes(value)
+	winner.Mod(winner, big.NewInt(int64(len(tickets))))
+	beacon := &sdk.Beacon{Height: height, Window: len(hashes), BlockHashes: hashes, Value: hex.EncodeToString(value)}
+	return beacon, tickets[winner.Int64()], nil
+}
+
+// payout builds the transaction paying the pot to the winner
+func (l *Lottery) payout(tickets []Ticket, winner Ticket) *Transaction {
+	tx := &Transaction{}
+	for _, t := range tickets {
+		tx.Vin = append(tx.Vin, TXInput{t.Txid, t.Vout, l.Pot(), nil, nil})
+	}
+	tx.Vout = []TXOutput{{Amount(len(tickets)) * l.TicketPrice, winner.Owner}}
+	tx.SetID()
+	return tx
+}
+
+// checkLotteryRules applies the lottery rules to a transaction in a block at
+// height, where chain holds the blocks below it and view the outputs it may
+// spend
+func checkLotteryRules(view *utxoView, tx *Transaction, height int, chain []*Block) error {
+	if len(params.Lotteries) == 0 {
+		return nil
+	}
+
+	for _, out := range tx.Vout {
+		l := lotteryByPot(out.ScriptPubKey)
+		if l == nil {
+			continue
+		}
+		if tx.IsCoinbase() || len(tx.Vin) == 0 {
+			return fmt.Errorf("ERROR: %s tickets must be bought with inputs", l.Name)
+		}
+		if height > l.CloseHeight {
+			return fmt.Errorf("ERROR: Lottery %s closed at height %d", l.Name, l.CloseHeight)
+		}
+		if out.Value != l.TicketPrice {
+			return fmt.Errorf("ERROR: %s tickets cost %d", l.Name, l.TicketPrice)
+		}
+	}
+
+	if tx.IsCoinbase() {
+		return nil
+	}
+	for _, in := range tx.Vin {
+		prev, ok := view.get(outpoint{in.Txid, in.Vout})
+		if !ok {
+			continue
+		}
+		if l := lotteryByPot(prev.ScriptPubKey); l != nil {
+			return l.checkPayout(tx, height, chain)
+		}
+	}
+	return nil
+}
+
+// checkPayout verifies that tx is the lottery's payout and may be mined at
+// height
+func (l *Lottery) checkPayout(tx *Transaction, height int, chain []*Block) error {
+	if height <= l.DrawHeight() {
+		return fmt.Errorf("ERROR: Lottery %s draws at height %d", l.Name, l.DrawHeight())
+	}
+	tickets := l.tickets(chain)
+	if len(tickets) == 0 {
+		return fmt.Errorf("ERROR: Lottery %s has no tickets", l.Name)
+	}
+	_, winner, err := l.draw(chain, tickets)
+	if err != nil {
+		return err
+	}
+
+	want := l.payout(tickets, winner)
+	if len(tx.Vin) != len(want.Vin) || len(tx.Vout) != 1 || tx.Vout[0] != want.Vout[0] {
+		return fmt.Errorf("ERROR: Transaction %s isn't the payout of lottery %s", tx.ID, l.Name)
+	}
+	for i, in := range tx.Vin {
+		if in.Txid != want.Vin[i].Txid || in.Vout != want.Vin[i].Vout || in.ScriptSig != l.Pot() {
+			return fmt.Errorf("ERROR: Transaction %s isn't the payout of lottery %s", tx.ID, l.Name)
+		}
+	}
+	return nil
+}
+
+// LotteryStatus reports the tickets, draw and payout of a lottery
+func (bc *Blockchain) LotteryStatus(name string) (*LotteryStatus, error) {
+	l := lotteryByPot(lotteryPotPrefix + name)
+	if l == nil {
+		return nil, fmt.Errorf("ERROR: No lottery named %s", name)
+	}
+
+	bc.RLock()
+	defer bc.RUnlock()
+
+	status := &LotteryStatus{Lottery: *l, Pot: l.Pot(), DrawHeight: l.DrawHeight()}
+	status.Tickets = l.tickets(bc.blocks)
+	status.Jackpot = Amount(len(status.Tickets)) * l.TicketPrice
+	if len(status.Tickets) == 0 || len(bc.blocks) <= l.DrawHeight() {
+		return status, nil
+	}
+
+	beacon, winner, err := l.draw(bc.blocks, status.Tickets)
+	if err != nil {
+		return nil, err
+	}
+	tip := len(bc.blocks) - 1
+	beacon.Confirmations = tip - beacon.Height + 1
+	beacon.Final = beacon.Confirmations >= sdk.BeaconConfirmations
+	status.Beacon = beacon
+	status.Winner = winner.Owner
+
+	first := status.Tickets[0]
+	if _, unspent := bc.utxo.UTXOSet[outpoint{first.Txid, first.Vout}]; unspent {
+		status.Payout = l.payout(status.Tickets, winner)
+	} else {
+		status.PaidOut = true
+	}
+	return status, nil
+}
+
+// reports a lottery's tickets, draw and payout
+func handleGetLottery(w http.ResponseWriter, r *http.Request) {
+	status, err := bc.LotteryStatus(mux.Vars(r)["name"])
+	if err != nil {
+		respondWithError(w, r, http.StatusNotFound, err.Error())
+		return
+	}
+
+	respondWithJSON(w, r, http.StatusOK, status)
+}
+
+// submits a drawn lottery's payout to the mempool
+func handleLotteryPayout(w http.ResponseWriter, r *http.Request) {
+	status, err := bc.LotteryStatus(mux.Vars(r)["name"])
+	if err != nil {
+		respondWithError(w, r, http.StatusNotFound, err.Error())
+		return
+	}
+	if status.Payout == nil {
+		respondWithError(w, r, http.StatusConflict, errLotteryNotPayable(status).Error())
+		return
+	}
+
+	if err := bc.AcceptTransaction(r.Context(), status.Payout); err != nil {
+		respondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	relayTransaction(status.Payout, nil)
+
+	respondWithJSON(w, r, http.StatusAccepted, status.Payout)
+}
+
+func errLotteryNotPayable(status *LotteryStatus) error {
+	switch {
+	case status.PaidOut:
+		return errors.New("ERROR: Lottery already paid out")
+	case len(status.Tickets) == 0:
+		return errors.New("ERROR: Lottery has no tickets")
+	default:
+		return fmt.Errorf("ERROR: Lottery draws at height %d", status.DrawHeight)
+	}
+}
@@ -2,28 +2,31 @@ package main
 
 import (
 	"bytes"
-	"crypto/sha256"
-	"encoding/hex"
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
+	"unicode"
 
-	"github.com/davecgh/go-spew/spew"
 	"github.com/gorilla/mux"
-	"github.com/joho/godotenv"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-const (
-	difficulty = 1
-
-	genesisCoinbaseData = "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks"
-)
+// difficulty is the number of leading zero hex digits a block hash needs,
+// the network's genesis difficulty unless DIFFICULTY is set
+var difficulty = 1
 
 // Block represents each 'item' in the blockchain
 type Block struct {
@@ -31,41 +34,120 @@ type Block struct {
 	Transactions []*Transaction
 	Hash         string
 	PrevHash     string
-	Nonce        string
+	Nonce        uint64
+
+	// txHash caches HashTransactions for blocks whose transactions are
+	// settled, like a miner's candidate
+	txHash []byte
 }
 
 // Blockchain is a series of validated Blocks
 type Blockchain struct {
-	sync.Mutex
-	blocks []*Block
+	sync.RWMutex
+	blocks []*Block // the active chain, by height
+
+	known   map[string]*Block        // every validated block, including side branches
+	heights map[string]int           // height of every known block
+	index   map[string]int           // height of every active chain block
+	invalid map[string]bool          // blocks marked invalid, see InvalidateBlock
+	undo    map[string][]spentOutput // outputs spent by each connected block
+	utxo    *UTXOCache               // unspent outputs of the active chain
+	txIndex map[string]string        // block hash of every active chain transaction
+	mempool *Mempool                 // transactions waiting for a block
+	store   *BlockStore              // on-disk copy of every known block
+	headers *HeaderChain             // validated headers, possibly ahead of the blocks
 }
 
+// NewGenesisBlock builds the genesis block of the network from its chain
+// params. It has no proof of work; its hash commits to its contents like
+// any other block's.
 func NewGenesisBlock() *Block {
-	genesisBlock := &Block{}
-	return &Block{time.Now().String(), []*Transaction{NewCoinbaseTX("Ivan", genesisCoinbaseData)}, calculateHash(genesisBlock), "", ""}
+	genesis := &Block{
+		Timestamp:    params.Genesis.Timestamp,
+		Transactions: []*Transaction{NewCoinbaseTX(params.Genesis.Address, params.Genesis.Message)},
+	}
+	genesis.Hash = calculateHash(genesis)
+	return genesis
 }
 
-func (bc *Blockchain) AddBlock(newBlock *Block) {
-	bc.Lock()
-	defer bc.Unlock()
-	bc.blocks = append(bc.blocks, newBlock)
-}
+// NewBlockchain opens the chain kept in store, recording the network's
+// genesis block in an empty store. Stored blocks are loaded with loadBlocks
+func NewBlockchain(store *BlockStore) Blockchain {
+	genesisHash, err := store.Genesis()
+	if err != nil {
+		log.Fatal(err)
+	}
 
-func NewBlockchain() Blockchain {
 	genesisBlock := NewGenesisBlock()
-	spew.Dump(genesisBlock)
-	return Blockchain{sync.Mutex{}, []*Block{genesisBlock}}
+	if genesisHash == "" {
+		if err := store.SetGenesis(genesisBlock); err != nil {
+			log.Fatal(err)
+		}
+	} else if genesisHash != genesisBlock.Hash {
+		log.Fatalf("The stored chain starts at genesis block %s, not the %s network's %s; it belongs to another network or predates fixed genesis blocks, move %s away to start over",
+			genesisHash, params.Name, genesisBlock.Hash, store.dir)
+	}
+	storageLog.Debug("Genesis block", "hash", genesisBlock.Hash, "timestamp", genesisBlock.Timestamp)
+
+	utxo := newUTXOCache()
+	view := utxo.view()
+	undo, err := view.connectBlock(genesisBlock, nil, true)
+	if err != nil {
+		log.Fatal(err)
+	}
+	view.commit()
+
+	txIndex := make(map[string]string)
+	for _, tx := range genesisBlock.Transactions {
+		txIndex[tx.ID] = genesisBlock.Hash
+	}
+
+	return Blockchain{
+		blocks:  []*Block{genesisBlock},
+		known:   map[string]*Block{genesisBlock.Hash: genesisBlock},
+		heights: map[string]int{genesisBlock.Hash: 0},
+		index:   map[string]int{genesisBlock.Hash: 0},
+		invalid: make(map[string]bool),
+		undo:    map[string][]spentOutput{genesisBlock.Hash: undo},
+		utxo:    utxo,
+		txIndex: txIndex,
+		mempool: NewMempool(),
+		store:   store,
+		headers: newHeaderChain(genesisBlock),
+	}
+}
+
+// dataDir returns the directory the node keeps its data in. Networks other
+// than main get a subdirectory, so switching NETWORK never mixes chains.
+func dataDir() string {
+	dir := "data"
+	if v := os.Getenv("DATA_DIR"); v != "" {
+		dir = v
+	}
+	if params.Name != "main" {
+		dir = filepath.Join(dir, params.Name)
+	}
+	return dir
 }
 
 // SendMessage takes incoming JSON payload for writing heart rate
 type SendMessage struct {
-	From, To string
-	Value    int
+	From  string `validate:"required,address"`
+	To    string `validate:"required,address"`
+	Value Amount `validate:"required,min=1,max=2100000000000000"`
 }
 
 // SendMessage takes incoming JSON payload for writing heart rate
 type BalanceMessage struct {
-	Address string
+	Address string `validate:"required,address"`
+}
+
+// RefundMessage asks the node to pay a received transaction back. To
+// overrides the refund address, which defaults to the payment's first input
+type RefundMessage struct {
+	Txid string `validate:"required"`
+	From string `validate:"required,address"`
+	To   string `validate:"address"`
 }
 
 var (
@@ -73,49 +155,317 @@ var (
 )
 
 func main() {
-	err := godotenv.Load()
-	if err != nil {
+	if err := rootCmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+// startNode loads the chain and runs the node until it's stopped
+func startNode() {
+	loadMinerConfig()
+	loadAccessLists()
+	loadCacheConfig()
+	loadResourceConfig()
+	loadFederationConfig()
+	loadAuthConfig()
+	loadRateLimits()
+	loadCORSConfig()
+	loadAdminConfig()
+	loadHTTPLimits()
+	loadFaucetConfig()
+	loadAlertConfig()
+	loadAuditConfig()
+	startTracing()
+
+	if err := openChain(); err != nil {
+		log.Fatal(err)
+	}
+	if err := bc.validateOnStartup(); err != nil {
 		log.Fatal(err)
 	}
+	watchChainMetrics()
+	chainCache.watchReorgs()
+	watchConsensus()
+	startLight()
+	if url := os.Getenv("BOOTSTRAP_ARCHIVE"); url != "" && light == nil {
+		if err := bootstrapFromArchive(strings.TrimRight(url, "/")); err != nil {
+			storageLog.Warn("Bootstrap from archive stopped, syncing from peers instead", "err", err)
+		}
+	}
+	if light == nil {
+		startArchive()
+	}
+	go resourceGuard.monitor(dataDir())
+	startP2P()
+	if light == nil {
+		startHeartbeat()
+		startWebhooks()
+		startGRPC()
+	}
+	if err := run(); err != nil {
+		log.Fatal(err)
+	}
+	log.Println("Stopped")
+}
 
-	bc = NewBlockchain()
-	log.Fatal(run())
+// openChain loads the stored chain into bc
+func openChain() error {
+	store, err := OpenBlockStore(dataDir())
+	if err != nil {
+		return err
+	}
+	bc = NewBlockchain(store)
+	if err := bc.loadBlocks(); err != nil {
+		return err
+	}
+	utxoStore, err := OpenUTXOStore(dataDir())
+	if err != nil {
+		return err
+	}
+	return bc.utxo.attach(utxoStore, bc.blocks[len(bc.blocks)-1].Hash)
 }
 
 // web server
 func run() error {
 	mux := makeMuxRouter()
 	httpPort := os.Getenv("PORT")
-	log.Println("HTTP Server Listening on port :", httpPort)
-	s := &http.Server{
-		Addr:    ":" + httpPort,
-		Handler: mux,
+	s := newHTTPServer(":"+httpPort, mux)
+	s.TLSConfig = loadHTTPTLS()
+
+	failed := make(chan error, 2)
+	if s.TLSConfig != nil {
+		httpLog.Info("HTTPS server listening", "port", httpPort)
+		// the certificates come from TLSConfig
+		go func() { failed <- s.ListenAndServeTLS("", "") }()
+	} else {
+		httpLog.Info("HTTP server listening", "port", httpPort)
+		go func() { failed <- s.ListenAndServe() }()
 	}
-
-	if err := s.ListenAndServe(); err != nil {
+	servers := []*http.Server{s}
+	if adminAddr != "" && light == nil {
+		admin := newHTTPServer(adminAddr, makeAdminRouter())
+		admin.TLSConfig = s.TLSConfig
+		httpLog.Info("Admin API listening", "addr", adminAddr)
+		if admin.TLSConfig != nil {
+			go func() { failed <- admin.ListenAndServeTLS("", "") }()
+		} else {
+			go func() { failed <- admin.ListenAndServe() }()
+		}
+		servers = append(servers, admin)
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	select {
+	case err := <-failed:
 		return err
+	case sig := <-signals:
+		log.Printf("Received %v, shutting down", sig)
+	case <-stopRequests:
+		log.Println("Stop requested through the admin API, shutting down")
 	}
-
-	return nil
+	return shutdown(servers...)
 }
 
 // create handlers
 func makeMuxRouter() http.Handler {
+	if light != nil {
+		return makeLightRouter()
+	}
 	muxRouter := mux.NewRouter()
+	addAPIRoutes(muxRouter)
+	addAPIRoutes(muxRouter.PathPrefix(apiVersionPrefix).Subrouter())
+	muxRouter.HandleFunc("/ws", handleWebSocket).Methods("GET")
+	muxRouter.HandleFunc("/events", handleEvents).Methods("GET")
+	muxRouter.HandleFunc("/rpc", handleRPC).Methods("POST")
+	muxRouter.HandleFunc("/graphql", handleGraphQL).Methods("GET", "POST")
+	muxRouter.HandleFunc("/graphql/schema", handleGetGraphQLSchema).Methods("GET")
+	if archive != nil {
+		muxRouter.HandleFunc("/archive/{name}", handleGetArchiveFile).Methods("GET", "HEAD")
+	}
+	muxRouter.HandleFunc("/healthz", handleHealthz).Methods("GET")
+	muxRouter.Handle("/metrics", metricsHandler()).Methods("GET")
+	muxRouter.HandleFunc("/readyz", handleReadyz).Methods("GET")
+	muxRouter.HandleFunc("/openapi.json", serveOpenAPI(muxRouter)).Methods("GET")
+	muxRouter.HandleFunc("/docs", handleDocs).Methods("GET")
+	muxRouter.HandleFunc("/explorer", handleExplorerRedirect).Methods("GET")
+	muxRouter.PathPrefix("/explorer/").Handler(explorerHandler()).Methods("GET", "HEAD")
+	muxRouter.NotFoundHandler = http.HandlerFunc(handleNotFound)
+	muxRouter.MethodNotAllowedHandler = http.HandlerFunc(handleMethodNotAllowed)
+	muxRouter.Use(metricsMiddleware, tracingMiddleware)
+	muxRouter.Use(aclMiddleware)
+	muxRouter.Use(rateLimitMiddleware)
+	muxRouter.Use(auditMiddleware(apiAuth))
+	muxRouter.Use(authMiddleware)
+	muxRouter.Use(deprecationMiddleware)
+	return corsHandler(muxRouter)
+}
+
+// addAPIRoutes adds the JSON endpoints, which are served both bare and in
+// envelopes under apiVersionPrefix
+func addAPIRoutes(muxRouter *mux.Router) {
 	muxRouter.HandleFunc("/", handleGetBlockchain).Methods("GET")
-	muxRouter.HandleFunc("/", handleWriteBlock).Methods("POST")
+	muxRouter.HandleFunc("/", idempotent(handleWriteBlock)).Methods("POST")
 	muxRouter.HandleFunc("/balance", handleGetBalance).Methods("POST")
-	return muxRouter
+	muxRouter.HandleFunc("/balance/{address}", handleGetAddressBalance).Methods("GET")
+	muxRouter.HandleFunc("/refund", idempotent(handleRefund)).Methods("POST")
+	muxRouter.HandleFunc("/tx/raw/send", idempotent(handleSendRawTransaction)).Methods("POST")
+	muxRouter.HandleFunc("/tx", idempotent(handleSubmitTransaction)).Methods("POST")
+	muxRouter.HandleFunc("/jobs/{id}", handleGetJob).Methods("GET")
+	muxRouter.HandleFunc("/mempool", handleGetMempool).Methods("GET")
+	muxRouter.HandleFunc("/mempool/{txid}", handleGetMempoolTx).Methods("GET")
+	muxRouter.HandleFunc("/fees/estimate", handleGetFeeEstimate).Methods("GET")
+	muxRouter.HandleFunc("/mining/template", handleGetBlockTemplate).Methods("GET")
+	muxRouter.HandleFunc("/mining/submit", handleSubmitBlock).Methods("POST")
+	muxRouter.HandleFunc("/miner/stats", handleGetMinerStats).Methods("GET")
+	muxRouter.HandleFunc("/height", handleGetHeight).Methods("GET")
+	muxRouter.HandleFunc("/chaininfo", handleGetChainInfo).Methods("GET")
+	muxRouter.HandleFunc("/beacon/{height}", handleGetBeacon).Methods("GET")
+	muxRouter.HandleFunc("/lottery/{name}", handleGetLottery).Methods("GET")
+	muxRouter.HandleFunc("/lottery/{name}/payout", handleLotteryPayout).Methods("POST")
+	muxRouter.HandleFunc("/peers", handleGetPeers).Methods("GET")
+	muxRouter.HandleFunc("/sync", handleGetSync).Methods("GET")
+	muxRouter.HandleFunc("/deprecations", handleGetDeprecations).Methods("GET")
+	muxRouter.HandleFunc("/tx/{txid}", handleGetTransaction).Methods("GET")
+	muxRouter.HandleFunc("/search", handleSearch).Methods("GET")
+	muxRouter.HandleFunc("/block/{hash}", handleGetBlock).Methods("GET")
+	muxRouter.HandleFunc("/block/height/{height}", handleGetBlockAtHeight).Methods("GET")
+	muxRouter.HandleFunc("/cache/stats", handleGetCacheStats).Methods("GET")
+	muxRouter.HandleFunc("/xpub/{xpub}/addresses", handleDeriveAddresses).Methods("GET")
+	muxRouter.HandleFunc("/xpub/{xpub}/addresses/{index}", handleDeriveAddress).Methods("GET")
+	if len(federation) > 0 {
+		muxRouter.HandleFunc("/federation/nodes", handleFederationNodes).Methods("GET")
+		muxRouter.HandleFunc("/federation/heights", handleFederationHeights).Methods("GET")
+		muxRouter.HandleFunc("/federation/balance/{address}", handleFederationBalance).Methods("GET")
+	}
+	if faucet != nil {
+		muxRouter.HandleFunc("/faucet", idempotent(handleFaucet)).Methods("POST")
+	}
+	if archive != nil {
+		muxRouter.HandleFunc("/archive/manifest", handleGetArchiveManifest).Methods("GET")
+	}
 }
 
-// write blockchain when we receive an http request
+// writes the active chain, or the range selected by ?from=, ?limit= and
+// ?order=asc/desc, with its height in X-Chain-Height
 func handleGetBlockchain(w http.ResponseWriter, r *http.Request) {
-	bytes, err := json.MarshalIndent(bc.blocks, "", "  ")
+	from, err := queryHeight(r, "from", -1)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		respondWithError(w, r, http.StatusBadRequest, err.Error())
 		return
 	}
-	io.WriteString(w, string(bytes))
+	limit, err := queryHeight(r, "limit", 0)
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	order := r.URL.Query().Get("order")
+	if order != "" && order != "asc" && order != "desc" {
+		respondWithError(w, r, http.StatusBadRequest, "order must be asc or desc")
+		return
+	}
+
+	blocks, start, height := bc.blockRange(from, limit, order == "desc")
+	w.Header().Set("X-Chain-Height", strconv.Itoa(height))
+	if versioned(r) {
+		step := 1
+		if order == "desc" {
+			step = -1
+		}
+		page := &Pagination{From: start, Limit: limit, Count: len(blocks), Total: height + 1}
+		page.Next = nextPage(r, start, limit, len(blocks), step, height+1)
+		respondWithPage(w, r, http.StatusOK, blocks, page)
+		return
+	}
+	if negotiate(r, true) != mediaJSON {
+		respondWithJSON(w, r, http.StatusOK, blockList{blocks, start, order == "desc"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Vary", "Accept, Accept-Encoding")
+	var out io.Writer = w
+	if acceptsGzip(r) {
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+		out = gz
+	}
+	if err := writeBlocksJSON(out, blocks); err != nil {
+		httpLog.Debug("Can't stream the chain", "err", err)
+	}
+}
+
+// queryHeight reads an optional non-negative integer query parameter
+func queryHeight(r *http.Request, name string, def int) (int, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid %s parameter %q", name, v)
+	}
+	return n, nil
+}
+
+// blockRange returns up to limit active chain blocks, or all of them with 0,
+// starting at height from and going up, or down with desc. A negative from
+// starts at the genesis block going up and at the tip going down. It also
+// returns the height of the first block and of the chain.
+func (bc *Blockchain) blockRange(from, limit int, desc bool) (blocks []*Block, start, height int) {
+	bc.RLock()
+	defer bc.RUnlock()
+
+	height = len(bc.blocks) - 1
+	step := 1
+	if desc {
+		step = -1
+		if from < 0 || from > height {
+			from = height
+		}
+	} else if from < 0 {
+		from = 0
+	}
+	blocks = []*Block{}
+	for i := from; i >= 0 && i <= height; i += step {
+		if limit > 0 && len(blocks) == limit {
+			break
+		}
+		blocks = append(blocks, bc.blocks[i])
+	}
+	return blocks, from, height
+}
+
+// writeBlocksJSON streams blocks as an indented JSON array, one block at a
+// time through a json.Encoder, so memory stays flat however many blocks are
+// written. The output matches respondWithJSON's.
+func writeBlocksJSON(w io.Writer, blocks []*Block) error {
+	if len(blocks) == 0 {
+		_, err := io.WriteString(w, "[]")
+		return err
+	}
+	if _, err := io.WriteString(w, "["); err != nil {
+		return err
+	}
+	var block bytes.Buffer
+	enc := json.NewEncoder(&block)
+	enc.SetIndent("  ", "  ")
+	for i, b := range blocks {
+		block.Reset()
+		if i == 0 {
+			block.WriteString("\n  ")
+		} else {
+			block.WriteString(",\n  ")
+		}
+		if err := enc.Encode(b); err != nil {
+			return err
+		}
+		// Encode ends each block with a newline the array puts after the comma
+		if _, err := w.Write(bytes.TrimSuffix(block.Bytes(), []byte("\n"))); err != nil {
+			return err
+		}
+	}
+	_, err := io.WriteString(w, "\n]")
+	return err
 }
 
 // takes JSON payload as an input for heart rate (BPM)
@@ -123,27 +473,118 @@ func handleWriteBlock(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	var m SendMessage
 
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&m); err != nil {
-		respondWithJSON(w, r, http.StatusBadRequest, r.Body)
+	if err := decodeRequest(r, &m); err != nil {
+		respondWithRequestError(w, r, err)
 		return
 	}
-	defer r.Body.Close()
 
-	newBlock, err := generateBlock(bc.blocks[len(bc.blocks)-1], m)
+	_, span := tracer.Start(r.Context(), "validate transaction")
+	tx, err := NewUTXOTransaction(m.From, m.To, m.Value, &bc)
+	if err == nil {
+		span.SetAttributes(txAttribute(tx))
+	}
+	endSpan(span, err)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte(err.Error()))
+		respondWithError(w, r, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+
+	mineTransaction(w, r, tx)
+}
+
+// mineTransaction queues tx to be mined into a new block and answers 202
+// with the job, to be polled at /jobs/{id}
+func mineTransaction(w http.ResponseWriter, r *http.Request, tx *Transaction) {
+	mineTransactionThen(w, r, tx, nil)
+}
+
+// mineTransactionThen is mineTransaction calling done, unless nil, with the
+// outcome: the error tx was refused with, or else the job's once it's mined
+// or failed
+func mineTransactionThen(w http.ResponseWriter, r *http.Request, tx *Transaction, done func(error)) {
+	refuse := func(status int, err error) {
+		if done != nil {
+			done(err)
+		}
+		respondWithError(w, r, status, err.Error())
+	}
+	if err := checkNodeFunds(tx, apiAuth.authorized(r)); err != nil {
+		refuse(http.StatusForbidden, err)
+		return
+	}
+	if err := signNodeSpend(tx); err != nil {
+		refuse(http.StatusUnprocessableEntity, err)
+		return
+	}
+	if err := bc.checkMinedTransactions(tx); err != nil {
+		refuse(http.StatusUnprocessableEntity, err)
+		return
+	}
+	if err := resourceGuard.check(); err != nil {
+		refuse(http.StatusServiceUnavailable, err)
+		return
+	}
+
+	job, err := jobs.SubmitThen(r.Context(), tx, done)
+	if err != nil {
+		refuse(http.StatusServiceUnavailable, err)
+		return
+	}
+	location := "/jobs/" + job.ID
+	if versioned(r) {
+		location = apiVersionPrefix + location
+	}
+	w.Header().Set("Location", location)
+	respondWithJSON(w, r, http.StatusAccepted, job)
+}
+
+// sends the amount received by a transaction back to its sender
+func handleRefund(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	var m RefundMessage
+
+	if err := decodeRequest(r, &m); err != nil {
+		respondWithRequestError(w, r, err)
 		return
 	}
 
-	if isBlockValid(newBlock, bc.blocks[len(bc.blocks)-1]) {
-		bc.AddBlock(newBlock)
-		spew.Dump(bc.blocks)
+	tx, err := NewRefundTransaction(m.Txid, m.From, m.To, &bc)
+	if err != nil {
+		respondWithError(w, r, http.StatusUnprocessableEntity, err.Error())
+		return
 	}
 
-	respondWithJSON(w, r, http.StatusCreated, newBlock)
+	mineTransaction(w, r, tx)
+}
+
+// maxAddressLength caps the length of an address
+const maxAddressLength = 100
 
+// checkAddress rejects strings that can't be an address: addresses are names
+// or Base58Check strings, printable and without spaces
+func checkAddress(address string) error {
+	if address == "" || len(address) > maxAddressLength {
+		return fmt.Errorf("ERROR: Invalid address: must be 1 to %d characters", maxAddressLength)
+	}
+	for _, c := range address {
+		if !unicode.IsPrint(c) || unicode.IsSpace(c) {
+			return fmt.Errorf("ERROR: Invalid address %q: must be printable without spaces", address)
+		}
+	}
+	return nil
+}
+
+// addressBalance returns the balance of an address; in light mode only the
+// confirmed balance the light client knows
+func addressBalance(address string) (BalanceInfo, error) {
+	if err := checkAddress(address); err != nil {
+		return BalanceInfo{}, err
+	}
+	if light != nil {
+		balance, err := light.Balance(address)
+		return BalanceInfo{Address: address, Confirmed: balance}, err
+	}
+	return bc.balanceInfo(address), nil
 }
 
 // takes JSON payload as an input for heart rate (BPM)
@@ -151,27 +592,42 @@ func handleGetBalance(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	var m BalanceMessage
 
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&m); err != nil {
-		respondWithJSON(w, r, http.StatusBadRequest, r.Body)
+	if err := decodeRequest(r, &m); err != nil {
+		respondWithRequestError(w, r, err)
 		return
 	}
-	defer r.Body.Close()
-
-	balance := 0
-	UTXOs := bc.FindUTXO(m.Address)
 
-	for _, out := range UTXOs {
-		balance += out.Value
+	balance, err := addressBalance(m.Address)
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
 	}
-
 	respondWithJSON(w, r, http.StatusCreated, balance)
+}
 
+// returns the balance of the address in the path
+func handleGetAddressBalance(w http.ResponseWriter, r *http.Request) {
+	balance, err := addressBalance(mux.Vars(r)["address"])
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	respondWithJSON(w, r, http.StatusOK, balance)
 }
 
 func respondWithJSON(w http.ResponseWriter, r *http.Request, code int, payload interface{}) {
-	w.Header().Set("Content-Type", "application/json")
-	response, err := json.MarshalIndent(payload, "", "  ")
+	respondWithPage(w, r, code, payload, nil)
+}
+
+// respondWithPage answers with payload, in an Envelope with the pagination
+// on the versioned API
+func respondWithPage(w http.ResponseWriter, r *http.Request, code int, payload interface{}, page *Pagination) {
+	if versioned(r) {
+		payload = envelope(payload, page)
+	}
+	mediaType, response, err := encodeResponse(r, payload)
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Add("Vary", "Accept")
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte("HTTP 500: Internal Server Error"))
@@ -187,48 +643,89 @@ func isBlockValid(newBlock, oldBlock *Block) bool {
 		return false
 	}
 
-	if calculateHash(newBlock) != newBlock.Hash {
+	if newBlock.Hash == "" || calculateHash(newBlock) != newBlock.Hash {
+		return false
+	}
+
+	if !isHashValid(newBlock.Hash, difficulty) {
 		return false
 	}
 
 	return true
 }
 
-// SHA256 hasing
+// calculateHash returns the SHA-256 hash of the block's encoded header, or ""
+// for a header that can't be encoded
 func calculateHash(block *Block) string {
-	record := block.Timestamp + block.PrevHash + block.Nonce
-	h := sha256.New()
-	h.Write(append([]byte(record), block.HashTransactions()...))
-	hashed := h.Sum(nil)
-	return hex.EncodeToString(hashed)
+	hash, err := hashHeader(block.Timestamp, block.PrevHash, block.Nonce, block.HashTransactions())
+	if err != nil {
+		return ""
+	}
+	return hash
 }
 
-// create a new block using previous block's hash
-func generateBlock(oldBlock *Block, m SendMessage) (*Block, error) {
+// hashHeader hashes the fields a block hash commits to, see encodeHeader
+func hashHeader(timestamp, prevHash string, nonce uint64, txHash []byte) (string, error) {
+	h := headerHashers.Get().(*headerHasher)
+	defer headerHashers.Put(h)
+	return h.hash(timestamp, prevHash, nonce, txHash)
+}
+
+// create a new block using previous block's hash. When the header nonce
+// space is exhausted the extranonce in the coinbase is bumped, which changes
+// the transactions hash and gives the nonce loop a fresh search space. It
+// gives up with errMiningAborted once ctx is canceled.
+func generateBlock(ctx context.Context, oldBlock *Block, newTranactions ...*Transaction) (*Block, error) {
 	newBlock := new(Block)
 
 	t := time.Now()
-	newTranaction, err := NewUTXOTransaction(m.From, m.To, m.Value, &bc)
-	if err != nil {
-		return nil, err
-	}
 	newBlock.Timestamp = t.String()
-	newBlock.Transactions = []*Transaction{newTranaction}
 	newBlock.PrevHash = oldBlock.Hash
+	bc.RLock()
+	height := bc.blockHeight(oldBlock) + 1
+	bc.RUnlock()
 
-	for i := 0; ; i++ {
-		newBlock.Nonce = fmt.Sprintf("%x", i)
-		newHash := calculateHash(newBlock)
-		if !isHashValid(newHash, difficulty) {
-			fmt.Println(newHash, " do more work!")
-			continue
+	_, span := tracer.Start(ctx, "assemble block", trace.WithAttributes(attribute.Int("block.height", height)))
+	txs, fees, err := bc.blockTransactions(newTranactions...)
+	if err != nil {
+		span.End()
+		return nil, err
+	}
+	for _, link := range bc.mempool.spanLinks(txs) {
+		span.AddLink(link)
+	}
+	span.SetAttributes(attribute.Int("block.transactions", len(txs)+1))
+	span.End()
+
+	_, span = tracer.Start(ctx, "proof of work", trace.WithAttributes(attribute.Int("block.height", height), attribute.Int("difficulty", miningDifficulty())))
+	started := minerStats.startJob()
+	for extraNonce := uint64(0); ; extraNonce++ {
+		coinbase := NewMinerCoinbaseTX(minerAddress(), height, extraNonce, fees)
+		newBlock.Transactions = append([]*Transaction{coinbase}, txs...)
+
+		if searchNonce(ctx, newBlock) {
+			minerStats.blockFound(started)
+			span.SetAttributes(attribute.String("block.hash", newBlock.Hash), attribute.Int64("extra_nonce", int64(extraNonce)))
+			span.End()
+			return newBlock, nil
 		}
-		fmt.Println(newHash, " work done!")
-		newBlock.Hash = newHash
-		break
+		if ctx.Err() != nil {
+			minerStats.jobAborted(started)
+			endSpan(span, errMiningAborted)
+			return nil, errMiningAborted
+		}
+	}
+}
 
+// minerAddress returns the address block rewards are paid to
+func minerAddress() string {
+	if minerKey != nil {
+		return minerKey.Address()
+	}
+	if address := os.Getenv("MINER_ADDRESS"); address != "" {
+		return address
 	}
-	return newBlock, nil
+	return params.Genesis.Address
 }
 
 func isHashValid(hash string, difficulty int) bool {
@@ -240,89 +737,74 @@ func (tx *Transaction) name() {
 
 }
 
-// FindUnspentTransactions returns a list of transactions containing unspent outputs
-func (bc *Blockchain) FindUnspentTransactions(address string) []*Transaction {
-	var unspentTXs []*Transaction
-	spentTXOs := make(map[string][]int)
-
-	for i := len(bc.blocks) - 1; i >= 0; i-- {
-		if len(bc.blocks[i].Transactions) == 0 {
-			return unspentTXs
-		}
-
-		for _, tx := range bc.blocks[i].Transactions {
-			if !tx.IsCoinbase() {
-				for _, in := range tx.Vin {
-					if in.CanUnlockOutputWith(address) {
-						spentTXOs[in.Txid] = append(spentTXOs[in.Txid], in.Vout)
-					}
-				}
-			}
-		Outputs:
-			for outIdx, out := range tx.Vout {
-				for _, spentOut := range spentTXOs[tx.ID] {
-					if spentOut == outIdx {
-						continue Outputs
-					}
-				}
-
-				if out.CanBeUnlockedWith(address) {
-					unspentTXs = append(unspentTXs, tx)
-				}
-			}
-		}
+// FindTransaction finds a transaction on the active chain by its ID
+func (bc *Blockchain) FindTransaction(id string) (*Transaction, error) {
+	info, err := bc.GetTransaction(id)
+	if err != nil {
+		return nil, err
 	}
+	return info.Transaction, nil
+}
 
-	return unspentTXs
+// Balance sums the unspent outputs of address
+func (bc *Blockchain) Balance(address string) Amount {
+	var balance Amount
+	for _, out := range bc.FindUTXO(address) {
+		balance += out.Value
+	}
+	return balance
 }
 
 // FindUTXO finds and returns all unspent transaction outputs
 func (bc *Blockchain) FindUTXO(address string) []TXOutput {
-	var UTXOs []TXOutput
-	unspentTransactions := bc.FindUnspentTransactions(address)
+	bc.RLock()
+	defer bc.RUnlock()
 
-	for _, tx := range unspentTransactions {
-		for _, out := range tx.Vout {
-			if out.CanBeUnlockedWith(address) {
-				UTXOs = append(UTXOs, out)
-			}
-		}
+	var UTXOs []TXOutput
+	for _, e := range bc.utxo.outputs(address) {
+		UTXOs = append(UTXOs, e.Output)
 	}
-
 	return UTXOs
 }
 
-// FindSpendableOutputs finds and returns unspent outputs to reference in inputs
-func (bc *Blockchain) FindSpendableOutputs(address string, amount int) (
-	int, map[string][]int) {
+// FindSpendableOutputs finds and returns unspent outputs to reference in
+// inputs, passing over those in skip
+func (bc *Blockchain) FindSpendableOutputs(address string, amount Amount, skip map[outpoint]bool) (
+	Amount, map[string][]int) {
+	bc.RLock()
+	defer bc.RUnlock()
 
 	unspentOutputs := make(map[string][]int)
-	unspentTXs := bc.FindUnspentTransactions(address)
-	accumulated := 0
-
-	for _, tx := range unspentTXs {
-		for idx, out := range tx.Vout {
-			if out.CanBeUnlockedWith(address) && accumulated < amount {
-				accumulated += out.Value
-				unspentOutputs[tx.ID] = append(unspentOutputs[tx.ID], idx)
-
-				if accumulated >= amount {
-					return accumulated, unspentOutputs
-				}
-			}
+	var accumulated Amount
+	for _, e := range bc.utxo.outputs(address) {
+		if accumulated >= amount {
+			break
+		}
+		if skip[outpoint{e.Txid, e.Vout}] {
+			continue
 		}
+		accumulated += e.Output.Value
+		unspentOutputs[e.Txid] = append(unspentOutputs[e.Txid], e.Vout)
 	}
 	return accumulated, unspentOutputs
 }
 
-func (b *Block) HashTransactions() []byte {
-	var txHashes [][]byte
-	var txHash [32]byte
+// withTxHash returns a copy of b that hashes its transactions once instead
+// of for every header hashed. The copy's transactions must not change.
+func (b *Block) withTxHash() Block {
+	c := *b
+	c.txHash = b.HashTransactions()
+	return c
+}
 
+// HashTransactions returns the Merkle root of the block's transactions
+func (b *Block) HashTransactions() []byte {
+	if b.txHash != nil {
+		return b.txHash
+	}
+	var txids []string
 	for _, tx := range b.Transactions {
-		txHashes = append(txHashes, []byte(tx.ID))
+		txids = append(txids, tx.ID)
 	}
-	txHash = sha256.Sum256(bytes.Join(txHashes, []byte{}))
-
-	return txHash[:]
+	return merkleRoot(txids)
 }
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Mempool holds transactions that are waiting to be included in a block
+type Mempool struct {
+	sync.Mutex
+	txs   map[string]*Transaction
+	order []string
+	added map[string]time.Time
+	// spans are those admitting the transactions, for blocks to link to
+	spans map[string]trace.SpanContext
+}
+
+// MempoolEntry describes a pooled transaction. Fee is what its inputs hold
+// beyond its outputs, and Size the length of its encoding.
+type MempoolEntry struct {
+	Txid string
+	Size int
+	Fee  Amount
+	Time time.Time
+}
+
+// MempoolInfo lists the mempool
+type MempoolInfo struct {
+	Count        int
+	Bytes        int
+	TotalFee     Amount
+	Transactions []MempoolEntry
+}
+
+// MempoolTx is a pooled transaction with its entry
+type MempoolTx struct {
+	MempoolEntry
+	Transaction *Transaction
+}
+
+func NewMempool() *Mempool {
+	return &Mempool{txs: make(map[string]*Transaction), added: make(map[string]time.Time), spans: make(map[string]trace.SpanContext)}
+}
+
+// Add puts a transaction into the pool unless it is already there
+func (mp *Mempool) Add(tx *Transaction) {
+	mp.Lock()
+	defer mp.Unlock()
+
+	if _, ok := mp.txs[tx.ID]; ok {
+		return
+	}
+	mp.txs[tx.ID] = tx
+	mp.order = append(mp.order, tx.ID)
+	mp.added[tx.ID] = time.Now()
+}
+
+// Has reports whether a transaction is in the pool
+func (mp *Mempool) Has(txid string) bool {
+	mp.Lock()
+	defer mp.Unlock()
+	_, ok := mp.txs[txid]
+	return ok
+}
+
+// Get returns a pooled transaction
+func (mp *Mempool) Get(txid string) (*Transaction, bool) {
+	mp.Lock()
+	defer mp.Unlock()
+	tx, ok := mp.txs[txid]
+	return tx, ok
+}
+
+// Remove drops a transaction from the pool
+func (mp *Mempool) Remove(txid string) {
+	mp.Lock()
+	defer mp.Unlock()
+	mp.remove(txid)
+}
+
+func (mp *Mempool) remove(txid string) {
+	if _, ok := mp.txs[txid]; !ok {
+		return
+	}
+	delete(mp.txs, txid)
+	delete(mp.added, txid)
+	delete(mp.spans, txid)
+	for i, id := range mp.order {
+		if id == txid {
+			mp.order = append(mp.order[:i], mp.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// setSpan records the span that admitted a pooled transaction
+func (mp *Mempool) setSpan(txid string, span trace.SpanContext) {
+	mp.Lock()
+	defer mp.Unlock()
+	if _, ok := mp.txs[txid]; ok && span.IsValid() {
+		mp.spans[txid] = span
+	}
+}
+
+// spanLinks links a block's span to the spans that admitted its pooled
+// transactions
+func (mp *Mempool) spanLinks(txs []*Transaction) []trace.Link {
+	mp.Lock()
+	defer mp.Unlock()
+
+	var links []trace.Link
+	for _, tx := range txs {
+		if span, ok := mp.spans[tx.ID]; ok {
+			links = append(links, trace.Link{SpanContext: span, Attributes: []attribute.KeyValue{txAttribute(tx)}})
+		}
+	}
+	return links
+}
+
+// Len returns the number of pooled transactions
+func (mp *Mempool) Len() int {
+	mp.Lock()
+	defer mp.Unlock()
+	return len(mp.txs)
+}
+
+// Transactions returns pooled transactions in the order they were admitted
+func (mp *Mempool) Transactions() []*Transaction {
+	mp.Lock()
+	defer mp.Unlock()
+
+	txs := make([]*Transaction, 0, len(mp.order))
+	for _, id := range mp.order {
+		txs = append(txs, mp.txs[id])
+	}
+	return txs
+}
+
+// removeBlockTxs drops every transaction that b confirmed
+func (mp *Mempool) removeBlockTxs(b *Block) {
+	mp.Lock()
+	defer mp.Unlock()
+
+	for _, tx := range b.Transactions {
+		mp.remove(tx.ID)
+	}
+}
+
+// prune drops transactions that no longer connect on top of the UTXO set,
+// e.g. because a reorg confirmed a conflicting spend
+func (mp *Mempool) prune(utxo UTXOSet) {
+	mp.Lock()
+	defer mp.Unlock()
+
+	view := newUTXOView(utxo)
+	for _, id := range append([]string(nil), mp.order...) {
+		if _, err := view.connectTransaction(mp.txs[id]); err != nil {
+			mp.remove(id)
+		}
+	}
+}
+
+// AcceptTransaction validates tx against the active chain plus the pooled
+// transactions, so it may spend unconfirmed outputs but not conflict with
+// another pooled spend, and admits it to the mempool. The block that later
+// includes it links to the trace of ctx.
+func (bc *Blockchain) AcceptTransaction(ctx context.Context, tx *Transaction) (err error) {
+	ctx, span := tracer.Start(ctx, "validate transaction", trace.WithAttributes(txAttribute(tx)))
+	defer func() { endSpan(span, err) }()
+
+	bc.Lock()
+	defer bc.Unlock()
+
+	if err := resourceGuard.check(); err != nil {
+		return err
+	}
+	if tx.IsCoinbase() {
+		return errors.New("ERROR: Coinbase transactions can't be relayed")
+	}
+	if err := checkTransactionID(tx); err != nil {
+		return err
+	}
+	if _, ok := bc.txIndex[tx.ID]; ok {
+		return errors.New("ERROR: Transaction already confirmed")
+	}
+	if bc.mempool.Has(tx.ID) {
+		return errors.New("ERROR: Transaction already in the mempool")
+	}
+
+	view := bc.utxo.view()
+	for _, pooled := range bc.mempool.Transactions() {
+		view.connectTransaction(pooled)
+	}
+	if err := view.checkTransaction(tx); err != nil {
+		return err
+	}
+	if err := checkLotteryRules(view, tx, len(bc.blocks), bc.blocks); err != nil {
+		return err
+	}
+
+	_, admit := tracer.Start(ctx, "mempool admission", trace.WithAttributes(txAttribute(tx)))
+	bc.mempool.Add(tx)
+	bc.mempool.setSpan(tx.ID, admit.SpanContext())
+	admit.End()
+	chainEvents.publish(TxAdmitted, tx)
+	return nil
+}
+
+// txSize is the length of a transaction's encoding, the one its ID hashes
+func txSize(tx *Transaction) int {
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(tx); err != nil {
+		return 0
+	}
+	return encoded.Len()
+}
+
+// blockSize is the length of a block's encoding, the one the store writes
+func blockSize(b *Block) int {
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(b); err != nil {
+		return 0
+	}
+	return encoded.Len()
+}
+
+// mempoolEntries describes the pooled transactions in admission order.
+// Parents come before the children spending them, so connecting each in
+// turn gives the fee of the next.
+func (bc *Blockchain) mempoolEntries() []MempoolEntry {
+	bc.RLock()
+	defer bc.RUnlock()
+	bc.mempool.Lock()
+	defer bc.mempool.Unlock()
+
+	view := bc.utxo.view()
+	entries := make([]MempoolEntry, 0, len(bc.mempool.order))
+	for _, id := range bc.mempool.order {
+		tx := bc.mempool.txs[id]
+		var fee Amount
+		for _, in := range tx.Vin {
+			if prev, ok := view.get(outpoint{in.Txid, in.Vout}); ok {
+				fee += prev.Value
+			}
+		}
+		for _, out := range tx.Vout {
+			fee -= out.Value
+		}
+		view.connectTransaction(tx)
+		entries = append(entries, MempoolEntry{id, txSize(tx), fee, bc.mempool.added[id]})
+	}
+	return entries
+}
+
+// evictTransaction drops a pooled transaction and the ones spending its
+// outputs, and returns the IDs of all of them
+func (bc *Blockchain) evictTransaction(txid string) ([]string, bool) {
+	bc.Lock()
+	defer bc.Unlock()
+
+	if !bc.mempool.Has(txid) {
+		return nil, false
+	}
+	before := bc.mempool.Transactions()
+	bc.mempool.Remove(txid)
+	bc.mempool.prune(bc.utxo.UTXOSet)
+
+	evicted := []string{}
+	for _, tx := range before {
+		if !bc.mempool.Has(tx.ID) {
+			evicted = append(evicted, tx.ID)
+		}
+	}
+	return evicted, true
+}
+
+// lists the pooled transactions with their size and fee
+func handleGetMempool(w http.ResponseWriter, r *http.Request) {
+	entries := bc.mempoolEntries()
+	info := MempoolInfo{Count: len(entries), Transactions: entries}
+	for _, e := range entries {
+		info.Bytes += e.Size
+		info.TotalFee += e.Fee
+	}
+	respondWithJSON(w, r, http.StatusOK, info)
+}
+
+// shows a pooled transaction
+func handleGetMempoolTx(w http.ResponseWriter, r *http.Request) {
+	txid := mux.Vars(r)["txid"]
+	if tx, ok := bc.mempool.Get(txid); ok {
+		for _, e := range bc.mempoolEntries() {
+			if e.Txid == txid {
+				respondWithJSON(w, r, http.StatusOK, MempoolTx{e, tx})
+				return
+			}
+		}
+	}
+	respondWithError(w, r, http.StatusNotFound, "Transaction not in the mempool")
+}
+
+// evicts a pooled transaction and its descendants
+func handleDeleteMempoolTx(w http.ResponseWriter, r *http.Request) {
+	evicted, ok := bc.evictTransaction(mux.Vars(r)["txid"])
+	if !ok {
+		respondWithError(w, r, http.StatusNotFound, "Transaction not in the mempool")
+		return
+	}
+	respondWithJSON(w, r, http.StatusOK, map[string][]string{"Evicted": evicted})
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+)
+
+// Blocks commit to their transactions with a Merkle tree over the
+// transaction IDs. Leaves and inner nodes are hashed with different prefixes
+// (as in RFC 6962) so a leaf can't pose as a subtree, and an odd node at the
+// end of a level moves up unchanged instead of being paired with itself.
+//
+// This replaced a plain hash of the concatenated IDs and changes every block
+// hash, so it is a hard fork: the consensus parameters name the algorithm
+// "sha256-merkle", which makes nodes on the old rule and the new one refuse
+// each other at the handshake. Chains stored under the old rule don't load;
+// remove the data directory and sync again.
+
+func merkleLeaf(txid string) []byte {
+	sum := sha256.Sum256(append([]byte{0}, txid...))
+	return sum[:]
+}
+
+func merkleNode(left, right []byte) []byte {
+	buf := make([]byte, 0, 1+len(left)+len(right))
+	buf = append(append(append(buf, 1), left...), right...)
+	sum := sha256.Sum256(buf)
+	return sum[:]
+}
+
+// merkleLevels returns every level of the tree, leaves first
+func merkleLevels(txids []string) [][][]byte {
+	level := make([][]byte, len(txids))
+	for i, id := range txids {
+		level[i] = merkleLeaf(id)
+	}
+	levels := [][][]byte{level}
+	for len(level) > 1 {
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			if i+1 < len(level) {
+				next = append(next, merkleNode(level[i], level[i+1]))
+			} else {
+				next = append(next, level[i])
+			}
+		}
+		levels = append(levels, next)
+		level = next
+	}
+	return levels
+}
+
+// merkleRoot computes the root for transaction IDs. A block without
+// transactions commits to the hash of nothing.
+func merkleRoot(txids []string) []byte {
+	if len(txids) == 0 {
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	}
+	levels := merkleLevels(txids)
+	return levels[len(levels)-1][0]
+}
+
+// MerkleProof shows that a transaction is the Index-th of a block with NumTx
+// transactions. Hashes are the siblings on the path to the root, bottom up,
+// skipping levels where the node has none.
+type MerkleProof struct {
+	Index  int
+	NumTx  int
+	Hashes []string
+}
+
+// newMerkleProof builds the proof for the transaction at index
+func newMerkleProof(txids []string, index int) MerkleProof {
+	proof := MerkleProof{Index: index, NumTx: len(txids), Hashes: []string{}}
+	levels := merkleLevels(txids)
+	for _, level := range levels[:len(levels)-1] {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			proof.Hashes = append(proof.Hashes, hex.EncodeToString(level[sibling]))
+		}
+		index /= 2
+	}
+	return proof
+}
+
+// verify checks that txid is in the tree with the given root (hex)
+func (p *MerkleProof) verify(txid, root string) error {
+	if p.Index < 0 || p.Index >= p.NumTx {
+		return errors.New("Merkle proof index out of range")
+	}
+
+	hash := merkleLeaf(txid)
+	index, width, used := p.Index, p.NumTx, 0
+	for width > 1 {
+		if index^1 < width {
+			if used >= len(p.Hashes) {
+				return errors.New("Merkle proof is too short")
+			}
+			sibling, err := hex.DecodeString(p.Hashes[used])
+			if err != nil {
+				return err
+			}
+			used++
+			if index%2 == 0 {
+				hash = merkleNode(hash, sibling)
+			} else {
+				hash = merkleNode(sibling, hash)
+			}
+		}
+		index /= 2
+		width = (width + 1) / 2
+	}
+	if used != len(p.Hashes) {
+		return errors.New("Merkle proof is too long")
+	}
+	if hex.EncodeToString(hash) != root {
+		return errors.New("Merkle proof doesn't lead to the block's root")
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import "github.com/VOOVOOZEL/go_blockchain/transactions/wire"
+
+// codec decodes peer messages into their payload types. A payload that
+// changes shape gets a new version registered next to the old one, whose
+// type upgrades to the new (see wire.Upgrader).
+var codec = wire.NewCodec()
+
+// GetAddrMessage asks for the addresses a peer knows about
+type GetAddrMessage struct{}
+
+func init() {
+	codec.Register(wire.CmdVersion, 1, VersionMessage{})
+	codec.Register(wire.CmdPing, 1, PingMessage{})
+	codec.Register(wire.CmdPong, 1, PingMessage{})
+	codec.Register(wire.CmdGetAddr, 1, GetAddrMessage{})
+	codec.Register(wire.CmdAddr, 1, AddrMessage{})
+	codec.Register(wire.CmdGetBlocks, 1, GetBlocksMessage{})
+	codec.Register(wire.CmdBlocks, 1, BlocksMessage{})
+	codec.Register(wire.CmdGetHeaders, 1, GetHeadersMessage{})
+	codec.Register(wire.CmdHeaders, 1, HeadersMessage{})
+	codec.Register(wire.CmdInv, 1, wire.InvMessage{})
+	codec.Register(wire.CmdGetData, 1, wire.GetDataMessage{})
+	codec.Register(wire.CmdNotFound, 1, wire.NotFoundMessage{})
+	codec.Register(wire.CmdBlock, 1, Block{})
+	codec.Register(wire.CmdTx, 1, Transaction{})
+	codec.Register(wire.CmdCmpctBlock, 1, CompactBlockMessage{})
+	codec.Register(wire.CmdGetBlockTxn, 1, GetBlockTxnMessage{})
+	codec.Register(wire.CmdBlockTxn, 1, BlockTxnMessage{})
+	codec.Register(wire.CmdFilterLoad, 1, filterLoadMessageV1{})
+	codec.Register(wire.CmdFilterLoad, 2, FilterLoadMessage{})
+	codec.Register(wire.CmdGetFiltered, 1, GetFilteredMessage{})
+	codec.Register(wire.CmdFiltered, 1, FilteredMessage{})
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// the Prometheus metrics served on /metrics, next to the Go runtime and
+// process metrics of the default registry
+var (
+	blocksMined = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "blockchain_blocks_mined_total",
+		Help: "Blocks found by this node's miner.",
+	})
+	blockInterval = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "blockchain_block_interval_seconds",
+		Help:    "Time between the timestamps of a connected block and its parent.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	})
+	miningDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "blockchain_mining_duration_seconds",
+		Help:    "Time the miner took to find a block.",
+		Buckets: prometheus.ExponentialBuckets(0.01, 2, 16),
+	})
+	reorgs = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "blockchain_reorgs_total",
+		Help: "Switches of the active chain to another branch.",
+	})
+	chainAlerts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "blockchain_alerts_total",
+		Help: "Consensus alerts raised, by kind: deep reorganizations and long side branches.",
+	}, []string{"kind"})
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "blockchain_http_request_duration_seconds",
+		Help:    "Latency of HTTP API requests by route template, method and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+)
+
+func init() {
+	prometheus.MustRegister(blocksMined, blockInterval, miningDuration, reorgs, chainAlerts, httpDuration,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "blockchain_height",
+			Help: "Height of the active chain.",
+		}, func() float64 {
+			return float64(bc.Height())
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "blockchain_mempool_transactions",
+			Help: "Transactions waiting in the mempool.",
+		}, func() float64 {
+			if bc.mempool == nil {
+				return 0
+			}
+			return float64(bc.mempool.Len())
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "blockchain_utxo_set_size",
+			Help: "Unspent outputs of the active chain.",
+		}, func() float64 {
+			bc.RLock()
+			defer bc.RUnlock()
+			return float64(len(bc.utxo.UTXOSet))
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "blockchain_cache_hits_total",
+			Help: "Block and transaction lookups served by the chain cache.",
+		}, func() float64 {
+			return float64(chainCache.Stats().Hits)
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name: "blockchain_cache_misses_total",
+			Help: "Block and transaction lookups the chain cache sent to the block store.",
+		}, func() float64 {
+			return float64(chainCache.Stats().Misses)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "blockchain_cache_bytes",
+			Help: "Estimated memory used by the chain cache's entries.",
+		}, func() float64 {
+			return float64(chainCache.Stats().UsedBytes)
+		}),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "blockchain_peers",
+			Help: "Connected P2P peers.",
+		}, func() float64 {
+			return float64(len(peerManager.Info()))
+		}),
+	)
+}
+
+// metricsHandler serves the metrics in the Prometheus text format
+func metricsHandler() http.Handler {
+	return promhttp.Handler()
+}
+
+// watchChainMetrics counts reorganizations and times the blocks joining the
+// active chain
+func watchChainMetrics() {
+	chainEvents.Handle("Metrics", func(e ChainEvent) {
+		if _, ok := e.Reorg(); ok {
+			reorgs.Inc()
+			return
+		}
+		b, _ := e.Block()
+		bc.RLock()
+		parent, ok := bc.known[b.PrevHash]
+		bc.RUnlock()
+		if ok {
+			observeBlockConnected(parent, b)
+		}
+	}, BlockConnected, Reorg)
+}
+
+// observeBlockConnected records the interval between a block joining the
+// active chain and its parent, when both timestamps parse and the parent
+// isn't the genesis block, whose timestamp is fixed
+func observeBlockConnected(parent, b *Block) {
+	if parent.PrevHash == "" {
+		return
+	}
+	from, err := parseBlockTime(parent.Timestamp)
+	if err != nil {
+		return
+	}
+	to, err := parseBlockTime(b.Timestamp)
+	if err != nil || to.Before(from) {
+		return
+	}
+	blockInterval.Observe(to.Sub(from).Seconds())
+}
+
+// metricsMiddleware times every request by its route template
+func metricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unmatched"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		httpDuration.WithLabelValues(route, r.Method, strconv.Itoa(rec.status)).Observe(time.Since(start).Seconds())
+	})
+}
+
+// statusRecorder remembers the status of a response. It passes flushes and
+// hijacks through for the event stream and WebSocket routes.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Flush() {
+	if flusher, ok := rec.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (rec *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := rec.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("the response can't be hijacked")
+	}
+	return hijacker.Hijack()
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
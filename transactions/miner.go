@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VOOVOOZEL/go_blockchain/transactions/sdk"
+)
+
+// throttlePeriod is the length of one work/sleep cycle of a throttled miner
+const throttlePeriod = 100 * time.Millisecond
+
+// throttleCheckEvery is how many hashes a miner thread computes between
+// looking at the clock
+const throttleCheckEvery = 256
+
+var (
+	// minerThreads is the number of goroutines searching for a nonce
+	minerThreads = 1
+	// minerDutyCycle is the percentage of each throttle period the miner
+	// threads spend hashing; they sleep for the rest
+	minerDutyCycle = 100
+	// heartbeatInterval is the longest the chain may go without a block
+	// before the node mines one, even an empty one; 0 disables it
+	heartbeatInterval time.Duration
+	// minerDifficulty is the difficulty the local miner aims for, 0 for the
+	// network's. It can only make blocks harder than the network requires.
+	minerDifficulty atomic.Int32
+	// minerKey signs the node's own spends from the miner address, nil
+	// unless MINER_WALLET is set
+	minerKey *sdk.ExtendedKey
+)
+
+// miningDifficulty is the difficulty of the blocks the local miner searches
+func miningDifficulty() int {
+	return max(int(minerDifficulty.Load()), difficulty)
+}
+
+// setMinerDifficulty changes the difficulty the local miner aims for; it
+// applies from the next block searched
+func setMinerDifficulty(d int) error {
+	if d < difficulty || d > maxDifficulty {
+		return fmt.Errorf("ERROR: Difficulty must be between the network's %d and %d", difficulty, maxDifficulty)
+	}
+	minerDifficulty.Store(int32(d))
+	return nil
+}
+
+// loadMinerConfig reads MINER_WALLET, MINER_THREADS, MINER_DUTY_CYCLE,
+// MINER_DIFFICULTY and HEARTBEAT_INTERVAL from the environment
+func loadMinerConfig() {
+	loadMinerKey()
+	if v := os.Getenv("MINER_THREADS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatalf("MINER_THREADS must be a positive integer, got %q", v)
+		}
+		minerThreads = n
+	}
+	if v := os.Getenv("MINER_DUTY_CYCLE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			log.Fatalf("MINER_DUTY_CYCLE must be a percentage between 1 and 100, got %q", v)
+		}
+		minerDutyCycle = n
+	}
+	if v := os.Getenv("MINER_DIFFICULTY"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || setMinerDifficulty(n) != nil {
+			log.Fatalf("MINER_DIFFICULTY must be between DIFFICULTY %d and %d, got %q", difficulty, maxDifficulty, v)
+		}
+	}
+	if v := os.Getenv("HEARTBEAT_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			log.Fatalf("HEARTBEAT_INTERVAL must be a duration such as 30s, got %q", v)
+		}
+		heartbeatInterval = d
+	}
+}
+
+// loadMinerKey reads MINER_WALLET, a wallet file as "wallet create" writes.
+// Its address becomes the miner address and its key signs the node's spends
+// from it. Where name addresses need signatures, as on main, the node
+// refuses to mine to a name: nothing could ever spend those coins.
+func loadMinerKey() {
+	if path := os.Getenv("MINER_WALLET"); path != "" {
+		w, err := readWallet(path)
+		if err != nil {
+			log.Fatalf("MINER_WALLET: %v", err)
+		}
+		key, err := sdk.ParseExtendedKey(w.Key)
+		if err != nil || !key.IsPrivate() {
+			log.Fatalf("MINER_WALLET: %s holds no private key", path)
+		}
+		if address := os.Getenv("MINER_ADDRESS"); address != "" && address != key.Address() {
+			log.Fatalf("MINER_ADDRESS %s isn't the address %s of MINER_WALLET", address, key.Address())
+		}
+		minerKey = key
+	}
+	if !params.UnsignedNames && !sdk.ValidateAddress(minerAddress()) {
+		log.Fatalf("The miner address %s is a name, which can't spend on %s; set MINER_WALLET or MINER_ADDRESS to a wallet address", minerAddress(), params.Name)
+	}
+}
+
+// startHeartbeat mines a block whenever heartbeatInterval passes without a
+// new block, so transactions and timestamps get a bounded confirmation
+// latency. The block carries whatever the mempool holds, which may be
+// nothing but the coinbase.
+func startHeartbeat() {
+	if heartbeatInterval == 0 {
+		return
+	}
+	minerLog.Info("Heartbeat mining", "interval", heartbeatInterval)
+
+	go func() {
+		events := chainEvents.Subscribe(BlockConnected)
+		deadline := time.Now().Add(heartbeatInterval)
+		for {
+			select {
+			case _, ok := <-events:
+				if !ok {
+					events = chainEvents.Subscribe(BlockConnected)
+				} else {
+					deadline = time.Now().Add(heartbeatInterval)
+				}
+			case <-time.After(time.Until(deadline)):
+				mineHeartbeat()
+				deadline = time.Now().Add(heartbeatInterval)
+			}
+		}
+	}()
+}
+
+func mineHeartbeat() {
+	if err := resourceGuard.check(); err != nil {
+		minerLog.Warn("Skipping heartbeat block", "err", err)
+		return
+	}
+
+	ctx, span := tracer.Start(shutdownCtx, "heartbeat block")
+	defer span.End()
+	b, err := generateBlock(ctx, bc.Tip())
+	if err != nil {
+		minerLog.Warn("Heartbeat block not mined", "err", err)
+		return
+	}
+	if err := commitBlock(ctx, b); err != nil {
+		minerLog.Error("Heartbeat block rejected", "hash", b.Hash, "err", err)
+		return
+	}
+	relayBlock(b, nil)
+	minerLog.Info("Heartbeat block", "hash", b.Hash, "txs", len(b.Transactions))
+}
+
+// throttle keeps a miner thread busy for dutyCycle percent of every period
+type throttle struct {
+	busy    time.Duration
+	started time.Time
+	hashes  int
+}
+
+func newThrottle(dutyCycle int) *throttle {
+	return &throttle{throttlePeriod * time.Duration(dutyCycle) / 100, time.Now(), 0}
+}
+
+// tick is called after every hash and sleeps once the busy share of the
+// current period is used up
+func (t *throttle) tick() {
+	t.hashes++
+	if t.hashes%throttleCheckEvery != 0 {
+		return
+	}
+	resourceGuard.wait()
+	if t.busy >= throttlePeriod {
+		runtime.Gosched()
+		return
+	}
+	if time.Since(t.started) >= t.busy {
+		time.Sleep(throttlePeriod - t.busy)
+		t.started = time.Now()
+	}
+}
+
+// searchNonce looks for a nonce that satisfies the miner's difficulty,
+// splitting the nonce space between minerThreads goroutines. It sets Nonce
+// and Hash on block and returns true, or returns false once the nonce space
+// is exhausted or ctx is canceled
+func searchNonce(ctx context.Context, block *Block) bool {
+	done := ctx.Done()
+	threads := uint64(minerThreads)
+	target := miningDifficulty()
+	var found atomic.Bool
+	var wg sync.WaitGroup
+	result := make(chan Block, 1)
+
+	for start := uint64(0); start < threads; start++ {
+		wg.Add(1)
+		go func(nonce uint64) {
+			defer wg.Done()
+
+			// only the nonce changes, so the transactions are hashed once
+			candidate := block.withTxHash()
+			throttle := newThrottle(minerDutyCycle)
+			for !found.Load() {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				candidate.Nonce = nonce
+				newHash := calculateHash(&candidate)
+				minerStats.hashes.Add(1)
+				if isHashValid(newHash, target) {
+					if found.CompareAndSwap(false, true) {
+						candidate.Hash = newHash
+						result <- candidate
+					}
+					return
+				}
+				if nonce > math.MaxUint64-threads {
+					return
+				}
+				nonce += threads
+				throttle.tick()
+			}
+		}(start)
+	}
+	wg.Wait()
+
+	select {
+	case mined := <-result:
+		block.Nonce, block.Hash = mined.Nonce, mined.Hash
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MinerStats tracks the work done by the built-in miner. Several nonce
+// searches may run at once, from the heartbeat, the job queue and RPC
+// generate; each keeps its own start time, and the hashrate is taken over
+// the time any of them was running.
+type MinerStats struct {
+	hashes atomic.Uint64
+
+	sync.Mutex
+	blocksFound int
+	blockTime   time.Duration // time spent on searches that found a block
+	busyTime    time.Duration // time some search was running, up to busySince
+	busySince   time.Time
+	running     int
+}
+
+// MinerStatsReport is the JSON view of MinerStats
+type MinerStatsReport struct {
+	Mining             bool
+	PausedReason       string // set while resource pressure pauses mining
+	Difficulty         int
+	Hashes             uint64
+	Hashrate           float64 // hashes per second while mining
+	BlocksFound        int
+	AverageTimeToBlock float64 // seconds
+}
+
+var minerStats MinerStats
+
+// startJob marks the beginning of a nonce search and returns its start,
+// to be handed to blockFound or jobAborted
+func (s *MinerStats) startJob() time.Time {
+	s.Lock()
+	defer s.Unlock()
+	now := time.Now()
+	if s.running == 0 {
+		s.busySince = now
+	}
+	s.running++
+	return now
+}
+
+// endJob marks the end of the search started at started and returns how
+// long it took
+func (s *MinerStats) endJob(started time.Time) time.Duration {
+	now := time.Now()
+	if s.running--; s.running == 0 {
+		s.busyTime += now.Sub(s.busySince)
+	}
+	return now.Sub(started)
+}
+
+// blockFound marks the end of a successful nonce search
+func (s *MinerStats) blockFound(started time.Time) {
+	s.Lock()
+	defer s.Unlock()
+	took := s.endJob(started)
+	s.blockTime += took
+	s.blocksFound++
+	blocksMined.Inc()
+	miningDuration.Observe(took.Seconds())
+}
+
+// jobAborted marks the end of a nonce search given up on. Its hashes count,
+// and so does its time.
+func (s *MinerStats) jobAborted(started time.Time) {
+	s.Lock()
+	defer s.Unlock()
+	s.endJob(started)
+}
+
+// Report takes a consistent snapshot of the statistics
+func (s *MinerStats) Report() MinerStatsReport {
+	s.Lock()
+	defer s.Unlock()
+
+	report := MinerStatsReport{
+		Mining:       s.running > 0,
+		PausedReason: resourceGuard.pauseReason(),
+		Difficulty:   miningDifficulty(),
+		Hashes:       s.hashes.Load(),
+		BlocksFound:  s.blocksFound,
+	}
+
+	elapsed := s.busyTime
+	if report.Mining {
+		elapsed += time.Since(s.busySince)
+	}
+	if elapsed > 0 {
+		report.Hashrate = float64(report.Hashes) / elapsed.Seconds()
+	}
+	if s.blocksFound > 0 {
+		report.AverageTimeToBlock = s.blockTime.Seconds() / float64(s.blocksFound)
+	}
+
+	return report
+}
+
+// reports hashrate, blocks found and average time to block
+func handleGetMinerStats(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, r, http.StatusOK, minerStats.Report())
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMinerStatsOverlappingJobs(t *testing.T) {
+	var s MinerStats
+	heartbeat := s.startJob()
+	time.Sleep(10 * time.Millisecond)
+	generate := s.startJob()
+	s.jobAborted(heartbeat)
+	if !s.Report().Mining {
+		t.Fatal("not mining while a job still runs")
+	}
+	time.Sleep(10 * time.Millisecond)
+	s.hashes.Add(100)
+	s.blockFound(generate)
+
+	report := s.Report()
+	if report.Mining || report.BlocksFound != 1 {
+		t.Fatalf("report %+v after the last job ended", report)
+	}
+	// busy from the first start to the last end, overlapping time once
+	if want := generate.Sub(heartbeat) + s.blockTime; s.busyTime != want {
+		t.Errorf("busy %v, want %v", s.busyTime, want)
+	}
+	if s.blockTime < 10*time.Millisecond {
+		t.Errorf("block found after %v", s.blockTime)
+	}
+}
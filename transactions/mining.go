@@ -0,0 +1,138 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// BlockTemplate has everything an external miner needs to search for a
+// nonce: a block hashes to sha256 of its header as encodeHeader lays it out
+// and is valid once that hash is at most Target
+type BlockTemplate struct {
+	Height           int
+	PrevHash         string
+	Timestamp        string
+	Difficulty       int
+	Target           string
+	Transactions     []*Transaction
+	TransactionsHash string
+}
+
+// hands out a block template on top of the current tip. The coinbase pays
+// the subsidy and the fees to the address query parameter, or the node's
+// miner address
+func handleGetBlockTemplate(w http.ResponseWriter, r *http.Request) {
+	if err := resourceGuard.check(); err != nil {
+		respondWithError(w, r, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	address := r.URL.Query().Get("address")
+	if address == "" {
+		address = minerAddress()
+	}
+
+	bc.RLock()
+	tip, height := bc.blocks[len(bc.blocks)-1], len(bc.blocks)
+	bc.RUnlock()
+	txs, fees, err := bc.blockTransactions()
+	if err != nil {
+		respondWithError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+	coinbase := NewMinerCoinbaseTX(address, height, 0, fees)
+	block := &Block{
+		Timestamp:    time.Now().String(),
+		Transactions: append([]*Transaction{coinbase}, txs...),
+		PrevHash:     tip.Hash,
+	}
+
+	respondWithJSON(w, r, http.StatusOK, BlockTemplate{
+		Height:           height,
+		PrevHash:         block.PrevHash,
+		Timestamp:        block.Timestamp,
+		Difficulty:       difficulty,
+		Target:           strings.Repeat("0", difficulty) + strings.Repeat("f", 64-difficulty),
+		Transactions:     block.Transactions,
+		TransactionsHash: hex.EncodeToString(block.HashTransactions()),
+	})
+}
+
+// accepts a block mined outside the node
+func handleSubmitBlock(w http.ResponseWriter, r *http.Request) {
+	var block Block
+
+	if err := decodeRequest(r, &block); err != nil {
+		respondWithRequestError(w, r, err)
+		return
+	}
+
+	if err := checkTransactionIDs(&block); err != nil {
+		respondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := bc.ProcessBlock(&block); err != nil {
+		respondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	relayBlock(&block, nil)
+
+	respondWithJSON(w, r, http.StatusCreated, block)
+}
+
+// checkTransactionIDs makes sure every transaction ID in a block received
+// from outside matches the transaction's contents
+func checkTransactionIDs(b *Block) error {
+	for _, tx := range b.Transactions {
+		if err := checkTransactionID(tx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+const (
+	// blockReserve is the room the miner keeps for the header and the
+	// coinbase when it fills a block with transactions
+	blockReserve = 1024
+	// minBlockSize is the smallest MAX_BLOCK_SIZE, leaving room for some
+	// transactions besides the reserve
+	minBlockSize = 4 * blockReserve
+)
+
+// checkBlockLimits rejects a block with more transactions or encoded bytes
+// than the network allows
+func checkBlockLimits(b *Block) error {
+	if len(b.Transactions) > params.MaxBlockTxs {
+		return fmt.Errorf("ERROR: Block has %d transactions, more than %d", len(b.Transactions), params.MaxBlockTxs)
+	}
+	if size := blockSize(b); size > params.MaxBlockSize {
+		return fmt.Errorf("ERROR: Block is %d bytes, more than %d", size, params.MaxBlockSize)
+	}
+	return nil
+}
+
+// assignTransactionID gives a submitted transaction its ID when it came
+// without one, as sdk.TxBuilder leaves it, and otherwise checks the one it
+// carries rather than silently replacing it
+func assignTransactionID(tx *Transaction) error {
+	if tx.ID == "" {
+		tx.SetID()
+		return nil
+	}
+	return checkTransactionID(tx)
+}
+
+// checkTransactionID makes sure a transaction's ID matches its contents
+func checkTransactionID(tx *Transaction) error {
+	check := *tx
+	check.ID = ""
+	check.SetID()
+	if check.ID != tx.ID {
+		return fmt.Errorf("ERROR: Transaction ID %s doesn't match its contents", tx.ID)
+	}
+	return nil
+}
@@ -0,0 +1,430 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VOOVOOZEL/go_blockchain/transactions/sdk"
+	"github.com/gorilla/mux"
+)
+
+// apiDoc describes one route of the API for the OpenAPI document
+type apiDoc struct {
+	Summary string
+	Tag     string
+	Query   []apiParam
+	// Request and Response are values of the JSON body types, nil for none
+	Request  interface{}
+	Response interface{}
+	// Status is the status of a successful answer, 200 if 0
+	Status int
+	// Content is the media type of answers that aren't JSON
+	Content string
+	// Idempotent routes take an Idempotency-Key header, see idempotent
+	Idempotent bool
+}
+
+// apiParam is a query parameter
+type apiParam struct {
+	Name, Type, Description string
+}
+
+// apiDocs documents the routes of makeMuxRouter, makeLightRouter and
+// makeAdminRouter, keyed by method and path template. Add an entry with every
+// new route; the document lists routes without one, but bare.
+var apiDocs = map[string]apiDoc{
+	"GET /": {
+		Summary: "List the blocks of the active chain", Tag: "chain", Response: []*Block{},
+		Query: []apiParam{
+			{"from", "integer", "height to start at"},
+			{"limit", "integer", "how many blocks to return, all if 0"},
+			{"order", "string", "asc or desc"},
+		},
+	},
+	"POST /":                       {Summary: "Queue a block paying Value from From to To", Tag: "wallet", Request: SendMessage{}, Response: Job{}, Status: http.StatusAccepted, Idempotent: true},
+	"POST /balance":                {Summary: "Get the balance of an address", Tag: "wallet", Request: BalanceMessage{}, Response: BalanceInfo{}, Status: http.StatusCreated},
+	"GET /balance/{address}":       {Summary: "Get the balance of an address", Tag: "wallet", Response: BalanceInfo{}},
+	"POST /faucet":                 {Summary: "Queue a block paying test coins to an address", Tag: "wallet", Request: FaucetMessage{}, Response: Job{}, Status: http.StatusAccepted, Idempotent: true},
+	"POST /refund":                 {Summary: "Queue a block paying a received transaction back", Tag: "wallet", Request: RefundMessage{}, Response: Job{}, Status: http.StatusAccepted, Idempotent: true},
+	"POST /tx/raw/send":            {Summary: "Queue a block with a signed transaction", Tag: "transactions", Request: &Transaction{}, Response: Job{}, Status: http.StatusAccepted, Idempotent: true},
+	"POST /tx":                     {Summary: "Submit a signed transaction to the mempool", Tag: "transactions", Request: &Transaction{}, Response: &Transaction{}, Status: http.StatusAccepted, Idempotent: true},
+	"GET /tx/{txid}":               {Summary: "Look a transaction up", Tag: "transactions", Response: &TransactionInfo{}},
+	"GET /fees/estimate":           {Summary: "Recommend a fee rate per byte for confirmation within target blocks", Tag: "transactions", Query: []apiParam{{"target", "integer", "blocks to confirm within, 1 to 100, 1 by default"}}, Response: FeeEstimate{}},
+	"GET /jobs/{id}":               {Summary: "Poll a mining job", Tag: "transactions", Response: Job{}},
+	"GET /mempool":                 {Summary: "List the pooled transactions with their size and fee", Tag: "transactions", Response: MempoolInfo{}},
+	"GET /mempool/{txid}":          {Summary: "Show a pooled transaction", Tag: "transactions", Response: MempoolTx{}},
+	"DELETE /admin/mempool/{txid}": {Summary: "Evict a pooled transaction and its descendants", Tag: "admin", Response: map[string][]string{}},
+	"POST /admin/webhooks":         {Summary: "Register a webhook for block, transaction, reorg and alert events", Tag: "admin", Request: WebhookRequest{}, Response: Webhook{}, Status: http.StatusCreated},
+	"GET /admin/webhooks":          {Summary: "List the webhooks", Tag: "admin", Response: []Webhook{}},
+	"DELETE /admin/webhooks/{id}":  {Summary: "Delete a webhook", Tag: "admin", Status: http.StatusNoContent},
+	"GET /mining/template": {
+		Summary: "Get a block template for external miners", Tag: "mining", Response: BlockTemplate{},
+		Query: []apiParam{{"address", "string", "address the coinbase pays, the node's miner address by default"}},
+	},
+	"POST /mining/submit":               {Summary: "Submit a mined block", Tag: "mining", Request: &Block{}, Response: &Block{}, Status: http.StatusCreated},
+	"GET /miner/stats":                  {Summary: "Get the statistics of the node's miner", Tag: "mining", Response: MinerStatsReport{}},
+	"GET /height":                       {Summary: "Get the height and tip of the active chain", Tag: "chain", Response: sdk.ChainHeight{}},
+	"GET /chaininfo":                    {Summary: "Summarize the state of the chain", Tag: "chain", Response: ChainInfo{}},
+	"GET /search":                       {Summary: "Find the block, transaction or address a hash, height or address names", Tag: "chain", Query: []apiParam{{"q", "string", "a block hash or height, transaction ID or address"}}, Response: SearchResult{}},
+	"GET /block/{hash}":                 {Summary: "Look a block up by hash", Tag: "chain", Response: &BlockInfo{}},
+	"GET /block/height/{height}":        {Summary: "Look a block of the active chain up by height", Tag: "chain", Response: &BlockInfo{}},
+	"GET /beacon/{height}":              {Summary: "Get the randomness beacon at a height", Tag: "chain", Response: &sdk.Beacon{}},
+	"GET /lottery/{name}":               {Summary: "Get the state of a lottery", Tag: "lottery", Response: &LotteryStatus{}},
+	"POST /lottery/{name}/payout":       {Summary: "Mine the payout of a drawn lottery", Tag: "lottery", Response: &Transaction{}, Status: http.StatusAccepted},
+	"GET /ws":                           {Summary: "Stream chain events over a WebSocket, optionally filtered by a FilterLoadMessage", Tag: "events", Status: http.StatusSwitchingProtocols},
+	"GET /events":                       {Summary: "Stream chain events as server-sent events", Tag: "events", Content: "text/event-stream"},
+	"GET /peers":                        {Summary: "List the connected peers", Tag: "network", Response: []PeerInfo{}},
+	"GET /sync":                         {Summary: "Get the progress of the initial block download", Tag: "network", Response: SyncStatus{}},
+	"POST /rpc":                         {Summary: "Call a JSON-RPC 2.0 method, or a batch of them", Tag: "rpc", Request: rpcRequest{}, Response: rpcResponse{}},
+	"GET /graphql":                      {Summary: "Run a GraphQL query given in the query, variables and operationName parameters", Tag: "graphql", Query: []apiParam{{"query", "string", "the GraphQL query"}, {"variables", "string", "a JSON object"}, {"operationName", "string", "the operation to run"}}, Response: GraphQLResponse{}},
+	"POST /graphql":                     {Summary: "Run a GraphQL query", Tag: "graphql", Request: GraphQLRequest{}, Response: GraphQLResponse{}},
+	"GET /graphql/schema":               {Summary: "Get the GraphQL schema", Tag: "graphql", Content: "text/plain"},
+	"GET /deprecations":                 {Summary: "List the deprecated endpoints", Tag: "meta", Response: []Deprecation{}},
+	"GET /healthz":                      {Summary: "Tell that the process is up", Tag: "meta", Response: Health{}},
+	"GET /metrics":                      {Summary: "Get the Prometheus metrics", Tag: "meta", Content: "text/plain"},
+	"GET /readyz":                       {Summary: "Tell whether the node is ready for traffic, 503 if not", Tag: "meta", Response: Readiness{}},
+	"GET /openapi.json":                 {Summary: "Get this document", Tag: "meta"},
+	"GET /admin/openapi.json":           {Summary: "Get the admin API's document", Tag: "admin"},
+	"GET /docs":                         {Summary: "Browse this document with Swagger UI", Tag: "meta", Content: "text/html"},
+	"GET /explorer":                     {Summary: "Redirect to the block explorer", Tag: "meta", Status: http.StatusMovedPermanently},
+	"GET /explorer/":                    {Summary: "Browse the chain in the block explorer", Tag: "meta", Content: "text/html"},
+	"HEAD /explorer/":                   {Summary: "Check a file of the block explorer", Tag: "meta"},
+	"GET /cache/stats":                  {Summary: "Get the statistics of the chain cache", Tag: "meta", Response: CacheStats{}},
+	"GET /light":                        {Summary: "Get the state of the light client", Tag: "light", Response: LightStatus{}},
+	"POST /admin/invalidateblock":       {Summary: "Mark a block and its descendants invalid", Tag: "admin", Request: BlockHashMessage{}, Response: &Block{}},
+	"POST /admin/reconsiderblock":       {Summary: "Undo invalidateblock", Tag: "admin", Request: BlockHashMessage{}, Response: &Block{}},
+	"POST /admin/replace-chain":         {Summary: "Replace the active chain with a longer valid one", Tag: "admin", Request: ReplaceChainMessage{}, Response: &Block{}},
+	"POST /admin/verify":                {Summary: "Check the integrity of the active chain and report the first inconsistency", Tag: "admin", Request: VerifyMessage{}, Response: ChainVerification{}},
+	"GET /admin/peers":                  {Summary: "Get the statistics of every peer", Tag: "admin", Response: []PeerStatsReport{}},
+	"GET /admin/acl":                    {Summary: "Get the API and P2P access lists", Tag: "admin", Response: map[string]AccessListConfig{}},
+	"PUT /admin/difficulty":             {Summary: "Set the local miner's difficulty on a development network", Tag: "admin", Request: DifficultyMessage{}, Response: DifficultyMessage{}},
+	"PUT /admin/acl/{list}":             {Summary: "Replace the api or p2p access list", Tag: "admin", Request: AccessListConfig{}, Response: AccessListConfig{}},
+	"GET /admin/status":                 {Summary: "Get the process and chain state of the node", Tag: "admin", Response: NodeStatus{}},
+	"POST /admin/stop":                  {Summary: "Shut the node down", Tag: "admin", Response: NodeStatus{}, Status: http.StatusAccepted},
+	"GET /admin/audit":                  {Summary: "Query the audit log of state-changing requests, newest first", Tag: "admin", Response: []AuditEntry{}},
+	"GET /debug/utxo-stats":             {Summary: "Sum up the unspent outputs by value and by address", Tag: "admin", Response: UTXOStats{}},
+	"GET /admin/debug/runtime":          {Summary: "Get the goroutine count, chain size and memory statistics", Tag: "admin", Response: RuntimeStats{}},
+	"GET /admin/debug/goroutines":       {Summary: "Count the goroutines by the function that started them", Tag: "admin", Response: []GoroutineGroup{}},
+	"GET /debug/pprof/":                 {Summary: "List the pprof profiles, or get one by name", Tag: "admin", Content: "text/html"},
+	"GET /debug/pprof/profile":          {Summary: "Get a CPU profile of the next seconds, 30 by default", Tag: "admin", Content: "application/octet-stream"},
+	"GET /debug/pprof/trace":            {Summary: "Get an execution trace of the next seconds, 1 by default", Tag: "admin", Content: "application/octet-stream"},
+	"GET /debug/pprof/cmdline":          {Summary: "Get the node's command line", Tag: "admin", Content: "text/plain"},
+	"GET /debug/pprof/symbol":           {Summary: "Look up program counters for pprof", Tag: "admin", Content: "text/plain"},
+	"POST /debug/pprof/symbol":          {Summary: "Look up program counters for pprof", Tag: "admin", Content: "text/plain"},
+	"GET /federation/nodes":             {Summary: "List the federated nodes", Tag: "federation", Response: []*FederatedNode{}},
+	"GET /federation/heights":           {Summary: "Get the height of every federated node", Tag: "federation", Response: []FederatedHeight{}},
+	"GET /federation/balance/{address}": {Summary: "Get the balance of an address on every federated node", Tag: "federation", Response: FederatedBalanceReport{}},
+	"GET /archive/manifest":             {Summary: "List the archive files", Tag: "archive", Response: []ArchiveFile{}},
+	"GET /archive/{name}":               {Summary: "Download an archive file", Tag: "archive", Content: "application/octet-stream"},
+	"HEAD /archive/{name}":              {Summary: "Check an archive file", Tag: "archive"},
+	"GET /xpub/{xpub}/addresses": {
+		Summary: "Derive receiving addresses from an account xpub", Tag: "wallet", Response: []*sdk.DerivedAddress{},
+		Query: []apiParam{
+			{"from", "integer", "index of the first address"},
+			{"count", "integer", "how many addresses to derive"},
+		},
+	},
+	"GET /xpub/{xpub}/addresses/{index}": {Summary: "Derive the receiving address at an index", Tag: "wallet", Response: &sdk.DerivedAddress{}},
+}
+
+// integerParams are the path parameters that are numbers
+var integerParams = map[string]bool{"height": true, "index": true}
+
+var pathParam = regexp.MustCompile(`{(\w+)}`)
+
+// schema is an OpenAPI schema object
+type schema map[string]interface{}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemaBuilder turns Go types into schemas, putting named structs in the
+// document's components
+type schemaBuilder struct {
+	components map[string]schema
+	names      map[reflect.Type]string
+}
+
+func (sb *schemaBuilder) of(t reflect.Type) schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t {
+	case timeType:
+		return schema{"type": "string", "format": "date-time"}
+	case rawMessageType:
+		return schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return schema{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return schema{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return schema{"type": "number"}
+	case reflect.String:
+		return schema{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return schema{"type": "string", "format": "byte"}
+		}
+		return schema{"type": "array", "items": sb.of(t.Elem())}
+	case reflect.Map:
+		return schema{"type": "object", "additionalProperties": sb.of(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return sb.object(t)
+		}
+		return schema{"$ref": "#/components/schemas/" + sb.name(t)}
+	}
+	return schema{}
+}
+
+// name registers a named struct in the components and returns its name,
+// prefixed with its package if another package has the same name
+func (sb *schemaBuilder) name(t reflect.Type) string {
+	if name, ok := sb.names[t]; ok {
+		return name
+	}
+	name := t.Name()
+	if _, taken := sb.components[name]; taken {
+		name = path.Base(t.PkgPath()) + "." + name
+	}
+	sb.names[t] = name
+	// a placeholder, for types that refer to themselves
+	sb.components[name] = schema{}
+	sb.components[name] = sb.object(t)
+	return name
+}
+
+// object describes a struct the way encoding/json writes it
+func (sb *schemaBuilder) object(t reflect.Type) schema {
+	properties := schema{}
+	var required []string
+	sb.addFields(t, properties, &required)
+
+	s := schema{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+func (sb *schemaBuilder) addFields(t reflect.Type, properties schema, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+
+		if field.Anonymous && name == "" {
+			embedded := field.Type
+			if embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				sb.addFields(embedded, properties, required)
+				continue
+			}
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = sb.of(field.Type)
+		for _, rule := range strings.Split(field.Tag.Get("validate"), ",") {
+			if rule == "required" {
+				*required = append(*required, name)
+			}
+		}
+	}
+}
+
+// operation describes one method of a route
+func (sb *schemaBuilder) operation(method, template string) schema {
+	route := unversioned(template)
+	doc := apiDocs[method+" "+route]
+	op := schema{"summary": doc.Summary}
+	if doc.Tag != "" {
+		op["tags"] = []string{doc.Tag}
+	}
+
+	var parameters []schema
+	for _, m := range pathParam.FindAllStringSubmatch(template, -1) {
+		typ := "string"
+		if integerParams[m[1]] {
+			typ = "integer"
+		}
+		parameters = append(parameters, schema{"name": m[1], "in": "path", "required": true, "schema": schema{"type": typ}})
+	}
+	for _, q := range doc.Query {
+		parameters = append(parameters, schema{"name": q.Name, "in": "query", "description": q.Description, "schema": schema{"type": q.Type}})
+	}
+	if doc.Idempotent {
+		parameters = append(parameters, schema{"name": "Idempotency-Key", "in": "header", "description": "replays the response of an earlier request with the same key", "schema": schema{"type": "string", "maxLength": maxIdempotencyKeyLength}})
+	}
+	if len(parameters) > 0 {
+		op["parameters"] = parameters
+	}
+
+	if doc.Request != nil {
+		op["requestBody"] = schema{
+			"required": true,
+			"content":  schema{"application/json": schema{"schema": sb.of(reflect.TypeOf(doc.Request))}},
+		}
+	}
+
+	status := doc.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := schema{"description": http.StatusText(status)}
+	errorBody := sb.of(reflect.TypeOf(ErrorResponse{}))
+	enveloped := route != template
+	if enveloped {
+		errorBody = sb.envelope(nil)
+	}
+	switch {
+	case doc.Response != nil:
+		body := sb.of(reflect.TypeOf(doc.Response))
+		if enveloped {
+			body = sb.envelope(body)
+		}
+		content := schema{mediaJSON: schema{"schema": body}, mediaMsgpack: schema{"schema": body}}
+		switch doc.Response.(type) {
+		case *BlockInfo, *TransactionInfo, *Transaction, []*Block:
+			if !enveloped {
+				content[mediaProtobuf] = schema{}
+			}
+		}
+		success["content"] = content
+	case doc.Content != "":
+		success["content"] = schema{doc.Content: schema{}}
+	}
+	op["responses"] = schema{
+		strconv.Itoa(status): success,
+		"default": schema{
+			"description": "Error",
+			"content":     schema{"application/json": schema{"schema": errorBody}},
+		},
+	}
+
+	auth := apiAuth
+	if strings.HasPrefix(route, adminPrefix) {
+		auth = adminAuth
+	}
+	if auth.enabled() && auth.routeNeedsAuth(method, route) {
+		op["security"] = []schema{{"bearerAuth": []string{}}, {"apiKey": []string{}}}
+	}
+	if _, ok := deprecationFor(method, route); ok {
+		op["deprecated"] = true
+	}
+	return op
+}
+
+// envelope is the schema of an Envelope holding data, or an error without
+func (sb *schemaBuilder) envelope(data schema) schema {
+	properties := schema{
+		"error": sb.of(reflect.TypeOf(ErrorResponse{})),
+		"meta":  sb.of(reflect.TypeOf(Meta{})),
+	}
+	if data != nil {
+		properties["data"] = data
+	}
+	return schema{"type": "object", "properties": properties}
+}
+
+// openAPIDocument describes every route of router in OpenAPI 3
+func openAPIDocument(router *mux.Router) schema {
+	sb := &schemaBuilder{components: make(map[string]schema), names: make(map[reflect.Type]string)}
+	paths := schema{}
+
+	router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return nil
+		}
+		sort.Strings(methods)
+
+		item, ok := paths[template].(schema)
+		if !ok {
+			item = schema{}
+			paths[template] = item
+		}
+		for _, method := range methods {
+			item[strings.ToLower(method)] = sb.operation(method, template)
+		}
+		return nil
+	})
+
+	return schema{
+		"openapi": "3.0.3",
+		"info": schema{
+			"title":       "go_blockchain node API",
+			"version":     "1",
+			"description": "The HTTP API of a " + params.Name + " node",
+		},
+		"paths": paths,
+		"components": schema{
+			"schemas": sb.components,
+			"securitySchemes": schema{
+				"bearerAuth": schema{"type": "http", "scheme": "bearer"},
+				"apiKey":     schema{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			},
+		},
+	}
+}
+
+// serveOpenAPI returns the handler of /openapi.json for router. The document
+// is built on the first request, once all routes are registered.
+func serveOpenAPI(router *mux.Router) http.HandlerFunc {
+	var once sync.Once
+	var doc schema
+	return func(w http.ResponseWriter, r *http.Request) {
+		once.Do(func() { doc = openAPIDocument(router) })
+		respondWithJSON(w, r, http.StatusOK, doc)
+	}
+}
+
+// swaggerUI loads Swagger UI from a CDN and points it at /openapi.json
+const swaggerUI = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>go_blockchain API</title>
+<link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+<div id="swagger-ui"></div>
+<script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js"></script>
+<script>SwaggerUIBundle({url: "/openapi.json", dom_id: "#swagger-ui"});</script>
+</body>
+</html>
+`
+
+// shows the API documentation with Swagger UI
+func handleDocs(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(swaggerUI))
+}
@@ -0,0 +1,547 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/VOOVOOZEL/go_blockchain/transactions/wire"
+)
+
+const (
+	protocolVersion = 5
+
+	// maxBlocksPerMessage caps the blocks sent in reply to one getblocks or
+	// getdata
+	maxBlocksPerMessage = 500
+	// maxMessageSize caps a single encoded message
+	maxMessageSize = 32 << 20
+
+	statusInterval    = 10 * time.Second
+	reconnectInterval = 15 * time.Second
+)
+
+// VersionMessage announces a node's protocol version and chain tip. It is
+// sent on connect and then periodically as a status update. ListenPort is
+// the port the node accepts peers on, or 0, and Nonce identifies the node
+// so it can detect connections to itself. Nodes only peer when they are on
+// the same Network and their ChainParams hashes match. NodeID must match
+// the key the peer authenticated with over TLS.
+type VersionMessage struct {
+	Version     int
+	Network     string
+	Height      int
+	TipHash     string
+	ListenPort  int
+	Nonce       uint64
+	ChainParams string
+	NodeID      string
+	// Light is set by light clients, which have headers but no blocks
+	Light bool
+}
+
+// GetBlocksMessage asks for the active chain blocks that follow the first
+// locator hash the peer knows
+type GetBlocksMessage struct {
+	Locator []string
+}
+
+// BlocksMessage carries consecutive blocks, oldest first
+type BlocksMessage struct {
+	Blocks []*Block
+}
+
+// Peer is a connection to another node
+type Peer struct {
+	conn    net.Conn
+	addr    string
+	inbound bool
+	// nodeID is the peer's node ID as authenticated by TLS, empty without it
+	nodeID   string
+	sendLock sync.Mutex
+	encoder  *wire.Encoder
+
+	// pubsub is set for libp2p peers, whose block and transaction gossip
+	// travels over pubsub topics instead of this connection
+	pubsub bool
+
+	sync.Mutex
+	version     *VersionMessage
+	connectedAt time.Time
+	pingNonce   uint64
+	pingSent    time.Time
+	// compact holds compact blocks waiting for getblocktxn answers
+	compact map[string]*partialBlock
+	// announced holds the blocks we asked for by getdata outside of sync,
+	// with the time by which they must arrive
+	announced map[string]time.Time
+	// filter holds the addresses a light peer watches; filterLoaded is set
+	// once we, as a light client, sent ours
+	filter       addressFilter
+	filterLoaded bool
+}
+
+// PeerInfo is the JSON view of a peer
+type PeerInfo struct {
+	Addr string
+	// NodeID is the peer's identity; Authenticated tells whether it proved
+	// it over TLS or merely claimed it in its version message
+	NodeID        string
+	Authenticated bool
+	Inbound       bool
+	Height        int
+	ConnectedAt   time.Time
+}
+
+// PeerManager keeps track of connected peers
+type PeerManager struct {
+	sync.Mutex
+	peers   map[*Peer]bool
+	dialing map[string]bool
+}
+
+var peerManager = &PeerManager{peers: make(map[*Peer]bool), dialing: make(map[string]bool)}
+
+var (
+	// listenPort is the P2P port we accept peers on, 0 if we don't
+	listenPort int
+	// nodeNonce tells our own version messages apart from other nodes'
+	nodeNonce = randomNonce()
+)
+
+// startLibp2p is set when the node is built with the libp2p tag
+var startLibp2p func() error
+
+// startP2P starts the transport chosen by P2P_TRANSPORT: "tcp" (the default)
+// or "libp2p"
+func startP2P() {
+	peerStats.load(filepath.Join(dataDir(), peerStatsFile))
+	loadNodeIdentity()
+
+	switch transport := os.Getenv("P2P_TRANSPORT"); transport {
+	case "", "tcp":
+		startTCP()
+	case "libp2p":
+		if startLibp2p == nil {
+			log.Fatal("P2P_TRANSPORT=libp2p needs a node built with -tags libp2p")
+		}
+		if err := startLibp2p(); err != nil {
+			log.Fatal(err)
+		}
+	default:
+		log.Fatalf("unknown P2P_TRANSPORT %q", transport)
+	}
+
+	go func() {
+		for range time.Tick(statusInterval) {
+			v := localVersion()
+			syncProgress.sample(v.Height)
+			peerManager.broadcast(wire.CmdVersion, v)
+			peerManager.ping()
+			peerManager.expireAnnounced()
+		}
+	}()
+}
+
+// startTCP listens on P2P_PORT, keeps connections to the seed addresses in
+// PEERS and discovers further peers through them
+func startTCP() {
+	if port := os.Getenv("P2P_PORT"); port != "" {
+		var err error
+		if listenPort, err = strconv.Atoi(port); err != nil {
+			log.Fatalf("invalid P2P_PORT %q", port)
+		}
+		ln, err := net.Listen("tcp", ":"+port)
+		if err != nil {
+			log.Fatal(err)
+		}
+		p2pLog.Info("P2P listening", "port", port)
+		go func() {
+			for {
+				conn, err := ln.Accept()
+				if err != nil {
+					p2pLog.Warn("Can't accept peer", "err", err)
+					continue
+				}
+				if !p2pACL.AllowedAddr(conn.RemoteAddr().String()) {
+					p2pLog.Warn("Refusing peer not allowed by P2P access list", "peer", conn.RemoteAddr())
+					conn.Close()
+					continue
+				}
+				go peerManager.accept(conn)
+			}
+		}()
+	}
+
+	for _, addr := range strings.Split(os.Getenv("PEERS"), ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			go peerManager.keepConnected(addr)
+		}
+	}
+	peerManager.startDiscovery()
+}
+
+// accept secures an inbound connection and runs it
+func (pm *PeerManager) accept(conn net.Conn) {
+	addr := conn.RemoteAddr().String()
+	secured, id, err := secureConn(conn, true, "")
+	if err != nil {
+		p2pLog.Warn("Refusing peer", "peer", addr, "err", err)
+		conn.Close()
+		return
+	}
+	p := newPeer(secured, addr, true)
+	p.nodeID = id
+	pm.handle(p)
+}
+
+// keepConnected dials addr, optionally given as nodeid@host:port, and
+// redials whenever the connection drops
+func (pm *PeerManager) keepConnected(addr string) {
+	wantID, hostport := splitPeerAddr(addr)
+	for {
+		conn, err := net.DialTimeout("tcp", hostport, 5*time.Second)
+		if err == nil && !p2pACL.AllowedAddr(conn.RemoteAddr().String()) {
+			p2pLog.Warn("Not connecting to peer not allowed by P2P access list", "peer", hostport)
+			conn.Close()
+		} else if err != nil {
+			p2pLog.Warn("Can't connect to peer", "peer", hostport, "err", err)
+		} else if secured, id, err := secureConn(conn, false, wantID); err != nil {
+			p2pLog.Warn("Can't connect to peer", "peer", hostport, "err", err)
+			conn.Close()
+		} else {
+			p := newPeer(secured, hostport, false)
+			p.nodeID = id
+			pm.handle(p)
+		}
+		time.Sleep(reconnectInterval)
+	}
+}
+
+func newPeer(conn net.Conn, addr string, inbound bool) *Peer {
+	return &Peer{conn: conn, addr: addr, inbound: inbound, encoder: codec.NewEncoder(conn, params.Magic), connectedAt: time.Now()}
+}
+
+// handle runs a peer connection until it fails
+func (pm *PeerManager) handle(p *Peer) {
+	conn, addr := p.conn, p.addr
+	pm.Lock()
+	pm.peers[p] = true
+	pm.Unlock()
+	peerStats.connected(p)
+	p2pLog.Info("Peer connected", "peer", addr, "inbound", p.inbound)
+
+	defer func() {
+		peerStats.disconnected(p)
+		conn.Close()
+		pm.Lock()
+		delete(pm.peers, p)
+		pm.Unlock()
+		p2pLog.Info("Peer disconnected", "peer", addr)
+	}()
+
+	if err := p.send(wire.CmdVersion, localVersion()); err != nil {
+		return
+	}
+
+	decoder := codec.NewDecoder(conn, params.Magic, maxMessageSize)
+	for {
+		command, payload, err := decoder.Decode()
+		var magicErr *wire.MagicError
+		switch {
+		case err == nil:
+		case errors.Is(err, wire.ErrUnknownCommand), errors.Is(err, wire.ErrUnknownVersion):
+			p2pLog.Debug("Ignoring message", "peer", addr, "err", err)
+			continue
+		case errors.As(err, &magicErr):
+			p2pLog.Warn("Peer is on another network", "peer", addr, "magic", fmt.Sprintf("%08x", magicErr.Magic), "ours", fmt.Sprintf("%08x", params.Magic))
+			addrBook.Remove(addr)
+			return
+		case errors.Is(err, wire.ErrMalformed):
+			p2pLog.Warn("Peer error", "peer", addr, "err", err)
+			peerStats.recordInvalid(p)
+			return
+		default:
+			return
+		}
+		if err := p.handleMessage(command, payload); err != nil {
+			p2pLog.Warn("Peer error", "peer", addr, "err", err)
+			return
+		}
+	}
+}
+
+// send writes one message to the peer
+func (p *Peer) send(command string, payload interface{}) error {
+	p.sendLock.Lock()
+	defer p.sendLock.Unlock()
+	p.conn.SetWriteDeadline(time.Now().Add(30 * time.Second))
+	return p.encoder.Encode(command, payload)
+}
+
+// handleMessage acts on a decoded message; the codec guarantees the payload
+// is a pointer to the type registered for the command
+func (p *Peer) handleMessage(command string, payload interface{}) error {
+	switch command {
+	case wire.CmdVersion:
+		v := payload.(*VersionMessage)
+		if v.Nonce == nodeNonce {
+			addrBook.Remove(p.addr)
+			return errors.New("connected to ourselves")
+		}
+		if p.nodeID != "" && v.NodeID != p.nodeID {
+			return fmt.Errorf("peer claims node ID %s but authenticated as %s", v.NodeID, p.nodeID)
+		}
+		if v.Network != params.Name {
+			addrBook.Remove(p.addr)
+			return fmt.Errorf("peer is on network %q, we are on %q", v.Network, params.Name)
+		}
+		if local := localVersion().ChainParams; v.ChainParams != local {
+			addrBook.Remove(p.addr)
+			return fmt.Errorf("peer runs a different network: chain parameters %q, ours %q; check its genesis block, difficulty, subsidy, block limits and LOTTERIES", v.ChainParams, local)
+		}
+		p.Lock()
+		first := p.version == nil
+		p.version = v
+		p.Unlock()
+		if first && !p.pubsub {
+			if v.ListenPort > 0 {
+				host, _, _ := net.SplitHostPort(p.conn.RemoteAddr().String())
+				addrBook.Add(net.JoinHostPort(host, strconv.Itoa(v.ListenPort)), time.Now())
+			}
+			if err := p.send(wire.CmdGetAddr, GetAddrMessage{}); err != nil {
+				return err
+			}
+		}
+		// sync from the best peer that is ahead of us
+		if height := localHeight(); v.Height > height && peerManager.bestSyncPeer(height) == p {
+			return p.syncWith(v.Height)
+		}
+
+	case wire.CmdPing:
+		return p.send(wire.CmdPong, payload.(*PingMessage))
+
+	case wire.CmdPong:
+		p.receivePong(*payload.(*PingMessage))
+
+	case wire.CmdGetAddr:
+		return p.send(wire.CmdAddr, AddrMessage{addrBook.Recent(maxAddrsPerMessage)})
+
+	case wire.CmdAddr:
+		m := payload.(*AddrMessage)
+		if len(m.Addrs) > maxAddrsPerMessage {
+			return fmt.Errorf("sent %d addresses, more than %d", len(m.Addrs), maxAddrsPerMessage)
+		}
+		// relayed addresses are older news than a direct version message
+		seen := time.Now().Add(-time.Hour)
+		for _, addr := range m.Addrs {
+			addrBook.Add(addr, seen)
+		}
+
+	case wire.CmdGetBlocks:
+		blocks := bc.blocksAfter(payload.(*GetBlocksMessage).Locator, maxBlocksPerMessage)
+		if blocks == nil {
+			p2pLog.Warn("Peer shares no block with us; is it on another network?", "peer", p.addr)
+			return nil
+		}
+		return p.send(wire.CmdBlocks, BlocksMessage{blocks})
+
+	case wire.CmdGetHeaders:
+		headers := bc.headersAfter(payload.(*GetHeadersMessage).Locator, maxHeadersPerMessage)
+		if headers == nil {
+			p2pLog.Warn("Peer shares no block with us; is it on another network?", "peer", p.addr)
+			return nil
+		}
+		return p.send(wire.CmdHeaders, HeadersMessage{headers})
+
+	case wire.CmdHeaders:
+		return p.receiveHeaders(payload.(*HeadersMessage).Headers)
+
+	case wire.CmdInv:
+		return p.receiveInv(payload.(*wire.InvMessage))
+
+	case wire.CmdGetData:
+		return p.sendData(payload.(*wire.GetDataMessage))
+
+	case wire.CmdNotFound:
+		p.receiveNotFound(payload.(*wire.NotFoundMessage))
+
+	case wire.CmdBlocks:
+		return p.receiveBlocks(payload.(*BlocksMessage).Blocks)
+
+	case wire.CmdBlock:
+		b := payload.(*Block)
+		if light != nil {
+			return p.receiveAnnouncement(b.Header())
+		}
+		return p.receiveBlock(b)
+
+	case wire.CmdCmpctBlock:
+		m := payload.(*CompactBlockMessage)
+		if light != nil {
+			return p.receiveAnnouncement(m.Header)
+		}
+		return p.receiveCompactBlock(m)
+
+	case wire.CmdFilterLoad:
+		return p.loadFilter(payload.(*FilterLoadMessage))
+
+	case wire.CmdGetFiltered:
+		return p.sendFiltered(payload.(*GetFilteredMessage))
+
+	case wire.CmdFiltered:
+		if light == nil {
+			return nil
+		}
+		return p.receiveFiltered(payload.(*FilteredMessage))
+
+	case wire.CmdGetBlockTxn:
+		return p.sendBlockTxn(payload.(*GetBlockTxnMessage))
+
+	case wire.CmdBlockTxn:
+		return p.receiveBlockTxn(payload.(*BlockTxnMessage))
+
+	case wire.CmdTx:
+		if light != nil {
+			// light clients have no UTXO set to check transactions against
+			return nil
+		}
+		return p.receiveTransaction(payload.(*Transaction))
+	}
+	return nil
+}
+
+// receiveBlocks processes a batch of blocks and asks for the next bodies
+// along the best header chain
+func (p *Peer) receiveBlocks(blocks []*Block) error {
+	for i, check := range bc.verifyBlocks(blocks) {
+		b := blocks[i]
+		if check.err != nil {
+			peerStats.recordInvalid(p)
+			return check.err
+		}
+		if p.takeAnnounced(b.Hash) {
+			// announced blocks are new, relay them on
+			if err := p.acceptBlock(b, check.verified); err != nil {
+				return err
+			}
+			continue
+		}
+		switch err := bc.processBlock(b, check.verified); err {
+		case nil:
+			peerStats.recordBlock(p)
+		case errBlockKnown:
+		default:
+			p2pLog.Warn("Rejected block", "hash", b.Hash, "peer", p.addr, "err", err)
+			peerStats.recordInvalid(p)
+			return nil
+		}
+	}
+
+	if len(blocks) > 0 {
+		return p.requestBlocks()
+	}
+	return nil
+}
+
+// broadcast sends a message to every connected peer
+func (pm *PeerManager) broadcast(command string, payload interface{}) {
+	pm.broadcastExcept(nil, command, payload)
+}
+
+// broadcastExcept sends a message to every connected peer but one
+func (pm *PeerManager) broadcastExcept(except *Peer, command string, payload interface{}) {
+	pm.Lock()
+	defer pm.Unlock()
+	for p := range pm.peers {
+		if p != except {
+			go p.send(command, payload)
+		}
+	}
+}
+
+// closeAll disconnects every peer
+func (pm *PeerManager) closeAll() {
+	pm.Lock()
+	defer pm.Unlock()
+	for p := range pm.peers {
+		p.conn.Close()
+	}
+}
+
+// disconnectDisallowed drops peers the P2P access list no longer allows
+func (pm *PeerManager) disconnectDisallowed() {
+	pm.Lock()
+	defer pm.Unlock()
+	for p := range pm.peers {
+		if !p2pACL.AllowedAddr(p.conn.RemoteAddr().String()) {
+			p.conn.Close()
+		}
+	}
+}
+
+// Info lists the connected peers
+func (pm *PeerManager) Info() []PeerInfo {
+	pm.Lock()
+	defer pm.Unlock()
+
+	infos := []PeerInfo{}
+	for p := range pm.peers {
+		p.Lock()
+		info := PeerInfo{Addr: p.addr, NodeID: p.nodeID, Authenticated: p.nodeID != "", Inbound: p.inbound, Height: -1, ConnectedAt: p.connectedAt}
+		if p.version != nil {
+			info.Height = p.version.Height
+			if info.NodeID == "" {
+				info.NodeID = p.version.NodeID
+			}
+		}
+		p.Unlock()
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+func localVersion() VersionMessage {
+	bc.RLock()
+	height, tip, genesis := len(bc.blocks)-1, bc.blocks[len(bc.blocks)-1].Hash, bc.blocks[0].Hash
+	bc.RUnlock()
+	if light != nil {
+		height, tip = bc.headers.bestTip()
+	}
+	return VersionMessage{
+		protocolVersion, params.Name, height, tip,
+		listenPort, nodeNonce, params.Hash(genesis), nodeID, light != nil,
+	}
+}
+
+// localHeight is the height of our active chain, or of the header chain for
+// a light client
+func localHeight() int {
+	if light != nil {
+		return bc.headers.bestHeight()
+	}
+	return bc.Height()
+}
+
+func randomNonce() uint64 {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		log.Fatal(err)
+	}
+	return binary.BigEndian.Uint64(b[:])
+}
+
+// lists connected peers
+func handleGetPeers(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, r, http.StatusOK, peerManager.Info())
+}
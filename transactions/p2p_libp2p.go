@@ -0,0 +1,287 @@
+//go:build libp2p
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/VOOVOOZEL/go_blockchain/transactions/wire"
+	"github.com/libp2p/go-libp2p"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pubsubpb "github.com/libp2p/go-libp2p-pubsub/pb"
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+const (
+	// syncProtocol carries the same line-delimited messages as the TCP
+	// transport, used for handshakes and catching up
+	syncProtocol = "/go_blockchain/sync/1"
+
+	// the pubsub topics are per network, see topicName, and carry bare
+	// block and tx payloads of version topicVersion
+	blocksTopic  = "blocks/1"
+	txsTopic     = "txs/1"
+	topicVersion = 1
+
+	defaultLibp2pListen = "/ip4/0.0.0.0/tcp/4001"
+	libp2pKeyFile       = "libp2p.key"
+)
+
+// libp2pNode is the running libp2p host and its pubsub topics
+type libp2pNode struct {
+	host   host.Host
+	topics map[string]*pubsub.Topic
+
+	sync.Mutex
+	peers map[peer.ID]*Peer
+}
+
+func init() {
+	startLibp2p = runLibp2p
+}
+
+// runLibp2p starts a libp2p host listening on LIBP2P_LISTEN, joins the block
+// and transaction topics and keeps connections to the multiaddrs in
+// LIBP2P_PEERS
+func runLibp2p() error {
+	key, err := loadLibp2pKey(filepath.Join(dataDir(), libp2pKeyFile))
+	if err != nil {
+		return err
+	}
+
+	listen := splitList(os.Getenv("LIBP2P_LISTEN"))
+	if len(listen) == 0 {
+		listen = []string{defaultLibp2pListen}
+	}
+	h, err := libp2p.New(
+		libp2p.Identity(key),
+		libp2p.ListenAddrStrings(listen...),
+		libp2p.NATPortMap(),
+		libp2p.EnableHolePunching(),
+	)
+	if err != nil {
+		return err
+	}
+
+	// identical payloads are the same message, so a block relayed by several
+	// nodes propagates once
+	ps, err := pubsub.NewGossipSub(context.Background(), h, pubsub.WithMessageIdFn(func(m *pubsubpb.Message) string {
+		sum := sha256.Sum256(m.Data)
+		return hex.EncodeToString(sum[:])
+	}))
+	if err != nil {
+		return err
+	}
+
+	n := &libp2pNode{host: h, topics: make(map[string]*pubsub.Topic), peers: make(map[peer.ID]*Peer)}
+	for command, name := range map[string]string{wire.CmdBlock: blocksTopic, wire.CmdTx: txsTopic} {
+		topic, err := ps.Join(topicName(name))
+		if err != nil {
+			return err
+		}
+		sub, err := topic.Subscribe()
+		if err != nil {
+			return err
+		}
+		n.topics[command] = topic
+		go n.readTopic(command, sub)
+	}
+	publish = n.publish
+
+	h.SetStreamHandler(syncProtocol, func(s network.Stream) {
+		n.run(s, true)
+	})
+	// the dialing side opens the sync stream
+	h.Network().Notify(&network.NotifyBundle{
+		ConnectedF: func(_ network.Network, c network.Conn) {
+			if c.Stat().Direction == network.DirOutbound {
+				go n.openSync(c.RemotePeer())
+			}
+		},
+	})
+
+	for _, addr := range h.Addrs() {
+		p2pLog.Info("libp2p listening", "addr", fmt.Sprintf("%s/p2p/%s", addr, h.ID()))
+	}
+	for _, addr := range splitList(os.Getenv("LIBP2P_PEERS")) {
+		info, err := peer.AddrInfoFromString(addr)
+		if err != nil {
+			return err
+		}
+		go n.keepConnected(*info)
+	}
+	return nil
+}
+
+// loadLibp2pKey reads the node's identity key, creating it on first start so
+// the peer ID stays the same across restarts
+func loadLibp2pKey(path string) (crypto.PrivKey, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		return crypto.UnmarshalPrivateKey(data)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	key, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	data, err = crypto.MarshalPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	return key, os.WriteFile(path, data, 0600)
+}
+
+// keepConnected dials a bootstrap peer and redials whenever it drops
+func (n *libp2pNode) keepConnected(info peer.AddrInfo) {
+	for {
+		if n.host.Network().Connectedness(info.ID) != network.Connected {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			if err := n.host.Connect(ctx, info); err != nil {
+				p2pLog.Warn("Can't connect to peer", "peer", info.ID, "err", err)
+			}
+			cancel()
+		}
+		time.Sleep(reconnectInterval)
+	}
+}
+
+func (n *libp2pNode) openSync(id peer.ID) {
+	s, err := n.host.NewStream(context.Background(), id, syncProtocol)
+	if err != nil {
+		p2pLog.Warn("Can't open sync stream", "peer", id, "err", err)
+		return
+	}
+	n.run(s, false)
+}
+
+// run handles a sync stream as a peer connection until it fails
+func (n *libp2pNode) run(s network.Stream, inbound bool) {
+	id := s.Conn().RemotePeer()
+	conn := &streamConn{s}
+	// relayed connections have no IP address to check, so a configured
+	// access list refuses them rather than let them route around it
+	allowed := !p2pACL.configured()
+	if _, relayed := conn.RemoteAddr().(libp2pAddr); !relayed {
+		allowed = p2pACL.AllowedAddr(conn.RemoteAddr().String())
+	}
+	if !allowed {
+		p2pLog.Warn("Refusing peer not allowed by P2P access list", "peer", id)
+		s.Reset()
+		return
+	}
+
+	p := newPeer(conn, id.String(), inbound)
+	p.pubsub = true
+	n.Lock()
+	n.peers[id] = p
+	n.Unlock()
+
+	peerManager.handle(p)
+
+	n.Lock()
+	if n.peers[id] == p {
+		delete(n.peers, id)
+	}
+	n.Unlock()
+}
+
+// topicName scopes a pubsub topic to our network, so testnet gossip never
+// reaches mainnet nodes sharing the same libp2p swarm
+func topicName(name string) string {
+	return "go_blockchain/" + params.Name + "/" + name
+}
+
+// readTopic processes announcements arriving on a pubsub topic
+func (n *libp2pNode) readTopic(command string, sub *pubsub.Subscription) {
+	for {
+		msg, err := sub.Next(context.Background())
+		if err != nil {
+			p2pLog.Warn("Stopped reading topic", "topic", command, "err", err)
+			return
+		}
+		if msg.ReceivedFrom == n.host.ID() {
+			continue
+		}
+
+		n.Lock()
+		p := n.peers[msg.ReceivedFrom]
+		n.Unlock()
+		if p == nil {
+			// no sync stream yet; the version exchange will catch us up
+			continue
+		}
+
+		payload, err := codec.Unmarshal(command, topicVersion, msg.Data)
+		if err == nil {
+			if b, ok := payload.(*Block); ok {
+				err = p.receiveBlock(b)
+			} else {
+				err = p.receiveTransaction(payload.(*Transaction))
+			}
+		}
+		if err != nil {
+			p2pLog.Warn("Peer error", "peer", p.addr, "err", err)
+		}
+	}
+}
+
+func (n *libp2pNode) publish(command string, payload interface{}) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		p2pLog.Error("Can't encode message", "topic", command, "err", err)
+		return
+	}
+	if err := n.topics[command].Publish(context.Background(), data); err != nil {
+		p2pLog.Warn("Can't publish", "topic", command, "err", err)
+	}
+}
+
+// streamConn lets the peer code run over a libp2p stream
+type streamConn struct {
+	network.Stream
+}
+
+func (c *streamConn) LocalAddr() net.Addr {
+	return netAddr(c.Conn().LocalMultiaddr())
+}
+
+func (c *streamConn) RemoteAddr() net.Addr {
+	return netAddr(c.Conn().RemoteMultiaddr())
+}
+
+func netAddr(ma multiaddr.Multiaddr) net.Addr {
+	addr, err := manet.ToNetAddr(ma)
+	if err != nil {
+		return libp2pAddr(ma.String())
+	}
+	return addr
+}
+
+// libp2pAddr is a multiaddr with no IP equivalent, e.g. a relayed one
+type libp2pAddr string
+
+func (a libp2pAddr) Network() string { return "libp2p" }
+func (a libp2pAddr) String() string  { return string(a) }
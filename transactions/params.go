@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Checkpoint pins the hash of the block at a given height
+type Checkpoint struct {
+	Height int
+	Hash   string
+}
+
+// ChainParams defines the consensus rules of a network
+type ChainParams struct {
+	Name string
+	// Magic starts every P2P message, so nodes of different networks drop
+	// each other's traffic instead of mixing chains
+	Magic uint32
+	// Checkpoints are trusted blocks, sorted by height. A block at a
+	// checkpointed height must match its hash, and once the active chain has
+	// passed a checkpoint no block at or below it is accepted as a fork.
+	Checkpoints []Checkpoint
+	// Lotteries are the on-chain lotteries this network runs
+	Lotteries []Lottery
+	// Genesis defines the network's genesis block
+	Genesis GenesisParams
+	// MaxBlockSize caps the encoded size of a block in bytes
+	MaxBlockSize int
+	// MaxBlockTxs caps the transactions of a block, coinbase included
+	MaxBlockTxs int
+	// UnsignedNames lets an unsigned input spend from a name address. Anyone
+	// relaying such a spend can rewrite its outputs, so main leaves it off
+	// and every input there needs a signature, lottery pots aside.
+	UnsignedNames bool
+}
+
+// GenesisParams define the genesis block of a network. The block is built
+// from them alone, so every node of the network starts from the same one.
+type GenesisParams struct {
+	// Timestamp is the block's timestamp, in the format generateBlock
+	// writes
+	Timestamp string
+	// Address is paid the genesis coinbase
+	Address string
+	// Message is the coinbase data
+	Message string
+	// Difficulty is the network's difficulty unless DIFFICULTY overrides it
+	Difficulty int
+}
+
+// networkGenesis are the genesis blocks of the well-known networks. Other
+// networks start from main's unless GENESIS_TIMESTAMP, GENESIS_ADDRESS or
+// GENESIS_MESSAGE change it.
+var networkGenesis = map[string]GenesisParams{
+	"main": {
+		Timestamp:  time.Date(2009, 1, 3, 18, 15, 5, 0, time.UTC).String(),
+		Address:    "Ivan",
+		Message:    "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks",
+		Difficulty: 1,
+	},
+	"testnet": {
+		Timestamp:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).String(),
+		Address:    "Ivan",
+		Message:    "go_blockchain testnet",
+		Difficulty: 1,
+	},
+	"regtest": {
+		Timestamp:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC).String(),
+		Address:    "Ivan",
+		Message:    "go_blockchain regtest",
+		Difficulty: 1,
+	},
+}
+
+// genesisParams returns the genesis of network. Networks other than main
+// may change it with GENESIS_TIMESTAMP, an RFC 3339 time, GENESIS_ADDRESS
+// and GENESIS_MESSAGE.
+func genesisParams(network string) GenesisParams {
+	genesis, ok := networkGenesis[network]
+	if !ok {
+		genesis = networkGenesis["main"]
+	}
+	if network == "main" {
+		return genesis
+	}
+	if v := os.Getenv("GENESIS_TIMESTAMP"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			log.Fatalf("GENESIS_TIMESTAMP must be an RFC 3339 time such as 2024-01-01T00:00:00Z, got %q", v)
+		}
+		genesis.Timestamp = t.UTC().String()
+	}
+	if v := os.Getenv("GENESIS_ADDRESS"); v != "" {
+		genesis.Address = v
+	}
+	if v := os.Getenv("GENESIS_MESSAGE"); v != "" {
+		genesis.Message = v
+	}
+	return genesis
+}
+
+// networkMagics are the magic numbers of the well-known networks. Other
+// networks need NETWORK_MAGIC.
+var networkMagics = map[string]uint32{
+	"main":    0xb10cc4a1,
+	"testnet": 0x7e57b10c,
+	"regtest": 0xfabf0e0d,
+}
+
+// default block limits, overridden by MAX_BLOCK_SIZE and MAX_BLOCK_TXS
+const (
+	defaultMaxBlockSize = 1 << 20
+	defaultMaxBlockTxs  = 4096
+)
+
+var params = ChainParams{
+	Name:         "main",
+	Magic:        networkMagics["main"],
+	Genesis:      networkGenesis["main"],
+	MaxBlockSize: defaultMaxBlockSize,
+	MaxBlockTxs:  defaultMaxBlockTxs,
+}
+
+// consensusParams are the rules two nodes must share to follow the same chain
+type consensusParams struct {
+	Network       string
+	Magic         uint32
+	Genesis       string
+	Algorithm     string
+	Difficulty    int
+	Subsidy       int
+	Lotteries     []Lottery
+	MaxBlockSize  int
+	MaxBlockTxs   int
+	UnsignedNames bool
+}
+
+// Hash fingerprints the consensus-critical parameters of a network with the
+// given genesis block. Peers exchange it on connect so that nodes configured
+// for different networks refuse each other. Checkpoints are left out: they
+// only pin blocks both networks would agree on anyway.
+func (p *ChainParams) Hash(genesis string) string {
+	encoded, _ := json.Marshal(consensusParams{p.Name, p.Magic, genesis, "sha256-merkle", difficulty, subsidy, p.Lotteries, p.MaxBlockSize, p.MaxBlockTxs, p.UnsignedNames})
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:])
+}
+
+// maxDifficulty keeps the target reachable
+const maxDifficulty = 16
+
+// loadChainParams reads NETWORK and NETWORK_MAGIC, the GENESIS_ settings,
+// DIFFICULTY, MAX_BLOCK_SIZE, MAX_BLOCK_TXS, CHECKPOINTS, a comma separated
+// list of height:hash, and LOTTERIES
+func loadChainParams() {
+	if name := os.Getenv("NETWORK"); name != "" {
+		params.Name = name
+		params.Magic = networkMagics[name]
+	}
+	if v := os.Getenv("NETWORK_MAGIC"); v != "" {
+		magic, err := strconv.ParseUint(strings.TrimPrefix(v, "0x"), 16, 32)
+		if err != nil {
+			log.Fatalf("NETWORK_MAGIC must be a 32 bit hex number, got %q", v)
+		}
+		params.Magic = uint32(magic)
+	}
+	if params.Magic == 0 {
+		log.Fatalf("network %q is not a known network, set NETWORK_MAGIC", params.Name)
+	}
+	params.Genesis = genesisParams(params.Name)
+	params.UnsignedNames = params.Name != "main"
+	difficulty = params.Genesis.Difficulty
+	if v := os.Getenv("DIFFICULTY"); v != "" {
+		d, err := strconv.Atoi(v)
+		if err != nil || d < 1 || d > maxDifficulty {
+			log.Fatalf("DIFFICULTY must be between 1 and %d, got %q", maxDifficulty, v)
+		}
+		difficulty = d
+	}
+	if v := os.Getenv("MAX_BLOCK_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		// a block must fit in a P2P message
+		if err != nil || n < minBlockSize || n > maxMessageSize {
+			log.Fatalf("MAX_BLOCK_SIZE must be between %d and %d bytes, got %q", minBlockSize, maxMessageSize, v)
+		}
+		params.MaxBlockSize = n
+	}
+	if v := os.Getenv("MAX_BLOCK_TXS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			log.Fatalf("MAX_BLOCK_TXS must be a positive integer, got %q", v)
+		}
+		params.MaxBlockTxs = n
+	}
+	params.Lotteries = parseLotteries()
+
+	v := os.Getenv("CHECKPOINTS")
+	if v == "" {
+		return
+	}
+
+	for _, entry := range strings.Split(v, ",") {
+		height, hash, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		h, err := strconv.Atoi(height)
+		if !ok || err != nil || h < 0 || len(hash) != 64 {
+			log.Fatalf("invalid checkpoint %q, want height:hash", entry)
+		}
+		params.Checkpoints = append(params.Checkpoints, Checkpoint{h, hash})
+	}
+	sort.Slice(params.Checkpoints, func(i, j int) bool {
+		return params.Checkpoints[i].Height < params.Checkpoints[j].Height
+	})
+}
+
+// checkpoint returns the trusted hash for a height, if there is one
+func (p *ChainParams) checkpoint(height int) (string, bool) {
+	for _, cp := range p.Checkpoints {
+		if cp.Height == height {
+			return cp.Hash, true
+		}
+	}
+	return "", false
+}
+
+// lastCheckpoint returns the highest checkpoint at or below height, or -1
+func (p *ChainParams) lastCheckpoint(height int) int {
+	last := -1
+	for _, cp := range p.Checkpoints {
+		if cp.Height <= height {
+			last = cp.Height
+		}
+	}
+	return last
+}
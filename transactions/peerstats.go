@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/VOOVOOZEL/go_blockchain/transactions/wire"
+)
+
+const (
+	peerStatsFile         = "peerstats.json"
+	peerStatsSaveInterval = time.Minute
+	// peerStatsTTL is how long the stats of a disconnected peer are kept
+	peerStatsTTL = 30 * 24 * time.Hour
+	// maxPeerStats bounds the peers we keep stats of; the disconnected peer
+	// seen longest ago goes first
+	maxPeerStats = 5000
+)
+
+// PingMessage measures the round trip to a peer, which answers with a pong
+// carrying the same nonce
+type PingMessage struct {
+	Nonce uint64
+}
+
+// PeerStats is what we know about the quality of a peer, kept across
+// connections and restarts
+type PeerStats struct {
+	Key             string
+	LatencyMs       float64
+	BlocksServed    int
+	InvalidMessages int
+	Uptime          time.Duration
+	Connections     int
+	LastSeen        time.Time
+
+	connectedSince time.Time
+}
+
+// PeerStatsReport is the JSON view of a peer's stats
+type PeerStatsReport struct {
+	PeerStats
+	Connected bool
+	Score     float64
+}
+
+// PeerStatsBook holds the stats of every peer we have been connected to,
+// persisted in DATA_DIR
+type PeerStatsBook struct {
+	sync.Mutex
+	path  string
+	stats map[string]*PeerStats
+}
+
+var peerStats = &PeerStatsBook{stats: make(map[string]*PeerStats)}
+
+// statsKey identifies a peer across connections: the address we dial for
+// outbound peers, the IP address for inbound ones
+func (p *Peer) statsKey() string {
+	if p.inbound && !p.pubsub {
+		if host, _, err := net.SplitHostPort(p.addr); err == nil {
+			return host
+		}
+	}
+	return p.addr
+}
+
+// load reads the persisted stats and saves them periodically from then on
+func (sb *PeerStatsBook) load(path string) {
+	sb.Lock()
+	sb.path = path
+	data, err := os.ReadFile(path)
+	if err == nil {
+		var stats []*PeerStats
+		if err = json.Unmarshal(data, &stats); err == nil {
+			for _, s := range stats {
+				sb.stats[s.Key] = s
+			}
+			sb.prune(time.Now())
+		}
+	}
+	sb.Unlock()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		p2pLog.Warn("Can't load peer stats", "err", err)
+	}
+
+	go func() {
+		for range time.Tick(peerStatsSaveInterval) {
+			sb.save()
+		}
+	}()
+}
+
+func (sb *PeerStatsBook) save() {
+	sb.Lock()
+	defer sb.Unlock()
+
+	stats := make([]*PeerStats, 0, len(sb.stats))
+	for _, s := range sb.stats {
+		stats = append(stats, s)
+	}
+	data, err := json.MarshalIndent(stats, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(sb.path, data)
+	}
+	if err != nil {
+		p2pLog.Warn("Can't save peer stats", "err", err)
+	}
+}
+
+// update applies f to the stats of p
+func (sb *PeerStatsBook) update(p *Peer, f func(s *PeerStats)) {
+	sb.Lock()
+	defer sb.Unlock()
+
+	key := p.statsKey()
+	s, ok := sb.stats[key]
+	if !ok {
+		sb.prune(time.Now())
+		s = &PeerStats{Key: key}
+		sb.stats[key] = s
+	}
+	f(s)
+	s.LastSeen = time.Now()
+}
+
+// prune drops the stats of peers disconnected for longer than peerStatsTTL,
+// and those of the disconnected peers seen longest ago beyond maxPeerStats
+func (sb *PeerStatsBook) prune(now time.Time) {
+	var idle []*PeerStats
+	for key, s := range sb.stats {
+		if !s.connectedSince.IsZero() {
+			continue
+		}
+		if now.Sub(s.LastSeen) >= peerStatsTTL {
+			delete(sb.stats, key)
+		} else {
+			idle = append(idle, s)
+		}
+	}
+	if excess := len(sb.stats) - maxPeerStats + 1; excess > 0 {
+		sort.Slice(idle, func(i, j int) bool { return idle[i].LastSeen.Before(idle[j].LastSeen) })
+		for _, s := range idle[:min(excess, len(idle))] {
+			delete(sb.stats, s.Key)
+		}
+	}
+}
+
+func (sb *PeerStatsBook) connected(p *Peer) {
+	sb.update(p, func(s *PeerStats) {
+		s.Connections++
+		s.connectedSince = time.Now()
+	})
+}
+
+func (sb *PeerStatsBook) disconnected(p *Peer) {
+	sb.update(p, func(s *PeerStats) {
+		if !s.connectedSince.IsZero() {
+			s.Uptime += time.Since(s.connectedSince)
+			s.connectedSince = time.Time{}
+		}
+	})
+}
+
+// recordLatency folds a ping round trip into the peer's average latency
+func (sb *PeerStatsBook) recordLatency(p *Peer, rtt time.Duration) {
+	ms := float64(rtt) / float64(time.Millisecond)
+	sb.update(p, func(s *PeerStats) {
+		if s.LatencyMs == 0 {
+			s.LatencyMs = ms
+		} else {
+			s.LatencyMs = 0.8*s.LatencyMs + 0.2*ms
+		}
+	})
+}
+
+// recordBlock counts a new block the peer sent us
+func (sb *PeerStatsBook) recordBlock(p *Peer) {
+	sb.update(p, func(s *PeerStats) { s.BlocksServed++ })
+}
+
+// recordInvalid counts a malformed message or invalid block, header or
+// transaction the peer sent us
+func (sb *PeerStatsBook) recordInvalid(p *Peer) {
+	sb.update(p, func(s *PeerStats) { s.InvalidMessages++ })
+}
+
+// score rates a peer: every block served and every hour connected counts
+// for it, every invalid message heavily against it, and so does latency
+func (s *PeerStats) score() float64 {
+	uptime := s.Uptime
+	if !s.connectedSince.IsZero() {
+		uptime += time.Since(s.connectedSince)
+	}
+	return float64(s.BlocksServed) + uptime.Hours() - 10*float64(s.InvalidMessages) - s.LatencyMs/100
+}
+
+// Score returns the score of the peer with the given stats key, 0 if unknown
+func (sb *PeerStatsBook) Score(key string) float64 {
+	sb.Lock()
+	defer sb.Unlock()
+	if s, ok := sb.stats[key]; ok {
+		return s.score()
+	}
+	return 0
+}
+
+// Report lists the stats of every peer, best score first
+func (sb *PeerStatsBook) Report() []PeerStatsReport {
+	sb.Lock()
+	defer sb.Unlock()
+
+	reports := []PeerStatsReport{}
+	for _, s := range sb.stats {
+		r := PeerStatsReport{*s, !s.connectedSince.IsZero(), s.score()}
+		if r.Connected {
+			r.Uptime += time.Since(s.connectedSince)
+		}
+		reports = append(reports, r)
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Score > reports[j].Score })
+	return reports
+}
+
+// ping sends a ping to every peer to measure latency
+func (pm *PeerManager) ping() {
+	pm.Lock()
+	defer pm.Unlock()
+	for p := range pm.peers {
+		p.Lock()
+		p.pingNonce, p.pingSent = randomNonce(), time.Now()
+		nonce := p.pingNonce
+		p.Unlock()
+		go p.send(wire.CmdPing, PingMessage{nonce})
+	}
+}
+
+// receivePong records the latency of an answered ping
+func (p *Peer) receivePong(m PingMessage) {
+	p.Lock()
+	if m.Nonce != p.pingNonce || p.pingSent.IsZero() {
+		p.Unlock()
+		return
+	}
+	rtt := time.Since(p.pingSent)
+	p.pingSent = time.Time{}
+	p.Unlock()
+
+	peerStats.recordLatency(p, rtt)
+}
+
+// bestSyncPeer returns the best scoring peer that claims a chain higher
+// than height, or nil
+func (pm *PeerManager) bestSyncPeer(height int) *Peer {
+	pm.Lock()
+	defer pm.Unlock()
+
+	var best *Peer
+	bestScore := 0.0
+	for p := range pm.peers {
+		p.Lock()
+		ahead := p.version != nil && !p.version.Light && p.version.Height > height
+		p.Unlock()
+		if !ahead {
+			continue
+		}
+		if score := peerStats.Score(p.statsKey()); best == nil || score > bestScore {
+			best, bestScore = p, score
+		}
+	}
+	return best
+}
+
+// reports the quality stats of every peer we have been connected to
+func handleGetPeerStats(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, r, http.StatusOK, peerStats.Report())
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestPeerStatsPrune(t *testing.T) {
+	now := time.Now()
+	sb := &PeerStatsBook{stats: make(map[string]*PeerStats)}
+	add := func(key string, lastSeen time.Time, connected bool) {
+		s := &PeerStats{Key: key, LastSeen: lastSeen}
+		if connected {
+			s.connectedSince = lastSeen
+		}
+		sb.stats[key] = s
+	}
+
+	add("expired", now.Add(-peerStatsTTL), false)
+	add("connected long ago", now.Add(-2*peerStatsTTL), true)
+	for i := 0; i < maxPeerStats; i++ {
+		add(fmt.Sprint("peer", i), now.Add(time.Duration(i-maxPeerStats)*time.Second), false)
+	}
+	sb.prune(now)
+
+	if len(sb.stats) != maxPeerStats-1 {
+		t.Fatalf("%d stats kept, want %d", len(sb.stats), maxPeerStats-1)
+	}
+	for _, key := range []string{"expired", "peer0", "peer1"} {
+		if _, ok := sb.stats[key]; ok {
+			t.Errorf("%s kept", key)
+		}
+	}
+	for _, key := range []string{"connected long ago", "peer2", fmt.Sprint("peer", maxPeerStats-1)} {
+		if _, ok := sb.stats[key]; !ok {
+			t.Errorf("%s dropped", key)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultReadLimit and defaultWriteLimit are requests per minute per
+	// client. Writes are few since each POST / mines a block.
+	defaultReadLimit  = 600
+	defaultWriteLimit = 30
+
+	// maxRateBuckets is how many clients are tracked before idle ones are
+	// dropped
+	maxRateBuckets = 10000
+)
+
+// RateLimiter hands out requests to each client from a token bucket that
+// holds a minute's worth of requests and refills continuously
+type RateLimiter struct {
+	sync.Mutex
+	perMinute float64
+	buckets   map[string]*rateBucket
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// readLimiter counts GET and HEAD requests, writeLimiter all others. A nil
+// limiter lets everything through.
+var readLimiter, writeLimiter *RateLimiter
+
+func NewRateLimiter(perMinute int) *RateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &RateLimiter{perMinute: float64(perMinute), buckets: make(map[string]*rateBucket)}
+}
+
+// loadRateLimits reads RATE_LIMIT_READS and RATE_LIMIT_WRITES, requests per
+// minute per client; 0 turns the limit off
+func loadRateLimits() {
+	readLimiter = NewRateLimiter(envLimit("RATE_LIMIT_READS", defaultReadLimit))
+	writeLimiter = NewRateLimiter(envLimit("RATE_LIMIT_WRITES", defaultWriteLimit))
+}
+
+func envLimit(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		log.Fatalf("%s must be a number of requests per minute, got %q", name, v)
+	}
+	return n
+}
+
+// Allow takes a request from client's bucket. When it's empty, it returns
+// how long until the next request is allowed.
+func (l *RateLimiter) Allow(client string, now time.Time) (bool, time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+
+	b, ok := l.buckets[client]
+	if !ok {
+		if len(l.buckets) >= maxRateBuckets {
+			l.dropIdle(now)
+		}
+		b = &rateBucket{tokens: l.perMinute, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.perMinute, b.tokens+now.Sub(b.last).Minutes()*l.perMinute)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.perMinute * float64(time.Minute))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// dropIdle forgets the clients whose bucket has filled up again, which is
+// the same as never having seen them
+func (l *RateLimiter) dropIdle(now time.Time) {
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Minutes()*l.perMinute >= l.perMinute {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// rateLimitClient names the bucket of a request: its API key or JWT when
+// valid, so clients behind one address don't share a limit, else its IP.
+// Invalid credentials count against the IP, or random keys would escape it.
+func rateLimitClient(r *http.Request) string {
+	if token := requestToken(r); token != "" && apiAuth.enabled() && apiAuth.authorized(r) {
+		sum := sha256.Sum256([]byte(token))
+		return "key:" + hex.EncodeToString(sum[:])
+	}
+	return "ip:" + clientHost(r)
+}
+
+// rateLimitMiddleware answers 429 to clients over their limit
+func rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limiter := writeLimiter
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			limiter = readLimiter
+		}
+		if limiter != nil {
+			if ok, wait := limiter.Allow(rateLimitClient(r), time.Now()); !ok {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				respondWithError(w, r, http.StatusTooManyRequests, "Too many requests, retry later")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
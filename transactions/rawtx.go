@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// accepts a transaction built and signed by a client, e.g. with sdk.TxBuilder
+func handleSendRawTransaction(w http.ResponseWriter, r *http.Request) {
+	var tx Transaction
+
+	if err := decodeRequest(r, &tx); err != nil {
+		respondWithRequestError(w, r, err)
+		return
+	}
+
+	if err := assignTransactionID(&tx); err != nil {
+		respondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	_, span := tracer.Start(r.Context(), "validate transaction", trace.WithAttributes(txAttribute(&tx)))
+	err := bc.checkTransaction(&tx)
+	endSpan(span, err)
+	if err != nil {
+		respondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	mineTransaction(w, r, &tx)
+}
+
+// checkTransaction verifies a standalone transaction against the UTXO set of
+// the active chain
+func (bc *Blockchain) checkTransaction(tx *Transaction) error {
+	bc.RLock()
+	defer bc.RUnlock()
+
+	if tx.IsCoinbase() {
+		return errors.New("ERROR: Coinbase transactions can't be relayed")
+	}
+	return bc.utxo.view().checkTransaction(tx)
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// maxRequestBody limits the size of request bodies, see loadHTTPLimits
+var maxRequestBody = 1 << 20
+
+// FieldError describes what is wrong with one field of a request
+type FieldError struct {
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// ErrorResponse is the body of every API error. Code is the HTTP status in
+// snake case, e.g. not_found; Details lists the fields at fault in a bad
+// request.
+type ErrorResponse struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Details []FieldError `json:"details,omitempty"`
+}
+
+// ValidationError collects every problem found in a request body
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	var msgs []string
+	for _, fe := range e.Errors {
+		if fe.Field == "" {
+			msgs = append(msgs, fe.Message)
+		} else {
+			msgs = append(msgs, fe.Field+": "+fe.Message)
+		}
+	}
+	return strings.Join(msgs, "; ")
+}
+
+func invalidRequest(field, format string, a ...interface{}) *ValidationError {
+	return &ValidationError{[]FieldError{{field, fmt.Sprintf(format, a...)}}}
+}
+
+// decodeRequest strictly decodes a JSON object body into v, which must be a
+// pointer to a struct, and validates it. Unknown fields are rejected, type
+// mismatches are reported per field, and struct fields are checked against
+// their `validate` tags:
+//
+//	required  the field must be present, non-null and, for strings, non-empty
+//	min=N     numbers must be at least N
+//	max=N     numbers must be at most N
+//	address   strings, if not empty, must pass checkAddress
+func decodeRequest(r *http.Request, v interface{}) error {
+	defer r.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, int64(maxRequestBody)+1))
+	if err != nil {
+		return invalidRequest("", "can't read request body: %v", err)
+	}
+	if len(body) > maxRequestBody {
+		return invalidRequest("", "request body exceeds %d bytes", maxRequestBody)
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return invalidRequest("", "request body is empty")
+	}
+
+	var present map[string]json.RawMessage
+	if err := json.Unmarshal(body, &present); err != nil {
+		return decodeError(err)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return decodeError(err)
+	}
+	if decoder.More() {
+		return invalidRequest("", "unexpected data after the JSON object")
+	}
+
+	return validateFields(reflect.ValueOf(v).Elem(), present)
+}
+
+// decodeError turns encoding/json errors into field errors
+func decodeError(err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+
+	switch {
+	case errors.As(err, &syntaxErr):
+		return invalidRequest("", "malformed JSON at offset %d", syntaxErr.Offset)
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return invalidRequest("", "request body must be a JSON object")
+		}
+		return invalidRequest(typeErr.Field, "must be %s, got %s", jsonTypeName(typeErr.Type), typeErr.Value)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		field, _ := strconv.Unquote(strings.TrimPrefix(err.Error(), "json: unknown field "))
+		return invalidRequest(field, "unknown field")
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return invalidRequest("", "request body is truncated")
+	}
+	return invalidRequest("", "%v", err)
+}
+
+func jsonTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "an integer"
+	case reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	}
+	return "an object"
+}
+
+// validateFields checks struct fields against their `validate` tags
+func validateFields(v reflect.Value, present map[string]json.RawMessage) error {
+	var errs []FieldError
+
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("validate")
+		if tag == "" {
+			continue
+		}
+
+		value := v.Field(i)
+		for _, rule := range strings.Split(tag, ",") {
+			name, arg, _ := strings.Cut(rule, "=")
+			if msg := checkRule(name, arg, field.Name, value, present); msg != "" {
+				errs = append(errs, FieldError{field.Name, msg})
+				break
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return &ValidationError{errs}
+	}
+	return nil
+}
+
+func checkRule(rule, arg, name string, value reflect.Value, present map[string]json.RawMessage) string {
+	switch rule {
+	case "required":
+		raw, ok := lookupField(present, name)
+		if !ok || string(raw) == "null" {
+			return "is required"
+		}
+		if value.Kind() == reflect.String && value.Len() == 0 {
+			return "must not be empty"
+		}
+	case "min", "max":
+		limit, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil || !value.CanInt() {
+			panic("bad validate rule " + rule + "=" + arg + " on " + name)
+		}
+		if rule == "min" && value.Int() < limit {
+			return fmt.Sprintf("must be at least %d", limit)
+		}
+		if rule == "max" && value.Int() > limit {
+			return fmt.Sprintf("must be at most %d", limit)
+		}
+	case "address":
+		if value.Kind() != reflect.String {
+			panic("bad validate rule address on " + name)
+		}
+		if value.Len() > 0 {
+			if err := checkAddress(value.String()); err != nil {
+				return errorMessage(err.Error())
+			}
+		}
+	default:
+		panic("unknown validate rule " + rule + " on " + name)
+	}
+	return ""
+}
+
+// lookupField finds a key the way encoding/json matches it to a field:
+// exactly, or else case-insensitively
+func lookupField(present map[string]json.RawMessage, name string) (json.RawMessage, bool) {
+	if raw, ok := present[name]; ok {
+		return raw, true
+	}
+	for key, raw := range present {
+		if strings.EqualFold(key, name) {
+			return raw, true
+		}
+	}
+	return nil, false
+}
+
+// respondWithRequestError writes a 400 listing what is wrong with the request
+func respondWithRequestError(w http.ResponseWriter, r *http.Request, err error) {
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		respondWithError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	message := "invalid request"
+	if len(validationErr.Errors) == 1 {
+		message = validationErr.Error()
+	}
+	respondWithJSON(w, r, http.StatusBadRequest, ErrorResponse{
+		Code:    errorCode(http.StatusBadRequest),
+		Message: message,
+		Details: validationErr.Errors,
+	})
+}
+
+// respondWithError writes an ErrorResponse with the given status
+func respondWithError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	respondWithJSON(w, r, status, ErrorResponse{Code: errorCode(status), Message: errorMessage(message)})
+}
+
+// answers requests no route matches
+func handleNotFound(w http.ResponseWriter, r *http.Request) {
+	respondWithError(w, r, http.StatusNotFound, "No such endpoint")
+}
+
+// answers requests for a route with a method it doesn't take
+func handleMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	respondWithError(w, r, http.StatusMethodNotAllowed, r.Method+" is not allowed on "+r.URL.Path)
+}
+
+func errorCode(status int) string {
+	return strings.ReplaceAll(strings.ToLower(http.StatusText(status)), " ", "_")
+}
+
+// errorMessage drops the ERROR: prefix of the node's error strings, which
+// the envelope already says
+func errorMessage(message string) string {
+	return strings.TrimPrefix(message, "ERROR: ")
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const resourceCheckInterval = 10 * time.Second
+
+// ResourceGuard pauses mining and mempool admission while the data
+// directory runs out of disk space or the heap grows past its limit, so the
+// node stops taking on work before a write fails half way
+type ResourceGuard struct {
+	minFreeDisk uint64 // bytes, 0 disables the check
+	maxHeap     uint64 // bytes, 0 disables the check
+
+	sync.Mutex
+	cond   *sync.Cond
+	reason string // empty while resources are fine
+}
+
+var resourceGuard = newResourceGuard(100<<20, 0)
+
+func newResourceGuard(minFreeDisk, maxHeap uint64) *ResourceGuard {
+	g := &ResourceGuard{minFreeDisk: minFreeDisk, maxHeap: maxHeap}
+	g.cond = sync.NewCond(&g.Mutex)
+	return g
+}
+
+// loadResourceConfig reads MIN_FREE_DISK_MB and MAX_HEAP_MB
+func loadResourceConfig() {
+	minFreeDisk, maxHeap := uint64(100), uint64(0)
+	for _, setting := range []struct {
+		name  string
+		value *uint64
+	}{{"MIN_FREE_DISK_MB", &minFreeDisk}, {"MAX_HEAP_MB", &maxHeap}} {
+		if v := os.Getenv(setting.name); v != "" {
+			n, err := strconv.ParseUint(v, 10, 64)
+			if err != nil {
+				log.Fatalf("%s must be a non-negative integer, got %q", setting.name, v)
+			}
+			*setting.value = n
+		}
+	}
+	resourceGuard = newResourceGuard(minFreeDisk<<20, maxHeap<<20)
+}
+
+// monitor re-evaluates resources periodically
+func (g *ResourceGuard) monitor(dir string) {
+	for {
+		g.update(g.measure(dir))
+		time.Sleep(resourceCheckInterval)
+	}
+}
+
+// measure returns why resources are short, or "" if they are fine
+func (g *ResourceGuard) measure(dir string) string {
+	if g.minFreeDisk > 0 {
+		if free, err := freeDiskSpace(dir); err == nil && free < g.minFreeDisk {
+			return fmt.Sprintf("only %d MB free in %s, need %d MB", free>>20, dir, g.minFreeDisk>>20)
+		}
+	}
+	if g.maxHeap > 0 {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		if m.HeapAlloc > g.maxHeap {
+			return fmt.Sprintf("heap is %d MB, limit %d MB", m.HeapAlloc>>20, g.maxHeap>>20)
+		}
+	}
+	return ""
+}
+
+func (g *ResourceGuard) update(reason string) {
+	g.Lock()
+	defer g.Unlock()
+
+	switch {
+	case reason != "" && g.reason == "":
+		storageLog.Error("ALERT: resource pressure, pausing mining and mempool admission", "reason", reason)
+	case reason == "" && g.reason != "":
+		storageLog.Info("Resource pressure cleared, resuming mining and mempool admission")
+		g.cond.Broadcast()
+	}
+	g.reason = reason
+}
+
+// check returns an error while the node is under resource pressure
+func (g *ResourceGuard) check() error {
+	g.Lock()
+	defer g.Unlock()
+	if g.reason != "" {
+		return fmt.Errorf("ERROR: Node is paused under resource pressure: %s", g.reason)
+	}
+	return nil
+}
+
+// wait blocks while the node is under resource pressure
+func (g *ResourceGuard) wait() {
+	g.Lock()
+	defer g.Unlock()
+	for g.reason != "" {
+		g.cond.Wait()
+	}
+}
+
+// pauseReason returns why the node is paused, or ""
+func (g *ResourceGuard) pauseReason() string {
+	g.Lock()
+	defer g.Unlock()
+	return g.reason
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// freeDiskSpace is not implemented on this platform, disabling the check
+func freeDiskSpace(dir string) (uint64, error) {
+	return 0, errors.New("free disk space is unknown on this platform")
+}
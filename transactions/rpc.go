@@ -0,0 +1,597 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+)
+
+// JSON-RPC 2.0 on /rpc with bitcoind's method names and error codes, so
+// Bitcoin tooling can talk to the node. Amounts are integer coins rather
+// than BTC, and raw blocks and transactions are their JSON encoding in hex.
+
+// JSON-RPC and bitcoind error codes
+const (
+	rpcParseError      = -32700
+	rpcInvalidRequest  = -32600
+	rpcMethodNotFound  = -32601
+	rpcInvalidParams   = -32602
+	rpcMiscError       = -1
+	rpcWalletError     = -4
+	rpcInvalidAddress  = -5
+	rpcInvalidParam    = -8
+	rpcVerifyRejected  = -26
+	rpcBlockNotFound   = rpcInvalidAddress
+	rpcDeserialization = -22
+	// rpcUnauthorized is in the range JSON-RPC leaves to servers
+	rpcUnauthorized = -32001
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func newRPCError(code int, format string, a ...interface{}) *rpcError {
+	return &rpcError{code, fmt.Sprintf(format, a...)}
+}
+
+// rpcMethod takes positional arguments; params lists their names so they can
+// also be passed by name
+type rpcMethod struct {
+	params []string
+	call   func(args rpcArgs) (interface{}, *rpcError)
+}
+
+var rpcMethods map[string]rpcMethod
+
+// rpcWriteMethods change state and need API credentials once they are
+// configured, see APIAuth. Those marked true spend and mine with the node's
+// own coins and are refused outright while no credentials are configured. A
+// signed raw transaction spends its sender's coins, so sendrawtransaction
+// stays open then, like /tx/raw/send; checkNodeFunds guards the node's.
+var rpcWriteMethods = map[string]bool{
+	"sendrawtransaction": false,
+	"sendtoaddress":      true,
+	"generate":           true,
+}
+
+// maxGenerateBlocks caps the blocks one generate call mines
+const maxGenerateBlocks = 100
+
+func init() {
+	// assigned in init since the methods refer to rpcMethods via help
+	rpcMethods = map[string]rpcMethod{
+		"getblockcount":      {nil, rpcGetBlockCount},
+		"getbestblockhash":   {nil, rpcGetBestBlockHash},
+		"getblockhash":       {[]string{"height"}, rpcGetBlockHash},
+		"getblock":           {[]string{"blockhash", "verbosity"}, rpcGetBlock},
+		"getblockheader":     {[]string{"blockhash", "verbose"}, rpcGetBlockHeader},
+		"getblockchaininfo":  {nil, rpcGetBlockchainInfo},
+		"getdifficulty":      {nil, func(rpcArgs) (interface{}, *rpcError) { return difficulty, nil }},
+		"getconnectioncount": {nil, rpcGetConnectionCount},
+		"getrawmempool":      {nil, rpcGetRawMempool},
+		"getrawtransaction":  {[]string{"txid", "verbose"}, rpcGetRawTransaction},
+		"sendrawtransaction": {[]string{"hexstring"}, rpcSendRawTransaction},
+		"sendtoaddress":      {[]string{"address", "amount"}, rpcSendToAddress},
+		"getbalance":         {[]string{"address"}, rpcGetBalance},
+		"generate":           {[]string{"nblocks"}, rpcGenerate},
+		"help":               {nil, rpcHelp},
+	}
+}
+
+// handles a JSON-RPC request or batch of requests
+func handleRPC(w http.ResponseWriter, r *http.Request) {
+	authorized := apiAuth.authorized(r)
+	body, err := io.ReadAll(io.LimitReader(r.Body, int64(maxRequestBody)+1))
+	if err != nil || len(body) > maxRequestBody {
+		respondWithJSON(w, r, http.StatusOK, rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"),
+			Error: newRPCError(rpcInvalidRequest, "request body must be at most %d bytes", maxRequestBody)})
+		return
+	}
+
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil {
+			respondWithJSON(w, r, http.StatusOK, rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"),
+				Error: newRPCError(rpcParseError, "Parse error: %v", err)})
+			return
+		}
+		if len(batch) == 0 {
+			respondWithJSON(w, r, http.StatusOK, rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"),
+				Error: newRPCError(rpcInvalidRequest, "Empty batch")})
+			return
+		}
+		responses := []rpcResponse{}
+		for _, raw := range batch {
+			if resp, ok := serveRPC(raw, authorized); ok {
+				responses = append(responses, resp)
+			}
+		}
+		if len(responses) == 0 {
+			// a batch of notifications gets no response at all
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		respondWithJSON(w, r, http.StatusOK, responses)
+		return
+	}
+
+	resp, ok := serveRPC(body, authorized)
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	respondWithJSON(w, r, http.StatusOK, resp)
+}
+
+// serveRPC runs one request. Notifications, which have no ID, get no
+// response.
+func serveRPC(raw json.RawMessage, authorized bool) (rpcResponse, bool) {
+	resp := rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null")}
+
+	var req rpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		resp.Error = newRPCError(rpcParseError, "Parse error: %v", err)
+		return resp, true
+	}
+	if req.Method == "" {
+		resp.Error = newRPCError(rpcInvalidRequest, "Missing method")
+		return resp, true
+	}
+	if req.ID == nil && req.JSONRPC == "2.0" {
+		callRPC(req, authorized)
+		return resp, false
+	}
+	if req.ID != nil {
+		resp.ID = req.ID
+	}
+	resp.Result, resp.Error = callRPC(req, authorized)
+	if resp.Error == nil && resp.Result == nil {
+		resp.Result = json.RawMessage("null")
+	}
+	return resp, true
+}
+
+func callRPC(req rpcRequest, authorized bool) (interface{}, *rpcError) {
+	method, ok := rpcMethods[req.Method]
+	if !ok {
+		return nil, newRPCError(rpcMethodNotFound, "Method not found")
+	}
+	nodeFunds, write := rpcWriteMethods[req.Method]
+	if nodeFunds && !apiAuth.enabled() {
+		return nil, newRPCError(rpcUnauthorized, "Method %s is disabled until API credentials are configured", req.Method)
+	}
+	if write && apiAuth.enabled() && !authorized {
+		return nil, newRPCError(rpcUnauthorized, "Method %s needs API credentials", req.Method)
+	}
+	args, err := method.args(req.Params)
+	if err != nil {
+		return nil, err
+	}
+	return method.call(args)
+}
+
+// args turns positional or named params into positional arguments
+func (m rpcMethod) args(params json.RawMessage) (rpcArgs, *rpcError) {
+	params = bytes.TrimSpace(params)
+	if len(params) == 0 || bytes.Equal(params, []byte("null")) {
+		return nil, nil
+	}
+
+	var args rpcArgs
+	if params[0] == '{' {
+		var named map[string]json.RawMessage
+		if err := json.Unmarshal(params, &named); err != nil {
+			return nil, newRPCError(rpcInvalidParams, "Invalid params: %v", err)
+		}
+		for i, name := range m.params {
+			if v, ok := named[name]; ok {
+				for len(args) < i {
+					args = append(args, nil)
+				}
+				args = append(args, v)
+				delete(named, name)
+			}
+		}
+		for name := range named {
+			return nil, newRPCError(rpcInvalidParams, "Unknown named parameter %s", name)
+		}
+		return args, nil
+	}
+
+	if err := json.Unmarshal(params, &args); err != nil {
+		return nil, newRPCError(rpcInvalidParams, "Params must be an array or an object")
+	}
+	if len(args) > len(m.params) {
+		return nil, newRPCError(rpcInvalidParams, "Too many parameters, expected at most %d", len(m.params))
+	}
+	return args, nil
+}
+
+// rpcArgs are the positional arguments of a call; missing and null
+// arguments take their defaults
+type rpcArgs []json.RawMessage
+
+func (a rpcArgs) present(i int) bool {
+	return i < len(a) && a[i] != nil && !bytes.Equal(a[i], []byte("null"))
+}
+
+func (a rpcArgs) decode(i int, v interface{}, name string) *rpcError {
+	if err := json.Unmarshal(a[i], v); err != nil {
+		return newRPCError(rpcInvalidParams, "Invalid %s: %v", name, err)
+	}
+	return nil
+}
+
+func (a rpcArgs) string(i int, name string) (string, *rpcError) {
+	if !a.present(i) {
+		return "", newRPCError(rpcInvalidParams, "Missing %s", name)
+	}
+	var s string
+	if err := a.decode(i, &s, name); err != nil {
+		return "", err
+	}
+	return s, nil
+}
+
+func (a rpcArgs) int(i int, name string, def int) (int, *rpcError) {
+	if !a.present(i) {
+		return def, nil
+	}
+	var n int
+	if err := a.decode(i, &n, name); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// verbosity is int that also takes booleans as 0 and 1, as bitcoind does
+func (a rpcArgs) verbosity(i int, name string, def int) (int, *rpcError) {
+	var b bool
+	if a.present(i) && json.Unmarshal(a[i], &b) == nil {
+		if b {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	return a.int(i, name, def)
+}
+
+func rpcGetBlockCount(rpcArgs) (interface{}, *rpcError) {
+	return bc.Height(), nil
+}
+
+func rpcGetBestBlockHash(rpcArgs) (interface{}, *rpcError) {
+	return bc.Tip().Hash, nil
+}
+
+func rpcGetBlockHash(args rpcArgs) (interface{}, *rpcError) {
+	if !args.present(0) {
+		return nil, newRPCError(rpcInvalidParams, "Missing height")
+	}
+	height, err := args.int(0, "height", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	bc.RLock()
+	defer bc.RUnlock()
+	if height < 0 || height >= len(bc.blocks) {
+		return nil, newRPCError(rpcInvalidParam, "Block height out of range")
+	}
+	return bc.blocks[height].Hash, nil
+}
+
+// rpcBlockInfo is a verbose block in bitcoind's field names
+type rpcBlockInfo struct {
+	Hash              string      `json:"hash"`
+	Confirmations     int         `json:"confirmations"`
+	Height            int         `json:"height"`
+	Time              string      `json:"time"`
+	Nonce             uint64      `json:"nonce"`
+	Difficulty        int         `json:"difficulty"`
+	PreviousBlockHash string      `json:"previousblockhash,omitempty"`
+	NextBlockHash     string      `json:"nextblockhash,omitempty"`
+	NTx               int         `json:"nTx"`
+	Tx                interface{} `json:"tx,omitempty"`
+}
+
+// blockInfo describes a known block. Blocks off the active chain have -1
+// confirmations, like in bitcoind.
+func (bc *Blockchain) blockInfo(b *Block) rpcBlockInfo {
+	info := rpcBlockInfo{
+		Hash:              b.Hash,
+		Confirmations:     -1,
+		Height:            bc.blockHeight(b),
+		Time:              b.Timestamp,
+		Nonce:             b.Nonce,
+		Difficulty:        difficulty,
+		PreviousBlockHash: b.PrevHash,
+		NTx:               len(b.Transactions),
+	}
+	if height := bc.heightOf(b.Hash); height >= 0 {
+		info.Confirmations = len(bc.blocks) - height
+		if height+1 < len(bc.blocks) {
+			info.NextBlockHash = bc.blocks[height+1].Hash
+		}
+	}
+	return info
+}
+
+func rpcGetBlock(args rpcArgs) (interface{}, *rpcError) {
+	hash, err := args.string(0, "blockhash")
+	if err != nil {
+		return nil, err
+	}
+	verbosity, err := args.verbosity(1, "verbosity", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	bc.RLock()
+	defer bc.RUnlock()
+	b, ok := bc.known[hash]
+	if !ok {
+		return nil, newRPCError(rpcBlockNotFound, "Block not found")
+	}
+	switch verbosity {
+	case 0:
+		data, _ := json.Marshal(b)
+		return hex.EncodeToString(data), nil
+	case 1:
+		info := bc.blockInfo(b)
+		txids := []string{}
+		for _, tx := range b.Transactions {
+			txids = append(txids, tx.ID)
+		}
+		info.Tx = txids
+		return info, nil
+	default:
+		info := bc.blockInfo(b)
+		info.Tx = b.Transactions
+		return info, nil
+	}
+}
+
+func rpcGetBlockHeader(args rpcArgs) (interface{}, *rpcError) {
+	hash, err := args.string(0, "blockhash")
+	if err != nil {
+		return nil, err
+	}
+	verbose, err := args.verbosity(1, "verbose", 1)
+	if err != nil {
+		return nil, err
+	}
+
+	bc.RLock()
+	defer bc.RUnlock()
+	b, ok := bc.known[hash]
+	if !ok {
+		return nil, newRPCError(rpcBlockNotFound, "Block not found")
+	}
+	if verbose == 0 {
+		data, _ := json.Marshal(b.Header())
+		return hex.EncodeToString(data), nil
+	}
+	return bc.blockInfo(b), nil
+}
+
+func rpcGetBlockchainInfo(rpcArgs) (interface{}, *rpcError) {
+	bc.RLock()
+	defer bc.RUnlock()
+	tip := bc.blocks[len(bc.blocks)-1]
+	return map[string]interface{}{
+		"chain":         params.Name,
+		"blocks":        len(bc.blocks) - 1,
+		"headers":       bc.headers.bestHeight(),
+		"bestblockhash": tip.Hash,
+		"difficulty":    difficulty,
+		"mediantime":    tip.Timestamp,
+		"pruned":        false,
+	}, nil
+}
+
+func rpcGetConnectionCount(rpcArgs) (interface{}, *rpcError) {
+	peerManager.Lock()
+	defer peerManager.Unlock()
+	return len(peerManager.peers), nil
+}
+
+func rpcGetRawMempool(rpcArgs) (interface{}, *rpcError) {
+	txids := []string{}
+	for _, tx := range bc.mempool.Transactions() {
+		txids = append(txids, tx.ID)
+	}
+	return txids, nil
+}
+
+func rpcGetRawTransaction(args rpcArgs) (interface{}, *rpcError) {
+	txid, err := args.string(0, "txid")
+	if err != nil {
+		return nil, err
+	}
+	verbose, err := args.verbosity(1, "verbose", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var tx *Transaction
+	blockHash := ""
+	for _, pooled := range bc.mempool.Transactions() {
+		if pooled.ID == txid {
+			tx = pooled
+		}
+	}
+	if tx == nil {
+		info, err := bc.GetTransaction(txid)
+		if err != nil {
+			return nil, newRPCError(rpcInvalidAddress, "No such mempool or blockchain transaction")
+		}
+		tx, blockHash = info.Transaction, info.BlockHash
+	}
+
+	data, _ := json.Marshal(tx)
+	if verbose == 0 {
+		return hex.EncodeToString(data), nil
+	}
+	result := map[string]interface{}{
+		"txid": tx.ID,
+		"hex":  hex.EncodeToString(data),
+		"vin":  tx.Vin,
+		"vout": tx.Vout,
+	}
+	if blockHash != "" {
+		bc.RLock()
+		result["blockhash"] = blockHash
+		if height := bc.heightOf(blockHash); height >= 0 {
+			result["confirmations"] = len(bc.blocks) - height
+		}
+		bc.RUnlock()
+	}
+	return result, nil
+}
+
+func rpcSendRawTransaction(args rpcArgs) (interface{}, *rpcError) {
+	s, err := args.string(0, "hexstring")
+	if err != nil {
+		return nil, err
+	}
+	data, decodeErr := hex.DecodeString(s)
+	if decodeErr != nil {
+		return nil, newRPCError(rpcDeserialization, "TX decode failed: %v", decodeErr)
+	}
+	var tx Transaction
+	if err := json.Unmarshal(data, &tx); err != nil {
+		return nil, newRPCError(rpcDeserialization, "TX decode failed: %v", err)
+	}
+	if err := assignTransactionID(&tx); err != nil {
+		return nil, newRPCError(rpcDeserialization, "%v", err)
+	}
+	// callRPC only gets here with credentials when they are configured
+	if err := checkNodeFunds(&tx, true); err != nil {
+		return nil, newRPCError(rpcUnauthorized, "%v", err)
+	}
+	return submitRPCTransaction(&tx)
+}
+
+// sendtoaddress pays from the node's miner address, the closest thing it
+// has to a wallet
+func rpcSendToAddress(args rpcArgs) (interface{}, *rpcError) {
+	address, err := args.string(0, "address")
+	if err != nil {
+		return nil, err
+	}
+	if !args.present(1) {
+		return nil, newRPCError(rpcInvalidParams, "Missing amount")
+	}
+	amount, err := args.int(1, "amount", 0)
+	if err != nil {
+		return nil, err
+	}
+	if address == "" {
+		return nil, newRPCError(rpcInvalidAddress, "Invalid address")
+	}
+	if amount <= 0 || !moneyRange(Amount(amount)) {
+		return nil, newRPCError(rpcInvalidParam, "Amount must be positive and at most %d", maxMoney)
+	}
+
+	tx, txErr := NewUTXOTransaction(minerAddress(), address, Amount(amount), &bc)
+	if txErr == nil {
+		txErr = signNodeSpend(tx)
+	}
+	if txErr != nil {
+		return nil, newRPCError(rpcWalletError, "%v", txErr)
+	}
+	return submitRPCTransaction(tx)
+}
+
+func submitRPCTransaction(tx *Transaction) (interface{}, *rpcError) {
+	if err := bc.AcceptTransaction(context.Background(), tx); err != nil {
+		return nil, newRPCError(rpcVerifyRejected, "%v", err)
+	}
+	relayTransaction(tx, nil)
+	return tx.ID, nil
+}
+
+// getbalance reports the miner address's balance, or that of the address
+// given; bitcoind's "*" means the wallet as well
+func rpcGetBalance(args rpcArgs) (interface{}, *rpcError) {
+	address := minerAddress()
+	if args.present(0) {
+		s, err := args.string(0, "address")
+		if err != nil {
+			return nil, err
+		}
+		if s != "" && s != "*" {
+			address = s
+		}
+	}
+	return bc.Balance(address), nil
+}
+
+// generate mines blocks on the tip with what the mempool holds and returns
+// their hashes
+func rpcGenerate(args rpcArgs) (interface{}, *rpcError) {
+	n, err := args.int(0, "nblocks", 1)
+	if err != nil {
+		return nil, err
+	}
+	if n < 1 || n > maxGenerateBlocks {
+		return nil, newRPCError(rpcInvalidParam, "nblocks must be between 1 and %d", maxGenerateBlocks)
+	}
+	if err := resourceGuard.check(); err != nil {
+		return nil, newRPCError(rpcMiscError, "%v", err)
+	}
+
+	hashes := []string{}
+	for i := 0; i < n; i++ {
+		b, err := generateBlock(shutdownCtx, bc.Tip())
+		if err == nil {
+			err = commitBlock(shutdownCtx, b)
+		}
+		if err != nil {
+			return nil, newRPCError(rpcMiscError, "%v", err)
+		}
+		relayBlock(b, nil)
+		hashes = append(hashes, b.Hash)
+	}
+	return hashes, nil
+}
+
+func rpcHelp(rpcArgs) (interface{}, *rpcError) {
+	var names []string
+	for name := range rpcMethods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&buf, "%s", name)
+		for _, p := range rpcMethods[name].params {
+			fmt.Fprintf(&buf, " %s", p)
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.String(), nil
+}
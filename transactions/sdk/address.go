@@ -0,0 +1,50 @@
+package sdk
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/ripemd160"
+)
+
+const addressVersion = byte(0x00)
+
+// HashPubKey hashes a compressed public key with SHA-256 and RIPEMD-160
+func HashPubKey(pubKey []byte) []byte {
+	publicSHA256 := sha256.Sum256(pubKey)
+
+	RIPEMD160Hasher := ripemd160.New()
+	RIPEMD160Hasher.Write(publicSHA256[:])
+
+	return RIPEMD160Hasher.Sum(nil)
+}
+
+// AddressFromPubKeyHash encodes a public key hash as an address
+func AddressFromPubKeyHash(pubKeyHash []byte) string {
+	return Base58CheckEncode(append([]byte{addressVersion}, pubKeyHash...))
+}
+
+// AddressFromPubKey returns the address of a compressed public key
+func AddressFromPubKey(pubKey []byte) string {
+	return AddressFromPubKeyHash(HashPubKey(pubKey))
+}
+
+// PubKeyHashFromAddress decodes an address back into its public key hash
+func PubKeyHashFromAddress(address string) ([]byte, error) {
+	payload, err := Base58CheckDecode(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q: %v", address, err)
+	}
+	if len(payload) != 1+ripemd160.Size || payload[0] != addressVersion {
+		return nil, errors.New("invalid address " + address)
+	}
+
+	return payload[1:], nil
+}
+
+// ValidateAddress checks if address is valid
+func ValidateAddress(address string) bool {
+	_, err := PubKeyHashFromAddress(address)
+	return err == nil
+}
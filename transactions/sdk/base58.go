@@ -0,0 +1,102 @@
+package sdk
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+const checksumLen = 4
+
+var b58Alphabet = []byte("123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz")
+
+// ErrChecksum is returned when a Base58Check string fails verification
+var ErrChecksum = errors.New("checksum mismatch")
+
+// Base58Encode encodes a byte array to Base58
+func Base58Encode(input []byte) []byte {
+	var result []byte
+
+	x := new(big.Int).SetBytes(input)
+	base := big.NewInt(int64(len(b58Alphabet)))
+	zero := big.NewInt(0)
+	mod := &big.Int{}
+
+	for x.Cmp(zero) != 0 {
+		x.DivMod(x, base, mod)
+		result = append(result, b58Alphabet[mod.Int64()])
+	}
+
+	for _, b := range input {
+		if b != 0x00 {
+			break
+		}
+		result = append(result, b58Alphabet[0])
+	}
+
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+
+	return result
+}
+
+// Base58Decode decodes Base58-encoded data
+func Base58Decode(input []byte) ([]byte, error) {
+	result := big.NewInt(0)
+	zeroBytes := 0
+
+	for _, b := range input {
+		if b != b58Alphabet[0] {
+			break
+		}
+		zeroBytes++
+	}
+
+	for _, b := range input[zeroBytes:] {
+		charIndex := bytes.IndexByte(b58Alphabet, b)
+		if charIndex < 0 {
+			return nil, errors.New("invalid base58 character")
+		}
+		result.Mul(result, big.NewInt(58))
+		result.Add(result, big.NewInt(int64(charIndex)))
+	}
+
+	decoded := result.Bytes()
+	decoded = append(bytes.Repeat([]byte{0x00}, zeroBytes), decoded...)
+
+	return decoded, nil
+}
+
+// checksum generates a checksum for a payload
+func checksum(payload []byte) []byte {
+	firstSHA := sha256.Sum256(payload)
+	secondSHA := sha256.Sum256(firstSHA[:])
+
+	return secondSHA[:checksumLen]
+}
+
+// Base58CheckEncode appends a checksum to payload and encodes it to Base58
+func Base58CheckEncode(payload []byte) string {
+	full := append(append([]byte{}, payload...), checksum(payload)...)
+	return string(Base58Encode(full))
+}
+
+// Base58CheckDecode decodes a Base58Check string and verifies its checksum
+func Base58CheckDecode(s string) ([]byte, error) {
+	decoded, err := Base58Decode([]byte(s))
+	if err != nil {
+		return nil, err
+	}
+	if len(decoded) < checksumLen {
+		return nil, ErrChecksum
+	}
+
+	payload := decoded[:len(decoded)-checksumLen]
+	if !bytes.Equal(checksum(payload), decoded[len(decoded)-checksumLen:]) {
+		return nil, ErrChecksum
+	}
+
+	return payload, nil
+}
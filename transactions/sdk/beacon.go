@@ -0,0 +1,85 @@
+package sdk
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// BeaconWindow is how many consecutive block hashes feed one beacon value
+const BeaconWindow = 8
+
+// BeaconConfirmations is how deep the newest block of the window must be
+// before a beacon value is considered final
+const BeaconConfirmations = 6
+
+// beaconDomain separates beacon hashes from every other use of SHA-256
+const beaconDomain = "go_blockchain beacon v1"
+
+// ErrBeaconMismatch is returned when a beacon's value doesn't follow from
+// its block hashes
+var ErrBeaconMismatch = errors.New("beacon value doesn't match its block hashes")
+
+// Beacon is the randomness derived at a height.
+//
+// Value = SHA-256(beaconDomain || uint64be(Height) || hash(h-W+1) || ... ||
+// hash(h)), with W = BeaconWindow and raw 32-byte block hashes, oldest first.
+// Windows near genesis start at block 0.
+//
+// Caveats: a miner who finds a block can throw it away if they dislike the
+// resulting value, so each block in the window can be biased at the cost of
+// its reward. Use values only once Final, commit to the height before it is
+// mined, and don't secure more than a few block rewards with them. The
+// verifier only checks the construction; check BlockHashes against headers
+// you trust.
+type Beacon struct {
+	Height        int
+	Window        int
+	BlockHashes   []string
+	Value         string
+	Confirmations int
+	Final         bool
+}
+
+// BeaconValue derives the beacon value at height from the window of block
+// hashes ending at it
+func BeaconValue(height int, hashes []string) ([]byte, error) {
+	if height < 0 {
+		return nil, fmt.Errorf("negative beacon height %d", height)
+	}
+	want := BeaconWindow
+	if height+1 < want {
+		want = height + 1
+	}
+	if len(hashes) != want {
+		return nil, fmt.Errorf("beacon at height %d needs %d block hashes, got %d", height, want, len(hashes))
+	}
+
+	h := sha256.New()
+	h.Write([]byte(beaconDomain))
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], uint64(height))
+	h.Write(buf[:])
+	for _, hash := range hashes {
+		raw, err := hex.DecodeString(hash)
+		if err != nil || len(raw) != sha256.Size {
+			return nil, fmt.Errorf("invalid block hash %q", hash)
+		}
+		h.Write(raw)
+	}
+	return h.Sum(nil), nil
+}
+
+// VerifyBeacon checks that b.Value follows from b.Height and b.BlockHashes
+func VerifyBeacon(b *Beacon) error {
+	value, err := BeaconValue(b.Height, b.BlockHashes)
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(value) != b.Value {
+		return ErrBeaconMismatch
+	}
+	return nil
+}
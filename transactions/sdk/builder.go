@@ -0,0 +1,153 @@
+package sdk
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// sigAllowance is the serialized size reserved per input for a signature
+// and public key when estimating the fee of an unsigned transaction
+const sigAllowance = 2 * (72 + 33)
+
+type builderInput struct {
+	Txid         string
+	Vout         int
+	Value        int
+	ScriptPubKey string
+}
+
+// TxBuilder assembles and signs a raw transaction that can be submitted to
+// the node's /tx/raw/send endpoint. Methods can be chained; the first error
+// is reported by Build.
+type TxBuilder struct {
+	inputs        []builderInput
+	outputs       []TXOutput
+	feeRate       int
+	changeAddress string
+	signers       []Signer
+	err           error
+}
+
+// NewTxBuilder returns an empty transaction builder
+func NewTxBuilder() *TxBuilder {
+	return &TxBuilder{}
+}
+
+// AddInput spends output vout of txid, which holds value locked to scriptPubKey
+func (b *TxBuilder) AddInput(txid string, vout, value int, scriptPubKey string) *TxBuilder {
+	if value <= 0 {
+		b.fail(fmt.Errorf("input %s:%d has non-positive value %d", txid, vout, value))
+	}
+	b.inputs = append(b.inputs, builderInput{txid, vout, value, scriptPubKey})
+	return b
+}
+
+// AddOutput pays value to address
+func (b *TxBuilder) AddOutput(address string, value int) *TxBuilder {
+	if value <= 0 {
+		b.fail(fmt.Errorf("output to %s has non-positive value %d", address, value))
+	}
+	b.outputs = append(b.outputs, TXOutput{value, address})
+	return b
+}
+
+// SetFeeRate sets the fee paid per byte of the serialized transaction
+func (b *TxBuilder) SetFeeRate(rate int) *TxBuilder {
+	if rate < 0 {
+		b.fail(errors.New("fee rate can't be negative"))
+	}
+	b.feeRate = rate
+	return b
+}
+
+// SetChangeAddress sets where the difference between inputs, outputs and
+// fee is paid. Without a change address the difference goes to the fee.
+func (b *TxBuilder) SetChangeAddress(address string) *TxBuilder {
+	b.changeAddress = address
+	return b
+}
+
+// Sign registers a signer; Build uses it for every input locked to its address
+func (b *TxBuilder) Sign(signer Signer) *TxBuilder {
+	b.signers = append(b.signers, signer)
+	return b
+}
+
+// Build computes the fee and change, signs every input and returns the
+// transaction. The ID is left empty; the node assigns it on submission.
+func (b *TxBuilder) Build() (*Transaction, error) {
+	if b.err != nil {
+		return nil, b.err
+	}
+	if len(b.inputs) == 0 || len(b.outputs) == 0 {
+		return nil, errors.New("transaction needs at least one input and one output")
+	}
+
+	in, out := 0, 0
+	for _, input := range b.inputs {
+		in += input.Value
+	}
+	for _, output := range b.outputs {
+		out += output.Value
+	}
+
+	tx := b.unsigned(b.outputs)
+	fee := b.feeRate * b.estimateSize(tx)
+	if b.changeAddress != "" {
+		// the change output pays for its own bytes; when nothing is left
+		// after that the transaction goes out without it, at its own fee
+		withChange := b.unsigned(append(b.outputs, TXOutput{0, b.changeAddress}))
+		changeFee := b.feeRate * b.estimateSize(withChange)
+		if change := in - out - changeFee; change > 0 {
+			tx, fee = withChange, changeFee
+			tx.Vout[len(tx.Vout)-1].Value = change
+		}
+	}
+	if in < out+fee {
+		return nil, fmt.Errorf("inputs (%d) don't cover outputs (%d) and fee (%d)", in, out, fee)
+	}
+
+	for i, input := range b.inputs {
+		signer := b.signerFor(input.ScriptPubKey)
+		if signer == nil {
+			return nil, fmt.Errorf("no signer for input %s:%d locked to %s", input.Txid, input.Vout, input.ScriptPubKey)
+		}
+		signature, err := signer.Sign(SigHash(tx, i, input.ScriptPubKey))
+		if err != nil {
+			return nil, err
+		}
+		tx.Vin[i].Signature = signature
+		tx.Vin[i].PubKey = signer.PublicKey()
+	}
+
+	return tx, nil
+}
+
+func (b *TxBuilder) fail(err error) {
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+func (b *TxBuilder) unsigned(outputs []TXOutput) *Transaction {
+	tx := &Transaction{Vout: append([]TXOutput(nil), outputs...)}
+	for _, input := range b.inputs {
+		tx.Vin = append(tx.Vin, TXInput{Txid: input.Txid, Vout: input.Vout, ScriptSig: input.ScriptPubKey})
+	}
+	return tx
+}
+
+func (b *TxBuilder) estimateSize(tx *Transaction) int {
+	encoded, _ := json.Marshal(tx)
+	return len(encoded) + len(tx.Vin)*sigAllowance
+}
+
+func (b *TxBuilder) signerFor(scriptPubKey string) Signer {
+	for _, signer := range b.signers {
+		if AddressFromPubKey(signer.PublicKey()) == scriptPubKey {
+			return signer
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package sdk
+
+import "testing"
+
+func TestBuildChangeFee(t *testing.T) {
+	key, err := NewMasterKey([]byte("builder test seed, 32 bytes long"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const txid, rate = "00", 2
+	unsigned := func(outputs ...TXOutput) int {
+		b := NewTxBuilder().AddInput(txid, 0, 1, key.Address())
+		return rate * b.estimateSize(b.unsigned(outputs))
+	}
+	noChangeFee := unsigned(TXOutput{100, "bob"})
+	changeFee := unsigned(TXOutput{100, "bob"}, TXOutput{0, key.Address()})
+
+	tests := []struct {
+		name    string
+		input   int
+		outputs int
+		fee     int
+	}{
+		// too little left to pay for a change output: it is left out and
+		// the transaction pays the smaller fee
+		{name: "no room for change", input: 100 + noChangeFee, outputs: 1, fee: noChangeFee},
+		{name: "change", input: 100 + changeFee + 50, outputs: 2, fee: changeFee},
+	}
+	for _, tt := range tests {
+		tx, err := NewTxBuilder().
+			AddInput(txid, 0, tt.input, key.Address()).
+			AddOutput("bob", 100).
+			SetFeeRate(rate).
+			SetChangeAddress(key.Address()).
+			Sign(key).
+			Build()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		out := 0
+		for _, o := range tx.Vout {
+			out += o.Value
+		}
+		if len(tx.Vout) != tt.outputs || tt.input-out != tt.fee {
+			t.Errorf("%s: %d outputs paying fee %d, want %d paying %d", tt.name, len(tx.Vout), tt.input-out, tt.outputs, tt.fee)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package sdk
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Client talks to a node's HTTP API
+type Client struct {
+	BaseURL    string
+	HTTPClient *http.Client
+	// APIKey, if set, is sent as a bearer token with every request; nodes
+	// with API_KEYS need it for writes
+	APIKey string
+}
+
+// ChainHeight is the node's current tip
+type ChainHeight struct {
+	Height  int
+	TipHash string
+}
+
+// NewClient returns a client for the node at baseURL, e.g. http://localhost:9000
+func NewClient(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/"), HTTPClient: &http.Client{Timeout: 30 * time.Second}}
+}
+
+// Height returns the height and tip hash of the node's active chain
+func (c *Client) Height(ctx context.Context) (*ChainHeight, error) {
+	var h ChainHeight
+	if err := c.do(ctx, http.MethodGet, "/height", nil, &h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// AddressBalance breaks down the balance of an address. Unconfirmed is what
+// the node's mempool adds to Confirmed, and Immature the part of Confirmed
+// paid by recent coinbases. Light nodes only know Confirmed.
+type AddressBalance struct {
+	Address     string
+	Confirmed   int
+	Unconfirmed int
+	Immature    int
+	UTXOCount   int
+}
+
+// Balance returns the confirmed balance of address
+func (c *Client) Balance(ctx context.Context, address string) (int, error) {
+	balance, err := c.AddressBalance(ctx, address)
+	if err != nil {
+		return 0, err
+	}
+	return balance.Confirmed, nil
+}
+
+// AddressBalance returns the balance of address broken down
+func (c *Client) AddressBalance(ctx context.Context, address string) (*AddressBalance, error) {
+	var balance AddressBalance
+	if err := c.do(ctx, http.MethodPost, "/balance", map[string]string{"Address": address}, &balance); err != nil {
+		return nil, err
+	}
+	return &balance, nil
+}
+
+// SubmitTransaction sends a transaction built with TxBuilder to the node's
+// mempool and returns it with the ID the node assigned
+func (c *Client) SubmitTransaction(ctx context.Context, tx *Transaction) (*Transaction, error) {
+	var accepted Transaction
+	if err := c.do(ctx, http.MethodPost, "/tx", tx, &accepted); err != nil {
+		return nil, err
+	}
+	return &accepted, nil
+}
+
+// Job is a transaction the node queued to mine into a block of its own.
+// Status goes from queued to mining to done or failed.
+type Job struct {
+	ID     string
+	Status string
+	Txid   string
+	Error  string
+}
+
+// Send asks the node to pay value from one of its addresses to another and
+// mine the transaction; poll the returned job at /jobs/{id}
+func (c *Client) Send(ctx context.Context, from, to string, value int) (*Job, error) {
+	var job Job
+	body := map[string]interface{}{"From": from, "To": to, "Value": value}
+	if err := c.do(ctx, http.MethodPost, "/", body, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// FeeEstimate is the node's recommended fee rate, per byte, for
+// confirmation within Target blocks
+type FeeEstimate struct {
+	Target       int
+	FeeRate      int
+	HistoryRate  int
+	MempoolRate  int
+	Blocks       int
+	BlockBytes   int
+	MempoolCount int
+	MempoolBytes int
+}
+
+// EstimateFee asks the node for a fee rate confirming within target blocks,
+// to pass to TxBuilder.SetFeeRate
+func (c *Client) EstimateFee(ctx context.Context, target int) (*FeeEstimate, error) {
+	var estimate FeeEstimate
+	if err := c.do(ctx, http.MethodGet, "/fees/estimate?target="+strconv.Itoa(target), nil, &estimate); err != nil {
+		return nil, err
+	}
+	return &estimate, nil
+}
+
+// APIError is an error response of the node
+type APIError struct {
+	Status  int    `json:"-"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details []struct {
+		Field   string `json:"field"`
+		Message string `json:"message"`
+	} `json:"details"`
+}
+
+func (e *APIError) Error() string {
+	msg := fmt.Sprintf("%d %s", e.Status, e.Message)
+	for _, d := range e.Details {
+		if d.Field != "" {
+			msg += fmt.Sprintf("; %s: %s", d.Field, d.Message)
+		}
+	}
+	return msg
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.BaseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	}
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= 300 {
+		apiErr := &APIError{Status: resp.StatusCode}
+		if json.Unmarshal(data, apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(data))
+		}
+		return fmt.Errorf("%s %s: %w", method, path, apiErr)
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,57 @@
+package sdk
+
+// DerivedAddress describes the receiving address at one index of an account
+type DerivedAddress struct {
+	Index      uint32
+	Address    string
+	PubKey     []byte
+	PubKeyHash []byte
+	// ScriptPubKey is the locking value carried by outputs paying Address
+	ScriptPubKey string
+}
+
+// DeriveAddress derives the receiving address at index from an account
+// extended public key. Only public derivation is used, so an xpub is enough.
+func DeriveAddress(xpub string, index uint32) (*DerivedAddress, error) {
+	account, err := ParseExtendedKey(xpub)
+	if err != nil {
+		return nil, err
+	}
+
+	return deriveAddress(account.Neuter(), index)
+}
+
+// DeriveAddresses derives count consecutive addresses starting at from.
+// Indexes that yield an invalid key are skipped, as BIP32 prescribes.
+func DeriveAddresses(xpub string, from, count uint32) ([]*DerivedAddress, error) {
+	account, err := ParseExtendedKey(xpub)
+	if err != nil {
+		return nil, err
+	}
+	account = account.Neuter()
+
+	addresses := make([]*DerivedAddress, 0, count)
+	for i := from; uint32(len(addresses)) < count && i < HardenedKeyStart; i++ {
+		addr, err := deriveAddress(account, i)
+		if err == ErrInvalidChild {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, addr)
+	}
+
+	return addresses, nil
+}
+
+func deriveAddress(account *ExtendedKey, index uint32) (*DerivedAddress, error) {
+	child, err := account.Child(index)
+	if err != nil {
+		return nil, err
+	}
+
+	pubKey := child.PublicKey()
+	address := AddressFromPubKey(pubKey)
+	return &DerivedAddress{index, address, pubKey, HashPubKey(pubKey), address}, nil
+}
@@ -0,0 +1,202 @@
+package sdk
+
+import (
+	"bytes"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// HardenedKeyStart is the index of the first hardened child key
+const HardenedKeyStart = 0x80000000
+
+const serializedKeyLen = 78
+
+var (
+	// the version bytes make serialized keys start with pprv and ppub. They
+	// differ from Bitcoin's xprv and xpub on purpose: these are P-256 keys,
+	// and a wallet for another curve must not take them for its own.
+	pprvVersion = []byte{0x03, 0xe2, 0x59, 0x44}
+	ppubVersion = []byte{0x03, 0xe2, 0x5d, 0x7e}
+	// bitcoinVersions are Bitcoin's xprv and xpub, refused by name
+	bitcoinVersions = [][]byte{{0x04, 0x88, 0xad, 0xe4}, {0x04, 0x88, 0xb2, 0x1e}}
+
+	masterKeySalt = []byte("go_blockchain seed")
+
+	// ErrDeriveHardFromPublic is returned when a hardened child is requested
+	// from a public extended key
+	ErrDeriveHardFromPublic = errors.New("cannot derive a hardened key from a public key")
+	// ErrInvalidChild is returned for the (astronomically rare) indexes that
+	// don't produce a valid key; callers should skip to the next index
+	ErrInvalidChild = errors.New("derived key is invalid")
+)
+
+// curve is the elliptic curve used by wallet keys
+var curve = elliptic.P256()
+
+// ExtendedKey is a BIP32-style hierarchical deterministic key. Public
+// extended keys (xpubs) derive the same non-hardened child addresses as their
+// private counterparts without having access to any private key.
+type ExtendedKey struct {
+	key       []byte // 32 byte private scalar or 33 byte compressed public key
+	chainCode []byte
+	parentFP  []byte
+	depth     uint8
+	index     uint32
+	private   bool
+}
+
+// NewMasterKey derives the root extended private key from a seed
+func NewMasterKey(seed []byte) (*ExtendedKey, error) {
+	mac := hmac.New(sha512.New, masterKeySalt)
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+
+	k := new(big.Int).SetBytes(sum[:32])
+	if k.Sign() == 0 || k.Cmp(curve.Params().N) >= 0 {
+		return nil, ErrInvalidChild
+	}
+
+	return &ExtendedKey{sum[:32], sum[32:], []byte{0, 0, 0, 0}, 0, 0, true}, nil
+}
+
+// IsPrivate reports whether the key can sign
+func (k *ExtendedKey) IsPrivate() bool {
+	return k.private
+}
+
+// Index returns the child index the key was derived with
+func (k *ExtendedKey) Index() uint32 {
+	return k.index
+}
+
+// PublicKey returns the compressed public key
+func (k *ExtendedKey) PublicKey() []byte {
+	if !k.private {
+		return k.key
+	}
+	x, y := curve.ScalarBaseMult(k.key)
+	return elliptic.MarshalCompressed(curve, x, y)
+}
+
+// Address returns the address of the key
+func (k *ExtendedKey) Address() string {
+	return AddressFromPubKey(k.PublicKey())
+}
+
+// Neuter returns the public extended key corresponding to k
+func (k *ExtendedKey) Neuter() *ExtendedKey {
+	if !k.private {
+		return k
+	}
+	return &ExtendedKey{k.PublicKey(), k.chainCode, k.parentFP, k.depth, k.index, false}
+}
+
+// Child derives the extended key at index i below k
+func (k *ExtendedKey) Child(i uint32) (*ExtendedKey, error) {
+	hardened := i >= HardenedKeyStart
+	if hardened && !k.private {
+		return nil, ErrDeriveHardFromPublic
+	}
+
+	var data []byte
+	if hardened {
+		data = append([]byte{0x00}, k.key...)
+	} else {
+		data = append([]byte{}, k.PublicKey()...)
+	}
+	data = binary.BigEndian.AppendUint32(data, i)
+
+	mac := hmac.New(sha512.New, k.chainCode)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+	il, chainCode := sum[:32], sum[32:]
+
+	n := curve.Params().N
+	ilNum := new(big.Int).SetBytes(il)
+	if ilNum.Cmp(n) >= 0 {
+		return nil, ErrInvalidChild
+	}
+
+	var childKey []byte
+	if k.private {
+		keyNum := new(big.Int).SetBytes(k.key)
+		keyNum.Add(keyNum, ilNum).Mod(keyNum, n)
+		if keyNum.Sign() == 0 {
+			return nil, ErrInvalidChild
+		}
+		childKey = keyNum.FillBytes(make([]byte, 32))
+	} else {
+		ilx, ily := curve.ScalarBaseMult(il)
+		px, py := elliptic.UnmarshalCompressed(curve, k.key)
+		cx, cy := curve.Add(ilx, ily, px, py)
+		if cx.Sign() == 0 && cy.Sign() == 0 {
+			return nil, ErrInvalidChild
+		}
+		childKey = elliptic.MarshalCompressed(curve, cx, cy)
+	}
+
+	fp := HashPubKey(k.PublicKey())[:4]
+	return &ExtendedKey{childKey, chainCode, fp, k.depth + 1, i, k.private}, nil
+}
+
+// String serializes the key in the BIP32 Base58Check layout, as pprv or ppub
+func (k *ExtendedKey) String() string {
+	buf := make([]byte, 0, serializedKeyLen)
+	if k.private {
+		buf = append(buf, pprvVersion...)
+	} else {
+		buf = append(buf, ppubVersion...)
+	}
+	buf = append(buf, k.depth)
+	buf = append(buf, k.parentFP...)
+	buf = binary.BigEndian.AppendUint32(buf, k.index)
+	buf = append(buf, k.chainCode...)
+	if k.private {
+		buf = append(buf, 0x00)
+	}
+	buf = append(buf, k.key...)
+
+	return Base58CheckEncode(buf)
+}
+
+// ParseExtendedKey decodes a serialized pprv or ppub
+func ParseExtendedKey(s string) (*ExtendedKey, error) {
+	payload, err := Base58CheckDecode(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid extended key: %v", err)
+	}
+	if len(payload) != serializedKeyLen {
+		return nil, errors.New("invalid extended key length")
+	}
+
+	k := &ExtendedKey{
+		depth:     payload[4],
+		parentFP:  payload[5:9],
+		index:     binary.BigEndian.Uint32(payload[9:13]),
+		chainCode: payload[13:45],
+	}
+
+	switch {
+	case bytes.Equal(payload[:4], pprvVersion):
+		if payload[45] != 0x00 {
+			return nil, errors.New("invalid private extended key")
+		}
+		k.key, k.private = payload[46:], true
+	case bytes.Equal(payload[:4], ppubVersion):
+		k.key = payload[45:]
+		if x, _ := elliptic.UnmarshalCompressed(curve, k.key); x == nil {
+			return nil, errors.New("invalid public key in extended key")
+		}
+	case bytes.Equal(payload[:4], bitcoinVersions[0]), bytes.Equal(payload[:4], bitcoinVersions[1]):
+		return nil, errors.New("extended key is a Bitcoin xprv or xpub, not a P-256 pprv or ppub")
+	default:
+		return nil, errors.New("unknown extended key version")
+	}
+
+	return k, nil
+}
@@ -0,0 +1,29 @@
+package sdk
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseExtendedKeyVersions(t *testing.T) {
+	key, err := NewMasterKey([]byte("hdkey test seed, 32 bytes long.."))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []*ExtendedKey{key, key.Neuter()} {
+		s := k.String()
+		if !strings.HasPrefix(s, "pprv") && !strings.HasPrefix(s, "ppub") {
+			t.Errorf("%s isn't a pprv or ppub", s)
+		}
+		parsed, err := ParseExtendedKey(s)
+		if err != nil || parsed.String() != s {
+			t.Errorf("%s parsed as %v, %v", s, parsed, err)
+		}
+	}
+
+	// the master xpub of BIP32 test vector 1
+	bitcoin := "xpub661MyMwAqRbcFtXgS5sYJABqqG9YLmC4Q1Rdap9gSE8NqtwybGhePY2gZ29ESFjqJoCu1Rupje8YtGqsefD265TMg7usUDFdp6W1EGMcet8"
+	if _, err := ParseExtendedKey(bitcoin); err == nil || !strings.Contains(err.Error(), "Bitcoin") {
+		t.Errorf("Bitcoin xpub parsed: %v", err)
+	}
+}
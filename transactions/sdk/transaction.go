@@ -0,0 +1,92 @@
+package sdk
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"math/big"
+)
+
+// ErrPublicKeyOnly is returned when signing with a public extended key
+var ErrPublicKeyOnly = errors.New("key has no private part")
+
+// Transaction mirrors the node's JSON representation of a transaction
+type Transaction struct {
+	ID   string
+	Vin  []TXInput
+	Vout []TXOutput
+}
+
+// TXInput mirrors the node's transaction input
+type TXInput struct {
+	Txid      string
+	Vout      int
+	ScriptSig string
+	Signature []byte
+	PubKey    []byte
+}
+
+// TXOutput mirrors the node's transaction output
+type TXOutput struct {
+	Value        int
+	ScriptPubKey string
+}
+
+// Signer signs transaction digests on behalf of one address
+type Signer interface {
+	PublicKey() []byte
+	Sign(digest []byte) ([]byte, error)
+}
+
+// SigHash returns the digest an input signature commits to. It is computed
+// over a trimmed copy of the transaction: the ID and every input's
+// ScriptSig, Signature and PubKey are cleared, then the input being signed
+// gets the ScriptPubKey of the output it spends.
+func SigHash(tx *Transaction, inputIndex int, prevScriptPubKey string) []byte {
+	hash := sha256.Sum256(SigHashPreimage(tx, inputIndex, prevScriptPubKey))
+	return hash[:]
+}
+
+// SigHashPreimage returns the serialized trimmed copy SigHash hashes: the
+// compact JSON encoding of the copy, with fields in declaration order
+func SigHashPreimage(tx *Transaction, inputIndex int, prevScriptPubKey string) []byte {
+	trimmed := Transaction{Vout: tx.Vout}
+	for _, in := range tx.Vin {
+		trimmed.Vin = append(trimmed.Vin, TXInput{Txid: in.Txid, Vout: in.Vout})
+	}
+	trimmed.Vin[inputIndex].ScriptSig = prevScriptPubKey
+
+	encoded, _ := json.Marshal(trimmed)
+	return encoded
+}
+
+// VerifySignature checks an ASN.1 signature of digest by a compressed public key
+func VerifySignature(pubKey, digest, signature []byte) bool {
+	x, y := elliptic.UnmarshalCompressed(curve, pubKey)
+	if x == nil {
+		return false
+	}
+	return ecdsa.VerifyASN1(&ecdsa.PublicKey{Curve: curve, X: x, Y: y}, digest, signature)
+}
+
+// Sign signs digest with the extended private key, so private extended keys
+// can be used as a Signer. Signatures are deterministic (RFC 6979): the same
+// key and digest always give the same bytes, so no randomness can leak the
+// key and test vectors stay reproducible.
+func (k *ExtendedKey) Sign(digest []byte) ([]byte, error) {
+	if !k.private {
+		return nil, ErrPublicKeyOnly
+	}
+
+	x, y := curve.ScalarBaseMult(k.key)
+	priv := &ecdsa.PrivateKey{
+		PublicKey: ecdsa.PublicKey{Curve: curve, X: x, Y: y},
+		D:         new(big.Int).SetBytes(k.key),
+	}
+	// a nil random source selects RFC 6979 nonces, derived with the hash
+	// the digest was made with
+	return priv.Sign(nil, digest, crypto.SHA256)
+}
@@ -0,0 +1,34 @@
+package sdk
+
+import (
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+// TestSignRFC6979 checks Sign against the P-256, SHA-256 example of RFC 6979
+// appendix A.2.5
+func TestSignRFC6979(t *testing.T) {
+	key, _ := hex.DecodeString("C9AFA9D845BA75166B5C215767B1D6934E50C3DB36E89B127B8A622B120F6721")
+	k := &ExtendedKey{key: key, private: true}
+	digest := sha256.Sum256([]byte("sample"))
+
+	signature, err := k.Sign(digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sig struct{ R, S *big.Int }
+	if _, err := asn1.Unmarshal(signature, &sig); err != nil {
+		t.Fatal(err)
+	}
+	wantR, _ := new(big.Int).SetString("EFD48B2AACB6A8FD1140DD9CD45E81D69D2C877B56AAF991C34D0EA84EAF3716", 16)
+	wantS, _ := new(big.Int).SetString("F7CB1C942D657C41D436C7A1B6E29F65F3E900DBB9AFF4064DC4AB2F843ACDA8", 16)
+	if sig.R.Cmp(wantR) != 0 || sig.S.Cmp(wantS) != 0 {
+		t.Fatalf("signature (%x, %x), want (%x, %x)", sig.R, sig.S, wantR, wantS)
+	}
+	if !VerifySignature(k.PublicKey(), digest[:], signature) {
+		t.Fatal("signature doesn't verify")
+	}
+}
@@ -0,0 +1,217 @@
+package sdk
+
+//go:generate go run ../cmd/vectors generate -o testdata/vectors.json
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// Vectors is a language-agnostic set of consensus test vectors. Every byte
+// string field of the vectors themselves is hex. The embedded Transaction is
+// the exception: it is in the node's JSON encoding, where the input
+// Signature and PubKey fields are base64 of the same bytes given in hex by
+// SigHashVector.
+type Vectors struct {
+	Addresses []AddressVector
+	SigHashes []SigHashVector
+}
+
+// AddressVector pins key derivation, public key hashing and address encoding
+type AddressVector struct {
+	Seed         string
+	Path         string
+	Xprv         string
+	Xpub         string
+	PubKey       string
+	PubKeyHash   string
+	Address      string
+	ScriptPubKey string
+}
+
+// SigHashVector pins the trimmed-copy preimage and digest of one input, and
+// carries a signature of the digest that must verify under PubKey
+type SigHashVector struct {
+	Transaction      *Transaction
+	InputIndex       int
+	PrevScriptPubKey string
+	Preimage         string
+	SigHash          string
+	PubKey           string
+	Signature        string
+}
+
+// VectorSeeds returns the n fixed seeds the published vectors are generated
+// from
+func VectorSeeds(n int) [][]byte {
+	var seeds [][]byte
+	for i := 0; i < n; i++ {
+		seed := sha256.Sum256([]byte(fmt.Sprintf("go_blockchain test vector seed %d", i)))
+		seeds = append(seeds, seed[:])
+	}
+	return seeds
+}
+
+// GenerateVectors derives count addresses below the hardened account m/0'
+// of each seed, and signs a transaction spending one output of every
+// address to the next one
+func GenerateVectors(seeds [][]byte, count int) (*Vectors, error) {
+	v := &Vectors{}
+
+	for _, seed := range seeds {
+		master, err := NewMasterKey(seed)
+		if err != nil {
+			return nil, err
+		}
+		account, err := master.Child(HardenedKeyStart)
+		if err != nil {
+			return nil, err
+		}
+
+		var keys []*ExtendedKey
+		for i := uint32(0); len(keys) < count; i++ {
+			key, err := account.Child(i)
+			if err == ErrInvalidChild {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			keys = append(keys, key)
+
+			pubKey := key.PublicKey()
+			v.Addresses = append(v.Addresses, AddressVector{
+				Seed:         hex.EncodeToString(seed),
+				Path:         fmt.Sprintf("m/0'/%d", i),
+				Xprv:         key.String(),
+				Xpub:         key.Neuter().String(),
+				PubKey:       hex.EncodeToString(pubKey),
+				PubKeyHash:   hex.EncodeToString(HashPubKey(pubKey)),
+				Address:      key.Address(),
+				ScriptPubKey: key.Address(),
+			})
+		}
+
+		for i, key := range keys {
+			next := keys[(i+1)%len(keys)]
+			prevTxid := hex.EncodeToString(HashPubKey(key.PublicKey()))
+			tx, err := NewTxBuilder().
+				AddInput(prevTxid, i, 50, key.Address()).
+				AddOutput(next.Address(), 30).
+				SetChangeAddress(key.Address()).
+				Sign(key).
+				Build()
+			if err != nil {
+				return nil, err
+			}
+
+			v.SigHashes = append(v.SigHashes, SigHashVector{
+				Transaction:      tx,
+				InputIndex:       0,
+				PrevScriptPubKey: key.Address(),
+				Preimage:         hex.EncodeToString(SigHashPreimage(tx, 0, key.Address())),
+				SigHash:          hex.EncodeToString(SigHash(tx, 0, key.Address())),
+				PubKey:           hex.EncodeToString(tx.Vin[0].PubKey),
+				Signature:        hex.EncodeToString(tx.Vin[0].Signature),
+			})
+		}
+	}
+
+	return v, nil
+}
+
+// Verify recomputes every vector and returns the first mismatch
+func (v *Vectors) Verify() error {
+	for i, av := range v.Addresses {
+		if err := av.verify(); err != nil {
+			return fmt.Errorf("address vector %d: %v", i, err)
+		}
+	}
+	for i, sv := range v.SigHashes {
+		if err := sv.verify(); err != nil {
+			return fmt.Errorf("sighash vector %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+func (av AddressVector) verify() error {
+	seed, err := hex.DecodeString(av.Seed)
+	if err != nil {
+		return err
+	}
+	var index uint32
+	if _, err := fmt.Sscanf(av.Path, "m/0'/%d", &index); err != nil {
+		return fmt.Errorf("unsupported path %q", av.Path)
+	}
+
+	master, err := NewMasterKey(seed)
+	if err != nil {
+		return err
+	}
+	account, err := master.Child(HardenedKeyStart)
+	if err != nil {
+		return err
+	}
+	key, err := account.Child(index)
+	if err != nil {
+		return err
+	}
+	pubKey := key.PublicKey()
+
+	checks := []struct{ name, got, want string }{
+		{"xprv", key.String(), av.Xprv},
+		{"xpub", key.Neuter().String(), av.Xpub},
+		{"public key", hex.EncodeToString(pubKey), av.PubKey},
+		{"public key hash", hex.EncodeToString(HashPubKey(pubKey)), av.PubKeyHash},
+		{"address", AddressFromPubKey(pubKey), av.Address},
+		{"script", AddressFromPubKey(pubKey), av.ScriptPubKey},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			return fmt.Errorf("%s is %s, want %s", c.name, c.got, c.want)
+		}
+	}
+
+	derived, err := DeriveAddress(account.Neuter().String(), index)
+	if err != nil {
+		return err
+	}
+	if derived.Address != av.Address {
+		return fmt.Errorf("public derivation gives %s, want %s", derived.Address, av.Address)
+	}
+	return nil
+}
+
+func (sv SigHashVector) verify() error {
+	preimage := SigHashPreimage(sv.Transaction, sv.InputIndex, sv.PrevScriptPubKey)
+	if got := hex.EncodeToString(preimage); got != sv.Preimage {
+		return fmt.Errorf("preimage is %s, want %s", got, sv.Preimage)
+	}
+	digest := SigHash(sv.Transaction, sv.InputIndex, sv.PrevScriptPubKey)
+	if got := hex.EncodeToString(digest); got != sv.SigHash {
+		return fmt.Errorf("sighash is %s, want %s", got, sv.SigHash)
+	}
+
+	pubKey, err := hex.DecodeString(sv.PubKey)
+	if err != nil {
+		return err
+	}
+	signature, err := hex.DecodeString(sv.Signature)
+	if err != nil {
+		return err
+	}
+	in := sv.Transaction.Vin[sv.InputIndex]
+	if !bytes.Equal(in.PubKey, pubKey) || !bytes.Equal(in.Signature, signature) {
+		return fmt.Errorf("input %d doesn't carry the vector's key and signature", sv.InputIndex)
+	}
+	if AddressFromPubKey(pubKey) != sv.PrevScriptPubKey {
+		return fmt.Errorf("public key doesn't match %s", sv.PrevScriptPubKey)
+	}
+	if !VerifySignature(pubKey, digest, signature) {
+		return fmt.Errorf("signature doesn't verify")
+	}
+	return nil
+}
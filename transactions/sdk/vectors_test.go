@@ -0,0 +1,36 @@
+package sdk
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+)
+
+// TestVectors checks the published vectors against this implementation, and
+// that regenerating them gives the same file
+func TestVectors(t *testing.T) {
+	data, err := os.ReadFile("testdata/vectors.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v Vectors
+	if err := json.Unmarshal(data, &v); err != nil {
+		t.Fatal(err)
+	}
+	if err := v.Verify(); err != nil {
+		t.Fatal(err)
+	}
+
+	generated, err := GenerateVectors(VectorSeeds(3), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := json.MarshalIndent(generated, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(encoded, data) {
+		t.Fatal("regenerated vectors differ from testdata/vectors.json; after a deliberate change run go generate, never edit the file by hand")
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/VOOVOOZEL/go_blockchain/transactions/sdk"
+)
+
+// search result types
+const (
+	SearchBlock       = "block"
+	SearchTransaction = "transaction"
+	SearchAddress     = "address"
+)
+
+// SearchResult is what a search query names; Type tells which of the other
+// fields is set
+type SearchResult struct {
+	Type        string
+	Block       *BlockInfo       `json:",omitempty"`
+	Transaction *TransactionInfo `json:",omitempty"`
+	Address     *BalanceInfo     `json:",omitempty"`
+}
+
+// search looks q up as a block hash or transaction ID, a block height on the
+// active chain, then an address. An address matches when it's well formed
+// or has outputs on the chain or in the mempool.
+func search(q string) (SearchResult, bool) {
+	if len(q) == 64 {
+		if _, err := hex.DecodeString(q); err == nil {
+			q = strings.ToLower(q)
+			if info, ok := bc.lookupBlock(q); ok {
+				return SearchResult{Type: SearchBlock, Block: info}, true
+			}
+			if info, ok := lookupTransaction(q); ok {
+				return SearchResult{Type: SearchTransaction, Transaction: info}, true
+			}
+			return SearchResult{}, false
+		}
+	}
+	if height, err := strconv.Atoi(q); err == nil && height >= 0 {
+		if info, ok := bc.lookupHeight(height); ok {
+			return SearchResult{Type: SearchBlock, Block: info}, true
+		}
+	}
+	if checkAddress(q) != nil {
+		return SearchResult{}, false
+	}
+	balance := bc.balanceInfo(q)
+	if sdk.ValidateAddress(q) || balance.UTXOCount > 0 || balance.Unconfirmed != 0 {
+		return SearchResult{Type: SearchAddress, Address: &balance}, true
+	}
+	return SearchResult{}, false
+}
+
+// finds the block, transaction or address named by ?q=
+func handleSearch(w http.ResponseWriter, r *http.Request) {
+	q := strings.TrimSpace(r.URL.Query().Get("q"))
+	if q == "" {
+		respondWithError(w, r, http.StatusBadRequest, "q is required")
+		return
+	}
+	result, ok := search(q)
+	if !ok {
+		respondWithError(w, r, http.StatusNotFound, "Nothing matches the query")
+		return
+	}
+	respondWithJSON(w, r, http.StatusOK, result)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// shutdownTimeout bounds how long in-flight requests may take to finish
+const shutdownTimeout = 30 * time.Second
+
+// errMiningAborted is returned when the node shuts down while mining
+var errMiningAborted = errors.New("ERROR: Mining aborted, the node is shutting down")
+
+// shutdownCtx is canceled when the node starts shutting down. Mining and the
+// HTTP requests watch it.
+var shutdownCtx, beginShutdown = context.WithCancel(context.Background())
+
+// stopRequests asks run to shut the node down like a SIGTERM would
+var stopRequests = make(chan struct{}, 1)
+
+// shutdown stops mining, lets in-flight requests of the servers finish,
+// disconnects the peers and writes everything the node keeps on disk
+func shutdown(servers ...*http.Server) error {
+	beginShutdown()
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	var err error
+	for _, s := range servers {
+		if serr := s.Shutdown(ctx); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	if err != nil {
+		httpLog.Warn("HTTP requests still running", "after", shutdownTimeout, "err", err)
+	}
+	if grpcServer != nil {
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			grpcServer.Stop()
+		}
+	}
+	peerManager.closeAll()
+
+	flushState()
+	return err
+}
+
+// flushState waits for running block and archive writes, refuses further
+// ones, and saves the peer and light client state
+func flushState() {
+	if archive != nil {
+		// held until exit so no archive update starts
+		archive.Lock()
+	}
+	bc.Lock()
+	bc.store.Close()
+	bc.utxo.Close()
+	bc.Unlock()
+
+	peerStats.save()
+	addrBook.save()
+	stopTracing()
+	if audit != nil {
+		audit.Close()
+	}
+	if light != nil {
+		if err := light.save(); err != nil {
+			log.Printf("Can't save light client state: %v", err)
+		}
+	}
+}
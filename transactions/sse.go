@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// sseKeepAlive is how often an idle event stream gets a comment line, so
+// proxies don't close it
+const sseKeepAlive = 15 * time.Second
+
+// HeaderEvent is the data of a block event on /events
+type HeaderEvent struct {
+	Height int
+	BlockHeader
+}
+
+// blocksSince returns the active chain blocks a client that last saw hash is
+// missing, together with the height of the first one. If hash was on a
+// branch that has since been reorganized away, the blocks start after the
+// fork point. An unknown hash returns nothing.
+func (bc *Blockchain) blocksSince(hash string) ([]*Block, int) {
+	bc.RLock()
+	defer bc.RUnlock()
+
+	b, ok := bc.known[hash]
+	if !ok {
+		return nil, 0
+	}
+	for ; b != nil; b = bc.known[b.PrevHash] {
+		if height := bc.heightOf(b.Hash); height >= 0 {
+			return append([]*Block{}, bc.blocks[height+1:]...), height + 1
+		}
+	}
+	return nil, 0
+}
+
+// streams the headers of new active chain blocks as Server-Sent Events.
+// Event IDs are block hashes, so a client reconnecting with Last-Event-ID
+// first gets the blocks it missed.
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		respondWithError(w, r, http.StatusInternalServerError, "Streaming is not supported")
+		return
+	}
+
+	// subscribe before reading the backlog so nothing falls in between
+	events := chainEvents.Subscribe(BlockConnected)
+	defer chainEvents.Unsubscribe(events)
+
+	keepWriting(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	sent := make(map[string]bool)
+	if last := r.Header.Get("Last-Event-ID"); last != "" {
+		missed, height := bc.blocksSince(last)
+		for i, b := range missed {
+			if err := writeBlockEvent(w, b, height+i); err != nil {
+				return
+			}
+			sent[b.Hash] = true
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			b, isBlock := e.Block()
+			if !isBlock || sent[b.Hash] {
+				continue
+			}
+			bc.RLock()
+			height := bc.blockHeight(b)
+			bc.RUnlock()
+			if err := writeBlockEvent(w, b, height); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+func writeBlockEvent(w http.ResponseWriter, b *Block, height int) error {
+	data, err := json.Marshal(HeaderEvent{height, b.Header()})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %s\nevent: block\ndata: %s\n\n", b.Hash, data)
+	return err
+}
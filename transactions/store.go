@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// errStoreClosed is returned for writes after the node shut down its store
+var errStoreClosed = errors.New("ERROR: Block store is closed")
+
+// BlockStore keeps every accepted block on disk, one gob file per block
+type BlockStore struct {
+	dir    string
+	closed atomic.Bool
+}
+
+// OpenBlockStore opens or creates a block store below dir
+func OpenBlockStore(dir string) (*BlockStore, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "blocks"), 0755); err != nil {
+		return nil, err
+	}
+	return &BlockStore{dir: dir}, nil
+}
+
+func (s *BlockStore) blockPath(hash string) string {
+	return filepath.Join(s.dir, "blocks", hash+".gob")
+}
+
+// Put writes a block unless it is already stored. The file is written under
+// a temporary name and renamed, so a crash never leaves a partial block.
+func (s *BlockStore) Put(b *Block) error {
+	if s.closed.Load() {
+		return errStoreClosed
+	}
+	path := s.blockPath(b.Hash)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+
+	var encoded bytes.Buffer
+	if err := gob.NewEncoder(&encoded).Encode(b); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, encoded.Bytes())
+}
+
+// Close refuses further writes. Writes are synchronous, so once the caller
+// knows none is running, everything accepted is on disk.
+func (s *BlockStore) Close() {
+	s.closed.Store(true)
+}
+
+// Get reads and decodes a block, also returning its encoded size
+func (s *BlockStore) Get(hash string) (*Block, int, error) {
+	data, err := os.ReadFile(s.blockPath(hash))
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var b Block
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&b); err != nil {
+		return nil, 0, err
+	}
+	return &b, len(data), nil
+}
+
+// Hashes lists the hashes of all stored blocks
+func (s *BlockStore) Hashes() ([]string, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, "blocks"))
+	if err != nil {
+		return nil, err
+	}
+
+	var hashes []string
+	for _, e := range entries {
+		if hash, ok := strings.CutSuffix(e.Name(), ".gob"); ok {
+			hashes = append(hashes, hash)
+		}
+	}
+	return hashes, nil
+}
+
+// Genesis returns the hash of the stored chain's genesis block, or "" for
+// an empty store
+func (s *BlockStore) Genesis() (string, error) {
+	data, err := os.ReadFile(filepath.Join(s.dir, "genesis"))
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	return strings.TrimSpace(string(data)), err
+}
+
+// SetGenesis records the genesis block of the stored chain
+func (s *BlockStore) SetGenesis(b *Block) error {
+	if err := s.Put(b); err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(s.dir, "genesis"), []byte(b.Hash+"\n"))
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	// syncTolerance is how many blocks behind the best known height the node
+	// may be and still count as synced
+	syncTolerance = 1
+	// syncRateWindow is how far back the download rate is measured
+	syncRateWindow = 2 * time.Minute
+)
+
+// SyncStatus tells operators whether the node has caught up with the network
+type SyncStatus struct {
+	InitialSync bool
+	Height      int
+	// HeaderHeight is the height of the best header chain downloaded
+	HeaderHeight int
+	// PeerHeight is the highest height a connected peer reported, -1 with no
+	// peers
+	PeerHeight int
+	Peers      int
+	// Progress is Height as a fraction of the best known height
+	Progress float64
+	// BlocksPerSecond is the recent download rate
+	BlocksPerSecond float64
+	// EstimatedCompletion is when the sync should be done at that rate, if
+	// the node is syncing and making progress
+	EstimatedCompletion *time.Time `json:",omitempty"`
+}
+
+type heightSample struct {
+	at     time.Time
+	height int
+}
+
+// syncTracker keeps recent heights to measure the download rate
+type syncTracker struct {
+	sync.Mutex
+	samples []heightSample
+}
+
+var syncProgress = &syncTracker{}
+
+// sample records the current height
+func (t *syncTracker) sample(height int) {
+	t.Lock()
+	defer t.Unlock()
+
+	now := time.Now()
+	t.samples = append(t.samples, heightSample{now, height})
+	for len(t.samples) > 2 && now.Sub(t.samples[0].at) > syncRateWindow {
+		t.samples = t.samples[1:]
+	}
+}
+
+// rate returns blocks per second over the window
+func (t *syncTracker) rate() float64 {
+	t.Lock()
+	defer t.Unlock()
+
+	if len(t.samples) < 2 {
+		return 0
+	}
+	first, last := t.samples[0], t.samples[len(t.samples)-1]
+	elapsed := last.at.Sub(first.at).Seconds()
+	if elapsed <= 0 || last.height <= first.height {
+		return 0
+	}
+	return float64(last.height-first.height) / elapsed
+}
+
+// syncStatus reports how far the node is from the best height it knows of
+func syncStatus() SyncStatus {
+	height := localHeight()
+	s := SyncStatus{Height: height, HeaderHeight: bc.headers.bestHeight(), PeerHeight: -1, BlocksPerSecond: syncProgress.rate()}
+	peerManager.Lock()
+	for p := range peerManager.peers {
+		s.Peers++
+		p.Lock()
+		if p.version != nil && p.version.Height > s.PeerHeight {
+			s.PeerHeight = p.version.Height
+		}
+		p.Unlock()
+	}
+	peerManager.Unlock()
+
+	target := s.HeaderHeight
+	if s.PeerHeight > target {
+		target = s.PeerHeight
+	}
+	s.Progress = 1
+	if target > 0 && height < target {
+		s.Progress = float64(height) / float64(target)
+	}
+	s.InitialSync = target-height > syncTolerance
+	if s.InitialSync && s.BlocksPerSecond > 0 {
+		eta := time.Now().Add(time.Duration(float64(target-height) / s.BlocksPerSecond * float64(time.Second)))
+		s.EstimatedCompletion = &eta
+	}
+	return s
+}
+
+// reports sync progress
+func handleGetSync(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, r, http.StatusOK, syncStatus())
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracing: a transaction can be followed from the HTTP request submitting it
+// through validation and mempool admission to the block assembled, mined and
+// committed with it. The spans are exported over OTLP/HTTP once
+// OTEL_EXPORTER_OTLP_ENDPOINT (or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT) is
+// set; the other OTEL_* variables configure the exporter as usual.
+
+// tracer makes the node's spans. It does nothing until startTracing
+// installs an exporting provider.
+var tracer = otel.Tracer("github.com/VOOVOOZEL/go_blockchain/transactions")
+
+// traceFlushTimeout bounds how long the last spans take to export on shutdown
+const traceFlushTimeout = 5 * time.Second
+
+// tracerProvider is nil unless spans are exported
+var tracerProvider *sdktrace.TracerProvider
+
+// startTracing exports spans when an OTLP endpoint is configured
+func startTracing() {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" {
+		return
+	}
+
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		log.Fatalf("OTLP trace exporter: %v", err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", "blockchain"),
+		attribute.String("blockchain.network", params.Name),
+	))
+	if err != nil {
+		log.Fatalf("OTLP trace resource: %v", err)
+	}
+	tracerProvider = sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tracerProvider)
+	log.Println("Exporting traces over OTLP")
+}
+
+// stopTracing exports the spans still buffered
+func stopTracing() {
+	if tracerProvider == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), traceFlushTimeout)
+	defer cancel()
+	if err := tracerProvider.Shutdown(ctx); err != nil {
+		log.Printf("Can't export the last traces: %v", err)
+	}
+}
+
+// tracingMiddleware starts a server span for every request, continuing the
+// trace of a traceparent header
+func tracingMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unmatched"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", r.URL.Path),
+			))
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		span.SetAttributes(attribute.Int("http.response.status_code", rec.status))
+		if rec.status >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
+
+// endSpan ends a span, marking it failed with err
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// blockAttributes describes a block on its spans
+func blockAttributes(b *Block) trace.SpanStartEventOption {
+	return trace.WithAttributes(
+		attribute.String("block.hash", b.Hash),
+		attribute.String("block.prev_hash", b.PrevHash),
+		attribute.Int("block.transactions", len(b.Transactions)),
+	)
+}
+
+// commitBlock validates and stores a block this node mined
+func commitBlock(ctx context.Context, b *Block) error {
+	_, span := tracer.Start(ctx, "commit block", blockAttributes(b))
+	err := bc.ProcessBlock(b)
+	endSpan(span, err)
+	return err
+}
+
+// txAttribute names the transaction a span is about
+func txAttribute(tx *Transaction) attribute.KeyValue {
+	return attribute.String("tx.id", tx.ID)
+}
@@ -8,6 +8,8 @@ import (
 	"errors"
 	"fmt"
 	"log"
+
+	"github.com/VOOVOOZEL/go_blockchain/transactions/sdk"
 )
 
 const subsidy = 10
@@ -38,16 +40,89 @@ func (tx *Transaction) SetID() {
 	tx.ID = hex.EncodeToString(hash[:])
 }
 
-// TXInput represents a transaction input
+// Verify checks that every input is unlocked by the owner of the output it
+// spends; prevOuts holds those outputs in input order. Outputs locked to a
+// key address need a signature: the input must carry the public key of the
+// address and a valid signature over the input's sighash, which commits to
+// every input and output, so the transaction can't be altered once signed.
+// Unsigned inputs giving the address as ScriptSig only unlock lottery pots,
+// whose payouts checkLotteryRules fixes, and, where the network's
+// UnsignedNames allows it, name addresses, which have no key. Either way
+// ScriptSig names the address spent; the sighash leaves it out, so it may
+// not say anything else.
+func (tx *Transaction) Verify(prevOuts []TXOutput) error {
+	for i, in := range tx.Vin {
+		prev := prevOuts[i]
+		if !in.CanUnlockOutputWith(prev.ScriptPubKey) {
+			return fmt.Errorf("ERROR: Input %d of %s can't unlock its output", i, tx.ID)
+		}
+		if len(in.Signature) == 0 {
+			if sdk.ValidateAddress(prev.ScriptPubKey) {
+				return fmt.Errorf("ERROR: Input %d of %s spends from key address %s without a signature", i, tx.ID, prev.ScriptPubKey)
+			}
+			if !params.UnsignedNames && lotteryByPot(prev.ScriptPubKey) == nil {
+				return fmt.Errorf("ERROR: Input %d of %s spends from %s without a signature", i, tx.ID, prev.ScriptPubKey)
+			}
+			continue
+		}
+
+		if sdk.AddressFromPubKey(in.PubKey) != prev.ScriptPubKey {
+			return fmt.Errorf("ERROR: Input %d of %s has a foreign public key", i, tx.ID)
+		}
+		digest := sdk.SigHash(tx.sdkTransaction(), i, prev.ScriptPubKey)
+		if !sdk.VerifySignature(in.PubKey, digest, in.Signature) {
+			return fmt.Errorf("ERROR: Input %d of %s has an invalid signature", i, tx.ID)
+		}
+	}
+
+	return nil
+}
+
+// sign signs every input with signer, for transactions the node builds from
+// a key address it holds, and sets the ID the signatures change. Inputs
+// name the address they spend as ScriptSig, which is what they commit to.
+func (tx *Transaction) sign(signer sdk.Signer) error {
+	pubKey := signer.PublicKey()
+	trimmed := tx.sdkTransaction()
+	for i := range tx.Vin {
+		sig, err := signer.Sign(sdk.SigHash(trimmed, i, tx.Vin[i].ScriptSig))
+		if err != nil {
+			return err
+		}
+		tx.Vin[i].Signature, tx.Vin[i].PubKey = sig, pubKey
+	}
+	tx.ID = ""
+	tx.SetID()
+	return nil
+}
+
+// sdkTransaction converts tx to the SDK representation sighashes are defined on
+func (tx *Transaction) sdkTransaction() *sdk.Transaction {
+	out := &sdk.Transaction{ID: tx.ID}
+	for _, in := range tx.Vin {
+		out.Vin = append(out.Vin, sdk.TXInput{
+			Txid: in.Txid, Vout: in.Vout, ScriptSig: in.ScriptSig, Signature: in.Signature, PubKey: in.PubKey,
+		})
+	}
+	for _, o := range tx.Vout {
+		out.Vout = append(out.Vout, sdk.TXOutput{Value: int(o.Value), ScriptPubKey: o.ScriptPubKey})
+	}
+	return out
+}
+
+// TXInput represents a transaction input. Signature and PubKey are set on
+// inputs signed by a wallet key; see Verify
 type TXInput struct {
 	Txid      string
 	Vout      int
 	ScriptSig string
+	Signature []byte
+	PubKey    []byte
 }
 
 // TXOutput represents a transaction output
 type TXOutput struct {
-	Value        int
+	Value        Amount
 	ScriptPubKey string
 }
 
@@ -61,35 +136,62 @@ func (out *TXOutput) CanBeUnlockedWith(unlockingData string) bool {
 	return out.ScriptPubKey == unlockingData
 }
 
-// NewCoinbaseTX creates a new coinbase transaction
+// NewCoinbaseTX creates a new coinbase transaction paying the subsidy
 func NewCoinbaseTX(to, data string) *Transaction {
+	return newCoinbaseTX(to, data, subsidy)
+}
+
+func newCoinbaseTX(to, data string, value Amount) *Transaction {
 	if data == "" {
 		data = fmt.Sprintf("Reward to '%s'", to)
 	}
 
-	txin := TXInput{"", -1, data}
-	txout := TXOutput{subsidy, to}
+	txin := TXInput{"", -1, data, nil, nil}
+	txout := TXOutput{value, to}
 	tx := Transaction{"", []TXInput{txin}, []TXOutput{txout}}
 	tx.SetID()
 
 	return &tx
 }
 
-// NewUTXOTransaction creates a new transaction
-func NewUTXOTransaction(from, to string, amount int, bc *Blockchain) (
+// NewMinerCoinbaseTX creates the coinbase of a mined block, paying the
+// subsidy and the fees of the block's transactions. The height makes every
+// coinbase unique and the extranonce widens the miner's search space
+func NewMinerCoinbaseTX(to string, height int, extraNonce uint64, fees Amount) *Transaction {
+	data := fmt.Sprintf("height %d extranonce %d", height, extraNonce)
+	return newCoinbaseTX(to, data, subsidy+fees)
+}
+
+// errNotEnoughFunds is returned when an address can't cover a payment
+var errNotEnoughFunds = errors.New("ERROR: Not enough funds")
+
+// NewUTXOTransaction creates a new transaction. Its inputs are unsigned, so
+// spends from a key address must be signed before they verify, and spends
+// from a name address only verify where the network has UnsignedNames.
+func NewUTXOTransaction(from, to string, amount Amount, bc *Blockchain) (
+	*Transaction, error) {
+	return newSpend(from, to, amount, bc, nil)
+}
+
+// newSpend is NewUTXOTransaction leaving the outputs in skip unspent
+func newSpend(from, to string, amount Amount, bc *Blockchain, skip map[outpoint]bool) (
 	*Transaction, error) {
 	var inputs []TXInput
 	var outputs []TXOutput
 
-	acc, validOutputs := bc.FindSpendableOutputs(from, amount)
+	if !params.UnsignedNames && !sdk.ValidateAddress(from) {
+		return nil, fmt.Errorf("ERROR: Spends from name address %s need a signature on %s", from, params.Name)
+	}
+
+	acc, validOutputs := bc.FindSpendableOutputs(from, amount, skip)
 
 	if acc < amount {
-		return nil, errors.New("ERROR: Not enough funds")
+		return nil, errNotEnoughFunds
 	}
 
 	for txid, outs := range validOutputs {
 		for _, out := range outs {
-			input := TXInput{txid, out, from}
+			input := TXInput{txid, out, from, nil, nil}
 			inputs = append(inputs, input)
 		}
 	}
@@ -104,3 +206,36 @@ func NewUTXOTransaction(from, to string, amount int, bc *Blockchain) (
 
 	return tx, nil
 }
+
+// NewRefundTransaction creates a transaction that returns the amount 'from'
+// received in transaction txid. The refund goes to 'to' when given, otherwise
+// to the address that funded the payment's first input
+func NewRefundTransaction(txid, from, to string, bc *Blockchain) (
+	*Transaction, error) {
+	payment, err := bc.FindTransaction(txid)
+	if err != nil {
+		return nil, err
+	}
+	if payment.IsCoinbase() {
+		return nil, errors.New("ERROR: Coinbase transactions can't be refunded")
+	}
+
+	var amount Amount
+	for _, out := range payment.Vout {
+		if out.CanBeUnlockedWith(from) {
+			amount += out.Value
+		}
+	}
+	if amount == 0 {
+		return nil, fmt.Errorf("ERROR: Transaction %s paid nothing to %s", txid, from)
+	}
+
+	if to == "" {
+		if len(payment.Vin) == 0 {
+			return nil, fmt.Errorf("ERROR: Transaction %s has no sender to refund, give a refund address", txid)
+		}
+		to = payment.Vin[0].ScriptSig
+	}
+
+	return NewUTXOTransaction(from, to, amount, bc)
+}
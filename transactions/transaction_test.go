@@ -0,0 +1,142 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/VOOVOOZEL/go_blockchain/transactions/sdk"
+)
+
+func TestVerify(t *testing.T) {
+	key, err := sdk.NewMasterKey([]byte("transaction test seed, 32 bytes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := key.Child(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lottery := Lottery{Name: "weekly", TicketPrice: 2, CloseHeight: 10}
+
+	keyCoins := testCoinbase(key.Address(), "k")
+	nameCoins := testCoinbase("alice", "a")
+	potCoins := testCoinbase(lottery.Pot(), "p")
+
+	signed := func(prev *Transaction, signer sdk.Signer, outs ...TXOutput) *Transaction {
+		tx := testSpend(prev, []int{0}, outs...)
+		if err := tx.sign(signer); err != nil {
+			t.Fatal(err)
+		}
+		return tx
+	}
+
+	tests := []struct {
+		name          string
+		prev          *Transaction
+		tx            func() *Transaction
+		unsignedNames bool
+		wantErr       string
+	}{
+		{
+			name: "signed key address spend",
+			prev: keyCoins,
+			tx:   func() *Transaction { return signed(keyCoins, key, TXOutput{4, "bob"}, TXOutput{6, key.Address()}) },
+		},
+		{
+			name: "output rewritten after signing",
+			prev: keyCoins,
+			tx: func() *Transaction {
+				tx := signed(keyCoins, key, TXOutput{4, "bob"}, TXOutput{6, key.Address()})
+				tx.Vout[0].ScriptPubKey = "mallory"
+				return tx
+			},
+			wantErr: "invalid signature",
+		},
+		{
+			name: "value rewritten after signing",
+			prev: keyCoins,
+			tx: func() *Transaction {
+				tx := signed(keyCoins, key, TXOutput{4, "bob"}, TXOutput{6, key.Address()})
+				tx.Vout[0].Value, tx.Vout[1].Value = 10, 0
+				return tx
+			},
+			wantErr: "invalid signature",
+		},
+		{
+			name:    "signed by another key",
+			prev:    keyCoins,
+			tx:      func() *Transaction { return signed(keyCoins, other, TXOutput{10, "bob"}) },
+			wantErr: "foreign public key",
+		},
+		{
+			name:    "unsigned key address spend",
+			prev:    keyCoins,
+			tx:      func() *Transaction { return testSpend(keyCoins, []int{0}, TXOutput{10, "bob"}) },
+			wantErr: "without a signature",
+		},
+		{
+			name:    "unsigned name address spend",
+			prev:    nameCoins,
+			tx:      func() *Transaction { return testSpend(nameCoins, []int{0}, TXOutput{10, "bob"}) },
+			wantErr: "without a signature",
+		},
+		{
+			name:          "unsigned name address spend with UnsignedNames",
+			prev:          nameCoins,
+			tx:            func() *Transaction { return testSpend(nameCoins, []int{0}, TXOutput{10, "bob"}) },
+			unsignedNames: true,
+		},
+		{
+			name: "unsigned lottery pot spend",
+			prev: potCoins,
+			tx:   func() *Transaction { return testSpend(potCoins, []int{0}, TXOutput{10, "bob"}) },
+		},
+		{
+			name: "input naming another address",
+			prev: nameCoins,
+			tx: func() *Transaction {
+				tx := testSpend(nameCoins, []int{0}, TXOutput{10, "bob"})
+				tx.Vin[0].ScriptSig = "bob"
+				return tx
+			},
+			unsignedNames: true,
+			wantErr:       "can't unlock",
+		},
+	}
+
+	saved := params
+	defer func() { params = saved }()
+	params.Lotteries = []Lottery{lottery}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params.UnsignedNames = tt.unsignedNames
+			err := tt.tx().Verify(tt.prev.Vout)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAssignTransactionID(t *testing.T) {
+	tx := testSpend(testCoinbase("alice", "a"), []int{0}, TXOutput{10, "bob"})
+	want := tx.ID
+
+	tx.ID = ""
+	if err := assignTransactionID(tx); err != nil || tx.ID != want {
+		t.Fatalf("missing ID assigned %q, %v; want %q", tx.ID, err, want)
+	}
+	if err := assignTransactionID(tx); err != nil {
+		t.Fatalf("matching ID: %v", err)
+	}
+	tx.ID = strings.Repeat("0", len(want))
+	if err := assignTransactionID(tx); err == nil || tx.ID == want {
+		t.Fatal("mismatched ID replaced instead of rejected")
+	}
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// outpoint identifies a single transaction output
+type outpoint struct {
+	Txid string
+	Vout int
+}
+
+// spentOutput is undo data recorded when a block spends an output, so the
+// output can be restored if the block is disconnected
+type spentOutput struct {
+	outpoint
+	Output TXOutput
+}
+
+// UTXOSet holds every unspent output of the active chain
+type UTXOSet map[outpoint]TXOutput
+
+// utxoView stages changes on top of a UTXOSet so that a whole series of block
+// connects and disconnects can be applied atomically or thrown away
+type utxoView struct {
+	base  UTXOSet
+	added map[outpoint]TXOutput
+	spent map[outpoint]bool
+	// cache, when the view was made by one, commits the changes instead
+	cache *UTXOCache
+}
+
+func newUTXOView(base UTXOSet) *utxoView {
+	return &utxoView{base: base, added: make(map[outpoint]TXOutput), spent: make(map[outpoint]bool)}
+}
+
+func (v *utxoView) get(op outpoint) (TXOutput, bool) {
+	if v.spent[op] {
+		return TXOutput{}, false
+	}
+	if out, ok := v.added[op]; ok {
+		return out, true
+	}
+	out, ok := v.base[op]
+	return out, ok
+}
+
+func (v *utxoView) add(op outpoint, out TXOutput) {
+	delete(v.spent, op)
+	v.added[op] = out
+}
+
+func (v *utxoView) spend(op outpoint) {
+	delete(v.added, op)
+	v.spent[op] = true
+}
+
+// commit writes the staged changes into the underlying set
+func (v *utxoView) commit() {
+	if v.cache != nil {
+		for op := range v.spent {
+			v.cache.spend(op)
+		}
+		for op, out := range v.added {
+			v.cache.add(op, out)
+		}
+		return
+	}
+	for op := range v.spent {
+		delete(v.base, op)
+	}
+	for op, out := range v.added {
+		v.base[op] = out
+	}
+}
+
+// connectTransaction spends the inputs and adds the outputs of tx, returning
+// the spent outputs as undo data
+func (v *utxoView) connectTransaction(tx *Transaction) ([]spentOutput, error) {
+	var undo []spentOutput
+
+	if !tx.IsCoinbase() {
+		for _, in := range tx.Vin {
+			op := outpoint{in.Txid, in.Vout}
+			out, ok := v.get(op)
+			if !ok {
+				return nil, fmt.Errorf("ERROR: Transaction %s spends missing output %s:%d", tx.ID, in.Txid, in.Vout)
+			}
+			v.spend(op)
+			undo = append(undo, spentOutput{op, out})
+		}
+	}
+	for idx, out := range tx.Vout {
+		v.add(outpoint{tx.ID, idx}, out)
+	}
+
+	return undo, nil
+}
+
+// connectBlock validates and applies the transactions of b in order, so one
+// may spend the outputs of an earlier one but no output is spent twice, and
+// returns the block's undo data. chain holds the blocks below b, which the
+// lottery rules draw on; they see each transaction against the outputs of
+// the ones before it. Signatures are left out when verifyBlocks already
+// checked them.
+func (v *utxoView) connectBlock(b *Block, chain []*Block, verified bool) ([]spentOutput, error) {
+	var undo []spentOutput
+
+	if len(b.Transactions) == 0 || !b.Transactions[0].IsCoinbase() {
+		return nil, errors.New("ERROR: The first transaction of a block must be its coinbase")
+	}
+	var fees Amount
+	for i, tx := range b.Transactions {
+		if i > 0 {
+			if tx.IsCoinbase() {
+				return nil, fmt.Errorf("ERROR: Transaction %d is a second coinbase", i)
+			}
+			prevOuts, err := v.checkInputs(tx)
+			if err == nil && !verified {
+				err = tx.Verify(prevOuts)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("ERROR: Transaction %s: %v", tx.ID, err)
+			}
+		}
+		if err := checkLotteryRules(v, tx, len(chain), chain); err != nil {
+			return nil, err
+		}
+		spent, err := v.connectTransaction(tx)
+		if err != nil {
+			return nil, err
+		}
+		if !addAmount(&fees, transactionFee(tx, spent)) {
+			return nil, fmt.Errorf("ERROR: Block fees exceed %d", maxMoney)
+		}
+		undo = append(undo, spent...)
+	}
+	if err := checkCoinbaseValue(b.Transactions[0], fees); err != nil {
+		return nil, err
+	}
+
+	return undo, nil
+}
+
+// transactionFee is what a transaction's inputs, spent as spent, leave over
+// its outputs; a coinbase pays none. The transaction must have passed
+// checkInputs, which keeps the sums in range.
+func transactionFee(tx *Transaction, spent []spentOutput) Amount {
+	if tx.IsCoinbase() {
+		return 0
+	}
+	var fee Amount
+	for _, s := range spent {
+		fee += s.Output.Value
+	}
+	for _, out := range tx.Vout {
+		fee -= out.Value
+	}
+	return fee
+}
+
+// checkCoinbaseValue makes sure a coinbase pays exactly the subsidy and the
+// fees of its block, in positive outputs
+func checkCoinbaseValue(coinbase *Transaction, fees Amount) error {
+	var value Amount
+	for _, out := range coinbase.Vout {
+		if out.Value <= 0 {
+			return errors.New("ERROR: Coinbase output values must be positive")
+		}
+		if !addAmount(&value, out.Value) {
+			return fmt.Errorf("ERROR: Coinbase outputs exceed %d", maxMoney)
+		}
+	}
+	if value != subsidy+fees {
+		return fmt.Errorf("ERROR: Coinbase pays %d, not the subsidy %d plus fees %d", value, subsidy, fees)
+	}
+	return nil
+}
+
+// disconnectBlock reverts connectBlock using the undo data recorded for b.
+// Outputs the block both created and spent are not restored.
+func (v *utxoView) disconnectBlock(b *Block, undo []spentOutput) {
+	created := make(map[string]bool, len(b.Transactions))
+	for i := len(b.Transactions) - 1; i >= 0; i-- {
+		tx := b.Transactions[i]
+		created[tx.ID] = true
+		for idx := range tx.Vout {
+			v.spend(outpoint{tx.ID, idx})
+		}
+	}
+	for _, s := range undo {
+		if !created[s.Txid] {
+			v.add(s.outpoint, s.Output)
+		}
+	}
+}
+
+// checkTransaction verifies a non-coinbase transaction against the view:
+// every input must spend a distinct unspent output and be unlocked by its
+// owner, and outputs must be positive, within maxMoney and may not exceed
+// the inputs
+func (v *utxoView) checkTransaction(tx *Transaction) error {
+	prevOuts, err := v.checkInputs(tx)
+	if err != nil {
+		return err
+	}
+	return tx.Verify(prevOuts)
+}
+
+// checkInputs is checkTransaction without the signatures. It returns the
+// outputs the inputs spend.
+func (v *utxoView) checkInputs(tx *Transaction) ([]TXOutput, error) {
+	if len(tx.Vin) == 0 || len(tx.Vout) == 0 {
+		return nil, errors.New("ERROR: Transaction needs inputs and outputs")
+	}
+
+	var prevOuts []TXOutput
+	seen := make(map[outpoint]bool)
+	var in, out Amount
+	for _, vin := range tx.Vin {
+		op := outpoint{vin.Txid, vin.Vout}
+		prev, ok := v.get(op)
+		if !ok || seen[op] {
+			return nil, fmt.Errorf("ERROR: Output %s:%d is missing or already spent", vin.Txid, vin.Vout)
+		}
+		seen[op] = true
+		prevOuts = append(prevOuts, prev)
+		if !addAmount(&in, prev.Value) {
+			return nil, fmt.Errorf("ERROR: Inputs exceed %d", maxMoney)
+		}
+	}
+	for _, vout := range tx.Vout {
+		if vout.Value <= 0 {
+			return nil, errors.New("ERROR: Output values must be positive")
+		}
+		if !addAmount(&out, vout.Value) {
+			return nil, fmt.Errorf("ERROR: Outputs exceed %d", maxMoney)
+		}
+	}
+	if out > in {
+		return nil, fmt.Errorf("ERROR: Outputs (%d) exceed inputs (%d)", out, in)
+	}
+
+	return prevOuts, nil
+}
@@ -0,0 +1,562 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// testCoinbase pays the subsidy to address; tag keeps coinbase IDs distinct
+func testCoinbase(to, tag string) *Transaction {
+	return newCoinbaseTX(to, tag, subsidy)
+}
+
+// testSpend spends the given outputs of prev into outs without signing
+func testSpend(prev *Transaction, vouts []int, outs ...TXOutput) *Transaction {
+	tx := &Transaction{Vout: outs}
+	for _, vout := range vouts {
+		tx.Vin = append(tx.Vin, TXInput{prev.ID, vout, prev.Vout[vout].ScriptPubKey, nil, nil})
+	}
+	tx.SetID()
+	return tx
+}
+
+func testBlock(txs ...*Transaction) *Block {
+	return &Block{Transactions: txs}
+}
+
+// utxoDesc lists the unspent outputs of address as txid:vout=value, in the
+// order coin selection sees them
+func utxoDesc(c *UTXOCache, address string) []string {
+	var desc []string
+	for _, e := range c.outputs(address) {
+		desc = append(desc, fmt.Sprintf("%s:%d=%d", e.Txid, e.Vout, e.Output.Value))
+	}
+	return desc
+}
+
+func TestUTXOEngine(t *testing.T) {
+	alice := testCoinbase("alice", "a")
+	carol := testCoinbase("carol", "c")
+	pay := testSpend(alice, []int{0}, TXOutput{3, "bob"}, TXOutput{7, "alice"})
+	self := testSpend(alice, []int{0}, TXOutput{4, "alice"}, TXOutput{6, "alice"})
+	onward := testSpend(pay, []int{0, 1}, TXOutput{10, "carol"})
+
+	utxo := func(tx *Transaction, vout int) string {
+		return fmt.Sprintf("%s:%d=%d", tx.ID, vout, tx.Vout[vout].Value)
+	}
+	sorted := func(ops ...string) []string {
+		sort.Strings(ops)
+		return ops
+	}
+
+	tests := []struct {
+		name    string
+		blocks  []*Block
+		want    map[string][]string
+		balance map[string]Amount
+		wantErr string
+	}{
+		{
+			name:    "coinbase only",
+			blocks:  []*Block{testBlock(alice)},
+			want:    map[string][]string{"alice": {utxo(alice, 0)}},
+			balance: map[string]Amount{"alice": subsidy},
+		},
+		{
+			name: "empty blocks before and after a payment",
+			blocks: []*Block{
+				testBlock(alice),
+				testBlock(testCoinbase("miner", "1")),
+				testBlock(testCoinbase("miner", "2"), pay),
+				testBlock(testCoinbase("miner", "3")),
+			},
+			want: map[string][]string{
+				"alice": {utxo(pay, 1)},
+				"bob":   {utxo(pay, 0)},
+			},
+			balance: map[string]Amount{"alice": 7, "bob": 3, "miner": 3 * subsidy},
+		},
+		{
+			name:   "change output",
+			blocks: []*Block{testBlock(alice), testBlock(carol, pay)},
+			want: map[string][]string{
+				"alice": {utxo(pay, 1)},
+				"bob":   {utxo(pay, 0)},
+				"carol": {utxo(carol, 0)},
+			},
+			balance: map[string]Amount{"alice": 7, "bob": 3, "carol": subsidy},
+		},
+		{
+			name:    "self-send keeps both outputs once",
+			blocks:  []*Block{testBlock(alice), testBlock(carol, self)},
+			want:    map[string][]string{"alice": sorted(utxo(self, 0), utxo(self, 1))},
+			balance: map[string]Amount{"alice": subsidy},
+		},
+		{
+			name:    "spend within the same block",
+			blocks:  []*Block{testBlock(alice), testBlock(carol, pay, onward)},
+			want:    map[string][]string{"alice": nil, "bob": nil, "carol": sorted(utxo(carol, 0), utxo(onward, 0))},
+			balance: map[string]Amount{"carol": 2 * subsidy},
+		},
+		{
+			name:    "double spend across blocks",
+			blocks:  []*Block{testBlock(alice), testBlock(carol, pay), testBlock(testCoinbase("miner", "1"), self)},
+			wantErr: "missing or already spent",
+		},
+		{
+			name:    "double spend within a block",
+			blocks:  []*Block{testBlock(alice), testBlock(carol, pay, self)},
+			wantErr: "missing or already spent",
+		},
+		{
+			name:    "same output twice in one transaction",
+			blocks:  []*Block{testBlock(alice), testBlock(carol, testSpend(alice, []int{0, 0}, TXOutput{20, "bob"}))},
+			wantErr: "missing or already spent",
+		},
+		{
+			name:    "spend of an unknown output",
+			blocks:  []*Block{testBlock(carol, pay)},
+			wantErr: "missing or already spent",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newUTXOCache()
+			var err error
+			for _, b := range tt.blocks {
+				v := c.view()
+				if _, err = v.connectBlock(b, nil, true); err != nil {
+					break
+				}
+				v.commit()
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for address, want := range tt.want {
+				if got := utxoDesc(c, address); !reflect.DeepEqual(got, want) {
+					t.Errorf("%s: unspent %v, want %v", address, got, want)
+				}
+			}
+			for address, want := range tt.balance {
+				var got Amount
+				for _, e := range c.outputs(address) {
+					got += e.Output.Value
+				}
+				if got != want {
+					t.Errorf("%s: balance %d, want %d", address, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestUTXODisconnectRestores(t *testing.T) {
+	alice := testCoinbase("alice", "a")
+	pay := testSpend(alice, []int{0}, TXOutput{3, "bob"}, TXOutput{7, "alice"})
+	onward := testSpend(pay, []int{0}, TXOutput{3, "carol"})
+	tip := testBlock(testCoinbase("miner", "1"), pay, onward)
+
+	c := newUTXOCache()
+	v := c.view()
+	if _, err := v.connectBlock(testBlock(alice), nil, true); err != nil {
+		t.Fatal(err)
+	}
+	v.commit()
+	before := fmt.Sprint(c.UTXOSet)
+
+	v = c.view()
+	undo, err := v.connectBlock(tip, nil, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v.commit()
+	if len(undo) != 2 {
+		t.Fatalf("undo records %d spent outputs, want 2", len(undo))
+	}
+
+	v = c.view()
+	v.disconnectBlock(tip, undo)
+	v.commit()
+	if after := fmt.Sprint(c.UTXOSet); after != before {
+		t.Fatalf("set after disconnect\n%s\nwant\n%s", after, before)
+	}
+	for _, address := range []string{"bob", "carol", "miner"} {
+		if got := c.outputs(address); len(got) != 0 {
+			t.Errorf("%s still holds %v", address, got)
+		}
+	}
+	if got := utxoDesc(c, "alice"); len(got) != 1 || !strings.HasPrefix(got[0], alice.ID+":0=") {
+		t.Errorf("alice holds %v, want the coinbase back", got)
+	}
+}
+
+func TestConnectBlockLotteryRules(t *testing.T) {
+	saved := params.Lotteries
+	defer func() { params.Lotteries = saved }()
+	lottery := Lottery{Name: "weekly", TicketPrice: 2, CloseHeight: 10}
+	params.Lotteries = []Lottery{lottery}
+
+	alice := testCoinbase("alice", "a")
+	ticket := testSpend(alice, []int{0}, TXOutput{2, lottery.Pot()}, TXOutput{8, "alice"})
+	cheap := testSpend(alice, []int{0}, TXOutput{1, lottery.Pot()}, TXOutput{9, "alice"})
+	// spends the ticket bought earlier in the same block
+	steal := testSpend(ticket, []int{0}, TXOutput{2, "mallory"})
+
+	tests := []struct {
+		name    string
+		block   *Block
+		wantErr string
+	}{
+		{name: "ticket", block: testBlock(alice, ticket)},
+		{name: "ticket of the wrong price", block: testBlock(alice, cheap), wantErr: "tickets cost 2"},
+		{name: "pot spent in the block buying the ticket", block: testBlock(alice, ticket, steal), wantErr: "draws at height"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newUTXOCache().view().connectBlock(tt.block, nil, true)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// testChain is a chain of blocks connected without checks, with an empty
+// mempool
+func testChain(t *testing.T, blocks ...*Block) *Blockchain {
+	c := &Blockchain{utxo: newUTXOCache(), txIndex: make(map[string]string), mempool: NewMempool()}
+	for _, b := range blocks {
+		v := c.utxo.view()
+		if _, err := v.connectBlock(b, c.blocks, true); err != nil {
+			t.Fatal(err)
+		}
+		v.commit()
+		c.indexTransactions(b)
+		c.blocks = append(c.blocks, b)
+	}
+	return c
+}
+
+// testForeignInput spends prev's first output with an input naming another
+// address than the one it is locked to
+func testForeignInput(prev *Transaction) *Transaction {
+	tx := &Transaction{
+		Vin:  []TXInput{{prev.ID, 0, "mallory", nil, nil}},
+		Vout: []TXOutput{{prev.Vout[0].Value, "mallory"}},
+	}
+	tx.SetID()
+	return tx
+}
+
+func TestConnectBlockRejects(t *testing.T) {
+	saved := params
+	defer func() { params = saved }()
+	params.UnsignedNames = true
+
+	alice := testCoinbase("alice", "a")
+	miner := testCoinbase("miner", "1")
+	// rich holds more than maxMoney in total, which no valid chain can
+	// create; the tests seed its outputs directly
+	rich := &Transaction{Vout: []TXOutput{{maxMoney, "alice"}, {maxMoney, "alice"}}}
+	rich.SetID()
+
+	tests := []struct {
+		name    string
+		block   *Block
+		wantErr string
+	}{
+		{
+			name:    "outputs exceed inputs",
+			block:   testBlock(miner, testSpend(alice, []int{0}, TXOutput{subsidy + 1, "bob"})),
+			wantErr: "exceed inputs",
+		},
+		{
+			name:    "output of zero",
+			block:   testBlock(miner, testSpend(alice, []int{0}, TXOutput{0, "bob"}, TXOutput{subsidy, "alice"})),
+			wantErr: "must be positive",
+		},
+		{
+			name:    "transaction without outputs",
+			block:   testBlock(miner, testSpend(alice, []int{0})),
+			wantErr: "needs inputs and outputs",
+		},
+		{
+			name:    "input unlocking another address",
+			block:   testBlock(miner, testForeignInput(alice)),
+			wantErr: "can't unlock",
+		},
+		{
+			name:    "no coinbase",
+			block:   testBlock(testSpend(alice, []int{0}, TXOutput{subsidy, "bob"})),
+			wantErr: "must be its coinbase",
+		},
+		{
+			name:    "coinbase after a transaction",
+			block:   testBlock(testSpend(alice, []int{0}, TXOutput{subsidy, "bob"}), miner),
+			wantErr: "must be its coinbase",
+		},
+		{
+			name:    "second coinbase",
+			block:   testBlock(miner, testCoinbase("miner", "2")),
+			wantErr: "second coinbase",
+		},
+		{
+			name:    "coinbase paying more than the subsidy",
+			block:   testBlock(newCoinbaseTX("miner", "1", subsidy+1)),
+			wantErr: "not the subsidy",
+		},
+		{
+			name:    "coinbase leaving out the fees",
+			block:   testBlock(miner, testSpend(alice, []int{0}, TXOutput{subsidy - 2, "bob"})),
+			wantErr: "fees 2",
+		},
+		{
+			name:    "coinbase output of zero",
+			block:   testBlock(&Transaction{Vin: miner.Vin, Vout: []TXOutput{{subsidy, "miner"}, {0, "miner"}}}),
+			wantErr: "must be positive",
+		},
+		{
+			name:    "output above the money range",
+			block:   testBlock(miner, testSpend(rich, []int{0}, TXOutput{maxMoney + 1, "bob"})),
+			wantErr: "Outputs exceed",
+		},
+		{
+			name:    "outputs summing above the money range",
+			block:   testBlock(miner, testSpend(rich, []int{0}, TXOutput{maxMoney, "bob"}, TXOutput{maxMoney, "carol"})),
+			wantErr: "Outputs exceed",
+		},
+		{
+			name:    "inputs summing above the money range",
+			block:   testBlock(miner, testSpend(rich, []int{0, 1}, TXOutput{1, "bob"})),
+			wantErr: "Inputs exceed",
+		},
+		{
+			name:    "coinbase above the money range",
+			block:   testBlock(newCoinbaseTX("miner", "1", maxMoney+1)),
+			wantErr: "Coinbase outputs exceed",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testChain(t, testBlock(alice))
+			v := c.utxo.view()
+			for i, out := range rich.Vout {
+				v.add(outpoint{rich.ID, i}, out)
+			}
+			_, err := v.connectBlock(tt.block, c.blocks, false)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAcceptTransactionRejects(t *testing.T) {
+	saved := params
+	defer func() { params = saved }()
+	params.UnsignedNames = true
+
+	alice := testCoinbase("alice", "a")
+	pay := testSpend(alice, []int{0}, TXOutput{3, "bob"}, TXOutput{7, "alice"})
+
+	tests := []struct {
+		name    string
+		pooled  []*Transaction
+		tx      *Transaction
+		wantErr string
+	}{
+		{name: "valid spend", tx: pay},
+		{name: "spend of a pooled output", pooled: []*Transaction{pay}, tx: testSpend(pay, []int{0}, TXOutput{3, "carol"})},
+		{
+			name:    "outputs exceed inputs",
+			tx:      testSpend(alice, []int{0}, TXOutput{subsidy + 1, "bob"}),
+			wantErr: "exceed inputs",
+		},
+		{
+			name:    "output above the money range",
+			tx:      testSpend(alice, []int{0}, TXOutput{maxMoney + 1, "bob"}),
+			wantErr: "Outputs exceed",
+		},
+		{
+			name:    "transaction without outputs",
+			tx:      testSpend(alice, []int{0}),
+			wantErr: "needs inputs and outputs",
+		},
+		{
+			name:    "input unlocking another address",
+			tx:      testForeignInput(alice),
+			wantErr: "can't unlock",
+		},
+		{
+			name:    "spend of an unknown output",
+			tx:      testSpend(pay, []int{0}, TXOutput{3, "carol"}),
+			wantErr: "missing or already spent",
+		},
+		{
+			name:    "conflict with a pooled spend",
+			pooled:  []*Transaction{pay},
+			tx:      testSpend(alice, []int{0}, TXOutput{subsidy, "carol"}),
+			wantErr: "missing or already spent",
+		},
+		{
+			name:    "coinbase",
+			tx:      testCoinbase("miner", "1"),
+			wantErr: "can't be relayed",
+		},
+		{
+			name:    "ID not matching the contents",
+			tx:      &Transaction{ID: "00", Vin: pay.Vin, Vout: pay.Vout},
+			wantErr: "doesn't match its contents",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testChain(t, testBlock(alice))
+			for _, tx := range tt.pooled {
+				if err := c.AcceptTransaction(context.Background(), tx); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := c.AcceptTransaction(context.Background(), tt.tx)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !c.mempool.Has(tt.tx.ID) {
+					t.Fatal("accepted but not pooled")
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error %v, want %q", err, tt.wantErr)
+			}
+			if c.mempool.Has(tt.tx.ID) {
+				t.Fatal("rejected but pooled")
+			}
+		})
+	}
+}
+
+func TestCheckUniqueTransactions(t *testing.T) {
+	alice := testCoinbase("alice", "a")
+	pay := testSpend(alice, []int{0}, TXOutput{3, "bob"}, TXOutput{7, "alice"})
+	onward := testSpend(pay, []int{0}, TXOutput{3, "carol"})
+	c := testChain(t, testBlock(alice), testBlock(testCoinbase("miner", "1"), pay))
+	confirmed := func(txid string) bool {
+		_, ok := c.txIndex[txid]
+		return ok
+	}
+
+	tests := []struct {
+		name    string
+		block   *Block
+		wantErr bool
+	}{
+		{name: "new transactions", block: testBlock(testCoinbase("miner", "2"), onward)},
+		{name: "coinbase of an earlier block", block: testBlock(testCoinbase("alice", "a")), wantErr: true},
+		{name: "transaction of an earlier block", block: testBlock(testCoinbase("miner", "2"), pay), wantErr: true},
+		{name: "transaction twice in the block", block: testBlock(testCoinbase("miner", "2"), onward, onward), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkUniqueTransactions(tt.block, confirmed)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("error %v, want an error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBlockLimits(t *testing.T) {
+	saved := params
+	defer func() { params = saved }()
+	params.UnsignedNames = true
+
+	alice := testCoinbase("alice", "a")
+	// a chain of three spends from alice's coins, each paying a fee of 1
+	pay1 := testSpend(alice, []int{0}, TXOutput{3, "bob"}, TXOutput{6, "alice"})
+	pay2 := testSpend(pay1, []int{1}, TXOutput{2, "bob"}, TXOutput{3, "alice"})
+	pay3 := testSpend(pay2, []int{1}, TXOutput{2, "bob"})
+	miner := testCoinbase("miner", "1")
+	full := testBlock(miner, pay1, pay2, pay3)
+
+	tests := []struct {
+		name   string
+		maxTxs int
+		// maxSize is a block's size in bytes past blockReserve
+		maxSize int
+		pooled  []*Transaction
+		txs     []*Transaction
+		want    []*Transaction
+		wantErr string
+	}{
+		{name: "pool fits", maxTxs: 4, maxSize: 1 << 20, pooled: []*Transaction{pay1, pay2, pay3}, want: []*Transaction{pay1, pay2, pay3}},
+		{name: "pool capped by count", maxTxs: 3, maxSize: 1 << 20, pooled: []*Transaction{pay1, pay2, pay3}, want: []*Transaction{pay1, pay2}},
+		{name: "pool capped by size", maxTxs: 4, maxSize: txSize(pay1) + txSize(pay2), pooled: []*Transaction{pay1, pay2, pay3}, want: []*Transaction{pay1, pay2}},
+		{name: "explicit transactions over the count", maxTxs: 3, maxSize: 1 << 20, txs: []*Transaction{pay1, pay2, pay3}, wantErr: "more than a block's 3"},
+		{name: "explicit transactions over the size", maxTxs: 4, maxSize: txSize(pay1), txs: []*Transaction{pay1, pay2}, wantErr: "bytes with the coinbase"},
+		{name: "explicit transaction overspending", maxTxs: 4, maxSize: 1 << 20, txs: []*Transaction{testSpend(alice, []int{0}, TXOutput{20, "bob"})}, wantErr: "exceed inputs"},
+		{name: "explicit coinbase", maxTxs: 4, maxSize: 1 << 20, txs: []*Transaction{testCoinbase("mallory", "m")}, wantErr: "coinbase"},
+		{name: "explicit transactions come first", maxTxs: 3, maxSize: 1 << 20, pooled: []*Transaction{pay1, pay2}, txs: []*Transaction{pay1, pay2}, want: []*Transaction{pay1, pay2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params.MaxBlockTxs, params.MaxBlockSize = tt.maxTxs, blockReserve+tt.maxSize
+			c := testChain(t, testBlock(alice))
+			for _, tx := range tt.pooled {
+				if err := c.AcceptTransaction(context.Background(), tx); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, fees, err := c.blockTransactions(tt.txs...)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) || fees != Amount(len(tt.want)) {
+				t.Fatalf("picked %d transactions paying %d, want %d", len(got), fees, len(tt.want))
+			}
+		})
+	}
+
+	t.Run("checkBlockLimits", func(t *testing.T) {
+		params.MaxBlockTxs, params.MaxBlockSize = 4, 1<<20
+		if err := checkBlockLimits(full); err != nil {
+			t.Fatal(err)
+		}
+		params.MaxBlockTxs = 3
+		if err := checkBlockLimits(full); err == nil || !strings.Contains(err.Error(), "4 transactions") {
+			t.Fatalf("error %v, want the count", err)
+		}
+		params.MaxBlockTxs, params.MaxBlockSize = 4, blockSize(full)-1
+		if err := checkBlockLimits(full); err == nil || !strings.Contains(err.Error(), "bytes") {
+			t.Fatalf("error %v, want the size", err)
+		}
+	})
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// utxoCompactBatches is how many batches the UTXO journal collects before
+// it is folded into a new snapshot
+const utxoCompactBatches = 1000
+
+// utxoEntry is an unspent output as the UTXO index stores it
+type utxoEntry struct {
+	outpoint
+	Output TXOutput
+}
+
+// UTXOCache keeps the UTXO set of the active chain in memory, indexed by
+// address, so balances and coin selection never scan the chain. Committed
+// changes are collected and written through to the UTXO index on disk in
+// one batch per block or reorganization. It is guarded by the chain's lock.
+type UTXOCache struct {
+	UTXOSet
+	byAddress map[string]map[outpoint]bool
+	store     *UTXOStore
+	pending   utxoBatch
+}
+
+func newUTXOCache() *UTXOCache {
+	return &UTXOCache{UTXOSet: make(UTXOSet), byAddress: make(map[string]map[outpoint]bool)}
+}
+
+// view stages changes that are committed through the cache
+func (c *UTXOCache) view() *utxoView {
+	v := newUTXOView(c.UTXOSet)
+	v.cache = c
+	return v
+}
+
+func (c *UTXOCache) add(op outpoint, out TXOutput) {
+	if old, ok := c.UTXOSet[op]; ok {
+		c.unindex(op, old.ScriptPubKey)
+	}
+	c.UTXOSet[op] = out
+	ops := c.byAddress[out.ScriptPubKey]
+	if ops == nil {
+		ops = make(map[outpoint]bool)
+		c.byAddress[out.ScriptPubKey] = ops
+	}
+	ops[op] = true
+	c.pending.Added = append(c.pending.Added, utxoEntry{op, out})
+}
+
+func (c *UTXOCache) spend(op outpoint) {
+	if out, ok := c.UTXOSet[op]; ok {
+		delete(c.UTXOSet, op)
+		c.unindex(op, out.ScriptPubKey)
+	}
+	c.pending.Spent = append(c.pending.Spent, op)
+}
+
+func (c *UTXOCache) unindex(op outpoint, address string) {
+	delete(c.byAddress[address], op)
+	if len(c.byAddress[address]) == 0 {
+		delete(c.byAddress, address)
+	}
+}
+
+// outputs returns the unspent outputs of address, oldest transaction ID
+// first so coin selection is deterministic
+func (c *UTXOCache) outputs(address string) []utxoEntry {
+	entries := make([]utxoEntry, 0, len(c.byAddress[address]))
+	for op := range c.byAddress[address] {
+		entries = append(entries, utxoEntry{op, c.UTXOSet[op]})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Txid != entries[j].Txid {
+			return entries[i].Txid < entries[j].Txid
+		}
+		return entries[i].Vout < entries[j].Vout
+	})
+	return entries
+}
+
+// flush writes the changes committed since the last flush to the UTXO index
+// as one batch ending at tip. If the write fails the index is dropped and
+// rebuilt on the next start.
+func (c *UTXOCache) flush(tip string) {
+	batch := c.pending
+	c.pending = utxoBatch{}
+	if c.store == nil {
+		return
+	}
+
+	batch.Tip = tip
+	err := c.store.write(batch)
+	if err == nil && c.store.batches >= utxoCompactBatches {
+		err = c.store.snapshot(tip, c.UTXOSet)
+	}
+	if err != nil {
+		storageLog.Error("Can't write the UTXO index, dropping it", "err", err)
+		c.store.Close()
+		c.store = nil
+	}
+}
+
+// attach starts writing through to store, whose index is rewritten unless
+// it already holds the set at tip
+func (c *UTXOCache) attach(store *UTXOStore, tip string) error {
+	c.pending = utxoBatch{}
+	storedTip, stored, err := store.load()
+	if err != nil || storedTip != tip || compareUTXO(c.UTXOSet, stored) != nil {
+		storageLog.Info("Rebuilding the UTXO index", "outputs", len(c.UTXOSet), "tip", tip)
+		if err := store.snapshot(tip, c.UTXOSet); err != nil {
+			return err
+		}
+	}
+	c.store = store
+	return nil
+}
+
+// reset replaces the cached set with set at tip, rewriting the UTXO index
+func (c *UTXOCache) reset(set UTXOSet, tip string) error {
+	c.UTXOSet = make(UTXOSet, len(set))
+	c.byAddress = make(map[string]map[outpoint]bool)
+	for op, out := range set {
+		c.add(op, out)
+	}
+	c.pending = utxoBatch{}
+	if c.store == nil {
+		return nil
+	}
+	return c.store.snapshot(tip, c.UTXOSet)
+}
+
+// Close closes the UTXO index; later changes stay in memory
+func (c *UTXOCache) Close() {
+	if c.store != nil {
+		c.store.Close()
+		c.store = nil
+	}
+}
+
+// utxoBatch is the change of the UTXO set by a block or reorganization
+type utxoBatch struct {
+	Tip   string
+	Added []utxoEntry `json:",omitempty"`
+	Spent []outpoint  `json:",omitempty"`
+}
+
+// utxoSnapshot is the whole UTXO set at Tip
+type utxoSnapshot struct {
+	Tip     string
+	Outputs []utxoEntry
+}
+
+// UTXOStore keeps the UTXO index on disk: a snapshot of the set plus a
+// journal of the batches committed since, one JSON line each. Replaying a
+// batch twice leaves the same set, so a crash between writing a snapshot and
+// truncating the journal is harmless.
+type UTXOStore struct {
+	dir     string
+	journal *os.File
+	batches int
+}
+
+// OpenUTXOStore opens or creates the UTXO index below dir
+func OpenUTXOStore(dir string) (*UTXOStore, error) {
+	dir = filepath.Join(dir, "chainstate")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	journal, err := os.OpenFile(filepath.Join(dir, "utxo.log"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &UTXOStore{dir: dir, journal: journal}, nil
+}
+
+// load reads the snapshot and replays the journal, returning the tip the set
+// belongs to, or "" for an empty index
+func (s *UTXOStore) load() (string, UTXOSet, error) {
+	set := make(UTXOSet)
+	var snapshot utxoSnapshot
+	data, err := os.ReadFile(filepath.Join(s.dir, "utxo.json"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return "", nil, err
+		}
+	}
+	for _, e := range snapshot.Outputs {
+		set[e.outpoint] = e.Output
+	}
+	tip := snapshot.Tip
+
+	journal, err := os.Open(s.journal.Name())
+	if err != nil {
+		return "", nil, err
+	}
+	defer journal.Close()
+	s.batches = 0
+	scanner := bufio.NewScanner(journal)
+	scanner.Buffer(make([]byte, 64*1024), maxMessageSize)
+	for scanner.Scan() {
+		var batch utxoBatch
+		if err := json.Unmarshal(scanner.Bytes(), &batch); err != nil {
+			return "", nil, err
+		}
+		for _, op := range batch.Spent {
+			delete(set, op)
+		}
+		for _, e := range batch.Added {
+			set[e.outpoint] = e.Output
+		}
+		tip = batch.Tip
+		s.batches++
+	}
+	return tip, set, scanner.Err()
+}
+
+// write appends a batch to the journal
+func (s *UTXOStore) write(batch utxoBatch) error {
+	line, err := json.Marshal(batch)
+	if err != nil {
+		return err
+	}
+	if _, err := s.journal.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	s.batches++
+	return nil
+}
+
+// snapshot replaces the index with set at tip and empties the journal
+func (s *UTXOStore) snapshot(tip string, set UTXOSet) error {
+	snapshot := utxoSnapshot{Tip: tip, Outputs: make([]utxoEntry, 0, len(set))}
+	for op, out := range set {
+		snapshot.Outputs = append(snapshot.Outputs, utxoEntry{op, out})
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(filepath.Join(s.dir, "utxo.json"), data); err != nil {
+		return err
+	}
+	if err := s.journal.Truncate(0); err != nil {
+		return err
+	}
+	s.batches = 0
+	return nil
+}
+
+// Close closes the journal
+func (s *UTXOStore) Close() error {
+	return s.journal.Close()
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ChainViolation is the first rule a chain breaks, found by validateChain
+type ChainViolation struct {
+	Height int
+	Hash   string
+	Reason string
+}
+
+func (v *ChainViolation) Error() string {
+	return fmt.Sprintf("ERROR: Block %s at height %d: %s", v.Hash, v.Height, v.Reason)
+}
+
+// verifyLevel is how thoroughly checkChain verifies a chain; each level
+// includes the checks of the ones before it
+type verifyLevel int
+
+const (
+	// verifyLevelHeaders checks the links, hashes and proof of work of the
+	// blocks, and that they match the checkpoints
+	verifyLevelHeaders verifyLevel = iota
+	// verifyLevelBlocks adds the transaction IDs, that none repeats, and
+	// the block limits
+	verifyLevelBlocks
+	// verifyLevelFull replays every transaction: each input must spend an
+	// output unspent at that point and be signed by its owner, the lottery
+	// rules must hold, and each block must start with one coinbase paying
+	// the subsidy and fees
+	verifyLevelFull
+)
+
+// verifyLevels names the levels for the API
+var verifyLevels = map[string]verifyLevel{
+	"headers": verifyLevelHeaders,
+	"blocks":  verifyLevelBlocks,
+	"full":    verifyLevelFull,
+}
+
+// validateChain checks a chain from its genesis block up at verifyLevelFull,
+// without trusting anything the node derived from it. It returns the
+// unspent outputs the chain leaves.
+func validateChain(blocks []*Block) (UTXOSet, error) {
+	return checkChain(blocks, verifyLevelFull)
+}
+
+// checkChain checks a chain from its genesis block up at level, reporting
+// the first violation. The unspent outputs it returns are only complete at
+// verifyLevelFull.
+func checkChain(blocks []*Block, level verifyLevel) (UTXOSet, error) {
+	utxo := make(UTXOSet)
+	confirmed := make(map[string]bool)
+	for height, b := range blocks {
+		violation := func(format string, args ...interface{}) error {
+			return &ChainViolation{height, b.Hash, fmt.Sprintf(format, args...)}
+		}
+
+		if height == 0 {
+			if b.PrevHash != "" {
+				return nil, violation("the genesis block has a parent")
+			}
+		} else {
+			if b.PrevHash != blocks[height-1].Hash {
+				return nil, violation("its parent is %s, not %s", b.PrevHash, blocks[height-1].Hash)
+			}
+			if b.Hash == "" || calculateHash(b) != b.Hash {
+				return nil, violation("the hash doesn't match the header")
+			}
+			if !isHashValid(b.Hash, difficulty) {
+				return nil, violation("the hash doesn't meet difficulty %d", difficulty)
+			}
+		}
+		if hash, ok := params.checkpoint(height); ok && hash != b.Hash {
+			return nil, violation("it doesn't match checkpoint %s", hash)
+		}
+		if level < verifyLevelBlocks {
+			continue
+		}
+
+		if err := checkTransactionIDs(b); err != nil {
+			return nil, violation("%v", err)
+		}
+		if err := checkBlockLimits(b); err != nil {
+			return nil, violation("%v", err)
+		}
+		if err := checkUniqueTransactions(b, func(txid string) bool { return confirmed[txid] }); err != nil {
+			return nil, violation("%v", err)
+		}
+		for _, tx := range b.Transactions {
+			confirmed[tx.ID] = true
+		}
+		if level < verifyLevelFull {
+			continue
+		}
+
+		view := newUTXOView(utxo)
+		if _, err := view.connectBlock(b, blocks[:height], false); err != nil {
+			return nil, violation("%v", err)
+		}
+		view.commit()
+	}
+	return utxo, nil
+}
+
+// compareUTXO reports the first difference between the UTXO set the node
+// keeps and the one validateChain rebuilt
+func compareUTXO(kept, rebuilt UTXOSet) error {
+	if len(kept) != len(rebuilt) {
+		return fmt.Errorf("ERROR: The UTXO set holds %d outputs, the chain leaves %d", len(kept), len(rebuilt))
+	}
+	for op, out := range rebuilt {
+		if got, ok := kept[op]; !ok || got != out {
+			return fmt.Errorf("ERROR: The UTXO set disagrees with the chain on %s:%d", op.Txid, op.Vout)
+		}
+	}
+	return nil
+}
+
+// checkStoredBlocks reports the first stored block loading skipped, because
+// it is invalid or doesn't descend from the genesis block
+func (bc *Blockchain) checkStoredBlocks() error {
+	hashes, err := bc.store.Hashes()
+	if err != nil {
+		return err
+	}
+	bc.RLock()
+	defer bc.RUnlock()
+	for _, hash := range hashes {
+		if bc.known[hash] == nil && !bc.invalid[hash] {
+			return fmt.Errorf("ERROR: Stored block %s doesn't connect to the chain", hash)
+		}
+	}
+	return nil
+}
+
+// ChainVerification is what POST /admin/verify reports: the level the
+// active chain was checked at, up to which block, and the first violation
+// found, if any
+type ChainVerification struct {
+	Level     string
+	Height    int
+	Tip       string
+	Valid     bool
+	Violation *ChainViolation `json:",omitempty"`
+	Took      string
+}
+
+// verifyActiveChain checks the active chain at level. At verifyLevelFull the
+// UTXO set the node keeps must also match the replay; a mismatch is reported
+// against the tip.
+func (bc *Blockchain) verifyActiveChain(name string, level verifyLevel) *ChainVerification {
+	started := time.Now()
+	bc.RLock()
+	blocks := append([]*Block(nil), bc.blocks...)
+	var kept UTXOSet
+	if level == verifyLevelFull {
+		kept = make(UTXOSet, len(bc.utxo.UTXOSet))
+		for op, out := range bc.utxo.UTXOSet {
+			kept[op] = out
+		}
+	}
+	bc.RUnlock()
+
+	tip := blocks[len(blocks)-1]
+	report := &ChainVerification{Level: name, Height: len(blocks) - 1, Tip: tip.Hash}
+	utxo, err := checkChain(blocks, level)
+	if err == nil && level == verifyLevelFull {
+		if err = compareUTXO(kept, utxo); err != nil {
+			err = &ChainViolation{len(blocks) - 1, tip.Hash, err.Error()}
+		}
+	}
+	errors.As(err, &report.Violation)
+	report.Valid = report.Violation == nil
+	report.Took = time.Since(started).String()
+	return report
+}
+
+// validateActiveChain replays the active chain with validateChain
+func (bc *Blockchain) validateActiveChain() (UTXOSet, error) {
+	return validateChain(bc.Blocks(0, bc.Height()+1))
+}
+
+// validateOnStartup fully validates the chain the node loaded, as the
+// validate command does, before the node serves it. On a violation the node
+// refuses to start, or with RECOVER_CHAIN=1 invalidates the offending block,
+// falling back to the best valid chain, and rebuilds a UTXO set that
+// disagrees with the chain.
+func (bc *Blockchain) validateOnStartup() error {
+	recoverChain := os.Getenv("RECOVER_CHAIN") == "1"
+	started := time.Now()
+	storageLog.Info("Validating the stored chain", "height", bc.Height())
+
+	if err := bc.checkStoredBlocks(); err != nil {
+		if !recoverChain {
+			return fmt.Errorf("%v; set RECOVER_CHAIN=1 to start without it", err)
+		}
+		storageLog.Warn("Recovering the chain, ignoring stored block", "err", err)
+	}
+
+	for {
+		utxo, err := bc.validateActiveChain()
+		var violation *ChainViolation
+		if errors.As(err, &violation) && recoverChain {
+			storageLog.Warn("Recovering the chain, invalidating block", "hash", violation.Hash, "height", violation.Height, "err", violation.Reason)
+			if err := bc.InvalidateBlock(violation.Hash); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%v; set RECOVER_CHAIN=1 to fall back to the last valid block", err)
+		}
+
+		bc.Lock()
+		defer bc.Unlock()
+		if err := compareUTXO(bc.utxo.UTXOSet, utxo); err != nil {
+			if !recoverChain {
+				return fmt.Errorf("%v; set RECOVER_CHAIN=1 to rebuild it", err)
+			}
+			storageLog.Warn("Recovering the chain, rebuilding the UTXO set", "err", err)
+			if err := bc.utxo.reset(utxo, bc.blocks[len(bc.blocks)-1].Hash); err != nil {
+				return err
+			}
+		}
+		storageLog.Info("Stored chain valid", "height", len(bc.blocks)-1, "outputs", len(utxo), "took", time.Since(started))
+		return nil
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+// testMine builds a block on parent with a valid proof of work; a nil parent
+// makes a genesis block
+func testMine(parent *Block, txs ...*Transaction) *Block {
+	b := &Block{Timestamp: "2024-01-01 00:00:00 +0000 UTC", Transactions: txs}
+	if parent == nil {
+		b.Hash = calculateHash(b)
+		return b
+	}
+	b.PrevHash = parent.Hash
+	for b.Hash = calculateHash(b); !isHashValid(b.Hash, difficulty); b.Hash = calculateHash(b) {
+		b.Nonce++
+	}
+	return b
+}
+
+func TestCheckChainLevels(t *testing.T) {
+	saved := params
+	defer func() { params = saved }()
+	lottery := Lottery{Name: "weekly", TicketPrice: 2, CloseHeight: 10}
+	params.Lotteries = []Lottery{lottery}
+	params.UnsignedNames = true
+
+	alice := testCoinbase("alice", "a")
+	genesis := testMine(nil, alice)
+	pay := testSpend(alice, []int{0}, TXOutput{3, "bob"}, TXOutput{7, "alice"})
+	b1 := testMine(genesis, testCoinbase("miner", "1"), pay)
+	valid := []*Block{genesis, b1, testMine(b1, testCoinbase("miner", "2"))}
+
+	relinked := *valid[2]
+	relinked.PrevHash = genesis.Hash
+	cheapTicket := testSpend(pay, []int{0}, TXOutput{1, lottery.Pot()}, TXOutput{2, "bob"})
+
+	tests := []struct {
+		name        string
+		blocks      []*Block
+		checkpoints []Checkpoint
+		// failsAt is the lowest level reporting a violation at height,
+		// -1 when the chain is valid
+		failsAt verifyLevel
+		height  int
+		reason  string
+	}{
+		{name: "valid", blocks: valid, failsAt: -1},
+		{name: "broken link", blocks: []*Block{genesis, b1, &relinked}, failsAt: verifyLevelHeaders, height: 2, reason: "its parent is"},
+		{
+			name: "checkpoint mismatch", blocks: valid,
+			checkpoints: []Checkpoint{{2, strings.Repeat("0", 64)}},
+			failsAt:     verifyLevelHeaders, height: 2, reason: "checkpoint",
+		},
+		{
+			name:    "repeated transaction",
+			blocks:  []*Block{genesis, b1, testMine(b1, testCoinbase("miner", "2"), pay)},
+			failsAt: verifyLevelBlocks, height: 2, reason: "already confirmed",
+		},
+		{
+			name:    "double spend",
+			blocks:  []*Block{genesis, b1, testMine(b1, testCoinbase("miner", "2"), testSpend(alice, []int{0}, TXOutput{10, "carol"}))},
+			failsAt: verifyLevelFull, height: 2, reason: "missing or already spent",
+		},
+		{
+			name:    "lottery ticket of the wrong price",
+			blocks:  []*Block{genesis, b1, testMine(b1, testCoinbase("miner", "2"), cheapTicket)},
+			failsAt: verifyLevelFull, height: 2, reason: "tickets cost",
+		},
+	}
+
+	for _, tt := range tests {
+		params.Checkpoints = tt.checkpoints
+		for _, level := range []verifyLevel{verifyLevelHeaders, verifyLevelBlocks, verifyLevelFull} {
+			_, err := checkChain(tt.blocks, level)
+			if tt.failsAt < 0 || level < tt.failsAt {
+				if err != nil {
+					t.Errorf("%s at level %d: %v", tt.name, level, err)
+				}
+				continue
+			}
+			var v *ChainViolation
+			if !errors.As(err, &v) || v.Height != tt.height || !strings.Contains(v.Reason, tt.reason) {
+				t.Errorf("%s at level %d: error %v, want %q at height %d", tt.name, level, err, tt.reason, tt.height)
+			}
+		}
+	}
+}
+
+func TestVerifyActiveChainUTXOMismatch(t *testing.T) {
+	alice := testCoinbase("alice", "a")
+	genesis := testMine(nil, alice)
+	b1 := testMine(genesis, testCoinbase("miner", "1"))
+
+	cache := newUTXOCache()
+	for i, b := range []*Block{genesis, b1} {
+		v := cache.view()
+		if _, err := v.connectBlock(b, nil, true); err != nil {
+			t.Fatalf("block %d: %v", i, err)
+		}
+		v.commit()
+	}
+	chain := &Blockchain{blocks: []*Block{genesis, b1}, utxo: cache}
+
+	if report := chain.verifyActiveChain("full", verifyLevelFull); !report.Valid {
+		t.Fatalf("intact chain reported %+v", report.Violation)
+	}
+	delete(cache.UTXOSet, outpoint{alice.ID, 0})
+	if report := chain.verifyActiveChain("blocks", verifyLevelBlocks); !report.Valid {
+		t.Fatalf("blocks level compared the UTXO set: %+v", report.Violation)
+	}
+	report := chain.verifyActiveChain("full", verifyLevelFull)
+	if report.Valid || report.Violation.Height != 1 || !strings.Contains(report.Violation.Reason, "UTXO set") {
+		t.Fatalf("corrupted UTXO set reported %+v", report)
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// webhook event types
+const (
+	WebhookBlock       = "block"
+	WebhookTransaction = "transaction"
+	WebhookReorg       = "reorg"
+	WebhookAlert       = "alert"
+)
+
+const (
+	// webhookAttempts is how often a delivery is tried before it's dropped
+	webhookAttempts = 6
+	// webhookBackoff is the wait before the first retry; it doubles after
+	// every failed attempt
+	webhookBackoff = time.Second
+	// webhookTimeout bounds one delivery attempt
+	webhookTimeout = 10 * time.Second
+	// webhookQueue is how many deliveries may wait for a slow endpoint
+	// before new ones are dropped
+	webhookQueue = 100
+)
+
+var webhookEvents = map[string]bool{WebhookBlock: true, WebhookTransaction: true, WebhookReorg: true, WebhookAlert: true}
+
+// Webhook is a URL receiving chain events. Transaction events are sent for
+// confirmed transactions that pay or spend from one of Addresses.
+type Webhook struct {
+	ID        string
+	URL       string
+	Events    []string
+	Addresses []string `json:",omitempty"`
+	// Secret signs the deliveries; it's only shown when the webhook is
+	// registered
+	Secret  string `json:",omitempty"`
+	Created time.Time
+}
+
+// WebhookRequest registers a webhook. Events defaults to all of them and
+// Secret to a random one.
+type WebhookRequest struct {
+	URL       string `validate:"required"`
+	Events    []string
+	Addresses []string
+	Secret    string
+}
+
+// WebhookDelivery is the body POSTed to a webhook. The X-Webhook-Signature
+// header holds sha256= and the hex HMAC-SHA256, keyed with the secret, of
+// the X-Webhook-Timestamp header, a dot and the body.
+type WebhookDelivery struct {
+	ID    string
+	Event string
+	Time  time.Time
+	Data  interface{}
+}
+
+// WebhookBlockData is the data of a block event
+type WebhookBlockData struct {
+	Hash         string
+	PrevHash     string
+	Height       int
+	Transactions int
+}
+
+// WebhookTransactionData is the data of a transaction event
+type WebhookTransactionData struct {
+	Txid      string
+	BlockHash string
+	Height    int
+}
+
+// WebhookRegistry keeps the webhooks in DATA_DIR and delivers to each of
+// them in order from a queue of its own
+type WebhookRegistry struct {
+	sync.Mutex
+	path   string
+	hooks  map[string]*Webhook
+	queues map[string]chan WebhookDelivery
+	client *http.Client
+}
+
+var webhooks = &WebhookRegistry{
+	hooks:  make(map[string]*Webhook),
+	queues: make(map[string]chan WebhookDelivery),
+	client: &http.Client{Timeout: webhookTimeout},
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatal(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// startWebhooks loads the registered webhooks and delivers chain events to
+// them from now on
+func startWebhooks() {
+	webhooks.load(filepath.Join(dataDir(), "webhooks.json"))
+	chainEvents.Handle("Webhooks", webhooks.dispatch, BlockConnected, Reorg)
+}
+
+func (wr *WebhookRegistry) load(path string) {
+	wr.Lock()
+	defer wr.Unlock()
+
+	wr.path = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	var hooks []*Webhook
+	if err == nil {
+		err = json.Unmarshal(data, &hooks)
+	}
+	if err != nil {
+		httpLog.Warn("Can't load webhooks", "err", err)
+		return
+	}
+	for _, h := range hooks {
+		wr.hooks[h.ID] = h
+		wr.startQueue(h)
+	}
+}
+
+// save writes the webhooks, with wr locked
+func (wr *WebhookRegistry) save() {
+	if wr.path == "" {
+		return
+	}
+	hooks := make([]*Webhook, 0, len(wr.hooks))
+	for _, h := range wr.hooks {
+		hooks = append(hooks, h)
+	}
+	data, err := json.MarshalIndent(hooks, "", "  ")
+	if err == nil {
+		err = writeFileAtomic(wr.path, data)
+	}
+	if err != nil {
+		httpLog.Warn("Can't save webhooks", "err", err)
+	}
+}
+
+// Register adds a webhook and returns it with its secret
+func (wr *WebhookRegistry) Register(req WebhookRequest) (Webhook, error) {
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Webhook{}, invalidRequest("URL", "must be an absolute http or https URL")
+	}
+	events := req.Events
+	if len(events) == 0 {
+		events = []string{WebhookBlock, WebhookTransaction, WebhookReorg, WebhookAlert}
+	}
+	for _, e := range events {
+		if !webhookEvents[e] {
+			return Webhook{}, invalidRequest("Events", "unknown event %q, use block, transaction, reorg or alert", e)
+		}
+	}
+	for _, address := range req.Addresses {
+		if err := checkAddress(address); err != nil {
+			return Webhook{}, invalidRequest("Addresses", "%s", errorMessage(err.Error()))
+		}
+	}
+	secret := req.Secret
+	if secret == "" {
+		secret = randomHex(32)
+	}
+
+	h := &Webhook{ID: randomHex(8), URL: req.URL, Events: events, Addresses: req.Addresses, Secret: secret, Created: time.Now()}
+	wr.Lock()
+	defer wr.Unlock()
+	wr.hooks[h.ID] = h
+	wr.startQueue(h)
+	wr.save()
+	return *h, nil
+}
+
+// Remove deletes a webhook and drops its queued deliveries
+func (wr *WebhookRegistry) Remove(id string) bool {
+	wr.Lock()
+	defer wr.Unlock()
+
+	if _, ok := wr.hooks[id]; !ok {
+		return false
+	}
+	delete(wr.hooks, id)
+	close(wr.queues[id])
+	delete(wr.queues, id)
+	wr.save()
+	return true
+}
+
+// List returns the webhooks without their secrets
+func (wr *WebhookRegistry) List() []Webhook {
+	wr.Lock()
+	defer wr.Unlock()
+
+	list := make([]Webhook, 0, len(wr.hooks))
+	for _, h := range wr.hooks {
+		hook := *h
+		hook.Secret = ""
+		list = append(list, hook)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Created.Before(list[j].Created) })
+	return list
+}
+
+func (wr *WebhookRegistry) startQueue(h *Webhook) {
+	queue := make(chan WebhookDelivery, webhookQueue)
+	wr.queues[h.ID] = queue
+	go func() {
+		for d := range queue {
+			wr.deliver(h, d)
+		}
+	}()
+}
+
+// enqueue queues a delivery for every webhook subscribed to the event; for
+// transaction events, only to those watching one of the transaction's
+// addresses
+func (wr *WebhookRegistry) enqueue(event string, data interface{}, tx *Transaction) {
+	wr.Lock()
+	defer wr.Unlock()
+
+	for id, h := range wr.hooks {
+		if !h.subscribed(event) {
+			continue
+		}
+		if tx != nil {
+			watched := make(addressSet)
+			for _, address := range h.Addresses {
+				watched[address] = true
+			}
+			if !txMatches(tx, watched) {
+				continue
+			}
+		}
+		select {
+		case wr.queues[id] <- WebhookDelivery{randomHex(8), event, time.Now(), data}:
+		default:
+			httpLog.Warn("Webhook is too far behind, dropping an event", "webhook", id, "event", event)
+		}
+	}
+}
+
+func (h *Webhook) subscribed(event string) bool {
+	for _, e := range h.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// dispatch turns a chain event into webhook deliveries
+func (wr *WebhookRegistry) dispatch(e ChainEvent) {
+	if b, ok := e.Block(); ok {
+		height := -1
+		if info, ok := bc.lookupBlock(b.Hash); ok {
+			height = info.Height
+		}
+		wr.enqueue(WebhookBlock, WebhookBlockData{b.Hash, b.PrevHash, height, len(b.Transactions)}, nil)
+		for _, tx := range b.Transactions {
+			wr.enqueue(WebhookTransaction, WebhookTransactionData{tx.ID, b.Hash, height}, tx)
+		}
+	}
+	if reorg, ok := e.Reorg(); ok {
+		wr.enqueue(WebhookReorg, reorg, nil)
+	}
+}
+
+// deliver POSTs a delivery until the webhook answers 2xx, backing off
+// between attempts, and stops once the webhook is removed
+func (wr *WebhookRegistry) deliver(h *Webhook, d WebhookDelivery) {
+	body, err := json.Marshal(d)
+	if err != nil {
+		httpLog.Error("Can't encode webhook delivery", "err", err)
+		return
+	}
+
+	backoff := webhookBackoff
+	for attempt := 1; wr.registered(h.ID); attempt++ {
+		err := wr.post(h, d, body)
+		if err == nil {
+			return
+		}
+		if attempt == webhookAttempts {
+			httpLog.Warn("Giving up on webhook delivery", "webhook", h.ID, "delivery", d.ID, "attempts", attempt, "err", err)
+			return
+		}
+		select {
+		case <-time.After(backoff):
+		case <-shutdownCtx.Done():
+			return
+		}
+		backoff *= 2
+	}
+}
+
+func (wr *WebhookRegistry) registered(id string) bool {
+	wr.Lock()
+	defer wr.Unlock()
+	_, ok := wr.hooks[id]
+	return ok
+}
+
+func (wr *WebhookRegistry) post(h *Webhook, d WebhookDelivery, body []byte) error {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(h.Secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+
+	req, err := http.NewRequestWithContext(context.Background(), http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-ID", d.ID)
+	req.Header.Set("X-Webhook-Event", d.Event)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+
+	resp, err := wr.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+// registers a webhook and answers it with its secret
+func handleCreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req WebhookRequest
+	if err := decodeRequest(r, &req); err != nil {
+		respondWithRequestError(w, r, err)
+		return
+	}
+	h, err := webhooks.Register(req)
+	if err != nil {
+		respondWithRequestError(w, r, err)
+		return
+	}
+	respondWithJSON(w, r, http.StatusCreated, h)
+}
+
+// lists the webhooks
+func handleGetWebhooks(w http.ResponseWriter, r *http.Request) {
+	respondWithJSON(w, r, http.StatusOK, webhooks.List())
+}
+
+// deletes a webhook
+func handleDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if !webhooks.Remove(mux.Vars(r)["id"]) {
+		respondWithError(w, r, http.StatusNotFound, "No such webhook")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,160 @@
+package wire
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+var (
+	// ErrUnknownCommand is returned for a message whose command isn't
+	// registered; peers running a newer protocol may send those
+	ErrUnknownCommand = errors.New("unknown command")
+	// ErrUnknownVersion is returned for a known command with a payload
+	// version that isn't registered
+	ErrUnknownVersion = errors.New("unknown message version")
+	// ErrMalformed is returned for a message that doesn't decode
+	ErrMalformed = errors.New("malformed message")
+)
+
+// MagicError is returned for a message with another network's magic number
+type MagicError struct {
+	Magic uint32
+}
+
+func (e *MagicError) Error() string {
+	return fmt.Sprintf("message has network magic %08x", e.Magic)
+}
+
+// Upgrader is implemented by payload types of superseded versions. Decoded
+// payloads are upgraded until they reach the current type, so handlers only
+// ever see that one.
+type Upgrader interface {
+	Upgrade() interface{}
+}
+
+// Codec maps each command and payload version to a Go type. Messages are
+// sent with the highest version registered for their command, and any
+// registered version is accepted.
+type Codec struct {
+	types  map[string]map[int]reflect.Type
+	latest map[string]int
+}
+
+// NewCodec returns a codec without commands
+func NewCodec() *Codec {
+	return &Codec{types: make(map[string]map[int]reflect.Type), latest: make(map[string]int)}
+}
+
+// Register decodes payloads of a command at a version into values of the
+// prototype's type. It must be called before the codec is used.
+func (c *Codec) Register(command string, version int, prototype interface{}) {
+	if version < 1 {
+		panic(fmt.Sprintf("wire: %s registered with version %d", command, version))
+	}
+	if c.types[command] == nil {
+		c.types[command] = make(map[int]reflect.Type)
+	}
+	if _, ok := c.types[command][version]; ok {
+		panic(fmt.Sprintf("wire: %s version %d registered twice", command, version))
+	}
+	c.types[command][version] = reflect.TypeOf(prototype)
+	if version > c.latest[command] {
+		c.latest[command] = version
+	}
+}
+
+// Version returns the version a command is sent with, 0 if it isn't
+// registered
+func (c *Codec) Version(command string) int {
+	return c.latest[command]
+}
+
+// Unmarshal decodes a payload of a command at a version and returns a
+// pointer to it
+func (c *Codec) Unmarshal(command string, version int, data []byte) (interface{}, error) {
+	versions, ok := c.types[command]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownCommand, command)
+	}
+	t, ok := versions[version]
+	if !ok {
+		return nil, fmt.Errorf("%w %d of %s", ErrUnknownVersion, version, command)
+	}
+	v := reflect.New(t)
+	if err := json.Unmarshal(data, v.Interface()); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrMalformed, command, err)
+	}
+	payload := v.Interface()
+	for {
+		u, ok := payload.(Upgrader)
+		if !ok {
+			return payload, nil
+		}
+		payload = u.Upgrade()
+	}
+}
+
+// Encoder writes messages to a connection
+type Encoder struct {
+	codec *Codec
+	magic uint32
+	enc   *json.Encoder
+}
+
+// NewEncoder returns an encoder writing messages for the network with the
+// given magic number to w
+func (c *Codec) NewEncoder(w io.Writer, magic uint32) *Encoder {
+	return &Encoder{c, magic, json.NewEncoder(w)}
+}
+
+// Encode writes one message
+func (e *Encoder) Encode(command string, payload interface{}) error {
+	version := e.codec.Version(command)
+	if version == 0 {
+		return fmt.Errorf("wire: can't send unregistered command %q", command)
+	}
+	raw, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	return e.enc.Encode(Message{e.magic, command, version, raw})
+}
+
+// Decoder reads messages from a connection
+type Decoder struct {
+	codec   *Codec
+	magic   uint32
+	scanner *bufio.Scanner
+}
+
+// NewDecoder returns a decoder reading messages for the network with the
+// given magic number from r. Messages longer than maxSize are refused.
+func (c *Codec) NewDecoder(r io.Reader, magic uint32, maxSize int) *Decoder {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxSize)
+	return &Decoder{c, magic, scanner}
+}
+
+// Decode reads the next message and returns its command and a pointer to
+// its payload. It returns io.EOF when the connection is closed.
+func (d *Decoder) Decode() (string, interface{}, error) {
+	if !d.scanner.Scan() {
+		if err := d.scanner.Err(); err != nil {
+			return "", nil, err
+		}
+		return "", nil, io.EOF
+	}
+	var msg Message
+	if err := json.Unmarshal(d.scanner.Bytes(), &msg); err != nil {
+		return "", nil, fmt.Errorf("%w: %v", ErrMalformed, err)
+	}
+	if msg.Magic != d.magic {
+		return msg.Command, nil, &MagicError{msg.Magic}
+	}
+	payload, err := d.codec.Unmarshal(msg.Command, msg.Version, msg.Payload)
+	return msg.Command, payload, err
+}
@@ -0,0 +1,96 @@
+// Package wire is the peer-to-peer message format: the envelope every
+// message travels in, the inventory messages peers announce and request
+// blocks and transactions with, and a codec that decodes each payload into
+// the type registered for its command and version.
+package wire
+
+import "encoding/json"
+
+// Commands of the peer-to-peer protocol
+const (
+	CmdVersion     = "version"
+	CmdPing        = "ping"
+	CmdPong        = "pong"
+	CmdGetAddr     = "getaddr"
+	CmdAddr        = "addr"
+	CmdGetBlocks   = "getblocks"
+	CmdBlocks      = "blocks"
+	CmdGetHeaders  = "getheaders"
+	CmdHeaders     = "headers"
+	CmdInv         = "inv"
+	CmdGetData     = "getdata"
+	CmdNotFound    = "notfound"
+	CmdBlock       = "block"
+	CmdTx          = "tx"
+	CmdCmpctBlock  = "cmpctblock"
+	CmdGetBlockTxn = "getblocktxn"
+	CmdBlockTxn    = "blocktxn"
+	CmdFilterLoad  = "filterload"
+	CmdGetFiltered = "getfiltered"
+	CmdFiltered    = "filtered"
+)
+
+// Message is the envelope of everything sent between peers: one JSON object
+// per line. Magic is the network's magic number. Version is the version of
+// the payload's type for Command, so a payload can change shape without a
+// new command.
+type Message struct {
+	Magic   uint32
+	Command string
+	Version int
+	Payload json.RawMessage
+}
+
+// InvType says what an inventory item refers to
+type InvType int
+
+const (
+	InvTx    InvType = 1
+	InvBlock InvType = 2
+)
+
+func (t InvType) String() string {
+	switch t {
+	case InvTx:
+		return "tx"
+	case InvBlock:
+		return "block"
+	}
+	return "unknown"
+}
+
+// InvVect names a block or transaction by hash
+type InvVect struct {
+	Type InvType
+	Hash string
+}
+
+// MaxInvItems caps the items in one inv, getdata or notfound
+const MaxInvItems = 5000
+
+// InvMessage announces blocks and transactions the sender has. Peers ask
+// for the ones they are missing with getdata.
+type InvMessage struct {
+	Items []InvVect
+}
+
+// GetDataMessage asks for blocks and transactions. Blocks come back together
+// in one blocks message, transactions one tx message each, and whatever the
+// peer doesn't have in a notfound.
+type GetDataMessage struct {
+	Items []InvVect
+}
+
+// NotFoundMessage lists the items of a getdata the peer doesn't have
+type NotFoundMessage struct {
+	Items []InvVect
+}
+
+// NewInv returns inventory items of one type
+func NewInv(t InvType, hashes ...string) []InvVect {
+	items := make([]InvVect, len(hashes))
+	for i, hash := range hashes {
+		items[i] = InvVect{t, hash}
+	}
+	return items
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsWriteTimeout = 10 * time.Second
+	wsPingInterval = 30 * time.Second
+	// wsReadLimit fits the largest bloom filter a client may send
+	wsReadLimit = 64 * 1024
+)
+
+// the events are public, so pages on any origin may subscribe
+var wsUpgrader = websocket.Upgrader{
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// upgrades to a WebSocket that pushes chain events as JSON
+func handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade already answered the request
+		return
+	}
+	defer conn.Close()
+
+	events := chainEvents.Subscribe(BlockConnected, TxAdmitted, Reorg)
+	defer chainEvents.Unsubscribe(events)
+
+	// the client may send a FilterLoadMessage to be pushed only the
+	// transactions, and blocks holding them, of the addresses it watches;
+	// reading also notices when it goes away
+	filters := make(chan addressFilter)
+	closed := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(closed)
+		conn.SetReadLimit(wsReadLimit)
+		for {
+			var m FilterLoadMessage
+			if err := conn.ReadJSON(&m); err != nil {
+				return
+			}
+			filter, err := m.filter()
+			if err != nil {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.ClosePolicyViolation, err.Error()),
+					time.Now().Add(wsWriteTimeout))
+				return
+			}
+			select {
+			case filters <- filter:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var filter addressFilter
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				httpLog.Warn("WebSocket client fell behind, disconnecting", "client", r.RemoteAddr)
+				return
+			}
+			if !eventMatches(e, filter) {
+				continue
+			}
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			if err := conn.WriteJSON(e); err != nil {
+				return
+			}
+		case filter = <-filters:
+		case <-ping.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+				return
+			}
+		case <-closed:
+			return
+		}
+	}
+}
+
+// eventMatches tells if a subscriber with filter, nil for none, is sent an
+// event. Reorgs always are, the client can't tell if they touch its
+// addresses.
+func eventMatches(e ChainEvent, filter addressFilter) bool {
+	if filter == nil {
+		return true
+	}
+	if tx, ok := e.Transaction(); ok {
+		return txMatches(tx, filter)
+	}
+	if b, ok := e.Block(); ok {
+		for _, tx := range b.Transactions {
+			if txMatches(tx, filter) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}